@@ -3,15 +3,16 @@ package adapt
 import (
 	"bytes"
 	"encoding/json"
-	"fmt"
-	"io"
 	"mime"
 	"net/http"
+	"strconv"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/caddyserver/caddy/v2"
 	"github.com/caddyserver/caddy/v2/caddyconfig"
+	"go.opentelemetry.io/otel/attribute"
 )
 
 // Provider wraps the provider implementation as a Caddy module.
@@ -29,110 +30,852 @@ func (adminAdapt) CaddyModule() caddy.ModuleInfo {
 	}
 }
 
-// Routes returns a route for the /load endpoint.
+// Routes returns the routes for the /adapt endpoints.
 func (al adminAdapt) Routes() []caddy.AdminRoute {
-	return []caddy.AdminRoute{
+	routes := []caddy.AdminRoute{
 		{
 			Pattern: "/adapt",
-			Handler: caddy.AdminHandlerFunc(al.handleAdapt),
+			Handler: withRequestID(localized(authenticated(rateLimited(decompressBody(limitBody(al.handleAdapt)))))),
+		},
+		{
+			Pattern: "/adapt/jobs",
+			Handler: withRequestID(localized(authenticated(rateLimited(decompressBody(limitBody(al.handleJob)))))),
+		},
+		{
+			Pattern: "/adapt/jobs/",
+			Handler: withRequestID(localized(authenticated(rateLimited(decompressBody(limitBody(al.handleJob)))))),
+		},
+		{
+			Pattern: "/adapt/snapshots/",
+			Handler: withRequestID(localized(authenticated(rateLimited(decompressBody(limitBody(al.handleSnapshots)))))),
+		},
+		{
+			Pattern: "/adapt/quota",
+			Handler: withRequestID(localized(authenticated(rateLimited(decompressBody(limitBody(al.handleQuota)))))),
+		},
+		{
+			Pattern: "/adapt/usage",
+			Handler: withRequestID(localized(authenticated(rateLimited(decompressBody(limitBody(al.handleUsage)))))),
+		},
+		{
+			Pattern: "/adapt/audit",
+			Handler: withRequestID(localized(authenticated(rateLimited(decompressBody(limitBody(al.handleAudit)))))),
+		},
+		{
+			Pattern: "/adapt/adapters",
+			Handler: withRequestID(localized(authenticated(rateLimited(decompressBody(limitBody(al.handleAdapters)))))),
+		},
+		{
+			Pattern: "/adapt/equal",
+			Handler: withRequestID(localized(authenticated(rateLimited(decompressBody(limitBody(al.handleEqual)))))),
+		},
+		{
+			Pattern: "/adapt/diff",
+			Handler: withRequestID(localized(authenticated(rateLimited(decompressBody(limitBody(al.handleDiff)))))),
+		},
+		{
+			Pattern: "/adapt/validate",
+			Handler: withRequestID(localized(authenticated(rateLimited(decompressBody(limitBody(al.handleValidate)))))),
+		},
+		{
+			Pattern: "/adapt/load",
+			Handler: withRequestID(localized(authenticated(rateLimited(decompressBody(limitBody(al.handleLoad)))))),
+		},
+		{
+			Pattern: "/adapt/overlay",
+			Handler: withRequestID(localized(authenticated(rateLimited(decompressBody(limitBody(al.handleOverlay)))))),
+		},
+		{
+			Pattern: "/adapt/sign",
+			Handler: withRequestID(localized(authenticated(rateLimited(decompressBody(limitBody(al.handleSign)))))),
+		},
+		{
+			Pattern: "/adapt/verify",
+			Handler: withRequestID(localized(authenticated(rateLimited(decompressBody(limitBody(al.handleVerify)))))),
+		},
+		{
+			Pattern: "/adapt/rpc",
+			Handler: withRequestID(localized(authenticated(rateLimited(decompressBody(limitBody(al.handleRPC)))))),
+		},
+		{
+			Pattern: "/adapt/fix",
+			Handler: withRequestID(localized(authenticated(rateLimited(decompressBody(limitBody(al.handleFix)))))),
+		},
+		{
+			Pattern: "/adapt/fmt",
+			Handler: withRequestID(localized(authenticated(rateLimited(decompressBody(limitBody(al.handleFmt)))))),
+		},
+		{
+			Pattern: "/adapt/batch",
+			Handler: withRequestID(localized(authenticated(rateLimited(decompressBody(limitBody(al.handleBatch)))))),
+		},
+		{
+			Pattern: "/adapt/dry-run",
+			Handler: withRequestID(localized(authenticated(rateLimited(decompressBody(limitBody(al.handleDryRun)))))),
+		},
+		{
+			Pattern: "/adapt/hash",
+			Handler: withRequestID(localized(authenticated(rateLimited(decompressBody(limitBody(al.handleHash)))))),
+		},
+		{
+			Pattern: "/adapt/patch/",
+			Handler: withRequestID(localized(authenticated(rateLimited(decompressBody(limitBody(al.handlePatch)))))),
+		},
+		{
+			Pattern: "/adapt/reverse",
+			Handler: withRequestID(localized(authenticated(rateLimited(decompressBody(limitBody(al.handleReverse)))))),
+		},
+		{
+			Pattern: "/adapt/upstreams",
+			Handler: withRequestID(localized(authenticated(rateLimited(decompressBody(limitBody(al.handleUpstreams)))))),
+		},
+		{
+			Pattern: "/adapt/certificates",
+			Handler: withRequestID(localized(authenticated(rateLimited(decompressBody(limitBody(al.handleCertificates)))))),
 		},
 	}
+	routes = append(routes, al.shortcutRoutes(routes)...)
+	for i, route := range routes {
+		routes[i].Handler = instrumented(route.Pattern, traced(route.Pattern, withTimeout(withCORS(route.Handler))))
+	}
+	return routes
 }
 
-// handleLoad replaces the entire current configuration with
-// a new one provided in the response body. It supports config
-// adapters through the use of the Content-Type header. A
-// config that is identical to the currently-running config
-// will be a no-op unless Cache-Control: must-revalidate is set.
-func (adminAdapt) handleAdapt(w http.ResponseWriter, r *http.Request) error {
+// handleAdapt adapts the config provided in the request body
+// to Caddy JSON and responds with the result. It supports config
+// adapters through the use of the Content-Type header.
+func (al adminAdapt) handleAdapt(w http.ResponseWriter, r *http.Request) error {
+	// not for the routes of each adapter, which only adapt
+	if r.Method == http.MethodGet && impliedAdapter(r.Context()) == "" {
+		return al.handleStartupConfig(w, r)
+	}
 	if r.Method != http.MethodPost {
 		return caddy.APIError{
 			HTTPStatus: http.StatusMethodNotAllowed,
-			Err:        fmt.Errorf("method not allowed"),
+			Err:        errorf("method not allowed"),
 		}
 	}
 
-	buf := bufPool.Get().(*bytes.Buffer)
-	buf.Reset()
-	defer bufPool.Put(buf)
+	// adapting never applies the config, so there are
+	// no secrets to resolve in it
+	if _, ok := r.URL.Query()["apply"]; ok {
+		return caddy.APIError{
+			HTTPStatus: http.StatusBadRequest,
+			Err:        errorf("apply is not supported by %s; configs are only applied by /adapt/load, /adapt/patch/ and snapshot loads", r.URL.Path),
+		}
+	}
 
-	_, err := io.Copy(buf, r.Body)
+	async, err := queryBool(r, "async")
 	if err != nil {
+		return err
+	}
+	if async {
+		return al.startJob(w, r)
+	}
+
+	withWarnings, err := queryBool(r, "warnings")
+	if err != nil {
+		return err
+	}
+	withReport, err := queryBool(r, "report")
+	if err != nil {
+		return err
+	}
+	withStats, err := queryBool(r, "stats")
+	if err != nil {
+		return err
+	}
+	withSource, err := queryBool(r, "include_source")
+	if err != nil {
+		return err
+	}
+	if withSource && r.URL.Query().Get("redact") != "" {
+		// it is whatever the adapter takes, which can't be redacted
 		return caddy.APIError{
 			HTTPStatus: http.StatusBadRequest,
-			Err:        fmt.Errorf("reading request body: %v", err),
+			Err:        errorf("include_source can't be combined with redact"),
+		}
+	}
+	format, err := outputFormat(r)
+	if err != nil {
+		return err
+	}
+	pointer := r.URL.Query().Get("path")
+	if _, err := parsePointer(pointer); err != nil {
+		return err
+	}
+	split, err := splitMode(r)
+	if err != nil {
+		return err
+	}
+	canonical, err := queryBool(r, "canonical")
+	if err != nil {
+		return err
+	}
+	notify, err := queryBool(r, "notify")
+	if err != nil {
+		return err
+	}
+	if notify && len(settings().Webhooks) == 0 {
+		return caddy.APIError{
+			HTTPStatus: http.StatusForbidden,
+			Err:        errorf("notifying is disabled; no webhooks are configured"),
+		}
+	}
+
+	buf := getBuffer()
+	defer putBuffer(buf)
+	if split != "" && (pointer != "" || withWarnings || withReport || withStats || withSource) {
+		return caddy.APIError{
+			HTTPStatus: http.StatusBadRequest,
+			Err:        errorf("split can't be combined with path, warnings, report, stats or include_source"),
+		}
+	}
+
+	a, err := adaptRequest(r, buf)
+	if err != nil {
+		return err
+	}
+	// the client may have gone while the config was adapted
+	if r.Context().Err() != nil {
+		return contextError(r.Context())
+	}
+	a.setHeaders(w.Header())
+	// of the whole config, before any of it is left out
+	var stats *configStats
+	if withStats {
+		s, err := newStats(a.result)
+		if err != nil {
+			return err
+		}
+		stats = &s
+	}
+
+	respContentType := "application/json"
+	enc, reencoded := negotiateEncoding(r.Header.Get("Accept"))
+	if reencoded {
+		respContentType = enc.contentType
+	}
+	// encodings of the envelope, which they imply
+	var envelopeType string
+	if split == "" {
+		envelopeType = negotiateEnvelope(r.Header.Get("Accept"))
+	}
+	if envelopeType == protobufContentType && (withReport || withStats) {
+		return caddy.APIError{
+			HTTPStatus: http.StatusNotAcceptable,
+			Err:        errorf("report and stats aren't available in the protobuf encoding"),
+		}
+	}
+	if envelopeType != "" {
+		respContentType, reencoded = envelopeType, false
+	}
+	coding := negotiateCoding(r.Header.Get("Accept-Encoding"))
+	if split != "" {
+		// zip archives are compressed already
+		respContentType, reencoded, coding = "application/zip", false, ""
+	}
+
+	// the same input gives the same config, unless it is asked for
+	// in a different form, or the settings, such as which warnings
+	// are suppressed, have changed, or its warnings are in another
+	// language; a config that imports files from disk has no key, as
+	// they may change without it
+	w.Header().Add("Vary", "Accept")
+	w.Header().Add("Vary", "Accept-Encoding")
+	w.Header().Add("Vary", "Accept-Language")
+	if a.key != "" {
+		tag := etag(a.key, strconv.FormatUint(settings().generation, 10), respContentType, coding, format, r.URL.Query().Get("redact"), pointer, strconv.FormatBool(withWarnings), strconv.FormatBool(withReport), strconv.FormatBool(withStats), strconv.FormatBool(withSource), split, strconv.FormatBool(canonical), r.URL.Query().Get("caddy_version"), strings.Join(acceptedLanguages(r.Header.Get("Accept-Language")), ","))
+		w.Header().Set("ETag", tag)
+		if etagMatches(r, tag) {
+			w.WriteHeader(http.StatusNotModified)
+			return nil
+		}
+	}
+
+	result := a.result
+	if profile := r.URL.Query().Get("redact"); profile != "" {
+		result, err = redactConfig(result, profile)
+		if err != nil {
+			return err
 		}
 	}
-	body := buf.Bytes()
 
-	// if the config is formatted other than Caddy's native
-	// JSON, we need to adapt it before loading it
-	if ctHeader := r.Header.Get("Content-Type"); ctHeader != "" {
-		result, warnings, err := adaptByContentType(ctHeader, body)
+	// extracted after redacting, which may depend on the keys above
+	result, err = extractPointer(result, pointer)
+	if err != nil {
+		return err
+	}
+	if canonical {
+		result, err = canonicalJSON(result)
+		if err != nil {
+			return err
+		}
+	}
+
+	prov := newProvenance(r, a.adapter, a.source)
+	if envelopeType == protobufContentType {
+		// its result is the config as it would be returned without it
+		result, err = formatJSON(result, format)
+		if err != nil {
+			return err
+		}
+		var source *string
+		if withSource {
+			src := string(a.source)
+			source = &src
+		}
+		result = encodeProtobufEnvelope(result, a.warnings, prov, source)
+	} else if envelopeType == ndjsonContentType || withWarnings || withReport || withStats || withSource {
+		envelope := adaptEnvelope{
+			Result:     json.RawMessage(result),
+			Warnings:   a.warnings,
+			Provenance: prov,
+			Stats:      stats,
+		}
+		if withSource {
+			source := string(a.source)
+			envelope.Source = &source
+		}
+		if withReport {
+			report := newReport(a.adapter, a.warnings)
+			envelope.Report = &report
+		}
+		if envelopeType == ndjsonContentType {
+			result, err = encodeNDJSONEnvelope(envelope)
+		} else {
+			result, err = json.Marshal(envelope)
+		}
 		if err != nil {
 			return caddy.APIError{
 				HTTPStatus: http.StatusBadRequest,
-				Err:        err,
+				Err:        errorf("adapted config is not valid JSON: %v", err),
 			}
 		}
-		if len(warnings) > 0 {
-			_, err := json.Marshal(warnings)
-			if err != nil {
-				caddy.Log().Named("admin.api.load").Error(err.Error())
-			}
+	}
+
+	if split != "" {
+		result, err = splitZip(result, format, a.warnings, prov)
+		if err != nil {
+			return err
+		}
+		w.Header().Set("Content-Disposition", `attachment; filename="config.zip"`)
+	} else if reencoded {
+		result, err = reencode(result, enc)
+		if err != nil {
+			return err
+		}
+	} else if envelopeType == "" {
+		result, err = formatJSON(result, format)
+		if err != nil {
+			return err
 		}
-		body = result
 	}
 
-	w.Header().Add("Content-Type", "application/json")
-	w.Write(body)
+	result, compressed, err := compressBody(result, coding)
+	if err != nil {
+		return errorf("compressing response: %v", err)
+	}
+	if compressed {
+		w.Header().Set("Content-Encoding", coding)
+	}
+
+	// only once nothing can fail the request, nor has it been
+	// answered with a 304, so webhooks hear of no config that
+	// the client didn't get
+	if notify {
+		// the result is only borrowed from buf, and delivered later
+		notifyWebhooks(r, append([]byte(nil), a.result...), prov)
+	}
+
+	prov.setHeaders(w.Header())
+	w.Header().Add("Content-Type", respContentType)
+	w.Write(result)
 
 	return nil
 }
 
-// adaptByContentType adapts body to Caddy JSON using the adapter specified by contenType.
-// If contentType is empty or ends with "/json", the input will be returned, as a no-op.
-func adaptByContentType(contentType string, body []byte) ([]byte, []caddyconfig.Warning, error) {
-	// assume JSON as the default
-	if contentType == "" {
-		return body, nil, nil
+// adaptEnvelope is the response body of /adapt with ?warnings=true,
+// ?report=true, ?stats=true or ?include_source=true.
+type adaptEnvelope struct {
+	Result     json.RawMessage `json:"result"`
+	Warnings   []adaptWarning  `json:"warnings"`
+	Provenance provenance      `json:"provenance"`
+	Report     *adaptReport    `json:"report,omitempty"`
+	Stats      *configStats    `json:"stats,omitempty"`
+	Source     *string         `json:"source,omitempty"` // the config that was adapted
+}
+
+// queryBool returns the value of the boolean query parameter
+// key in r, which is false if it is absent.
+func queryBool(r *http.Request, key string) (bool, error) {
+	val := r.URL.Query().Get(key)
+	if val == "" {
+		return false, nil
+	}
+	b, err := strconv.ParseBool(val)
+	if err != nil {
+		return false, caddy.APIError{
+			HTTPStatus: http.StatusBadRequest,
+			Err:        errorf("invalid value for %s: %s", key, val),
+		}
 	}
+	return b, nil
+}
 
-	ct, _, err := mime.ParseMediaType(contentType)
+// queryTime returns the time in the query string of r at key, in
+// RFC 3339 format, or the zero time if there is none.
+func queryTime(r *http.Request, key string) (time.Time, error) {
+	val := r.URL.Query().Get(key)
+	if val == "" {
+		return time.Time{}, nil
+	}
+	t, err := time.Parse(time.RFC3339, val)
+	if err != nil {
+		return time.Time{}, caddy.APIError{
+			HTTPStatus: http.StatusBadRequest,
+			Err:        errorf("invalid value for %s: %s (must be an RFC 3339 time)", key, val),
+		}
+	}
+	return t, nil
+}
+
+// adaptation is the outcome of adapting the config in a request.
+type adaptation struct {
+	adapter  string
+	source   []byte
+	key      string // see adaptKey; empty if it imports files from disk
+	result   []byte
+	warnings []adaptWarning
+	duration time.Duration // once the config was read
+}
+
+// setHeaders adds the adapter, duration, sizes and number of warnings
+// of a to h as response headers, for pipelines to log and alert on
+// without parsing the response. The output is the adapted config, as
+// it is before it's formatted or left out of.
+func (a adaptation) setHeaders(h http.Header) {
+	h.Set("X-Adapter", a.adapter)
+	h.Set("X-Adapt-Duration-Ms", strconv.FormatFloat(a.duration.Seconds()*1000, 'f', 3, 64))
+	h.Set("X-Input-Bytes", strconv.Itoa(len(a.source)))
+	h.Set("X-Output-Bytes", strconv.Itoa(len(a.result)))
+	h.Set("X-Warning-Count", strconv.Itoa(len(a.warnings)))
+}
+
+// adaptRequest adapts the config in r, which is either its body,
+// with ?source the config at a URL, with ?source_file a file on
+// disk, or, in a multipart body, the config field along with the
+// files it includes. The body (or the config field) is read into
+// buf, which the returned source may refer to.
+func adaptRequest(r *http.Request, buf *bytes.Buffer) (adaptation, error) {
+	// resolve the adapter before receiving the body, so a request
+	// that can't be adapted is rejected before it is uploaded (a
+	// client sending Expect: 100-continue never has to send it)
+	adapter, err := requestAdapter(r)
 	if err != nil {
-		return nil, nil, caddy.APIError{
+		return adaptation{}, caddy.APIError{
 			HTTPStatus: http.StatusBadRequest,
-			Err:        fmt.Errorf("invalid Content-Type: %v", err),
+			Err:        err,
 		}
 	}
+	noteAdapter(r.Context(), adapter.Name())
 
-	// if already JSON, no need to adapt
-	if strings.HasSuffix(ct, "/json") {
-		return body, nil, nil
+	options, err := adapterOptions(r)
+	if err != nil {
+		return adaptation{}, err
 	}
 
-	// adapter name should be suffix of MIME type
-	slashIdx := strings.Index(ct, "/")
-	if slashIdx < 0 {
-		return nil, nil, fmt.Errorf("malformed Content-Type")
+	checks, err := requestChecks(r)
+	if err != nil {
+		return adaptation{}, err
+	}
+
+	// like the adapter, whatever can be told from the headers is
+	// checked before the body, and any config it refers to, is read
+	multipart := isMultipart(r.Header.Get("Content-Type"))
+	if settings().RequireSignature {
+		if multipart {
+			return adaptation{}, caddy.APIError{
+				HTTPStatus: http.StatusBadRequest,
+				Err:        errorf("multipart bodies cannot be signed, and signatures are required"),
+			}
+		}
+		if r.Header.Get("X-Signature") == "" {
+			return adaptation{}, caddy.APIError{
+				HTTPStatus: http.StatusForbidden,
+				Err:        errorf("config is not signed, and signatures are required"),
+			}
+		}
+	}
+	if err := checkEncryption(r.Header.Get("X-Encryption")); err != nil {
+		return adaptation{}, err
 	}
 
-	adapterName := ct[slashIdx+1:]
-	cfgAdapter := caddyconfig.GetAdapter(adapterName)
-	if cfgAdapter == nil {
-		return nil, nil, fmt.Errorf("unrecognized config adapter '%s'", adapterName)
+	// a request that has given up isn't read any further
+	r.Body = newContextBody(r.Body, r.Context())
+
+	ctx, span := startSpan(r.Context(), "read config")
+	body, inc, options, err := readConfig(r.WithContext(ctx), buf, multipart, options)
+	span.SetAttributes(attribute.Int("adapt.source_size", len(body)))
+	endSpan(span, err)
+	if err != nil {
+		return adaptation{}, err
+	}
+	start := time.Now()
+	if inc != nil && inc.adapter != "" {
+		adapter, err = formAdapter(r, adapter, inc.adapter)
+		if err != nil {
+			return adaptation{}, caddy.APIError{
+				HTTPStatus: http.StatusBadRequest,
+				Err:        err,
+			}
+		}
+		noteAdapter(r.Context(), adapter.Name())
 	}
 
-	result, warnings, err := cfgAdapter.Adapt(body, nil)
+	// only a config in the body itself may be encrypted, and the
+	// parts of a multipart body are decoded as they are read
+	query := r.URL.Query()
+	if inc == nil && query.Get("source") == "" && query.Get("source_file") == "" {
+		checks.encryption = r.Header.Get("X-Encryption")
+	}
+	checks.signature = r.Header.Get("X-Signature")
+	checks.contentType = r.Header.Get("Content-Type")
+	checks.decoded = inc != nil
+	if inc != nil {
+		if checks.env {
+			for name, contents := range inc.files {
+				inc.files[name], err = expandEnv(contents)
+				if err != nil {
+					return adaptation{}, err
+				}
+			}
+		}
+		if checks.template {
+			checks.values = inc.files[valuesField]
+			delete(inc.files, valuesField)
+		}
+	}
+	body, err = checks.source(body)
 	if err != nil {
-		return nil, nil, fmt.Errorf("adapting config using %s adapter: %v", adapterName, err)
+		return adaptation{}, err
 	}
 
-	return result, warnings, nil
+	keyInput := body
+	if inc != nil {
+		keyInput = inc.keyInput(body)
+	}
+	key := adaptKey(adapter.Name(), options, keyInput)
+	useCache := !adapter.passthrough() && !settings().DisableCache
+	if !adapter.passthrough() && readsAnyFiles(body, inc) {
+		// what it imports may have changed since
+		key, useCache = "", false
+	}
+	cached, ok := cachedResult{}, false
+	if useCache {
+		cached, ok = resultCache.get(key)
+	}
+	if !ok {
+		// if the config is formatted other than Caddy's native
+		// JSON, we need to adapt it
+		adaptWith := func(options map[string]interface{}) ([]byte, []caddyconfig.Warning, error) {
+			return adaptProfiled(r.Context(), r.URL.Path, adapter, body, options)
+		}
+		if inc != nil {
+			cached.result, cached.warnings, err = inc.adapt(adaptWith, body, options)
+		} else {
+			cached.result, cached.warnings, err = adaptWith(options)
+		}
+		if err != nil {
+			// a limit on adapting has its own status
+			if _, ok := err.(caddy.APIError); !ok {
+				err = caddy.APIError{
+					HTTPStatus: http.StatusBadRequest,
+					Err:        err,
+				}
+			}
+			return adaptation{}, err
+		}
+
+		// JSON isn't adapted, so there is nothing to save by caching
+		// it, and its result is body, which is only borrowed
+		if useCache {
+			resultCache.put(key, cached)
+		}
+	}
+	result, err := transformConfig(r.Context(), cached.result)
+	if err != nil {
+		return adaptation{}, err
+	}
+	if len(settings().transformers) > 0 && key != "" {
+		// what the transformers do can change with the config
+		key = adaptKey(key, nil, result)
+	}
+	noteAdaptation(r.Context(), body, result, len(cached.warnings))
+
+	warnings, err := checks.warnings(result, cached.warnings)
+	if err != nil {
+		return adaptation{}, err
+	}
+
+	autosave(r, result)
+
+	return adaptation{
+		adapter:  adapter.Name(),
+		source:   body,
+		key:      key,
+		result:   result,
+		warnings: warnings,
+		duration: time.Since(start),
+	}, nil
 }
 
+// readConfig reads the config in r, as described by adaptRequest,
+// and returns it along with the files it includes, if any, and the
+// options, which may have gained the config's file name.
+func readConfig(r *http.Request, buf *bytes.Buffer, multipart bool, options map[string]interface{}) ([]byte, *includes, map[string]interface{}, error) {
+	var body []byte
+	var inc *includes
+	var err error
+
+	// the config may be fetched or read from disk instead of
+	// coming in the request body
+	query := r.URL.Query()
+	if query.Get("source") != "" && query.Get("source_file") != "" {
+		return nil, nil, nil, caddy.APIError{
+			HTTPStatus: http.StatusBadRequest,
+			Err:        errorf("source and source_file are mutually exclusive"),
+		}
+	}
+	if source := query.Get("source"); source != "" {
+		body, err = fetchSource(r.Context(), source)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		if options == nil {
+			options = make(map[string]interface{})
+		}
+		options["filename"] = source
+	} else if sourceFile := query.Get("source_file"); sourceFile != "" {
+		path, fileBody, err := readSourceFile(settings().SourceRoot, sourceFile)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		if options == nil {
+			options = make(map[string]interface{})
+		}
+		options["filename"] = path
+		body = fileBody
+	} else if multipart {
+		if r.Header.Get("X-Encryption") != "" {
+			return nil, nil, nil, caddy.APIError{
+				HTTPStatus: http.StatusBadRequest,
+				Err:        errorf("multipart bodies cannot be encrypted"),
+			}
+		}
+		inc, err = readMultipart(r, buf)
+		if r.Context().Err() != nil {
+			return nil, nil, nil, contextError(r.Context())
+		}
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		body = buf.Bytes()
+	} else {
+		// the body is consumed as it arrives, which for chunked
+		// uploads means chunk by chunk, into a buffer big enough
+		// for all of it if its size is known
+		if r.ContentLength > 0 {
+			size := r.ContentLength
+			if size > maxPreallocSize {
+				size = maxPreallocSize
+			}
+			buf.Grow(int(size))
+		}
+		_, err := buf.ReadFrom(r.Body)
+		if r.Context().Err() != nil {
+			return nil, nil, nil, contextError(r.Context())
+		}
+		if err != nil {
+			return nil, nil, nil, caddy.APIError{
+				HTTPStatus: http.StatusBadRequest,
+				Err:        errorf("reading request body: %v", err),
+			}
+		}
+		body = buf.Bytes()
+	}
+	return body, inc, options, nil
+}
+
+// warningsError is an error caused by warnings from the adapter,
+// which are included in the error response.
+type warningsError struct {
+	message
+	warnings []adaptWarning
+}
+
+// isChainContentType returns true if contentType is text/x-chain,
+// which says the adapters are given by an adapter chain.
+func isChainContentType(contentType string) bool {
+	ct, _, err := mime.ParseMediaType(contentType)
+	return err == nil && ct == "text/x-chain"
+}
+
+// adapterOptions returns the options to pass to the adapter, which
+// are given as a JSON object in the X-Adapt-Options header of r.
+// The X-Filename header is short for the "filename" option, which
+// adapters such as the Caddyfile's use in messages and to resolve
+// relative imports.
+func adapterOptions(r *http.Request) (map[string]interface{}, error) {
+	var options map[string]interface{}
+	if header := r.Header.Get("X-Adapt-Options"); header != "" {
+		if err := json.Unmarshal([]byte(header), &options); err != nil {
+			return nil, caddy.APIError{
+				HTTPStatus: http.StatusBadRequest,
+				Err:        errorf("X-Adapt-Options must be a JSON object: %v", err),
+			}
+		}
+	}
+
+	if filename := r.Header.Get("X-Filename"); filename != "" {
+		if existing, ok := options["filename"]; ok && existing != filename {
+			return nil, caddy.APIError{
+				HTTPStatus: http.StatusBadRequest,
+				Err:        errorf("X-Filename conflicts with the filename in X-Adapt-Options"),
+			}
+		}
+		if options == nil {
+			options = make(map[string]interface{})
+		}
+		options["filename"] = filename
+	}
+	return options, nil
+}
+
+// requestAdapter returns the config adapter to use for r. It is the one
+// the route implies, such as /adapt/caddyfile, or else the one named by
+// the ?adapter query parameter or else by the Content-Type, or, for a ?source or ?source_file
+// without either, inferred from the file's name; failing all that,
+// it is the default adapter. If both ?adapter and Content-Type are
+// given, the latter must either agree or not name an adapter at all,
+// as is the case with the form encoding curl sends by default.
+func requestAdapter(r *http.Request) (Adapter, error) {
+	contentType := r.Header.Get("Content-Type")
+	chain := r.Header.Get("X-Adapter-Chain")
+	if chain == "" {
+		chain = r.URL.Query().Get("chain")
+	}
+	if implied := impliedAdapter(r.Context()); implied != "" {
+		if chain != "" {
+			return Adapter{}, errorf("%s and an adapter chain are mutually exclusive", r.URL.Path)
+		}
+		if name := r.URL.Query().Get("adapter"); name != "" && name != implied {
+			return Adapter{}, errorf("adapter %s conflicts with %s", name, r.URL.Path)
+		}
+		return AdapterByName(implied)
+	}
+	if chain != "" {
+		if r.URL.Query().Get("adapter") != "" {
+			return Adapter{}, errorf("adapter and an adapter chain are mutually exclusive")
+		}
+		adapter, err := AdapterChain(strings.Split(chain, ",")...)
+		if err != nil {
+			return Adapter{}, err
+		}
+		if contentType != "" && !isChainContentType(contentType) {
+			if ctAdapter, err := AdapterByContentType(contentType); err == nil && ctAdapter.Name() != adapter.Name() {
+				return Adapter{}, errorf("adapter chain %s conflicts with Content-Type %s", adapter.Name(), contentType)
+			}
+		}
+		return adapter, nil
+	}
+	if isChainContentType(contentType) {
+		return Adapter{}, errorf("Content-Type %s needs an X-Adapter-Chain header or ?chain", contentType)
+	}
+
+	if name := r.URL.Query().Get("adapter"); name != "" {
+		adapter, err := AdapterByName(name)
+		if err != nil {
+			return Adapter{}, err
+		}
+		if contentType != "" {
+			ctAdapter, err := AdapterByContentType(contentType)
+			if err == nil && ctAdapter.Name() != adapter.Name() {
+				return Adapter{}, errorf("adapter %s conflicts with Content-Type %s", adapter.Name(), contentType)
+			}
+		}
+		return adapter, nil
+	}
+
+	if isMultipart(contentType) {
+		// the config is in a part, and importing other files
+		// is what the Caddyfile adapter is uploaded with them for
+		if name := settings().DefaultAdapter; name != "" {
+			return AdapterByName(name)
+		}
+		return AdapterByName("caddyfile")
+	}
+
+	sourceFile := r.URL.Query().Get("source_file")
+	if source := r.URL.Query().Get("source"); source != "" {
+		if untypedContentType(contentType) {
+			contentType = contentTypeForFile(sourceURLName(source))
+			if contentType == "" {
+				return Adapter{}, errorf("cannot tell which adapter to use for %s; set Content-Type or ?adapter", source)
+			}
+		}
+	} else if sourceFile != "" && untypedContentType(contentType) {
+		contentType = contentTypeForFile(sourceFile)
+		if contentType == "" {
+			return Adapter{}, errorf("cannot tell which adapter to use for %s; set Content-Type or ?adapter", sourceFile)
+		}
+	}
+	if untypedContentType(contentType) {
+		if name := settings().DefaultAdapter; name != "" {
+			return AdapterByName(name)
+		}
+		return Adapter{}, nil
+	}
+	return AdapterByContentType(contentType)
+}
+
+// untypedContentType returns true if contentType doesn't say what
+// the body is, which is when it is empty or text/plain, as scripts
+// tend to send.
+func untypedContentType(contentType string) bool {
+	if contentType == "" {
+		return true
+	}
+	ct, _, err := mime.ParseMediaType(contentType)
+	return err == nil && ct == "text/plain"
+}
+
+// maxPooledBufferSize is the capacity of the largest buffer that
+// is kept for reuse, so that one large config doesn't keep that
+// much memory around for good, and maxPreallocSize the most that is
+// allocated for a body up front, as its Content-Length says.
+const (
+	maxPooledBufferSize = 1 << 20
+	maxPreallocSize     = 16 << 20
+)
+
 var bufPool = sync.Pool{
 	New: func() interface{} {
 		return new(bytes.Buffer)
 	},
 }
+
+// getBuffer returns an empty buffer from the pool.
+func getBuffer() *bytes.Buffer {
+	buf := bufPool.Get().(*bytes.Buffer)
+	buf.Reset()
+	return buf
+}
+
+// putBuffer returns buf to the pool, unless it has grown too
+// large to keep.
+func putBuffer(buf *bytes.Buffer) {
+	if buf.Cap() <= maxPooledBufferSize {
+		bufPool.Put(buf)
+	}
+}
@@ -0,0 +1,239 @@
+package adapt
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig"
+	"github.com/caddyserver/certmagic"
+)
+
+// echoAdapter adapts a config to one that holds it, with a warning.
+type echoAdapter struct{}
+
+func (echoAdapter) Adapt(body []byte, _ map[string]interface{}) ([]byte, []caddyconfig.Warning, error) {
+	result, err := json.Marshal(map[string]interface{}{
+		"apps": map[string]interface{}{"echo": map[string]string{"body": string(body)}},
+	})
+	return result, []caddyconfig.Warning{{File: "Caddyfile", Line: 1, Message: "echoed"}}, err
+}
+
+// importAdapter adapts "import <file>" to a config that holds
+// what the file has in it.
+type importAdapter struct{}
+
+func (importAdapter) Adapt(body []byte, _ map[string]interface{}) ([]byte, []caddyconfig.Warning, error) {
+	name := strings.TrimSpace(strings.TrimPrefix(string(body), "import"))
+	contents, err := ioutil.ReadFile(name)
+	if err != nil {
+		return nil, nil, err
+	}
+	result, err := json.Marshal(map[string]interface{}{
+		"apps": map[string]interface{}{"import": map[string]string{"body": string(contents)}},
+	})
+	return result, nil, err
+}
+
+func init() {
+	caddyconfig.RegisterAdapter("test-echo", echoAdapter{})
+	caddyconfig.RegisterAdapter("test-import", importAdapter{})
+}
+
+// startApp loads a config with app in it, making its settings the
+// ones in effect until the test ends. It returns the directory of
+// the config's storage.
+func startApp(t *testing.T, app *App) string {
+	t.Helper()
+	root, err := ioutil.TempDir("", "adapt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(root) })
+	// which the config's storage is, as it has none of its own
+	defaultStorage := caddy.DefaultStorage
+	caddy.DefaultStorage = &certmagic.FileStorage{Path: root}
+	t.Cleanup(func() { caddy.DefaultStorage = defaultStorage })
+	cfg, err := json.Marshal(map[string]interface{}{
+		"admin": map[string]interface{}{
+			"disabled": true,
+			"config":   map[string]interface{}{"persist": false},
+		},
+		"apps": map[string]interface{}{"adapt": app},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := caddy.Load(cfg, true); err != nil {
+		t.Fatalf("loading config: %v", err)
+	}
+	t.Cleanup(func() { caddy.Stop() })
+	return root
+}
+
+// runningTestConfig is the config that serve reports as running.
+var runningTestConfig = []byte("null")
+
+// serve serves r with the /adapt routes, writing their errors the
+// way the admin server does, and GET /config/ with runningTestConfig.
+func serve(r *http.Request) *httptest.ResponseRecorder {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/config/", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(runningTestConfig)
+	})
+	for _, route := range (adminAdapt{}).Routes() {
+		handler := route.Handler
+		mux.HandleFunc(route.Pattern, func(w http.ResponseWriter, r *http.Request) {
+			err := handler.ServeHTTP(w, r)
+			if err == nil {
+				return
+			}
+			apiErr, ok := err.(caddy.APIError)
+			if !ok {
+				apiErr = caddy.APIError{HTTPStatus: http.StatusInternalServerError, Err: err}
+			}
+			apiErr.Message = apiErr.Err.Error()
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(apiErr.HTTPStatus)
+			json.NewEncoder(w).Encode(apiErr)
+		})
+	}
+	w := httptest.NewRecorder()
+	ctx := context.WithValue(r.Context(), http.ServerContextKey, &http.Server{Handler: mux})
+	mux.ServeHTTP(w, r.WithContext(ctx))
+	return w
+}
+
+// post posts body to target with the given Content-Type and headers,
+// given as pairs of names and values.
+func post(target, contentType, body string, header ...string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodPost, target, strings.NewReader(body))
+	if contentType != "" {
+		r.Header.Set("Content-Type", contentType)
+	}
+	for i := 0; i+1 < len(header); i += 2 {
+		r.Header.Set(header[i], header[i+1])
+	}
+	return serve(r)
+}
+
+func expectStatus(t *testing.T, w *httptest.ResponseRecorder, status int) {
+	t.Helper()
+	if w.Code != status {
+		t.Fatalf("expected status %d, got %d: %s", status, w.Code, w.Body)
+	}
+}
+
+func TestAdaptDoesNotCacheImports(t *testing.T) {
+	startApp(t, &App{})
+	dir, err := ioutil.TempDir("", "adapt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "site")
+	for _, contents := range []string{"one", "two"} {
+		if err := ioutil.WriteFile(file, []byte(contents), 0600); err != nil {
+			t.Fatal(err)
+		}
+		w := post("/adapt", "text/test-import", "import "+file)
+		expectStatus(t, w, http.StatusOK)
+		if !strings.Contains(w.Body.String(), contents) {
+			t.Fatalf("expected config with %q, got %s", contents, w.Body)
+		}
+		if tag := w.Header().Get("ETag"); tag != "" {
+			t.Fatalf("expected no ETag for a config that imports files, got %s", tag)
+		}
+	}
+}
+
+func TestAdaptETagChangesWithSettings(t *testing.T) {
+	startApp(t, &App{})
+	w := post("/adapt", "text/test-echo", "hi")
+	expectStatus(t, w, http.StatusOK)
+	tag := w.Header().Get("ETag")
+	if tag == "" {
+		t.Fatal("expected an ETag")
+	}
+	w = post("/adapt", "text/test-echo", "hi", "If-None-Match", tag)
+	expectStatus(t, w, http.StatusNotModified)
+
+	// the echoed warning is suppressed now, which changes the response
+	startApp(t, &App{WarningRules: &WarningRules{Suppress: []string{"ADAPTER_WARNING"}}})
+	w = post("/adapt", "text/test-echo", "hi", "If-None-Match", tag)
+	expectStatus(t, w, http.StatusOK)
+	if w.Header().Get("ETag") == tag {
+		t.Fatalf("expected the ETag to change with the settings, got %s again", tag)
+	}
+}
+
+func TestAdaptIncludeSource(t *testing.T) {
+	startApp(t, &App{})
+	w := post("/adapt?adapter=test-echo&include_source=true", "", "the source")
+	expectStatus(t, w, http.StatusOK)
+	var envelope struct {
+		Source     *string `json:"source"`
+		Provenance struct {
+			SourceSHA256 string `json:"source_sha256"`
+		} `json:"provenance"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &envelope); err != nil {
+		t.Fatal(err)
+	}
+	if envelope.Source == nil || *envelope.Source != "the source" {
+		t.Fatalf("expected the source in the envelope, got %s", w.Body)
+	}
+	if envelope.Provenance.SourceSHA256 != sha256Hex([]byte("the source")) {
+		t.Fatalf("expected the provenance to have the hash of the source, got %s", w.Body)
+	}
+
+	w = post("/adapt?adapter=test-echo&warnings=true", "", "the source")
+	expectStatus(t, w, http.StatusOK)
+	if strings.Contains(w.Body.String(), `"source":`) {
+		t.Fatalf("expected no source without include_source, got %s", w.Body)
+	}
+
+	w = post("/adapt?adapter=test-echo&include_source=true&redact=support-bundle", "", "the source")
+	expectStatus(t, w, http.StatusBadRequest)
+}
+
+func TestAdaptNDJSON(t *testing.T) {
+	startApp(t, &App{})
+	w := post("/adapt?adapter=test-echo", "", "lines", "Accept", "application/x-ndjson")
+	expectStatus(t, w, http.StatusOK)
+	if ct := w.Header().Get("Content-Type"); ct != ndjsonContentType {
+		t.Fatalf("expected Content-Type %s, got %s", ndjsonContentType, ct)
+	}
+	lines := strings.Split(strings.TrimSuffix(w.Body.String(), "\n"), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("expected a line for the warning and one for the result, got %q", lines)
+	}
+	var warning struct {
+		Warning struct {
+			Message string `json:"message"`
+		} `json:"warning"`
+	}
+	if err := json.Unmarshal([]byte(lines[0]), &warning); err != nil || warning.Warning.Message != "echoed" {
+		t.Fatalf("expected the warning first, got %s (%v)", lines[0], err)
+	}
+	var result struct {
+		Result     json.RawMessage `json:"result"`
+		Provenance struct {
+			Adapter string `json:"adapter"`
+		} `json:"provenance"`
+	}
+	if err := json.Unmarshal([]byte(lines[1]), &result); err != nil {
+		t.Fatal(err)
+	}
+	if string(result.Result) != `{"apps":{"echo":{"body":"lines"}}}` || result.Provenance.Adapter != "test-echo" {
+		t.Fatalf("expected the result and provenance last, got %s", lines[1])
+	}
+}
@@ -0,0 +1,173 @@
+package adapt
+
+import (
+	"fmt"
+	"mime"
+	"net/http"
+	"strings"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig"
+)
+
+// Adapter adapts configs to Caddy JSON the way the /adapt endpoint
+// does, for plugins that want to do so without an HTTP round trip.
+// The zero value is the "json" adapter, which passes Caddy JSON
+// through as it is.
+type Adapter struct {
+	name       string
+	cfgAdapter caddyconfig.Adapter
+}
+
+// AdapterByName returns the Adapter that uses the config adapter
+// registered under name, or passes Caddy JSON through for "json".
+// JSON with comments is "jsonc", and Caddy config in CBOR,
+// MessagePack, YAML or TOML is "cbor", "msgpack", "yaml" or "toml",
+// unless another adapter has that name.
+func AdapterByName(name string) (Adapter, error) {
+	if name == "json" {
+		return Adapter{}, nil
+	}
+	cfgAdapter := caddyconfig.GetAdapter(name)
+	if cfgAdapter == nil && name == "jsonc" {
+		cfgAdapter = jsoncAdapter{}
+	}
+	if enc, ok := configEncodings[name]; ok && cfgAdapter == nil {
+		cfgAdapter = decodingAdapter{decode: enc.decode}
+	}
+	if cfgAdapter == nil {
+		return Adapter{}, errorf("unrecognized config adapter '%s'", name)
+	}
+	return Adapter{name: name, cfgAdapter: cfgAdapter}, nil
+}
+
+// AdapterByContentType returns the Adapter whose name is the subtype
+// of contentType, as in text/caddyfile. If contentType is empty or
+// ends with "/json", the config is already Caddy JSON.
+func AdapterByContentType(contentType string) (Adapter, error) {
+	// assume JSON as the default
+	if contentType == "" {
+		return Adapter{}, nil
+	}
+
+	ct, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return Adapter{}, caddy.APIError{
+			HTTPStatus: http.StatusBadRequest,
+			Err:        errorf("invalid Content-Type: %v", err),
+		}
+	}
+
+	// if already JSON, no need to adapt
+	if strings.HasSuffix(ct, "/json") {
+		return Adapter{}, nil
+	}
+	if name, ok := encodingMediaTypes[ct]; ok {
+		return AdapterByName(name)
+	}
+
+	// adapter name should be suffix of MIME type
+	slashIdx := strings.Index(ct, "/")
+	if slashIdx < 0 {
+		return Adapter{}, errorf("malformed Content-Type")
+	}
+
+	return AdapterByName(ct[slashIdx+1:])
+}
+
+// ByContentType adapts body with the Adapter named by contentType,
+// passing it options.
+func ByContentType(contentType string, body []byte, options map[string]interface{}) ([]byte, []caddyconfig.Warning, error) {
+	adapter, err := AdapterByContentType(contentType)
+	if err != nil {
+		return nil, nil, err
+	}
+	return adapter.Adapt(body, options)
+}
+
+// AdapterChain returns an Adapter that feeds the output of the config
+// adapter with each name into the next, such as one that emits a
+// Caddyfile into the caddyfile adapter. The options are passed to
+// all of them, and their warnings are combined.
+func AdapterChain(names ...string) (Adapter, error) {
+	if len(names) == 0 {
+		return Adapter{}, errorf("adapter chain is empty")
+	}
+	chain := make(chainAdapter, len(names))
+	for i, name := range names {
+		adapter, err := AdapterByName(strings.TrimSpace(name))
+		if err != nil {
+			return Adapter{}, err
+		}
+		chain[i] = adapter
+	}
+	if len(chain) == 1 {
+		return chain[0], nil
+	}
+	return Adapter{name: strings.Join(chain.names(), ","), cfgAdapter: chain}, nil
+}
+
+// chainAdapter is a config adapter that runs adapters in turn.
+type chainAdapter []Adapter
+
+func (c chainAdapter) names() []string {
+	names := make([]string, len(c))
+	for i, adapter := range c {
+		names[i] = adapter.Name()
+	}
+	return names
+}
+
+// Adapt adapts body with each adapter in c, in order.
+func (c chainAdapter) Adapt(body []byte, options map[string]interface{}) ([]byte, []caddyconfig.Warning, error) {
+	var allWarnings []caddyconfig.Warning
+	for _, adapter := range c {
+		if adapter.passthrough() {
+			continue
+		}
+		result, warnings, err := adapter.cfgAdapter.Adapt(body, options)
+		if err != nil {
+			return nil, nil, fmt.Errorf("%s: %v", adapter.name, err)
+		}
+		body = result
+		allWarnings = append(allWarnings, warnings...)
+	}
+	return body, allWarnings, nil
+}
+
+// Name returns the name of the config adapter, which for a
+// chain is the names of its adapters, separated by commas.
+func (a Adapter) Name() string {
+	if a.passthrough() {
+		return "json"
+	}
+	return a.name
+}
+
+// passthrough returns true if a doesn't adapt configs, since
+// they are Caddy JSON already.
+func (a Adapter) passthrough() bool {
+	return a.cfgAdapter == nil
+}
+
+// Adapt adapts body to Caddy JSON, passing options to the config
+// adapter. Caddy JSON is returned as it is.
+func (a Adapter) Adapt(body []byte, options map[string]interface{}) ([]byte, []caddyconfig.Warning, error) {
+	if a.passthrough() {
+		return body, nil, nil
+	}
+
+	result, warnings, err := a.cfgAdapter.Adapt(body, options)
+	if err != nil {
+		msg := message{
+			format: "adapting config using %s adapter: %v",
+			args:   []interface{}{a.name, err},
+		}
+		if pos := parseSourcePosition(err.Error()); pos != nil {
+			return nil, nil, sourceError{message: msg, sourcePosition: pos}
+		}
+		return nil, nil, msg
+	}
+
+	return result, warnings, nil
+}
@@ -0,0 +1,110 @@
+package adapt
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+)
+
+// adapterInfo describes a config adapter, in the response
+// body of /adapt/adapters.
+type adapterInfo struct {
+	Name        string `json:"name"`
+	ContentType string `json:"content_type"`
+	Formatting  bool   `json:"formatting"`
+}
+
+// formatters maps the names of adapters whose input format
+// can be formatted to the functions that format it.
+var formatters = map[string]func([]byte) []byte{
+	"caddyfile": caddyfile.Format,
+}
+
+// handleAdapters lists the config adapters compiled into this
+// Caddy binary, which are the ones that can be used with /adapt.
+func (adminAdapt) handleAdapters(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != http.MethodGet {
+		return caddy.APIError{
+			HTTPStatus: http.StatusMethodNotAllowed,
+			Err:        errorf("method not allowed"),
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(w).Encode(registeredAdapters())
+}
+
+// registeredAdapters returns the config adapters that can be
+// used with /adapt, sorted by name.
+func registeredAdapters() []adapterInfo {
+	// Caddy JSON needs no adapter, but is accepted all the same,
+	// as is JSON with comments, or Caddy config in other encodings
+	adapters := []adapterInfo{{Name: "json", ContentType: "application/json"}}
+	if caddyconfig.GetAdapter("jsonc") == nil {
+		adapters = append(adapters, adapterInfo{Name: "jsonc", ContentType: "application/jsonc"})
+	}
+	for name, enc := range configEncodings {
+		if caddyconfig.GetAdapter(name) == nil {
+			adapters = append(adapters, adapterInfo{Name: name, ContentType: enc.contentType})
+		}
+	}
+	for _, info := range caddy.GetModules("caddy.adapters") {
+		name := info.ID.Name()
+		_, formatting := formatters[name]
+		adapters = append(adapters, adapterInfo{
+			Name:        name,
+			ContentType: "text/" + name,
+			Formatting:  formatting,
+		})
+	}
+	sort.Slice(adapters, func(i, j int) bool { return adapters[i].Name < adapters[j].Name })
+
+	return adapters
+}
+
+// shortcutRoutes returns a route, /adapt/<name>, for each registered
+// adapter, which is /adapt with that adapter whatever the Content-Type,
+// except where a route with that pattern is already in routes.
+func (al adminAdapt) shortcutRoutes(routes []caddy.AdminRoute) []caddy.AdminRoute {
+	taken := make(map[string]bool, len(routes))
+	for _, route := range routes {
+		taken[strings.TrimSuffix(route.Pattern, "/")] = true
+	}
+	var shortcuts []caddy.AdminRoute
+	for _, info := range registeredAdapters() {
+		pattern := "/adapt/" + info.Name
+		if taken[pattern] {
+			continue
+		}
+		shortcuts = append(shortcuts, caddy.AdminRoute{
+			Pattern: pattern,
+			Handler: withRequestID(localized(authenticated(rateLimited(decompressBody(limitBody(withAdapter(info.Name, al.handleAdapt))))))),
+		})
+	}
+	return shortcuts
+}
+
+// withAdapter wraps next so that the requests it handles
+// are adapted by the named adapter.
+func withAdapter(name string, next caddy.AdminHandlerFunc) caddy.AdminHandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		ctx := context.WithValue(r.Context(), adapterCtxKey, name)
+		return next(w, r.WithContext(ctx))
+	}
+}
+
+// impliedAdapter returns the name of the adapter that the route
+// handling the request with ctx implies, if any.
+func impliedAdapter(ctx context.Context) string {
+	name, _ := ctx.Value(adapterCtxKey).(string)
+	return name
+}
+
+// adapterCtxKey is the context key for the adapter of a shortcut route.
+const adapterCtxKey caddy.CtxKey = "adapt_adapter"
@@ -0,0 +1,243 @@
+// Package adapttest serves the /adapt admin API in memory, for
+// testing plugins and clients against it without running Caddy.
+//
+// Config adapters are global in Caddy, so fake ones are registered
+// the usual way, typically from an init function in a test file:
+//
+//	func init() {
+//		caddyconfig.RegisterAdapter("fake", adapttest.FakeAdapter{
+//			Result: `{"apps":{}}`,
+//		})
+//	}
+package adapttest
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"testing"
+
+	_ "github.com/adamburgess/caddy-admin-adapt" // registers admin.api.adapt
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig"
+)
+
+// Harness is an in-memory admin API with the /adapt routes mounted.
+// It also serves GET /config/, with a running config that can be set
+// with SetRunningConfig, for the routes that compare against it.
+type Harness struct {
+	mux *http.ServeMux
+	srv *http.Server
+
+	mu      sync.Mutex
+	running []byte
+}
+
+// New returns a new Harness.
+func New() *Harness {
+	mod, err := caddy.GetModule("admin.api.adapt")
+	if err != nil {
+		panic(err)
+	}
+	router := mod.New().(caddy.AdminRouter)
+
+	h := &Harness{mux: http.NewServeMux(), running: []byte("null\n")}
+	for _, route := range router.Routes() {
+		h.mux.Handle(route.Pattern, adminHandler{route.Handler})
+	}
+	h.mux.HandleFunc("/config/", h.handleConfig)
+	h.srv = &http.Server{Handler: h.mux}
+	return h
+}
+
+// SetRunningConfig sets the config that the harness reports as
+// running. It is null until set.
+func (h *Harness) SetRunningConfig(cfg []byte) {
+	h.mu.Lock()
+	h.running = append(cfg[:0:0], cfg...)
+	h.mu.Unlock()
+}
+
+func (h *Harness) handleConfig(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(h.running)
+}
+
+// ServeHTTP serves r as the admin server would.
+func (h *Harness) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	ctx := context.WithValue(r.Context(), http.ServerContextKey, h.srv)
+	h.mux.ServeHTTP(w, r.WithContext(ctx))
+}
+
+// Response is a response recorded by the harness.
+type Response struct {
+	StatusCode int
+	Header     http.Header
+	Body       []byte
+}
+
+// Do serves r and returns the response.
+func (h *Harness) Do(r *http.Request) *Response {
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, r)
+	resp := rec.Result()
+	body, _ := ioutil.ReadAll(resp.Body)
+	return &Response{
+		StatusCode: resp.StatusCode,
+		Header:     resp.Header,
+		Body:       body,
+	}
+}
+
+// Post posts body to path with the given Content-Type.
+func (h *Harness) Post(path, contentType string, body []byte) *Response {
+	r := httptest.NewRequest(http.MethodPost, path, bytes.NewReader(body))
+	if contentType != "" {
+		r.Header.Set("Content-Type", contentType)
+	}
+	return h.Do(r)
+}
+
+// Adaptation is the outcome of adapting a config.
+type Adaptation struct {
+	Adapter  string                `json:"adapter"`
+	Config   json.RawMessage       `json:"config"`
+	Warnings []caddyconfig.Warning `json:"warnings"`
+}
+
+// Adapt adapts body with the named adapter through the "adapt"
+// JSON-RPC method, which, unlike POST /adapt, also returns the
+// adapter's warnings. It fails t if the call fails.
+func (h *Harness) Adapt(t testing.TB, adapter string, body []byte) Adaptation {
+	t.Helper()
+	req, err := json.Marshal(map[string]interface{}{
+		"jsonrpc": "2.0",
+		"id":      1,
+		"method":  "adapt",
+		"params": map[string]interface{}{
+			"body":    string(body),
+			"adapter": adapter,
+		},
+	})
+	if err != nil {
+		t.Fatalf("encoding request: %v", err)
+	}
+	resp := h.Post("/adapt/rpc", "application/json", req)
+	AssertStatus(t, resp, http.StatusOK)
+
+	var rpcResp struct {
+		Result *Adaptation `json:"result"`
+		Error  *struct {
+			Code    int    `json:"code"`
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	if err := json.Unmarshal(resp.Body, &rpcResp); err != nil {
+		t.Fatalf("decoding response: %v", err)
+	}
+	if rpcResp.Error != nil {
+		t.Fatalf("adapting with %s: %s (code %d)", adapter, rpcResp.Error.Message, rpcResp.Error.Code)
+	}
+	if rpcResp.Result == nil {
+		t.Fatalf("adapting with %s: response has no result", adapter)
+	}
+	return *rpcResp.Result
+}
+
+// adminHandler serves an admin route, writing its errors the way
+// the admin server does.
+type adminHandler struct {
+	h caddy.AdminHandler
+}
+
+func (ah adminHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	err := ah.h.ServeHTTP(w, r)
+	if err == nil {
+		return
+	}
+	apiErr, ok := err.(caddy.APIError)
+	if !ok {
+		apiErr = caddy.APIError{Err: err}
+	}
+	if apiErr.HTTPStatus == 0 {
+		apiErr.HTTPStatus = http.StatusInternalServerError
+	}
+	if apiErr.Message == "" && apiErr.Err != nil {
+		apiErr.Message = apiErr.Err.Error()
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(apiErr.HTTPStatus)
+	json.NewEncoder(w).Encode(apiErr)
+}
+
+// FakeAdapter is a config adapter that ignores its input and
+// returns the same result every time.
+type FakeAdapter struct {
+	Result   string
+	Warnings []caddyconfig.Warning
+	Err      error
+}
+
+// Adapt returns the fake result.
+func (fa FakeAdapter) Adapt(_ []byte, _ map[string]interface{}) ([]byte, []caddyconfig.Warning, error) {
+	if fa.Err != nil {
+		return nil, nil, fa.Err
+	}
+	return []byte(fa.Result), fa.Warnings, nil
+}
+
+// AdapterFunc is a config adapter implemented by a function.
+type AdapterFunc func(body []byte, options map[string]interface{}) ([]byte, []caddyconfig.Warning, error)
+
+// Adapt calls f.
+func (f AdapterFunc) Adapt(body []byte, options map[string]interface{}) ([]byte, []caddyconfig.Warning, error) {
+	return f(body, options)
+}
+
+// AssertStatus fails t if resp doesn't have the given status code.
+func AssertStatus(t testing.TB, resp *Response, status int) {
+	t.Helper()
+	if resp.StatusCode != status {
+		t.Fatalf("expected status %d, got %d: %s", status, resp.StatusCode, resp.Body)
+	}
+}
+
+// AssertConfig fails t if got and want aren't the same JSON
+// document, disregarding formatting and key order.
+func AssertConfig(t testing.TB, got []byte, want string) {
+	t.Helper()
+	var gotVal, wantVal interface{}
+	if err := json.Unmarshal(got, &gotVal); err != nil {
+		t.Fatalf("config is not valid JSON: %v: %s", err, got)
+	}
+	if err := json.Unmarshal([]byte(want), &wantVal); err != nil {
+		t.Fatalf("expected config is not valid JSON: %v", err)
+	}
+	if !reflect.DeepEqual(gotVal, wantVal) {
+		t.Fatalf("expected config %s, got %s", want, got)
+	}
+}
+
+// AssertWarnings fails t if the messages of got aren't
+// exactly want, in order.
+func AssertWarnings(t testing.TB, got []caddyconfig.Warning, want ...string) {
+	t.Helper()
+	msgs := make([]string, len(got))
+	for i, w := range got {
+		msgs[i] = w.Message
+	}
+	if len(msgs) != len(want) || (len(want) > 0 && !reflect.DeepEqual(msgs, want)) {
+		t.Fatalf("expected warnings %q, got %q", want, msgs)
+	}
+}
@@ -0,0 +1,77 @@
+package adapttest_test
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/adamburgess/caddy-admin-adapt/adapttest"
+	"github.com/caddyserver/caddy/v2/caddyconfig"
+)
+
+func init() {
+	caddyconfig.RegisterAdapter("fake", adapttest.FakeAdapter{
+		Result:   `{"apps":{"fake":{}}}`,
+		Warnings: []caddyconfig.Warning{{File: "Caddyfile", Line: 1, Message: "faked"}},
+	})
+	caddyconfig.RegisterAdapter("broken", adapttest.FakeAdapter{
+		Err: errors.New("broken on purpose"),
+	})
+	caddyconfig.RegisterAdapter("upper", adapttest.AdapterFunc(
+		func(body []byte, _ map[string]interface{}) ([]byte, []caddyconfig.Warning, error) {
+			return []byte(`{"body":"` + strings.ToUpper(string(body)) + `"}`), nil, nil
+		}))
+}
+
+func TestPost(t *testing.T) {
+	h := adapttest.New()
+	resp := h.Post("/adapt", "text/fake", []byte("anything"))
+	adapttest.AssertStatus(t, resp, http.StatusOK)
+	adapttest.AssertConfig(t, resp.Body, `{"apps": {"fake": {}}}`)
+
+	resp = h.Post("/adapt", "text/upper", []byte("hi"))
+	adapttest.AssertStatus(t, resp, http.StatusOK)
+	adapttest.AssertConfig(t, resp.Body, `{"body":"HI"}`)
+}
+
+func TestPostError(t *testing.T) {
+	h := adapttest.New()
+	resp := h.Post("/adapt", "text/broken", []byte("anything"))
+	adapttest.AssertStatus(t, resp, http.StatusBadRequest)
+	if !strings.Contains(string(resp.Body), "broken on purpose") {
+		t.Fatalf("expected the adapter's error, got %s", resp.Body)
+	}
+	if ct := resp.Header.Get("Content-Type"); ct != "application/json" {
+		t.Fatalf("expected the error as JSON, got %s", ct)
+	}
+}
+
+func TestAdapt(t *testing.T) {
+	h := adapttest.New()
+	a := h.Adapt(t, "fake", []byte("anything"))
+	if a.Adapter != "fake" {
+		t.Fatalf("expected adapter fake, got %s", a.Adapter)
+	}
+	adapttest.AssertConfig(t, a.Config, `{"apps":{"fake":{}}}`)
+	adapttest.AssertWarnings(t, a.Warnings, "faked")
+}
+
+func TestRunningConfig(t *testing.T) {
+	h := adapttest.New()
+	get := func() *adapttest.Response {
+		return h.Do(httptest.NewRequest(http.MethodGet, "/config/", nil))
+	}
+	resp := get()
+	adapttest.AssertStatus(t, resp, http.StatusOK)
+	adapttest.AssertConfig(t, resp.Body, `null`)
+
+	cfg := []byte(`{"apps":{"running":{}}}`)
+	h.SetRunningConfig(cfg)
+	copy(cfg, "xxxxxx")
+	adapttest.AssertConfig(t, get().Body, `{"apps":{"running":{}}}`)
+
+	resp = h.Post("/config/", "application/json", cfg)
+	adapttest.AssertStatus(t, resp, http.StatusMethodNotAllowed)
+}
@@ -0,0 +1,618 @@
+package adapt
+
+import (
+	"crypto/cipher"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"filippo.io/age"
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/certmagic"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+func init() {
+	caddy.RegisterModule(App{})
+}
+
+// App holds the settings of the /adapt admin endpoint. Caddy
+// instantiates admin API modules without any configuration, so
+// the endpoint is configured through this app instead, in the
+// "adapt" field of the top-level "apps" object. Its settings take
+// effect while the config containing it is running; without it,
+// the defaults apply.
+type App struct {
+	// The directory that `source_file` paths are resolved against.
+	// Files outside of it can not be adapted. If empty, adapting
+	// files from disk is disabled.
+	SourceRoot string `json:"source_root,omitempty"`
+
+	// The directory that certificate and key files must be in for
+	// /adapt/certificates to read them. If empty, checking the
+	// certificates in configs is disabled.
+	CertificateRoot string `json:"certificate_root,omitempty"`
+
+	// The hosts that configs may be fetched from with `?source=`,
+	// by http or https URL. If empty, fetching configs is disabled.
+	SourceHosts []string `json:"source_hosts,omitempty"`
+
+	// How long fetching a `?source` URL may take. Default: 10s
+	SourceTimeout caddy.Duration `json:"source_timeout,omitempty"`
+
+	// The largest config, in bytes, that is fetched from a
+	// `?source` URL. Default: 10 MiB
+	MaxSourceSize int64 `json:"max_source_size,omitempty"`
+
+	// Credentials to fetch `?source` URLs with, each for one of
+	// the source_hosts.
+	SourceCredentials []*SourceCredentials `json:"source_credentials,omitempty"`
+
+	// Bearer tokens, one of which every request to the /adapt
+	// endpoints must carry in its Authorization header. They may
+	// be placeholders, such as {env.ADAPT_TOKEN}. If empty, no
+	// token is required.
+	AuthTokens []string `json:"auth_tokens,omitempty"`
+
+	// Prefixes of the names of the environment variables that
+	// `?env=true` may expand in configs, such as "CADDY_". If
+	// empty, expanding environment variables is disabled.
+	EnvPrefixes []string `json:"env_prefixes,omitempty"`
+
+	// A PEM file containing the Ed25519 private key, in PKCS #8
+	// form, that /adapt/sign signs adapted configs with. If empty,
+	// signing is disabled.
+	SigningKeyFile string `json:"signing_key_file,omitempty"`
+
+	// Base64-encoded Ed25519 public keys whose signatures are
+	// accepted by /adapt/verify, in addition to the public key
+	// of the signing key.
+	TrustedKeys []string `json:"trusted_keys,omitempty"`
+
+	// Shared secrets for HMAC-SHA256 signatures, accepted by
+	// /adapt/verify and by require_signature along with Ed25519
+	// signatures by the trusted keys. They may be placeholders,
+	// such as {env.ADAPT_HMAC_SECRET}.
+	HMACSecrets []string `json:"hmac_secrets,omitempty"`
+
+	// Requires configs to be signed: requests that adapt a config
+	// must carry a valid X-Signature over it, by one of the trusted
+	// keys or HMAC secrets, or they are rejected with 403.
+	RequireSignature bool `json:"require_signature,omitempty"`
+
+	// A file containing a base64-encoded AES key (of 16, 24 or 32
+	// bytes) for decrypting request bodies sent with the header
+	// `X-Encryption: aes-gcm`. If empty, encrypted bodies are
+	// not accepted.
+	DecryptionKeyFile string `json:"decryption_key_file,omitempty"`
+
+	// A file of age identities, such as age-keygen writes, for
+	// decrypting request bodies sent with the header
+	// `X-Encryption: age`. If empty, they are not accepted.
+	AgeIdentityFile string `json:"age_identity_file,omitempty"`
+
+	// Named redaction profiles that can be applied to the adapted
+	// config with `?redact=<name>`. These add to, or override, the
+	// built-in "support-bundle" profile.
+	RedactionProfiles map[string]*RedactionProfile `json:"redaction_profiles,omitempty"`
+
+	// How /adapt formats the JSON it returns, unless the request
+	// asks for ?pretty or ?minify: "pretty" to indent it, "minify"
+	// to compact it, or empty to leave it as the adapter emitted it.
+	OutputFormat string `json:"output_format,omitempty"`
+
+	// The largest request body, in bytes, that is accepted. Larger
+	// ones are rejected with 413. If 0, there is no limit.
+	MaxBodySize int64 `json:"max_body_size,omitempty"`
+
+	// The adapter to use for requests that name none, by
+	// ?adapter or Content-Type, which includes those sent as
+	// text/plain. Default: json
+	DefaultAdapter string `json:"default_adapter,omitempty"`
+
+	// Disables the cache of recent adaptation results, which
+	// makes every request run the adapter.
+	DisableCache bool `json:"disable_cache,omitempty"`
+
+	// Treats warnings from the adapter as errors, failing the
+	// request with 422, unless it says otherwise with ?strict.
+	StrictWarnings bool `json:"strict_warnings,omitempty"`
+
+	// Deprecated: use warning_rules, whose suppress rules can be
+	// codes, such as "CADDYFILE_NOT_FORMATTED", too. These codes are
+	// added to them.
+	SuppressWarnings []string `json:"suppress_warnings,omitempty"`
+
+	// Rules that suppress adapter warnings, or make them errors,
+	// by their codes or messages.
+	WarningRules *WarningRules `json:"warning_rules,omitempty"`
+
+	// Cross-origin resource sharing, for browser-based clients.
+	// If unset, no CORS headers are sent.
+	CORS *CORS `json:"cors,omitempty"`
+
+	// The smallest response, in bytes, from /adapt that is compressed
+	// for clients that accept gzip or zstd. Default: 1024
+	CompressMinSize int64 `json:"compress_min_size,omitempty"`
+
+	// The level at which each request is logged, along with its
+	// adapter, size, duration and outcome: debug, info, warn or
+	// error. Requests that fail are always logged as errors.
+	// Default: info
+	RequestLogLevel string `json:"request_log_level,omitempty"`
+
+	// How long the result of a job started with `?async=true` is
+	// kept after it finishes. Default: 10m
+	JobRetention caddy.Duration `json:"job_retention,omitempty"`
+
+	// The most jobs started with `?async=true` that may be pending at
+	// a time; more are rejected with 503. Default: 100
+	MaxPendingJobs int `json:"max_pending_jobs,omitempty"`
+
+	// How long a job started with `?async=true` may take, including
+	// waiting for one of the max_concurrent_adaptations, after which
+	// it fails with 504. Default: 5m
+	JobTimeout caddy.Duration `json:"job_timeout,omitempty"`
+
+	// The most configs that may be adapted at a time. Requests
+	// beyond it are rejected with 429, while jobs started with
+	// `?async=true` wait their turn. If 0, there is no limit.
+	MaxConcurrentAdaptations int `json:"max_concurrent_adaptations,omitempty"`
+
+	// How long a request may take, after which it stops waiting for
+	// the adapter and fails with 504. Jobs started with `?async=true`
+	// are limited by job_timeout instead. If 0, there is no limit.
+	Timeout caddy.Duration `json:"timeout,omitempty"`
+
+	// A file that every request that submits a config (any but
+	// GET) is appended to, as a line of JSON with its time, client,
+	// adapter, the SHA-256 of the config and of the result, and its
+	// outcome. Recent entries can be read from /adapt/audit.
+	AuditLogFile string `json:"audit_log_file,omitempty"`
+
+	// Like audit_log_file, but a key in Caddy's storage instead
+	// of a file, for instances that share their storage.
+	AuditLogKey string `json:"audit_log_key,omitempty"`
+
+	// The size, in bytes, past which the audit log is rotated to
+	// <audit_log_file or audit_log_key>.<n>, numbered in order,
+	// and a new one started. Default: 1 MiB
+	AuditLogSegmentSize int64 `json:"audit_log_segment_size,omitempty"`
+
+	// A base64-encoded AES key (of 16, 24 or 32 bytes) that
+	// snapshots and audit log entries are encrypted with, using
+	// AES-GCM, before they are stored, since adapted configs often
+	// have credentials in them. It may be a placeholder, such as
+	// {env.ADAPT_STORAGE_KEY}, or one of the secret resolvers, such
+	// as {vault:transit/adapt}, for a key that is kept in a KMS.
+	// If empty, they are stored as they are.
+	StorageKey string `json:"storage_key,omitempty"`
+
+	// URLs that /adapt POSTs adapted configs to, when the
+	// request asks for it with `?notify=true`.
+	Webhooks []*Webhook `json:"webhooks,omitempty"`
+
+	// Resolvers of the secret placeholders in adapted configs that
+	// are applied with `?apply=true`, keyed by the name they are used
+	// with: a placeholder like {vault:secret/path} is resolved by the
+	// one named vault. This keeps secrets out of the configs that are
+	// posted, and out of every response.
+	SecretResolversRaw map[string]json.RawMessage `json:"secret_resolvers,omitempty" caddy:"namespace=admin.api.adapt.secrets inline_key=resolver"`
+
+	// Transformers that adapted configs are run through, in order,
+	// before they are returned or applied, such as to fill in the
+	// admin, logging or TLS settings every config should have.
+	TransformersRaw []json.RawMessage `json:"transformers,omitempty" caddy:"namespace=admin.api.adapt.transformers inline_key=transformer"`
+
+	// A file that every config that is successfully adapted, or
+	// loaded from a snapshot, is saved to, replacing the one before
+	// it, so the last good config can be recovered. Secrets in it are
+	// left as placeholders. If empty, configs aren't saved.
+	AutosavePath string `json:"autosave_path,omitempty"`
+
+	// How many of the configs replaced at autosave_path are kept
+	// beside it, named for when they were saved, as
+	// <autosave_path>.<timestamp>. If 0, none are kept.
+	AutosaveKeep int `json:"autosave_keep,omitempty"`
+
+	// Limits how often each client may make requests to the /adapt
+	// endpoints; those that go over are turned away with 429. If
+	// unset, clients aren't limited.
+	RateLimit *RateLimit `json:"rate_limit,omitempty"`
+
+	// Limits how much each client may use the /adapt endpoints a
+	// day; those that have used it up are turned away with 429 until
+	// the next. If unset, clients aren't limited.
+	Quotas *Quotas `json:"quotas,omitempty"`
+
+	// Limits the addresses that are connected to while adapting,
+	// for ?source URLs and by /adapt/upstreams. If unset, only
+	// public addresses are.
+	DialPolicy *DialPolicy `json:"dial_policy,omitempty"`
+
+	// The HTTP proxy that `?source` fetches and webhook deliveries
+	// go through. If unset, they connect directly; the HTTP_PROXY
+	// and HTTPS_PROXY environment variables are not used.
+	OutboundProxy *OutboundProxy `json:"outbound_proxy,omitempty"`
+
+	// Stops recording the Prometheus metrics of the /adapt endpoints,
+	// and drops those recorded so far, so they aren't served along
+	// with Caddy's admin metrics.
+	DisableMetrics bool `json:"disable_metrics,omitempty"`
+
+	// Exports OpenTelemetry traces of requests. If unset, spans go
+	// to the global tracer provider, which by default drops them.
+	Tracing *Tracing `json:"tracing,omitempty"`
+
+	adaptSlots        chan struct{}
+	tracerProvider    *sdktrace.TracerProvider
+	secretResolvers   map[string]SecretResolver
+	transformers      []Transformer
+	auditFile         *os.File
+	storage           certmagic.Storage
+	authTokens        [][sha256.Size]byte
+	logger            *zap.Logger
+	requestLogLevel   *zapcore.Level
+	signingKey        ed25519.PrivateKey
+	trustedKeys       []ed25519.PublicKey
+	hmacSecrets       [][]byte
+	aead              cipher.AEAD
+	ageIdentities     []age.Identity
+	storageAEAD       cipher.AEAD
+	sourceCredentials map[string]*SourceCredentials
+	generation        uint64 // tells the settings apart from earlier ones
+}
+
+// CaddyModule returns the Caddy module information.
+func (App) CaddyModule() caddy.ModuleInfo {
+	return caddy.ModuleInfo{
+		ID:  "adapt",
+		New: func() caddy.Module { return new(App) },
+	}
+}
+
+// Provision sets up the app.
+func (a *App) Provision(ctx caddy.Context) error {
+	// named for the admin module that logs with it
+	a.logger = ctx.Logger(adminAdapt{})
+	a.generation = atomic.AddUint64(&appGenerations, 1)
+
+	// while the config from it is first loaded, if Caddy
+	// was started from a file
+	recordStartupConfig()
+
+	if a.RequestLogLevel != "" {
+		level := new(zapcore.Level)
+		if err := level.UnmarshalText([]byte(a.RequestLogLevel)); err != nil {
+			return fmt.Errorf("request_log_level: %v", err)
+		}
+		a.requestLogLevel = level
+	}
+
+	if a.MaxConcurrentAdaptations > 0 {
+		a.adaptSlots = make(chan struct{}, a.MaxConcurrentAdaptations)
+	}
+
+	repl := caddy.NewReplacer()
+	for i, token := range a.AuthTokens {
+		token = repl.ReplaceAll(token, "")
+		if token == "" {
+			return fmt.Errorf("auth token %d is empty", i)
+		}
+		a.authTokens = append(a.authTokens, sha256.Sum256([]byte(token)))
+	}
+
+	if a.SourceRoot != "" {
+		root, err := filepath.Abs(a.SourceRoot)
+		if err != nil {
+			return fmt.Errorf("source_root: %v", err)
+		}
+		a.SourceRoot = root
+	}
+
+	if a.CertificateRoot != "" {
+		root, err := filepath.Abs(a.CertificateRoot)
+		if err != nil {
+			return fmt.Errorf("certificate_root: %v", err)
+		}
+		a.CertificateRoot = root
+	}
+
+	if a.SigningKeyFile != "" {
+		key, err := loadSigningKey(a.SigningKeyFile)
+		if err != nil {
+			return fmt.Errorf("loading signing key: %v", err)
+		}
+		a.signingKey = key
+		a.trustedKeys = append(a.trustedKeys, key.Public().(ed25519.PublicKey))
+	}
+	for i, encoded := range a.TrustedKeys {
+		key, err := parsePublicKey(encoded)
+		if err != nil {
+			return fmt.Errorf("trusted key %d: %v", i, err)
+		}
+		a.trustedKeys = append(a.trustedKeys, key)
+	}
+
+	for i, secret := range a.HMACSecrets {
+		secret = repl.ReplaceAll(secret, "")
+		if secret == "" {
+			return fmt.Errorf("HMAC secret %d is empty", i)
+		}
+		a.hmacSecrets = append(a.hmacSecrets, []byte(secret))
+	}
+	if a.RequireSignature && len(a.trustedKeys) == 0 && len(a.hmacSecrets) == 0 {
+		return fmt.Errorf("require_signature needs trusted keys or HMAC secrets to verify signatures with")
+	}
+
+	if a.DecryptionKeyFile != "" {
+		aead, err := loadAEAD(a.DecryptionKeyFile)
+		if err != nil {
+			return fmt.Errorf("loading decryption key: %v", err)
+		}
+		a.aead = aead
+	}
+	if a.AgeIdentityFile != "" {
+		identities, err := loadAgeIdentities(a.AgeIdentityFile)
+		if err != nil {
+			return fmt.Errorf("loading age identities: %v", err)
+		}
+		a.ageIdentities = identities
+	}
+
+	for name, profile := range a.RedactionProfiles {
+		if profile == nil {
+			return fmt.Errorf("redaction profile %s: missing", name)
+		}
+		if err := profile.provision(); err != nil {
+			return fmt.Errorf("redaction profile %s: %v", name, err)
+		}
+	}
+
+	for i, webhook := range a.Webhooks {
+		if webhook == nil {
+			return fmt.Errorf("webhook %d: missing", i)
+		}
+		if err := webhook.provision(repl); err != nil {
+			return fmt.Errorf("webhook %d: %v", i, err)
+		}
+		if _, ok := a.RedactionProfiles[webhook.RedactDiffs]; !ok && builtinRedactionProfiles[webhook.RedactDiffs] == nil {
+			return fmt.Errorf("webhook %d: unknown redaction profile '%s'", i, webhook.RedactDiffs)
+		}
+	}
+
+	if len(a.SuppressWarnings) > 0 {
+		a.logger.Warn("suppress_warnings is deprecated; use the suppress warning_rules instead")
+		if a.WarningRules == nil {
+			a.WarningRules = new(WarningRules)
+		}
+		suppress := a.WarningRules.Suppress
+		a.WarningRules.Suppress = append(suppress[:len(suppress):len(suppress)], a.SuppressWarnings...)
+	}
+	if a.WarningRules != nil {
+		if err := a.WarningRules.provision(); err != nil {
+			return fmt.Errorf("warning_rules: %v", err)
+		}
+	}
+
+	if a.RateLimit != nil {
+		if err := a.RateLimit.provision(); err != nil {
+			return fmt.Errorf("rate_limit: %v", err)
+		}
+	}
+
+	if a.Quotas != nil {
+		if err := a.Quotas.provision(); err != nil {
+			return fmt.Errorf("quotas: %v", err)
+		}
+	}
+
+	if a.DialPolicy != nil {
+		if err := a.DialPolicy.provision(); err != nil {
+			return fmt.Errorf("dial_policy: %v", err)
+		}
+	}
+
+	if a.OutboundProxy != nil {
+		if err := a.OutboundProxy.provision(repl); err != nil {
+			return fmt.Errorf("outbound_proxy: %v", err)
+		}
+	}
+
+	if a.SecretResolversRaw != nil {
+		mods, err := ctx.LoadModule(a, "SecretResolversRaw")
+		if err != nil {
+			return fmt.Errorf("loading secret resolvers: %v", err)
+		}
+		a.secretResolvers = make(map[string]SecretResolver)
+		for name, mod := range mods.(map[string]interface{}) {
+			if !secretPlaceholderRegexp.MatchString("{" + name + ":x}") {
+				return fmt.Errorf("secret resolver %s: name must be a letter followed by letters, digits, _ or -", name)
+			}
+			a.secretResolvers[name] = mod.(SecretResolver)
+		}
+	}
+
+	// after the secret resolvers, which may look it up
+	if a.StorageKey != "" {
+		key, err := a.resolveStorageKey(ctx, repl)
+		if err != nil {
+			return fmt.Errorf("storage_key: %v", err)
+		}
+		if a.storageAEAD, err = newAEAD(key); err != nil {
+			return fmt.Errorf("storage_key: %v", err)
+		}
+	}
+
+	// after the secret resolvers too
+	for i, creds := range a.SourceCredentials {
+		if creds == nil {
+			return fmt.Errorf("source credentials %d: missing", i)
+		}
+		if err := creds.provision(ctx, a, repl); err != nil {
+			return fmt.Errorf("source credentials %d: %v", i, err)
+		}
+		host := strings.ToLower(creds.Host)
+		if _, ok := a.sourceCredentials[host]; ok {
+			return fmt.Errorf("source credentials %d: host %s has credentials already", i, creds.Host)
+		}
+		if a.sourceCredentials == nil {
+			a.sourceCredentials = make(map[string]*SourceCredentials)
+		}
+		a.sourceCredentials[host] = creds
+	}
+
+	if a.TransformersRaw != nil {
+		mods, err := ctx.LoadModule(a, "TransformersRaw")
+		if err != nil {
+			return fmt.Errorf("loading transformers: %v", err)
+		}
+		for _, mod := range mods.([]interface{}) {
+			a.transformers = append(a.transformers, mod.(Transformer))
+		}
+	}
+
+	if a.Tracing != nil {
+		tp, err := a.Tracing.newTracerProvider(repl)
+		if err != nil {
+			return fmt.Errorf("tracing: %v", err)
+		}
+		a.tracerProvider = tp
+	}
+
+	if a.AuditLogFile != "" {
+		file, err := openAuditFile(a.AuditLogFile)
+		if err != nil {
+			return fmt.Errorf("opening audit log: %v", err)
+		}
+		a.auditFile = file
+	}
+
+	// snapshots are kept wherever the rest of the config keeps its data
+	a.storage = ctx.Storage()
+
+	return nil
+}
+
+// Validate ensures the app's settings are valid.
+func (a *App) Validate() error {
+	if a.MaxBodySize < 0 {
+		return fmt.Errorf("max_body_size may not be negative")
+	}
+	if a.CompressMinSize < 0 {
+		return fmt.Errorf("compress_min_size may not be negative")
+	}
+	if a.MaxSourceSize < 0 {
+		return fmt.Errorf("max_source_size may not be negative")
+	}
+	if a.SourceTimeout < 0 {
+		return fmt.Errorf("source_timeout may not be negative")
+	}
+	if a.JobRetention < 0 {
+		return fmt.Errorf("job_retention may not be negative")
+	}
+	if a.MaxPendingJobs < 0 {
+		return fmt.Errorf("max_pending_jobs may not be negative")
+	}
+	if a.JobTimeout < 0 {
+		return fmt.Errorf("job_timeout may not be negative")
+	}
+	if a.MaxConcurrentAdaptations < 0 {
+		return fmt.Errorf("max_concurrent_adaptations may not be negative")
+	}
+	if a.Timeout < 0 {
+		return fmt.Errorf("timeout may not be negative")
+	}
+	switch a.OutputFormat {
+	case outputAsIs, outputPretty, outputMinify:
+	default:
+		return fmt.Errorf("unrecognized output_format: %s", a.OutputFormat)
+	}
+	if a.AutosaveKeep < 0 {
+		return fmt.Errorf("autosave_keep may not be negative")
+	}
+	if a.AutosaveKeep > 0 && a.AutosavePath == "" {
+		return fmt.Errorf("autosave_keep needs autosave_path")
+	}
+	if a.AuditLogSegmentSize < 0 {
+		return fmt.Errorf("audit_log_segment_size may not be negative")
+	}
+	if a.AuditLogFile != "" && a.AuditLogKey != "" {
+		return fmt.Errorf("audit_log_file and audit_log_key are mutually exclusive")
+	}
+	if a.DefaultAdapter != "" {
+		if _, err := AdapterByName(a.DefaultAdapter); err != nil {
+			return fmt.Errorf("default_adapter: %v", err)
+		}
+	}
+	return nil
+}
+
+// Start puts the app's settings into effect.
+func (a *App) Start() error {
+	if a.DisableMetrics {
+		resetMetrics()
+	}
+	activeAppMu.Lock()
+	activeApp = a
+	activeAppMu.Unlock()
+	return nil
+}
+
+// Stop takes the app's settings out of effect, unless a
+// newer config has already replaced them.
+func (a *App) Stop() error {
+	activeAppMu.Lock()
+	if activeApp == a {
+		activeApp = nil
+	}
+	activeAppMu.Unlock()
+	return nil
+}
+
+// Cleanup closes the audit log and flushes any traces.
+func (a *App) Cleanup() error {
+	err := a.shutdownTracing()
+	auditMu.Lock()
+	defer auditMu.Unlock()
+	if a.auditFile != nil {
+		if closeErr := a.auditFile.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	return err
+}
+
+// settings returns the settings currently in effect.
+func settings() *App {
+	activeAppMu.RLock()
+	defer activeAppMu.RUnlock()
+	if activeApp == nil {
+		return new(App)
+	}
+	return activeApp
+}
+
+var (
+	activeApp   *App
+	activeAppMu sync.RWMutex
+
+	// how many times the app has been provisioned
+	appGenerations uint64
+)
+
+// Interface guards
+var (
+	_ caddy.App          = (*App)(nil)
+	_ caddy.Provisioner  = (*App)(nil)
+	_ caddy.Validator    = (*App)(nil)
+	_ caddy.CleanerUpper = (*App)(nil)
+)
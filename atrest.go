@@ -0,0 +1,85 @@
+package adapt
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+
+	"github.com/caddyserver/caddy/v2"
+)
+
+// encryptedPrefix starts what is stored encrypted, telling it apart
+// from what was stored before a storage_key was set, which is JSON.
+const encryptedPrefix = "aes-gcm:"
+
+// resolveStorageKey returns the storage_key, with a placeholder
+// in it replaced by repl, or looked up by the secret resolver it
+// names, if it is one of theirs.
+func (a *App) resolveStorageKey(ctx caddy.Context, repl *caddy.Replacer) (string, error) {
+	return a.resolveSecretSetting(ctx, repl, a.StorageKey)
+}
+
+// resolveSecretSetting returns value, a setting that holds a secret,
+// with a placeholder in it replaced by repl, or looked up by the
+// secret resolver it names, if it is one of theirs.
+func (a *App) resolveSecretSetting(ctx caddy.Context, repl *caddy.Replacer, value string) (string, error) {
+	if match := secretPlaceholderRegexp.FindStringSubmatch(value); match != nil && match[0] == value {
+		if resolver, ok := a.secretResolvers[match[1]]; ok {
+			return resolver.ResolveSecret(ctx, match[2])
+		}
+	}
+	resolved := repl.ReplaceAll(value, "")
+	if resolved == "" {
+		return "", fmt.Errorf("empty after replacing placeholders")
+	}
+	return resolved, nil
+}
+
+// sealStored returns data, to be stored under name, encrypted with
+// the storage_key, if one is set, as a single line of text. The name
+// is authenticated with it, so it can't be passed off as another.
+func sealStored(name string, data []byte) ([]byte, error) {
+	aead := settings().storageAEAD
+	if aead == nil {
+		return data, nil
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	sealed := aead.Seal(nonce, nonce, data, []byte(name))
+	enc := base64.StdEncoding
+	stored := make([]byte, len(encryptedPrefix)+enc.EncodedLen(len(sealed)))
+	copy(stored, encryptedPrefix)
+	enc.Encode(stored[len(encryptedPrefix):], sealed)
+	return stored, nil
+}
+
+// openStored returns stored, as it was stored under name, decrypted
+// if it was encrypted. What was stored before the storage_key was
+// set is returned as it is.
+func openStored(name string, stored []byte) ([]byte, error) {
+	if !bytes.HasPrefix(stored, []byte(encryptedPrefix)) {
+		return stored, nil
+	}
+	aead := settings().storageAEAD
+	if aead == nil {
+		return nil, errorf("it is encrypted, and no storage_key is configured")
+	}
+	enc := base64.StdEncoding
+	sealed := make([]byte, enc.DecodedLen(len(stored)-len(encryptedPrefix)))
+	n, err := enc.Decode(sealed, stored[len(encryptedPrefix):])
+	if err != nil {
+		return nil, errorf("decoding: %v", err)
+	}
+	sealed = sealed[:n]
+	if len(sealed) < aead.NonceSize() {
+		return nil, errorf("it is too short")
+	}
+	data, err := aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], []byte(name))
+	if err != nil {
+		return nil, errorf("decrypting: %v", err)
+	}
+	return data, nil
+}
@@ -0,0 +1,79 @@
+package adapt
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestStorageKey(t *testing.T) {
+	key := base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{7}, 32))
+	os.Setenv("ADAPT_TEST_STORAGE_KEY", key)
+	defer os.Unsetenv("ADAPT_TEST_STORAGE_KEY")
+	root := startApp(t, &App{
+		StorageKey:  "{env.ADAPT_TEST_STORAGE_KEY}",
+		AuditLogKey: "adapt/audit.log",
+	})
+
+	w := post("/adapt/snapshots/prod", "text/test-echo", "password123")
+	expectStatus(t, w, http.StatusCreated)
+
+	for _, name := range []string{"adapt/snapshots/prod", "adapt/audit.log"} {
+		stored, err := ioutil.ReadFile(filepath.Join(root, filepath.FromSlash(name)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.HasPrefix(stored, []byte(encryptedPrefix)) || bytes.Contains(stored, []byte("password123")) ||
+			bytes.Contains(stored, []byte("test-echo")) {
+			t.Fatalf("expected %s to be stored encrypted, got %s", name, stored)
+		}
+	}
+
+	w = serve(httptest.NewRequest(http.MethodGet, "/adapt/snapshots/prod", nil))
+	expectStatus(t, w, http.StatusOK)
+	if !bytes.Contains(w.Body.Bytes(), []byte("password123")) {
+		t.Fatalf("expected the snapshot's config, got %s", w.Body)
+	}
+	w = serve(httptest.NewRequest(http.MethodGet, "/adapt/audit", nil))
+	expectStatus(t, w, http.StatusOK)
+	var entries []auditEntry
+	if err := json.Unmarshal(w.Body.Bytes(), &entries); err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Adapter != "test-echo" {
+		t.Fatalf("expected the audit entry of the snapshot, got %s", w.Body)
+	}
+}
+
+func TestOpenStored(t *testing.T) {
+	key := base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{7}, 16))
+	startApp(t, &App{StorageKey: key})
+
+	// stored before the storage_key was set
+	plain := []byte(`{"name":"old"}`)
+	if got, err := openStored("a", plain); err != nil || !bytes.Equal(got, plain) {
+		t.Fatalf("expected %s as it is, got %s (%v)", plain, got, err)
+	}
+
+	sealed, err := sealStored("a", plain)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := openStored("a", sealed); err != nil || !bytes.Equal(got, plain) {
+		t.Fatalf("expected %s, got %s (%v)", plain, got, err)
+	}
+	if _, err := openStored("b", sealed); err == nil {
+		t.Fatal("expected what was stored under one name not to open under another")
+	}
+
+	startApp(t, &App{})
+	if _, err := openStored("a", sealed); err == nil {
+		t.Fatal("expected an error without a storage_key")
+	}
+}
@@ -0,0 +1,609 @@
+package adapt
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"go.uber.org/zap"
+)
+
+// defaultAuditQueryLimit and maxAuditQueryLimit are the default
+// and largest number of entries that /adapt/audit responds with.
+const (
+	defaultAuditQueryLimit = 100
+	maxAuditQueryLimit     = 10000
+)
+
+// defaultAuditSegmentSize is the size, in bytes, past which the
+// audit log is rotated, if audit_log_segment_size isn't set.
+const defaultAuditSegmentSize = 1 << 20
+
+// auditName is what encrypted entries of the audit log are
+// stored under, whether it is a file or a storage key.
+const auditName = "audit"
+
+// auditMu serializes writes to the audit log.
+var auditMu sync.Mutex
+
+// auditEntry is the record of a request in the audit log, with
+// the provenance of the config it adapted, if any, so that what
+// was loaded can be traced to the binary and adapter behind it.
+type auditEntry struct {
+	Time          time.Time `json:"ts"`
+	RequestID     string    `json:"request_id"`
+	JobID         string    `json:"job_id,omitempty"`
+	Method        string    `json:"method"`
+	Endpoint      string    `json:"endpoint"`
+	RemoteAddr    string    `json:"remote_addr"`
+	Origin        string    `json:"origin,omitempty"`
+	Requester     string    `json:"requester,omitempty"`
+	Adapter       string    `json:"adapter,omitempty"`
+	AdapterModule string    `json:"adapter_module,omitempty"`
+	CaddyVersion  string    `json:"caddy_version"`
+	ModuleVersion string    `json:"module_version"`
+	InputSHA256   string    `json:"input_sha256,omitempty"`
+	ResultSHA256  string    `json:"result_sha256,omitempty"`
+	Warnings      int       `json:"warnings"`
+	Status        int       `json:"status"`
+	Success       bool      `json:"success"`
+	Error         string    `json:"error,omitempty"`
+}
+
+// auditing returns true if requests are recorded in an audit log.
+func (a *App) auditing() bool {
+	return a.AuditLogFile != "" || a.AuditLogKey != ""
+}
+
+// audited returns true if r is recorded in the audit log, which
+// is the case for all requests but those that only read.
+func audited(r *http.Request) bool {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return false
+	}
+	return settings().auditing()
+}
+
+// recordAudit appends the outcome of r, which was given the ID id,
+// with the given status and stats, to the audit log. Failing to do
+// so is logged, since the response has already been written.
+func recordAudit(r *http.Request, id string, status int, stats *requestStats) {
+	app := settings()
+	caddyVersion, moduleVersion := buildVersions()
+	entry := auditEntry{
+		Time:          time.Now().UTC(),
+		RequestID:     id,
+		JobID:         jobID(r.Context()),
+		Method:        r.Method,
+		Endpoint:      r.URL.Path,
+		RemoteAddr:    r.RemoteAddr,
+		Origin:        r.Header.Get("Origin"),
+		Adapter:       stats.adapter,
+		AdapterModule: adapterModules(stats.adapter),
+		CaddyVersion:  caddyVersion,
+		ModuleVersion: moduleVersion,
+		InputSHA256:   stats.sourceSHA256,
+		ResultSHA256:  stats.resultSHA256,
+		Warnings:      stats.warnings,
+		Status:        status,
+		Success:       stats.err == nil && status < 400,
+	}
+	if r.TLS != nil && len(r.TLS.PeerCertificates) > 0 {
+		entry.Requester = requester(r)
+	}
+	if stats.err != nil {
+		entry.Error = stats.err.Error()
+	}
+
+	line, err := json.Marshal(entry)
+	if err == nil {
+		line, err = sealStored(auditName, line)
+	}
+	if err == nil {
+		err = app.appendAudit(append(line, '\n'))
+	}
+	if err != nil {
+		logger(r).Error("failed to record request in audit log", zap.Error(err))
+	}
+}
+
+// The audit log is split into segments, so that neither appending to
+// it nor reading its most recent entries takes longer as it grows.
+// Entries are appended to the audit_log_file or audit_log_key itself,
+// which is renamed or copied to <file or key>.<n>, numbered from 1 in
+// the order they are rotated, once it would grow past the segment size.
+
+// auditSegmentSize returns the size, in bytes, past which
+// the audit log is rotated.
+func (a *App) auditSegmentSize() int64 {
+	if a.AuditLogSegmentSize > 0 {
+		return a.AuditLogSegmentSize
+	}
+	return defaultAuditSegmentSize
+}
+
+// auditSegmentName returns the file name or storage key of the audit
+// log segment numbered seq, or of the one appended to, if seq is 0.
+func (a *App) auditSegmentName(seq int) string {
+	name := a.AuditLogFile
+	if name == "" {
+		name = a.AuditLogKey
+	}
+	if seq == 0 {
+		return name
+	}
+	return name + "." + strconv.Itoa(seq)
+}
+
+// rotatedAuditSegments returns the numbers of the segments of
+// the audit log that have been rotated, oldest first.
+func (a *App) rotatedAuditSegments() ([]int, error) {
+	var names []string
+	if a.AuditLogFile != "" {
+		dir := filepath.Dir(a.AuditLogFile)
+		infos, err := ioutil.ReadDir(dir)
+		if err != nil {
+			return nil, err
+		}
+		for _, info := range infos {
+			names = append(names, info.Name())
+		}
+	} else if storage, dir := appStorage(), path.Dir(a.AuditLogKey); storage.Exists(dir) {
+		keys, err := storage.List(dir, false)
+		if err != nil {
+			return nil, err
+		}
+		for _, key := range keys {
+			names = append(names, path.Base(key))
+		}
+	}
+
+	prefix := path.Base(filepath.ToSlash(a.auditSegmentName(0))) + "."
+	var segments []int
+	for _, name := range names {
+		if !strings.HasPrefix(name, prefix) {
+			continue
+		}
+		if seq, err := strconv.Atoi(name[len(prefix):]); err == nil && seq > 0 {
+			segments = append(segments, seq)
+		}
+	}
+	sort.Ints(segments)
+	return segments, nil
+}
+
+// nextAuditSegment returns the number that the segment
+// of the audit log being appended to is rotated to.
+func (a *App) nextAuditSegment() (int, error) {
+	segments, err := a.rotatedAuditSegments()
+	if err != nil || len(segments) == 0 {
+		return 1, err
+	}
+	return segments[len(segments)-1] + 1, nil
+}
+
+// appendAudit appends line to the audit log file or storage key,
+// rotating it first if it would grow past the segment size.
+func (a *App) appendAudit(line []byte) error {
+	auditMu.Lock()
+	defer auditMu.Unlock()
+
+	if a.auditFile != nil {
+		info, err := a.auditFile.Stat()
+		if err != nil {
+			return err
+		}
+		if info.Size() > 0 && info.Size()+int64(len(line)) > a.auditSegmentSize() {
+			if err := a.rotateAuditFile(); err != nil {
+				return errorf("rotating audit log: %v", err)
+			}
+		}
+		_, err = a.auditFile.Write(line)
+		return err
+	}
+
+	// storage can't append, so the log is rewritten under a lock,
+	// which is shared with other instances using the same storage
+	storage := appStorage()
+	if err := storage.Lock(context.Background(), a.AuditLogKey); err != nil {
+		return err
+	}
+	defer storage.Unlock(a.AuditLogKey)
+	var log []byte
+	if storage.Exists(a.AuditLogKey) {
+		var err error
+		log, err = storage.Load(a.AuditLogKey)
+		if err != nil {
+			return err
+		}
+	}
+	if len(log) > 0 && int64(len(log)+len(line)) > a.auditSegmentSize() {
+		seq, err := a.nextAuditSegment()
+		if err != nil {
+			return errorf("rotating audit log: %v", err)
+		}
+		// copied before it is replaced, so a failure in between
+		// repeats entries rather than losing them
+		if err := storage.Store(a.auditSegmentName(seq), log); err != nil {
+			return errorf("rotating audit log: %v", err)
+		}
+		log = nil
+	}
+	return storage.Store(a.AuditLogKey, append(log, line...))
+}
+
+// rotateAuditFile renames the audit log file to the next segment
+// and opens a new one in its place.
+func (a *App) rotateAuditFile() error {
+	seq, err := a.nextAuditSegment()
+	if err != nil {
+		return err
+	}
+	if err := a.auditFile.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(a.AuditLogFile, a.auditSegmentName(seq)); err != nil {
+		return err
+	}
+	a.auditFile, err = openAuditFile(a.AuditLogFile)
+	return err
+}
+
+// openAuditFile opens the audit log file for appending.
+func openAuditFile(name string) (*os.File, error) {
+	return os.OpenFile(name, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+}
+
+// readAuditSegment returns the entries in the segment of the
+// audit log numbered seq, or the one appended to, if seq is 0.
+func (a *App) readAuditSegment(seq int) ([]json.RawMessage, error) {
+	var log []byte
+	name := a.auditSegmentName(seq)
+	if a.AuditLogFile != "" {
+		var err error
+		log, err = ioutil.ReadFile(name)
+		if err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+	} else if storage := appStorage(); storage.Exists(name) {
+		var err error
+		log, err = storage.Load(name)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var entries []json.RawMessage
+	scanner := bufio.NewScanner(bytes.NewReader(log))
+	scanner.Buffer(nil, 1<<20)
+	for scanner.Scan() {
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+		entry, err := openStored(auditName, scanner.Bytes())
+		if err != nil {
+			return nil, errorf("reading audit log: %v", err)
+		}
+		entries = append(entries, append(json.RawMessage(nil), entry...))
+	}
+	return entries, scanner.Err()
+}
+
+// auditQuery selects entries of the audit log.
+type auditQuery struct {
+	limit  int
+	before *auditPosition // if set, only entries before it
+	since  time.Time      // if set, only entries recorded at or after it
+	until  time.Time      // if set, only entries recorded before it
+}
+
+// auditPosition is where an entry is in the audit log: its index in
+// the segment it is in, numbered as rotatedAuditSegments does, with
+// the one appended to numbered as it will be once it is rotated.
+type auditPosition struct {
+	segment, index int
+}
+
+func (p auditPosition) String() string {
+	return strconv.Itoa(p.segment) + "." + strconv.Itoa(p.index)
+}
+
+// parseAuditPosition parses a cursor from /adapt/audit.
+func parseAuditPosition(cursor string) (*auditPosition, bool) {
+	dot := strings.Index(cursor, ".")
+	if dot < 0 {
+		return nil, false
+	}
+	segment, err1 := strconv.Atoi(cursor[:dot])
+	index, err2 := strconv.Atoi(cursor[dot+1:])
+	if err1 != nil || err2 != nil || segment < 1 || index < 0 {
+		return nil, false
+	}
+	return &auditPosition{segment, index}, true
+}
+
+// readAudit returns the last q.limit entries of the audit log that q
+// selects, oldest first, reading only as many of its segments as it
+// takes, newest first. If there may be more, it also returns the
+// position of the oldest entry it returns, to continue before.
+func (a *App) readAudit(q auditQuery) ([]json.RawMessage, *auditPosition, error) {
+	auditMu.Lock()
+	defer auditMu.Unlock()
+
+	rotated, err := a.rotatedAuditSegments()
+	if err != nil {
+		return nil, nil, err
+	}
+	// the one appended to, which is the newest
+	segments := []int{len(rotated) + 1}
+	if len(rotated) > 0 {
+		segments[0] = rotated[len(rotated)-1] + 1
+	}
+	for i := len(rotated) - 1; i >= 0; i-- {
+		segments = append(segments, rotated[i])
+	}
+
+	var entries []json.RawMessage
+	var oldest *auditPosition
+	for i, seq := range segments {
+		if q.before != nil && seq > q.before.segment {
+			continue
+		}
+		name := seq
+		if i == 0 {
+			name = 0
+		}
+		segment, err := a.readAuditSegment(name)
+		if err != nil {
+			return nil, nil, err
+		}
+		if q.before != nil && seq == q.before.segment && q.before.index < len(segment) {
+			segment = segment[:q.before.index]
+		}
+
+		reachedSince := false
+		for index := len(segment) - 1; index >= 0; index-- {
+			if !q.since.IsZero() || !q.until.IsZero() {
+				var entry struct {
+					Time time.Time `json:"ts"`
+				}
+				json.Unmarshal(segment[index], &entry)
+				if entry.Time.Before(q.since) {
+					reachedSince = true
+					continue
+				}
+				if !q.until.IsZero() && !entry.Time.Before(q.until) {
+					continue
+				}
+			}
+			entries = append(entries, segment[index])
+			if len(entries) == q.limit {
+				oldest = &auditPosition{seq, index}
+				break
+			}
+		}
+		// entries are appended in the order they are recorded
+		if oldest != nil || reachedSince {
+			break
+		}
+	}
+
+	// oldest first
+	page := make([]json.RawMessage, len(entries))
+	for i, entry := range entries {
+		page[len(entries)-1-i] = entry
+	}
+	return page, oldest, nil
+}
+
+// purgeResult is the response body of a purge of the
+// audit log or snapshots.
+type purgeResult struct {
+	Deleted int `json:"deleted"`
+}
+
+// purgeAudit removes the entries of the audit log that were recorded
+// before the given time, and returns how many it removed. Segments
+// left empty are deleted, except the newest that was rotated, which
+// the ones rotated after it are numbered from.
+func (a *App) purgeAudit(before time.Time) (int, error) {
+	auditMu.Lock()
+	defer auditMu.Unlock()
+	if a.AuditLogKey != "" {
+		storage := appStorage()
+		if err := storage.Lock(context.Background(), a.AuditLogKey); err != nil {
+			return 0, err
+		}
+		defer storage.Unlock(a.AuditLogKey)
+	}
+
+	rotated, err := a.rotatedAuditSegments()
+	if err != nil {
+		return 0, err
+	}
+	deleted := 0
+	for i, seq := range append(rotated, 0) {
+		entries, err := a.readAuditSegment(seq)
+		if err != nil {
+			return deleted, err
+		}
+		var kept []json.RawMessage
+		for _, entry := range entries {
+			var recorded struct {
+				Time time.Time `json:"ts"`
+			}
+			if err := json.Unmarshal(entry, &recorded); err != nil || !recorded.Time.Before(before) {
+				kept = append(kept, entry)
+			}
+		}
+		if len(entries) == 0 {
+			continue
+		}
+		if len(kept) == len(entries) {
+			// entries are appended in the order they are recorded
+			break
+		}
+		if len(kept) == 0 && seq != 0 && i < len(rotated)-1 {
+			err = a.deleteAuditSegment(seq)
+		} else {
+			err = a.writeAuditSegment(seq, kept)
+		}
+		if err != nil {
+			return deleted, err
+		}
+		deleted += len(entries) - len(kept)
+	}
+	return deleted, nil
+}
+
+// deleteAuditSegment deletes the rotated segment of the
+// audit log numbered seq.
+func (a *App) deleteAuditSegment(seq int) error {
+	if a.AuditLogFile != "" {
+		return os.Remove(a.auditSegmentName(seq))
+	}
+	return appStorage().Delete(a.auditSegmentName(seq))
+}
+
+// writeAuditSegment replaces the entries in the segment of the audit
+// log numbered seq, or the one appended to, if seq is 0.
+func (a *App) writeAuditSegment(seq int, entries []json.RawMessage) error {
+	var log []byte
+	for _, entry := range entries {
+		line, err := sealStored(auditName, entry)
+		if err != nil {
+			return err
+		}
+		log = append(append(log, line...), '\n')
+	}
+	name := a.auditSegmentName(seq)
+	if a.AuditLogFile == "" {
+		return appStorage().Store(name, log)
+	}
+
+	// replaced all at once, so it is never left half written
+	if err := ioutil.WriteFile(name+".tmp", log, 0600); err != nil {
+		return err
+	}
+	if err := os.Rename(name+".tmp", name); err != nil {
+		return err
+	}
+	if seq != 0 || a.auditFile == nil {
+		return nil
+	}
+	a.auditFile.Close()
+	var err error
+	a.auditFile, err = openAuditFile(name)
+	return err
+}
+
+// handleAudit responds with the most recent entries of the audit
+// log, at most ?limit of them, oldest first, of those recorded from
+// ?since until ?until. If there may be more, X-Adapt-Cursor is set to
+// a cursor that ?cursor takes to respond with those before them.
+// DELETE removes the entries recorded before ?before instead.
+func (adminAdapt) handleAudit(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != http.MethodGet && r.Method != http.MethodDelete {
+		return caddy.APIError{
+			HTTPStatus: http.StatusMethodNotAllowed,
+			Err:        errorf("method not allowed"),
+		}
+	}
+
+	app := settings()
+	if !app.auditing() {
+		return caddy.APIError{
+			HTTPStatus: http.StatusNotFound,
+			Err:        errorf("no audit log is configured"),
+		}
+	}
+
+	if r.Method == http.MethodDelete {
+		before, err := queryBefore(r)
+		if err != nil {
+			return err
+		}
+		deleted, err := app.purgeAudit(before)
+		if err != nil {
+			return errorf("purging audit log: %v", err)
+		}
+		logger(r).Info("audit log purged", zap.Int("deleted", deleted))
+		w.Header().Set("Content-Type", "application/json")
+		return json.NewEncoder(w).Encode(purgeResult{Deleted: deleted})
+	}
+
+	limit := defaultAuditQueryLimit
+	if val := r.URL.Query().Get("limit"); val != "" {
+		n, err := strconv.Atoi(val)
+		if err != nil || n <= 0 || n > maxAuditQueryLimit {
+			return caddy.APIError{
+				HTTPStatus: http.StatusBadRequest,
+				Err:        errorf("invalid value for limit: %s (must be 1 to %d)", val, maxAuditQueryLimit),
+			}
+		}
+		limit = n
+	}
+
+	q := auditQuery{limit: limit}
+	if cursor := r.URL.Query().Get("cursor"); cursor != "" {
+		pos, ok := parseAuditPosition(cursor)
+		if !ok {
+			return caddy.APIError{
+				HTTPStatus: http.StatusBadRequest,
+				Err:        errorf("invalid value for cursor: %s", cursor),
+			}
+		}
+		q.before = pos
+	}
+	var err error
+	if q.since, err = queryTime(r, "since"); err != nil {
+		return err
+	}
+	if q.until, err = queryTime(r, "until"); err != nil {
+		return err
+	}
+
+	entries, oldest, err := app.readAudit(q)
+	if err != nil {
+		return errorf("reading audit log: %v", err)
+	}
+	if oldest != nil {
+		w.Header().Set("X-Adapt-Cursor", oldest.String())
+	}
+	w.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(w).Encode(entries)
+}
+
+// queryBefore returns the time in ?before, which a purge
+// of what was stored before it requires.
+func queryBefore(r *http.Request) (time.Time, error) {
+	before, err := queryTime(r, "before")
+	if err == nil && before.IsZero() {
+		err = caddy.APIError{
+			HTTPStatus: http.StatusBadRequest,
+			Err:        errorf("purging needs ?before, the time to purge what was stored before"),
+		}
+	}
+	return before, err
+}
+
+// sha256Hex returns the hex-encoded SHA-256 of b.
+func sha256Hex(b []byte) string {
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
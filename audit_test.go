@@ -0,0 +1,151 @@
+package adapt
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// readAuditEntries gets target, a request for /adapt/audit,
+// and returns the entries it responds with.
+func readAuditEntries(t *testing.T, target string) []auditEntry {
+	t.Helper()
+	w := serve(httptest.NewRequest(http.MethodGet, target, nil))
+	expectStatus(t, w, http.StatusOK)
+	var entries []auditEntry
+	if err := json.Unmarshal(w.Body.Bytes(), &entries); err != nil {
+		t.Fatal(err)
+	}
+	return entries
+}
+
+// expectAuditInputs fails t unless entries are of the
+// configs with the given bodies, in order.
+func expectAuditInputs(t *testing.T, entries []auditEntry, bodies ...string) {
+	t.Helper()
+	if len(entries) != len(bodies) {
+		t.Fatalf("expected %d entries, got %d", len(bodies), len(entries))
+	}
+	for i, body := range bodies {
+		if entries[i].InputSHA256 != sha256Hex([]byte(body)) {
+			t.Fatalf("expected entry %d to be of %q, got %+v", i, body, entries[i])
+		}
+	}
+}
+
+func TestAuditLogRotates(t *testing.T) {
+	dir, err := ioutil.TempDir("", "adapt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for _, app := range []*App{
+		{AuditLogFile: filepath.Join(dir, "audit.log")},
+		{AuditLogKey: "adapt/audit.log"},
+	} {
+		// small enough that every entry gets a segment of its own
+		app.AuditLogSegmentSize = 100
+		root := startApp(t, app)
+		var bodies []string
+		for i := 0; i < 5; i++ {
+			bodies = append(bodies, strconv.Itoa(i))
+			expectStatus(t, post("/adapt", "text/test-echo", bodies[i]), http.StatusOK)
+		}
+
+		name := app.AuditLogFile
+		if name == "" {
+			name = filepath.Join(root, "adapt", "audit.log")
+		}
+		for seq := 1; seq <= 4; seq++ {
+			if _, err := os.Stat(name + "." + strconv.Itoa(seq)); err != nil {
+				t.Fatalf("expected segment %d: %v", seq, err)
+			}
+		}
+
+		expectAuditInputs(t, readAuditEntries(t, "/adapt/audit"), bodies...)
+		expectAuditInputs(t, readAuditEntries(t, "/adapt/audit?limit=2"), bodies[3:]...)
+	}
+}
+
+func TestAuditPaging(t *testing.T) {
+	startApp(t, &App{AuditLogKey: "adapt/audit.log", AuditLogSegmentSize: 1000})
+	var bodies []string
+	for i := 0; i < 7; i++ {
+		bodies = append(bodies, strconv.Itoa(i))
+		expectStatus(t, post("/adapt", "text/test-echo", bodies[i]), http.StatusOK)
+	}
+
+	// pages of 3, newest first, across segments
+	target := "/adapt/audit?limit=3"
+	for _, want := range [][]string{bodies[4:], bodies[1:4], bodies[:1]} {
+		w := serve(httptest.NewRequest(http.MethodGet, target, nil))
+		expectStatus(t, w, http.StatusOK)
+		var entries []auditEntry
+		if err := json.Unmarshal(w.Body.Bytes(), &entries); err != nil {
+			t.Fatal(err)
+		}
+		expectAuditInputs(t, entries, want...)
+		target = "/adapt/audit?limit=3&cursor=" + w.Header().Get("X-Adapt-Cursor")
+	}
+	if target != "/adapt/audit?limit=3&cursor=" {
+		t.Fatalf("expected no cursor after the last page, got %s", target)
+	}
+
+	entries := readAuditEntries(t, "/adapt/audit")
+	since := entries[2].Time.Format(time.RFC3339Nano)
+	until := entries[5].Time.Format(time.RFC3339Nano)
+	expectAuditInputs(t, readAuditEntries(t, "/adapt/audit?since="+since+"&until="+until), bodies[2:5]...)
+
+	w := serve(httptest.NewRequest(http.MethodGet, "/adapt/audit?cursor=nope", nil))
+	expectStatus(t, w, http.StatusBadRequest)
+}
+
+func TestAuditPurge(t *testing.T) {
+	dir, err := ioutil.TempDir("", "adapt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for _, app := range []*App{
+		{AuditLogFile: filepath.Join(dir, "audit.log")},
+		{AuditLogKey: "adapt/audit.log"},
+	} {
+		app.AuditLogSegmentSize = 1000
+		startApp(t, app)
+		var bodies []string
+		for i := 0; i < 5; i++ {
+			bodies = append(bodies, strconv.Itoa(i))
+			expectStatus(t, post("/adapt", "text/test-echo", bodies[i]), http.StatusOK)
+		}
+
+		entries := readAuditEntries(t, "/adapt/audit")
+		before := entries[3].Time.Format(time.RFC3339Nano)
+		w := serve(httptest.NewRequest(http.MethodDelete, "/adapt/audit?before="+before, nil))
+		expectStatus(t, w, http.StatusOK)
+		var res purgeResult
+		if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil {
+			t.Fatal(err)
+		}
+		if res.Deleted != 3 {
+			t.Fatalf("expected 3 entries to be purged, got %s", w.Body)
+		}
+
+		// the purge itself is audited
+		entries = readAuditEntries(t, "/adapt/audit")
+		expectAuditInputs(t, entries[:2], bodies[3:]...)
+		if len(entries) != 3 || entries[2].Method != http.MethodDelete {
+			t.Fatalf("expected the purge to be audited after what was left, got %+v", entries)
+		}
+	}
+
+	w := serve(httptest.NewRequest(http.MethodDelete, "/adapt/audit", nil))
+	expectStatus(t, w, http.StatusBadRequest)
+}
@@ -0,0 +1,57 @@
+package adapt
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"net/http"
+	"strings"
+
+	"github.com/caddyserver/caddy/v2"
+)
+
+// authenticated wraps h so that, if any auth_tokens are configured,
+// requests must carry one of them as a bearer token. This is on top
+// of whatever access control the admin endpoint itself has.
+func authenticated(h caddy.AdminHandlerFunc) caddy.AdminHandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		tokens := settings().authTokens
+		if len(tokens) == 0 {
+			return h(w, r)
+		}
+
+		scheme, token := splitAuthorization(r.Header.Get("Authorization"))
+		if !strings.EqualFold(scheme, "Bearer") || token == "" {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="adapt"`)
+			return caddy.APIError{
+				HTTPStatus: http.StatusUnauthorized,
+				Err:        errorf("a bearer token is required"),
+			}
+		}
+
+		// comparing digests keeps the comparison constant-time
+		// regardless of the lengths of the tokens
+		sum := sha256.Sum256([]byte(token))
+		valid := 0
+		for _, allowed := range tokens {
+			valid |= subtle.ConstantTimeCompare(sum[:], allowed[:])
+		}
+		if valid != 1 {
+			return caddy.APIError{
+				HTTPStatus: http.StatusForbidden,
+				Err:        errorf("invalid bearer token"),
+			}
+		}
+		return h(w, r)
+	}
+}
+
+// splitAuthorization splits the value of an Authorization
+// header into the scheme and the credentials.
+func splitAuthorization(header string) (string, string) {
+	header = strings.TrimSpace(header)
+	idx := strings.IndexByte(header, ' ')
+	if idx < 0 {
+		return header, ""
+	}
+	return header[:idx], strings.TrimSpace(header[idx+1:])
+}
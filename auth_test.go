@@ -0,0 +1,62 @@
+package adapt
+
+import (
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig"
+)
+
+func TestAuthTokens(t *testing.T) {
+	os.Setenv("ADAPT_TEST_AUTH_TOKEN", "s3cret")
+	defer os.Unsetenv("ADAPT_TEST_AUTH_TOKEN")
+	startApp(t, &App{AuthTokens: []string{"{env.ADAPT_TEST_AUTH_TOKEN}", "other"}})
+
+	for _, test := range []struct {
+		authorization string
+		status        int
+	}{
+		// no token, or not as a bearer token
+		{"", http.StatusUnauthorized},
+		{"Bearer", http.StatusUnauthorized},
+		{"Bearer  ", http.StatusUnauthorized},
+		{"Basic czNjcmV0Og==", http.StatusUnauthorized},
+		// a token that isn't one of them
+		{"Bearer wrong", http.StatusForbidden},
+		{"Bearer s3cret2", http.StatusForbidden},
+		{"Bearer {env.ADAPT_TEST_AUTH_TOKEN}", http.StatusForbidden},
+		// one of them
+		{"Bearer s3cret", http.StatusOK},
+		{"bearer  s3cret ", http.StatusOK},
+		{"Bearer other", http.StatusOK},
+	} {
+		var header []string
+		if test.authorization != "" {
+			header = []string{"Authorization", test.authorization}
+		}
+		w := post("/adapt?adapter=test-echo", "", "config", header...)
+		if w.Code != test.status {
+			t.Fatalf("%q: expected status %d, got %d: %s", test.authorization, test.status, w.Code, w.Body)
+		}
+		challenge := w.Header().Get("WWW-Authenticate")
+		if test.status == http.StatusUnauthorized && challenge != `Bearer realm="adapt"` {
+			t.Fatalf("%q: expected a bearer challenge, got %q", test.authorization, challenge)
+		}
+		if test.status != http.StatusUnauthorized && challenge != "" {
+			t.Fatalf("%q: expected no challenge, got %q", test.authorization, challenge)
+		}
+	}
+}
+
+func TestAuthTokensEmpty(t *testing.T) {
+	// an unset variable would let any token through
+	os.Unsetenv("ADAPT_TEST_AUTH_TOKEN")
+	app := &App{AuthTokens: []string{"{env.ADAPT_TEST_AUTH_TOKEN}"}}
+	err := caddy.Validate(&caddy.Config{AppsRaw: caddy.ModuleMap{"adapt": caddyconfig.JSON(app, nil)}})
+	if err == nil || !strings.Contains(err.Error(), "auth token 0 is empty") {
+		t.Fatalf("expected an empty auth token to be rejected, got %v", err)
+	}
+}
@@ -0,0 +1,149 @@
+package adapt
+
+import (
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// autosaveTimeFormat is the format of the timestamps that configs
+// rotated out of autosave_path are named with. It is fixed-width,
+// so their names sort in the order they were saved.
+const autosaveTimeFormat = "20060102T150405.000000000Z"
+
+// The file configs were last autosaved to, and the SHA-256 of that
+// config, which isn't saved again; adapting the same config over and
+// over would otherwise rotate out every other one.
+var (
+	autosaveMu   sync.Mutex
+	autosavePath string
+	autosaveHash string
+)
+
+// autosave saves cfgJSON, a config that was adapted or applied for
+// r, to autosave_path, if it is set. Failing to is only logged, as
+// the config was adapted all the same.
+func autosave(r *http.Request, cfgJSON []byte) {
+	path := settings().AutosavePath
+	if path == "" {
+		return
+	}
+	if err := saveConfig(path, settings().AutosaveKeep, cfgJSON); err != nil {
+		logger(r).Error("failed to autosave config",
+			zap.String("path", path),
+			zap.Error(err))
+	}
+}
+
+// saveConfig atomically replaces the file at path with cfgJSON. If
+// keep is positive, the config it replaces is kept beside it, named
+// for when it was saved, along with the keep-1 before it.
+func saveConfig(path string, keep int, cfgJSON []byte) error {
+	autosaveMu.Lock()
+	defer autosaveMu.Unlock()
+
+	hash := sha256Hex(cfgJSON)
+	if path == autosavePath && hash == autosaveHash {
+		if _, err := os.Stat(path); err == nil {
+			return nil
+		}
+	}
+
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+
+	// written beside path, so it can be renamed over it
+	tmp, err := ioutil.TempFile(dir, "."+filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	_, err = tmp.Write(cfgJSON)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+
+	if keep > 0 {
+		if err := rotateConfig(path); err != nil {
+			return err
+		}
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return err
+	}
+	autosavePath, autosaveHash = path, hash
+
+	if keep > 0 {
+		return pruneConfigs(path, keep)
+	}
+	return nil
+}
+
+// rotateConfig keeps the config at path, if there is one, as
+// path.<timestamp>, the time it was saved. path itself is left as
+// it is, so there is always a config there.
+func rotateConfig(path string) error {
+	info, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	rotated := path + "." + info.ModTime().UTC().Format(autosaveTimeFormat)
+	if err := os.Link(path, rotated); err == nil || os.IsExist(err) {
+		return nil
+	}
+	// not every filesystem has hard links
+	cfgJSON, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(rotated, cfgJSON, 0600)
+}
+
+// pruneConfigs removes all but the newest keep configs rotated
+// out of path.
+func pruneConfigs(path string, keep int) error {
+	infos, err := ioutil.ReadDir(filepath.Dir(path))
+	if err != nil {
+		return err
+	}
+	prefix := filepath.Base(path) + "."
+	var rotated []string
+	for _, info := range infos {
+		name := info.Name()
+		if !strings.HasPrefix(name, prefix) {
+			continue
+		}
+		if _, err := time.Parse(autosaveTimeFormat, strings.TrimPrefix(name, prefix)); err != nil {
+			continue
+		}
+		rotated = append(rotated, name)
+	}
+	if len(rotated) <= keep {
+		return nil
+	}
+	sort.Strings(rotated)
+	for _, name := range rotated[:len(rotated)-keep] {
+		if err := os.Remove(filepath.Join(filepath.Dir(path), name)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,279 @@
+package adapt
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"strings"
+
+	"github.com/caddyserver/caddy/v2"
+)
+
+// maxBatchItems is the most configs that one batch may have, and
+// maxBatchLineSize the longest line of an NDJSON batch, within
+// the max_body_size of the whole body.
+const (
+	maxBatchItems    = 1000
+	maxBatchLineSize = 64 << 20
+)
+
+// batchItem is a config in a batch, as a line of an NDJSON batch.
+type batchItem struct {
+	// Identifies the config in the results.
+	Name string `json:"name,omitempty"`
+
+	// The media type of the config, which names its adapter
+	// as the Content-Type of /adapt does.
+	ContentType string `json:"content_type,omitempty"`
+
+	// The name of the adapter to use, instead of ContentType.
+	Adapter string `json:"adapter,omitempty"`
+
+	// The config to adapt.
+	Body string `json:"body"`
+
+	// Options to pass to the adapter, in addition to those
+	// in the X-Adapt-Options header of the request.
+	Options map[string]interface{} `json:"options,omitempty"`
+
+	// The signature of the config, as in the X-Signature header,
+	// which require_signature makes required.
+	Signature string `json:"signature,omitempty"`
+
+	encryption string // the X-Encryption of a multipart part
+	err        error  // why the item couldn't be read, if it couldn't
+}
+
+// batchResult is the outcome of adapting a config in a batch.
+type batchResult struct {
+	Name     string          `json:"name,omitempty"`
+	Adapter  string          `json:"adapter,omitempty"`
+	Status   int             `json:"status"`
+	Config   json.RawMessage `json:"config,omitempty"`
+	Warnings []adaptWarning  `json:"warnings,omitempty"`
+	Error    string          `json:"error,omitempty"`
+	*sourcePosition
+}
+
+// handleBatch adapts each of the configs in the request body, which
+// is either multipart, with a config in each part, or NDJSON, with a
+// batchItem on each line, and responds with their results, in order.
+// A config that can't be adapted doesn't fail the others.
+func (adminAdapt) handleBatch(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != http.MethodPost {
+		return caddy.APIError{
+			HTTPStatus: http.StatusMethodNotAllowed,
+			Err:        errorf("method not allowed"),
+		}
+	}
+
+	options, err := adapterOptions(r)
+	if err != nil {
+		return err
+	}
+	checks, err := requestChecks(r)
+	if err != nil {
+		return err
+	}
+
+	var items []batchItem
+	r.Body = newContextBody(r.Body, r.Context())
+	ct, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	switch {
+	case strings.HasPrefix(ct, "multipart/"):
+		items, err = readBatchParts(r)
+	case ct == "application/x-ndjson" || ct == "application/ndjson":
+		items, err = readBatchLines(r.Body)
+	default:
+		return caddy.APIError{
+			HTTPStatus: http.StatusUnsupportedMediaType,
+			Err:        errorf("batches must be multipart or application/x-ndjson"),
+		}
+	}
+	if err != nil {
+		return err
+	}
+	if len(items) > maxBatchItems {
+		return caddy.APIError{
+			HTTPStatus: http.StatusRequestEntityTooLarge,
+			Err:        errorf("batch has more than %d configs", maxBatchItems),
+		}
+	}
+
+	langs := acceptedLanguages(r.Header.Get("Accept-Language"))
+	results := make([]batchResult, len(items))
+	for i, item := range items {
+		if r.Context().Err() != nil {
+			return contextError(r.Context())
+		}
+		results[i] = adaptBatchItem(r, item, options, checks, langs)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(w).Encode(results)
+}
+
+// adaptBatchItem adapts the config of item, a config in a batch
+// requested by r, with options in addition to its own, by way of
+// checks along with the item's own signature.
+func adaptBatchItem(r *http.Request, item batchItem, options map[string]interface{}, checks adaptChecks, langs []string) batchResult {
+	result := batchResult{Name: item.Name}
+	fail := func(err error) batchResult {
+		result.Status = errorStatus(err)
+		if len(langs) > 0 {
+			err = localizeError(err, langs)
+		}
+		switch e := err.(type) {
+		case caddy.APIError:
+			result.Error = e.Message
+			if result.Error == "" && e.Err != nil {
+				result.Error = e.Err.Error()
+			}
+			if srcErr, ok := e.Err.(sourceError); ok {
+				result.sourcePosition = srcErr.sourcePosition
+			}
+		case sourceError:
+			result.Error = e.Error()
+			result.sourcePosition = e.sourcePosition
+		default:
+			result.Error = e.Error()
+		}
+		return result
+	}
+	if item.err != nil {
+		return fail(item.err)
+	}
+
+	adapter, err := batchItemAdapter(r, item)
+	if err != nil {
+		return fail(caddy.APIError{HTTPStatus: http.StatusBadRequest, Err: err})
+	}
+	result.Adapter = adapter.Name()
+
+	itemOptions := make(map[string]interface{}, len(options)+len(item.Options)+1)
+	for k, v := range options {
+		itemOptions[k] = v
+	}
+	for k, v := range item.Options {
+		itemOptions[k] = v
+	}
+	if _, ok := itemOptions["filename"]; !ok && item.Name != "" {
+		itemOptions["filename"] = item.Name
+	}
+
+	checks.signature = item.Signature
+	checks.encryption = item.encryption
+	checks.contentType = item.ContentType
+	cfg, warnings, err := adaptChecked(r.Context(), r.URL.Path, adapter, []byte(item.Body), itemOptions, checks)
+	if err != nil {
+		if _, ok := err.(caddy.APIError); !ok {
+			err = caddy.APIError{HTTPStatus: http.StatusBadRequest, Err: err}
+		}
+		return fail(err)
+	}
+	result.Warnings = warnings
+	result.Status = http.StatusOK
+	result.Config = json.RawMessage(cfg)
+	return result
+}
+
+// batchItemAdapter returns the adapter for item, a config in a batch
+// requested by r. It is the one item names, by adapter or content
+// type, or else the ?adapter of the batch, or else inferred from
+// item's name; failing all that, it is the default adapter.
+func batchItemAdapter(r *http.Request, item batchItem) (Adapter, error) {
+	if item.Adapter != "" {
+		return AdapterByName(item.Adapter)
+	}
+	ct, _, _ := mime.ParseMediaType(item.ContentType)
+	if ct != "" && ct != "application/octet-stream" && ct != "text/plain" {
+		return AdapterByContentType(item.ContentType)
+	}
+	if name := r.URL.Query().Get("adapter"); name != "" {
+		return AdapterByName(name)
+	}
+	if ct := contentTypeForFile(item.Name); item.Name != "" && ct != "" {
+		return AdapterByContentType(ct)
+	}
+	if name := settings().DefaultAdapter; name != "" {
+		return AdapterByName(name)
+	}
+	return Adapter{}, nil
+}
+
+// readBatchParts returns the configs in the parts of the multipart
+// request r, each named by its file name or else its field name.
+func readBatchParts(r *http.Request) ([]batchItem, error) {
+	mr, err := r.MultipartReader()
+	if err != nil {
+		return nil, caddy.APIError{
+			HTTPStatus: http.StatusBadRequest,
+			Err:        errorf("reading multipart body: %v", err),
+		}
+	}
+	var items []batchItem
+	for len(items) <= maxBatchItems {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, caddy.APIError{
+				HTTPStatus: http.StatusBadRequest,
+				Err:        errorf("reading multipart body: %v", err),
+			}
+		}
+		body, err := ioutil.ReadAll(part)
+		if err != nil {
+			return nil, caddy.APIError{
+				HTTPStatus: http.StatusBadRequest,
+				Err:        errorf("reading multipart body: %v", err),
+			}
+		}
+		name := part.FileName()
+		if name == "" {
+			name = part.FormName()
+		}
+		items = append(items, batchItem{
+			Name:        name,
+			ContentType: part.Header.Get("Content-Type"),
+			Body:        string(body),
+			Signature:   part.Header.Get("X-Signature"),
+			encryption:  part.Header.Get("X-Encryption"),
+		})
+	}
+	return items, nil
+}
+
+// readBatchLines returns the configs on the lines of the NDJSON
+// body. A line that isn't a batchItem is an item that failed.
+func readBatchLines(body io.Reader) ([]batchItem, error) {
+	var items []batchItem
+	scanner := bufio.NewScanner(body)
+	scanner.Buffer(nil, maxBatchLineSize)
+	for scanner.Scan() && len(items) <= maxBatchItems {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		var item batchItem
+		if err := json.Unmarshal(line, &item); err != nil {
+			item.err = caddy.APIError{
+				HTTPStatus: http.StatusBadRequest,
+				Err:        errorf("item %d is not a batch item: %v", len(items)+1, err),
+			}
+		}
+		items = append(items, item)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, caddy.APIError{
+			HTTPStatus: http.StatusBadRequest,
+			Err:        errorf("reading request body: %v", err),
+		}
+	}
+	return items, nil
+}
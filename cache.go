@@ -0,0 +1,165 @@
+package adapt
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/caddyserver/caddy/v2/caddyconfig"
+)
+
+// cacheSize is how many adaptation results are kept.
+const cacheSize = 64
+
+// resultCache holds recent adaptation results, so that adapting the
+// same input again, as reconciliation loops do, skips the adapter.
+var resultCache = newAdaptCache(cacheSize)
+
+// cachedResult is the output of an adapter.
+type cachedResult struct {
+	result   []byte
+	warnings []caddyconfig.Warning
+}
+
+// adaptCache is a least-recently-used cache of adaptation results,
+// keyed by adaptKey.
+type adaptCache struct {
+	mu    sync.Mutex
+	max   int
+	order *list.List // of *cacheEntry, most recently used first
+	items map[string]*list.Element
+}
+
+type cacheEntry struct {
+	key string
+	val cachedResult
+}
+
+func newAdaptCache(max int) *adaptCache {
+	return &adaptCache{
+		max:   max,
+		order: list.New(),
+		items: make(map[string]*list.Element),
+	}
+}
+
+// get returns the result cached under key, if any.
+func (c *adaptCache) get(key string) (cachedResult, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	elem, ok := c.items[key]
+	if !ok {
+		return cachedResult{}, false
+	}
+	c.order.MoveToFront(elem)
+	return elem.Value.(*cacheEntry).val, true
+}
+
+// put caches val under key, evicting the least recently
+// used result if the cache is full.
+func (c *adaptCache) put(key string, val cachedResult) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if elem, ok := c.items[key]; ok {
+		elem.Value.(*cacheEntry).val = val
+		c.order.MoveToFront(elem)
+		return
+	}
+	c.items[key] = c.order.PushFront(&cacheEntry{key, val})
+	if c.order.Len() > c.max {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.items, oldest.Value.(*cacheEntry).key)
+	}
+}
+
+// adaptKey identifies the input to an adapter: its name, the
+// options and the config. Equal inputs have equal keys.
+func adaptKey(adapterName string, options map[string]interface{}, body []byte) string {
+	h := sha256.New()
+	h.Write([]byte(adapterName))
+	h.Write([]byte{0})
+	opts, _ := json.Marshal(options) // map keys are sorted
+	h.Write(opts)
+	h.Write([]byte{0})
+	h.Write(body)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// importLine matches the directives by which configs read other files,
+// such as the Caddyfile's import and nginx's include, and what they
+// import, and snippetLine the Caddyfile's snippets, which an import
+// may name instead.
+var (
+	importLine  = regexp.MustCompile(`(?m)^[ \t]*(?:import|include)[ \t]+("[^"\n]*"|[^\s;]+)`)
+	snippetLine = regexp.MustCompile(`(?m)^[ \t]*\(([^)\s]+)\)`)
+)
+
+// readsFiles returns true if adapting body may read files from disk,
+// which neither the cache nor an ETag can tell have changed. Imports
+// of snippets defined in body don't, nor, if it came with the files
+// it includes, imports of relative paths within them.
+func readsFiles(body []byte, included bool) bool {
+	snippets := make(map[string]bool)
+	for _, m := range snippetLine.FindAllSubmatch(body, -1) {
+		snippets[string(m[1])] = true
+	}
+	for _, m := range importLine.FindAllSubmatch(body, -1) {
+		name := strings.Trim(string(m[1]), `"`)
+		if snippets[name] {
+			continue
+		}
+		if included && !filepath.IsAbs(name) && !strings.HasPrefix(filepath.ToSlash(filepath.Clean(name)), "../") {
+			continue
+		}
+		return true
+	}
+	return false
+}
+
+// readsAnyFiles is readsFiles of body and of the files it
+// includes, if it came with any.
+func readsAnyFiles(body []byte, inc *includes) bool {
+	if inc == nil {
+		return readsFiles(body, false)
+	}
+	if readsFiles(body, true) {
+		return true
+	}
+	for _, contents := range inc.files {
+		if readsFiles(contents, true) {
+			return true
+		}
+	}
+	return false
+}
+
+// etag returns the entity tag of a response to an adaptation with
+// the given key, in the representation described by variant. It is
+// weak, since the provenance of equal responses differs.
+func etag(key string, variant ...string) string {
+	sum := sha256.Sum256([]byte(key + "\x00" + strings.Join(variant, "\x00")))
+	return `W/"` + hex.EncodeToString(sum[:16]) + `"`
+}
+
+// etagMatches returns true if the If-None-Match header of r
+// lists tag, using the weak comparison (RFC 7232 section 3.2).
+func etagMatches(r *http.Request, tag string) bool {
+	header := r.Header.Get("If-None-Match")
+	if header == "" {
+		return false
+	}
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == strings.TrimPrefix(tag, "W/") {
+			return true
+		}
+	}
+	return false
+}
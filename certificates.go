@@ -0,0 +1,246 @@
+package adapt
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+)
+
+// certificatesResult is the response body of /adapt/certificates.
+type certificatesResult struct {
+	Valid        bool                `json:"valid"`
+	Certificates []certificateResult `json:"certificates"`
+}
+
+// certificateResult is the outcome of validating one certificate
+// and key pair.
+type certificateResult struct {
+	Certificate string     `json:"certificate"`
+	Key         string     `json:"key"`
+	Valid       bool       `json:"valid"`
+	NotAfter    *time.Time `json:"not_after,omitempty"`
+	Names       []string   `json:"names,omitempty"`
+	Errors      []string   `json:"errors,omitempty"`
+	Warnings    []string   `json:"warnings,omitempty"`
+}
+
+// expiryWarning is how close to expiring a certificate
+// can be before it is warned about.
+const expiryWarning = 14 * 24 * time.Hour
+
+// handleCertificates adapts the config in the request like
+// handleAdapt, then loads the certificate and key files it refers
+// to and reports pairs that don't match, aren't currently valid or
+// don't cover the names they are used for, which would otherwise
+// only be found out when HTTPS breaks after a reload. Only files
+// within the certificate_root are read, and only those of the tls
+// app's load_files; other certificate loaders are left out.
+func (adminAdapt) handleCertificates(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != http.MethodPost {
+		return caddy.APIError{
+			HTTPStatus: http.StatusMethodNotAllowed,
+			Err:        errorf("method not allowed"),
+		}
+	}
+
+	root := settings().CertificateRoot
+	if root == "" {
+		return caddy.APIError{
+			HTTPStatus: http.StatusForbidden,
+			Err:        errorf("checking certificates is disabled; no certificate_root is configured"),
+		}
+	}
+
+	buf := getBuffer()
+	defer putBuffer(buf)
+
+	a, err := adaptRequest(r, buf)
+	if err != nil {
+		return err
+	}
+	var cfg map[string]interface{}
+	if err := json.Unmarshal(a.result, &cfg); err != nil {
+		return caddy.APIError{
+			HTTPStatus: http.StatusBadRequest,
+			Err:        errorf("adapted config is not valid JSON: %v", err),
+		}
+	}
+
+	names := namesByTag(cfg)
+	langs := acceptedLanguages(r.Header.Get("Accept-Language"))
+	valid := true
+	results := []certificateResult{}
+	for _, lf := range loadFiles(cfg) {
+		res := validateCertificate(root, lf, names, time.Now(), langs)
+		valid = valid && res.Valid
+		results = append(results, res)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(w).Encode(certificatesResult{
+		Valid:        valid,
+		Certificates: results,
+	})
+}
+
+// loadFile is an entry of the tls app's certificates.load_files.
+type loadFile struct {
+	Certificate string   `json:"certificate"`
+	Key         string   `json:"key"`
+	Format      string   `json:"format,omitempty"`
+	Tags        []string `json:"tags,omitempty"`
+}
+
+// loadFiles returns the certificate and key files loaded by the
+// tls app in cfg.
+func loadFiles(cfg map[string]interface{}) []loadFile {
+	apps, _ := cfg["apps"].(map[string]interface{})
+	tlsApp, _ := apps["tls"].(map[string]interface{})
+	certs, _ := tlsApp["certificates"].(map[string]interface{})
+	raw, err := json.Marshal(certs["load_files"])
+	if err != nil {
+		return nil
+	}
+	var files []loadFile
+	json.Unmarshal(raw, &files)
+	return files
+}
+
+// namesByTag returns, for each certificate tag that an HTTP server's
+// TLS connection policy selects certificates by, the server names
+// (SNI) the policy applies to. This is how the Caddyfile's tls
+// directive ties a certificate to the site addresses it serves.
+func namesByTag(cfg map[string]interface{}) map[string][]string {
+	names := make(map[string][]string)
+	apps, _ := cfg["apps"].(map[string]interface{})
+	httpApp, _ := apps["http"].(map[string]interface{})
+	servers, _ := httpApp["servers"].(map[string]interface{})
+	for _, srv := range servers {
+		srvObj, _ := srv.(map[string]interface{})
+		policies, _ := srvObj["tls_connection_policies"].([]interface{})
+		for _, p := range policies {
+			policy, _ := p.(map[string]interface{})
+			match, _ := policy["match"].(map[string]interface{})
+			selection, _ := policy["certificate_selection"].(map[string]interface{})
+			sni := stringList(match["sni"])
+			for _, tag := range stringList(selection["any_tag"]) {
+				names[tag] = append(names[tag], sni...)
+			}
+		}
+	}
+	return names
+}
+
+// stringList returns the strings in val, a decoded JSON array.
+func stringList(val interface{}) []string {
+	arr, _ := val.([]interface{})
+	var list []string
+	for _, elem := range arr {
+		if s, ok := elem.(string); ok {
+			list = append(list, s)
+		}
+	}
+	return list
+}
+
+// validateCertificate loads the certificate and key pair in lf from
+// within root and checks that they match, that the certificate is
+// valid at now, and that it covers the names in namesByTag for each
+// of its tags. Its errors and warnings are in one of langs.
+func validateCertificate(root string, lf loadFile, namesByTag map[string][]string, now time.Time, langs []string) certificateResult {
+	res := certificateResult{Certificate: lf.Certificate, Key: lf.Key}
+	if lf.Format != "" && lf.Format != "pem" {
+		res.Errors = append(res.Errors, messagef("unsupported format: %s", lf.Format).localize(langs))
+		return res
+	}
+
+	certPEM, err := readCertificateFile(root, lf.Certificate)
+	if err != nil {
+		res.Errors = append(res.Errors, localizedText(err, langs))
+		return res
+	}
+	keyPEM, err := readCertificateFile(root, lf.Key)
+	if err != nil {
+		res.Errors = append(res.Errors, localizedText(err, langs))
+		return res
+	}
+	pair, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		res.Errors = append(res.Errors, localizedText(err, langs))
+		return res
+	}
+	leaf, err := x509.ParseCertificate(pair.Certificate[0])
+	if err != nil {
+		res.Errors = append(res.Errors, messagef("parsing certificate: %v", err).localize(langs))
+		return res
+	}
+	res.NotAfter = &leaf.NotAfter
+	res.Names = leaf.DNSNames
+	for _, ip := range leaf.IPAddresses {
+		res.Names = append(res.Names, ip.String())
+	}
+
+	switch {
+	case now.Before(leaf.NotBefore):
+		res.Errors = append(res.Errors, messagef("not valid until %s", leaf.NotBefore.Format(time.RFC3339)).localize(langs))
+	case now.After(leaf.NotAfter):
+		res.Errors = append(res.Errors, messagef("expired at %s", leaf.NotAfter.Format(time.RFC3339)).localize(langs))
+	case leaf.NotAfter.Sub(now) < expiryWarning:
+		res.Warnings = append(res.Warnings, messagef("expires at %s", leaf.NotAfter.Format(time.RFC3339)).localize(langs))
+	}
+
+	seen := make(map[string]bool)
+	for _, tag := range lf.Tags {
+		for _, name := range namesByTag[tag] {
+			if seen[name] {
+				continue
+			}
+			seen[name] = true
+			if !certificateCovers(leaf, name) {
+				res.Errors = append(res.Errors, messagef("does not cover %s", name).localize(langs))
+			}
+		}
+	}
+
+	res.Valid = len(res.Errors) == 0
+	return res
+}
+
+// readCertificateFile reads the file at path, as load_files names
+// it, which must be within root, not even through symlinks. Relative
+// paths are relative to the working directory, as they are to Caddy.
+func readCertificateFile(root, path string) ([]byte, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+	realPath, err := resolveWithin(root, abs)
+	if err == errOutsideRoot {
+		return nil, errorf("%s is outside of the certificate_root", path)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return ioutil.ReadFile(realPath)
+}
+
+// certificateCovers returns true if cert is valid for name, which
+// may itself be a wildcard.
+func certificateCovers(cert *x509.Certificate, name string) bool {
+	if strings.HasPrefix(name, "*.") {
+		for _, dnsName := range cert.DNSNames {
+			if strings.EqualFold(dnsName, name) {
+				return true
+			}
+		}
+		return false
+	}
+	return cert.VerifyHostname(name) == nil
+}
@@ -0,0 +1,80 @@
+package adapt
+
+import (
+	"bytes"
+	"encoding/binary"
+	"mime"
+	"net/http"
+	"strings"
+	"unicode/utf16"
+
+	"github.com/caddyserver/caddy/v2"
+)
+
+// Byte order marks, which tools on Windows in particular
+// like to start text files with.
+var (
+	utf8BOM    = []byte{0xEF, 0xBB, 0xBF}
+	utf16BEBOM = []byte{0xFE, 0xFF}
+	utf16LEBOM = []byte{0xFF, 0xFE}
+)
+
+// toUTF8 returns body, whose media type is contentType, as UTF-8
+// without a byte order mark, which is what adapters expect. It is
+// decoded from the charset parameter of contentType if there is
+// one, or else as the byte order mark says; otherwise it is
+// assumed to be UTF-8 already.
+func toUTF8(body []byte, contentType string) ([]byte, error) {
+	var charset string
+	if contentType != "" {
+		if _, params, err := mime.ParseMediaType(contentType); err == nil {
+			charset = strings.ToLower(params["charset"])
+		}
+	}
+
+	switch {
+	case bytes.HasPrefix(body, utf8BOM) && (charset == "" || charset == "utf-8"):
+		return body[len(utf8BOM):], nil
+	case bytes.HasPrefix(body, utf16BEBOM) && (charset == "" || charset == "utf-16" || charset == "utf-16be"):
+		return decodeUTF16(body[len(utf16BEBOM):], binary.BigEndian)
+	case bytes.HasPrefix(body, utf16LEBOM) && (charset == "" || charset == "utf-16" || charset == "utf-16le"):
+		return decodeUTF16(body[len(utf16LEBOM):], binary.LittleEndian)
+	}
+
+	switch charset {
+	case "", "utf-8", "utf8", "us-ascii":
+		return body, nil
+	case "utf-16", "utf-16be":
+		// big-endian unless a byte order mark says otherwise (RFC 2781)
+		return decodeUTF16(body, binary.BigEndian)
+	case "utf-16le":
+		return decodeUTF16(body, binary.LittleEndian)
+	case "iso-8859-1", "latin1":
+		// the first 256 code points are ISO 8859-1
+		var b bytes.Buffer
+		for _, c := range body {
+			b.WriteRune(rune(c))
+		}
+		return b.Bytes(), nil
+	}
+	return nil, caddy.APIError{
+		HTTPStatus: http.StatusUnsupportedMediaType,
+		Err:        errorf("unsupported charset: %s (use utf-8, utf-16 or iso-8859-1)", charset),
+	}
+}
+
+// decodeUTF16 returns body, which is UTF-16 in the given
+// byte order, as UTF-8.
+func decodeUTF16(body []byte, order binary.ByteOrder) ([]byte, error) {
+	if len(body)%2 != 0 {
+		return nil, caddy.APIError{
+			HTTPStatus: http.StatusBadRequest,
+			Err:        errorf("invalid UTF-16: odd number of bytes"),
+		}
+	}
+	units := make([]uint16, len(body)/2)
+	for i := range units {
+		units[i] = order.Uint16(body[2*i:])
+	}
+	return []byte(string(utf16.Decode(units))), nil
+}
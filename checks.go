@@ -0,0 +1,177 @@
+package adapt
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig"
+)
+
+// adaptChecks are what is checked of a config, and done to it, on
+// its way to and from its adapter. Every way of adapting a config
+// that was sent goes through them, so none is a way around
+// require_signature, strict mode or the error_on warning rules.
+type adaptChecks struct {
+	signature   string // its X-Signature, over it as it was sent
+	encryption  string // its X-Encryption
+	contentType string // its media type, which may give its charset
+	decoded     bool   // it is known to be UTF-8 already
+	env         bool   // environment variables are expanded in it
+	template    bool   // it is rendered as a template
+	values      []byte // the values it is rendered with
+	strict      bool   // any warning is an error
+	pinned      caddyVersion
+	pinning     bool     // it is checked against the pinned version
+	partial     bool     // it is part of a config, transformed once whole
+	langs       []string // what warnings are localized into
+}
+
+// requestChecks returns the checks that r asks for in its query,
+// on top of the settings. The config's own signature, encryption
+// and media type are up to the caller, as a request may have
+// more than one config.
+func requestChecks(r *http.Request) (adaptChecks, error) {
+	c := adaptChecks{langs: acceptedLanguages(r.Header.Get("Accept-Language"))}
+	var err error
+	if c.env, err = queryBool(r, "env"); err != nil {
+		return c, err
+	}
+	if c.template, err = queryBool(r, "template"); err != nil {
+		return c, err
+	}
+	if c.pinned, c.pinning, err = pinnedVersion(r); err != nil {
+		return c, err
+	}
+	c.strict = settings().StrictWarnings
+	if r.URL.Query().Get("strict") != "" {
+		if c.strict, err = queryBool(r, "strict"); err != nil {
+			return c, err
+		}
+	}
+	return c, nil
+}
+
+// source returns body, a config as it was sent, decrypted, verified,
+// decoded to UTF-8 and expanded as c says, ready to be adapted.
+func (c adaptChecks) source(body []byte) ([]byte, error) {
+	requireSignature := settings().RequireSignature
+	if requireSignature && c.signature == "" {
+		return nil, caddy.APIError{
+			HTTPStatus: http.StatusForbidden,
+			Err:        errorf("config is not signed, and signatures are required"),
+		}
+	}
+
+	body, err := decryptBody(c.encryption, body)
+	if err != nil {
+		return nil, err
+	}
+	// the signature is over the bytes that were signed, which
+	// decoding them to UTF-8 would change, even if only by a BOM
+	if requireSignature {
+		if _, err := verifySignature(c.signature, body); err != nil {
+			return nil, err
+		}
+	}
+
+	// tools on Windows tend to write UTF-16, or a BOM
+	if !c.decoded {
+		body, err = toUTF8(body, c.contentType)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// expanded after verifying the signature, which is over the
+	// config as it was written
+	if c.env {
+		if body, err = expandEnv(body); err != nil {
+			return nil, err
+		}
+	}
+	if c.template {
+		if body, err = renderTemplate(body, c.values); err != nil {
+			return nil, err
+		}
+	}
+	return body, nil
+}
+
+// warnings classifies the warnings from adapting a config to result,
+// adds those about the Caddy version c pins, if any, and returns an
+// error if strict mode or the error_on warning rules make any of
+// them errors.
+func (c adaptChecks) warnings(result []byte, warnings []caddyconfig.Warning) ([]adaptWarning, error) {
+	// classified here rather than cached, since which
+	// warnings are suppressed can change with the config
+	classified := classifyWarnings(warnings)
+	if c.pinning {
+		compat, err := compatWarnings(result, c.pinned)
+		if err != nil {
+			return nil, err
+		}
+		classified = append(classified, compat...)
+	}
+	errs := errorWarnings(classified)
+	localizeWarnings(classified, c.langs)
+	localizeWarnings(errs, c.langs)
+
+	if c.strict && len(classified) > 0 {
+		return nil, caddy.APIError{
+			HTTPStatus: http.StatusUnprocessableEntity,
+			Err: warningsError{
+				message: message{
+					format: "adapting config produced %d warning(s), which are errors in strict mode",
+					args:   []interface{}{len(classified)},
+				},
+				warnings: classified,
+			},
+		}
+	}
+	if len(errs) > 0 {
+		return nil, caddy.APIError{
+			HTTPStatus: http.StatusUnprocessableEntity,
+			Err: warningsError{
+				message: message{
+					format: "adapting config produced %d warning(s) that warning_rules make errors",
+					args:   []interface{}{len(errs)},
+				},
+				warnings: errs,
+			},
+		}
+	}
+	return classified, nil
+}
+
+// adaptChecked adapts body, a config sent to endpoint, with adapter
+// and options, by way of the checks c. Unless it is partial, the
+// result is transformed. Errors from the adapter itself are returned
+// as they are, for the caller to say which config they are about.
+func adaptChecked(ctx context.Context, endpoint string, adapter Adapter, body []byte, options map[string]interface{}, c adaptChecks) ([]byte, []adaptWarning, error) {
+	body, err := c.source(body)
+	if err != nil {
+		return nil, nil, err
+	}
+	result, warnings, err := adaptProfiled(ctx, endpoint, adapter, body, options)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !json.Valid(result) {
+		return nil, nil, caddy.APIError{
+			HTTPStatus: http.StatusBadRequest,
+			Err:        errorf("adapted config is not valid JSON"),
+		}
+	}
+	if !c.partial {
+		if result, err = transformConfig(ctx, result); err != nil {
+			return nil, nil, err
+		}
+	}
+	classified, err := c.warnings(result, warnings)
+	if err != nil {
+		return nil, nil, err
+	}
+	return result, classified, nil
+}
@@ -0,0 +1,155 @@
+package adapt
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+)
+
+// hmacSignature returns the X-Signature for body signed with secret.
+func hmacSignature(secret, body string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(body))
+	return "hmac-sha256=" + base64.StdEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// multipartBody returns a multipart/form-data body with a part for
+// each of the configs, named and adapted with test-echo, and signed
+// with signature, if not empty.
+func multipartBody(signature string, names ...string) (contentType, body string) {
+	var b strings.Builder
+	for _, name := range names {
+		b.WriteString("--B\r\nContent-Disposition: form-data; name=\"" + name + "\"\r\n")
+		b.WriteString("Content-Type: text/test-echo\r\n")
+		if signature != "" {
+			b.WriteString("X-Signature: " + signature + "\r\n")
+		}
+		b.WriteString("\r\nhi\r\n")
+	}
+	b.WriteString("--B--\r\n")
+	return "multipart/form-data; boundary=B", b.String()
+}
+
+func TestRequireSignature(t *testing.T) {
+	startApp(t, &App{
+		HMACSecrets:      []string{"s3cret"},
+		RequireSignature: true,
+		EnvPrefixes:      []string{"ADAPT_TEST_"},
+	})
+	signed := hmacSignature("s3cret", "hi")
+	forged := hmacSignature("guess", "hi")
+
+	for _, tc := range []struct {
+		signature string
+		status    int
+	}{
+		{"", http.StatusForbidden},
+		{forged, http.StatusForbidden},
+		{signed, http.StatusOK},
+	} {
+		w := post("/adapt", "text/test-echo", "hi", "X-Signature", tc.signature)
+		expectStatus(t, w, tc.status)
+	}
+
+	// the signature is over the config as it was sent
+	os.Setenv("ADAPT_TEST_HOST", "example.com")
+	defer os.Unsetenv("ADAPT_TEST_HOST")
+	body := "{env.ADAPT_TEST_HOST}"
+	w := post("/adapt?env=true", "text/test-echo", body, "X-Signature", hmacSignature("s3cret", body))
+	expectStatus(t, w, http.StatusOK)
+	if !strings.Contains(w.Body.String(), "example.com") {
+		t.Fatalf("expected the environment to be expanded, got %s", w.Body)
+	}
+	w = post("/adapt", "text/test-echo", "\xef\xbb\xbfhi", "X-Signature", signed)
+	expectStatus(t, w, http.StatusForbidden)
+}
+
+func TestRequireSignatureRPC(t *testing.T) {
+	startApp(t, &App{HMACSecrets: []string{"s3cret"}, RequireSignature: true})
+	for _, tc := range []struct {
+		signature string
+		fails     bool
+	}{
+		{"", true},
+		{hmacSignature("guess", "hi"), true},
+		{hmacSignature("s3cret", "hi"), false},
+	} {
+		req, _ := json.Marshal(map[string]interface{}{
+			"jsonrpc": "2.0",
+			"id":      1,
+			"method":  "adapt",
+			"params": map[string]interface{}{
+				"body":      "hi",
+				"adapter":   "test-echo",
+				"signature": tc.signature,
+			},
+		})
+		w := post("/adapt/rpc", "application/json", string(req))
+		expectStatus(t, w, http.StatusOK)
+		var resp struct {
+			Error *rpcError `json:"error"`
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+			t.Fatal(err)
+		}
+		if (resp.Error != nil) != tc.fails {
+			t.Fatalf("signature %q: expected failure %t, got %s", tc.signature, tc.fails, w.Body)
+		}
+	}
+}
+
+func TestRequireSignatureBatch(t *testing.T) {
+	startApp(t, &App{HMACSecrets: []string{"s3cret"}, RequireSignature: true})
+	body := `{"name":"unsigned","adapter":"test-echo","body":"hi"}` + "\n" +
+		`{"name":"signed","adapter":"test-echo","body":"hi","signature":"` + hmacSignature("s3cret", "hi") + `"}`
+	w := post("/adapt/batch", "application/x-ndjson", body)
+	expectStatus(t, w, http.StatusOK)
+
+	var results []struct {
+		Name   string `json:"name"`
+		Status int    `json:"status"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &results); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]int{"unsigned": http.StatusForbidden, "signed": http.StatusOK}
+	if len(results) != len(want) {
+		t.Fatalf("expected %d results, got %s", len(want), w.Body)
+	}
+	for _, result := range results {
+		if result.Status != want[result.Name] {
+			t.Fatalf("%s: expected status %d, got %d", result.Name, want[result.Name], result.Status)
+		}
+	}
+}
+
+func TestRequireSignatureMultipart(t *testing.T) {
+	startApp(t, &App{HMACSecrets: []string{"s3cret"}, RequireSignature: true})
+	signed := hmacSignature("s3cret", "hi")
+	for _, tc := range []struct {
+		target string
+		names  []string
+	}{
+		{"/adapt/overlay", []string{"base"}},
+		{"/adapt/diff", []string{"base", "new"}},
+	} {
+		contentType, body := multipartBody("", tc.names...)
+		expectStatus(t, post(tc.target, contentType, body), http.StatusForbidden)
+		contentType, body = multipartBody(signed, tc.names...)
+		expectStatus(t, post(tc.target, contentType, body), http.StatusOK)
+	}
+}
+
+func TestStrictWarnings(t *testing.T) {
+	startApp(t, &App{StrictWarnings: true})
+	expectStatus(t, post("/adapt", "text/test-echo", "hi"), http.StatusUnprocessableEntity)
+	expectStatus(t, post("/adapt?strict=false", "text/test-echo", "hi"), http.StatusOK)
+
+	contentType, body := multipartBody("", "base")
+	expectStatus(t, post("/adapt/overlay", contentType, body), http.StatusUnprocessableEntity)
+}
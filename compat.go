@@ -0,0 +1,225 @@
+package adapt
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig"
+)
+
+// caddyVersion is a Caddy release, as major, minor and patch.
+type caddyVersion [3]int
+
+// parseCaddyVersion parses a Caddy version, such as 2.7, v2.7.4
+// or 2.7.0-beta.1. A missing minor or patch version is 0.
+func parseCaddyVersion(s string) (caddyVersion, error) {
+	var v caddyVersion
+	s = strings.TrimPrefix(s, "v")
+	if idx := strings.IndexAny(s, "-+"); idx >= 0 {
+		s = s[:idx]
+	}
+	parts := strings.Split(s, ".")
+	if len(parts) > 3 {
+		return v, fmt.Errorf("not a version: %s", s)
+	}
+	for i, part := range parts {
+		n, err := strconv.Atoi(part)
+		if err != nil || n < 0 {
+			return v, fmt.Errorf("not a version: %s", s)
+		}
+		v[i] = n
+	}
+	return v, nil
+}
+
+// before returns true if v is an earlier release than other.
+func (v caddyVersion) before(other caddyVersion) bool {
+	for i := range v {
+		if v[i] != other[i] {
+			return v[i] < other[i]
+		}
+	}
+	return false
+}
+
+func (v caddyVersion) String() string {
+	return fmt.Sprintf("%d.%d.%d", v[0], v[1], v[2])
+}
+
+// moduleSince are the releases that added modules to the standard
+// Caddy distribution after 2.0, as far as they are known here. It
+// isn't every one of them, so a module that is missing isn't
+// known to be older.
+var moduleSince = map[string]caddyVersion{
+	"http.handlers.metrics":               {2, 3, 0},
+	"http.handlers.error":                 {2, 4, 0},
+	"http.handlers.tracing":               {2, 5, 0},
+	"http.handlers.copy_response":         {2, 5, 0},
+	"http.handlers.copy_response_headers": {2, 5, 0},
+	"tls.get_certificate.tailscale":       {2, 5, 0},
+	"tls.get_certificate.http":            {2, 5, 0},
+	"http.reverse_proxy.upstreams.srv":    {2, 6, 0},
+	"http.reverse_proxy.upstreams.a":      {2, 6, 0},
+	"http.reverse_proxy.upstreams.multi":  {2, 6, 0},
+	"events":                              {2, 6, 0},
+	"http.handlers.invoke":                {2, 7, 0},
+	"http.matchers.client_ip":             {2, 7, 0},
+	"http.handlers.intercept":             {2, 8, 0},
+}
+
+// moduleInlineKeys are the keys that name the modules in the objects
+// under the given keys, and the namespaces of those modules.
+var moduleInlineKeys = map[string]struct{ key, namespace string }{
+	"transport":         {"protocol", "http.reverse_proxy.transport."},
+	"selection_policy":  {"policy", "http.reverse_proxy.selection_policies."},
+	"dynamic_upstreams": {"source", "http.reverse_proxy.upstreams."},
+	"storage":           {"module", "caddy.storage."},
+	"issuers":           {"module", "tls.issuance."},
+	"get_certificate":   {"via", "tls.get_certificate."},
+	"writer":            {"output", "caddy.logging.writers."},
+	"encoder":           {"format", "caddy.logging.encoders."},
+}
+
+// compatWarnings returns warnings about the modules in cfgJSON that
+// a Caddy of the pinned version might not run: those that were added
+// to Caddy after the pinned version, and otherwise those that this
+// Caddy doesn't have, which may be plugins or newer than it. Modules are found where the
+// standard distribution's configs use them, by the keys that name
+// them, such as "handler" in a route's handlers.
+func compatWarnings(cfgJSON []byte, pinned caddyVersion) ([]adaptWarning, error) {
+	found, err := configModules(cfgJSON)
+	if err != nil {
+		return nil, caddy.APIError{
+			HTTPStatus: http.StatusBadRequest,
+			Err:        err,
+		}
+	}
+	ids := make([]string, 0, len(found))
+	for id := range found {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	warnings := []adaptWarning{}
+	for _, id := range ids {
+		// known to be too new for the pinned version whether or not
+		// this Caddy has it, which an older one wouldn't
+		var w adaptWarning
+		if since, ok := moduleSince[id]; ok && pinned.before(since) {
+			w = adaptWarning{
+				Warning:  caddyconfig.Warning{Directive: id},
+				Code:     "MODULE_TOO_NEW",
+				Severity: severityWarn,
+				msg:      messagef("module %s at %s was added in Caddy %s, after %s", id, found[id], since, pinned),
+			}
+		} else if _, err := caddy.GetModule(id); err != nil {
+			w = adaptWarning{
+				Warning:  caddyconfig.Warning{Directive: id},
+				Code:     "MODULE_NOT_REGISTERED",
+				Severity: severityWarn,
+				msg:      messagef("module %s at %s is not registered in this Caddy build", id, found[id]),
+			}
+		} else {
+			continue
+		}
+		w.Message = w.msg.Error()
+		if !suppressedWarning(w) {
+			warnings = append(warnings, w)
+		}
+	}
+	return warnings, nil
+}
+
+// configModules returns the IDs of the modules in cfgJSON, an
+// adapted config, with where each is first used, by JSON Pointer.
+func configModules(cfgJSON []byte) (map[string]string, error) {
+	dec := json.NewDecoder(bytes.NewReader(cfgJSON))
+	dec.UseNumber()
+	var cfg interface{}
+	if err := dec.Decode(&cfg); err != nil {
+		return nil, errorf("adapted config is not valid JSON: %v", err)
+	}
+	found := make(map[string]string)
+	findModules(cfg, "", "", false, found)
+	return found, nil
+}
+
+// findModules adds the IDs of the modules in val, which is at path
+// in the config, under key (in an array if inArray), to found.
+func findModules(val interface{}, path, key string, inArray bool, found map[string]string) {
+	note := func(id string) {
+		if _, ok := found[id]; !ok {
+			found[id] = path
+		}
+	}
+
+	switch v := val.(type) {
+	case []interface{}:
+		for i, elem := range v {
+			findModules(elem, path+"/"+strconv.Itoa(i), key, true, found)
+		}
+		return
+	case map[string]interface{}:
+		if name, ok := v["handler"].(string); ok {
+			note("http.handlers." + name)
+		}
+		if inline, ok := moduleInlineKeys[key]; ok {
+			if name, ok := v[inline.key].(string); ok {
+				note(inline.namespace + name)
+			}
+		}
+		if strings.HasSuffix(path, "/challenges/dns/provider") {
+			if name, ok := v["name"].(string); ok {
+				note("dns.providers." + name)
+			}
+		}
+
+		// objects whose keys are the names of modules
+		namespace, named := "", true
+		switch {
+		case path == "/apps":
+		case path == "/apps/tls/certificates":
+			namespace = "tls.certificates."
+		case key == "match" && inArray, key == "not":
+			namespace = "http.matchers."
+		case key == "match" && strings.Contains(path, "/connection_policies/"):
+			namespace = "tls.handshake_match."
+		case key == "encodings":
+			namespace = "http.encoders."
+		default:
+			named = false
+		}
+		if named {
+			for name := range v {
+				note(namespace + name)
+			}
+		}
+
+		for _, k := range sortedKeys(v) {
+			findModules(v[k], path+"/"+escapePointer(k), k, false, found)
+		}
+	}
+}
+
+// pinnedVersion returns the Caddy version r pins with ?caddy_version,
+// and false if it doesn't.
+func pinnedVersion(r *http.Request) (caddyVersion, bool, error) {
+	val := r.URL.Query().Get("caddy_version")
+	if val == "" {
+		return caddyVersion{}, false, nil
+	}
+	v, err := parseCaddyVersion(val)
+	if err != nil {
+		return v, false, caddy.APIError{
+			HTTPStatus: http.StatusBadRequest,
+			Err:        errorf("invalid value for caddy_version: %s", val),
+		}
+	}
+	return v, true, nil
+}
@@ -0,0 +1,96 @@
+package adapt
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"mime"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// defaultCompressMinSize is the smallest response that is
+// compressed if compress_min_size isn't set.
+const defaultCompressMinSize = 1024
+
+// encoders maps the content codings that responses can be
+// compressed with to functions that return writers which
+// encode in that coding.
+var encoders = map[string]func(io.Writer) (io.WriteCloser, error){
+	"gzip": func(w io.Writer) (io.WriteCloser, error) {
+		return gzip.NewWriter(w), nil
+	},
+	"zstd": func(w io.Writer) (io.WriteCloser, error) {
+		return zstd.NewWriter(w, zstd.WithEncoderConcurrency(1))
+	},
+}
+
+// negotiateCoding returns the content coding that a response should
+// be compressed with, given the Accept-Encoding header of its request,
+// which is the one the client prefers most of those in encoders, or
+// "" to not compress it.
+func negotiateCoding(acceptEncoding string) string {
+	type weighted struct {
+		coding string
+		q      float64
+	}
+	var accepted []weighted
+	for _, part := range strings.Split(acceptEncoding, ",") {
+		coding, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		q := 1.0
+		if qParam, ok := params["q"]; ok {
+			if q, err = strconv.ParseFloat(qParam, 64); err != nil {
+				continue
+			}
+		}
+		if q > 0 {
+			accepted = append(accepted, weighted{coding, q})
+		}
+	}
+	sort.SliceStable(accepted, func(i, j int) bool { return accepted[i].q > accepted[j].q })
+
+	for _, a := range accepted {
+		switch a.coding {
+		case "identity":
+			return ""
+		case "*":
+			return "gzip"
+		}
+		if _, ok := encoders[a.coding]; ok {
+			return a.coding
+		}
+	}
+	return ""
+}
+
+// compressBody returns body compressed with coding, if it is at
+// least as large as the compress_min_size setting, along with
+// whether it was.
+func compressBody(body []byte, coding string) ([]byte, bool, error) {
+	minSize := settings().CompressMinSize
+	if minSize == 0 {
+		minSize = defaultCompressMinSize
+	}
+	if coding == "" || int64(len(body)) < minSize {
+		return body, false, nil
+	}
+
+	var buf bytes.Buffer
+	enc, err := encoders[coding](&buf)
+	if err != nil {
+		return nil, false, err
+	}
+	if _, err := enc.Write(body); err != nil {
+		return nil, false, err
+	}
+	if err := enc.Close(); err != nil {
+		return nil, false, err
+	}
+	return buf.Bytes(), true, nil
+}
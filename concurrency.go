@@ -0,0 +1,134 @@
+package adapt
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+)
+
+// withTimeout wraps h so that, if a timeout is configured, the
+// context of its request ends after that long, at which point it
+// stops waiting for adapters and fails with 504.
+func withTimeout(h caddy.AdminHandler) caddy.AdminHandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		timeout := time.Duration(settings().Timeout)
+		if timeout <= 0 {
+			return h.ServeHTTP(w, r)
+		}
+		ctx, cancel := context.WithTimeout(r.Context(), timeout)
+		defer cancel()
+		return h.ServeHTTP(w, r.WithContext(ctx))
+	}
+}
+
+// acquireAdaptSlot takes one of the max_concurrent_adaptations
+// slots for the request with the given context, and returns a
+// function that gives it back. If none are free, requests fail
+// with 429, while async jobs wait for one.
+func acquireAdaptSlot(ctx context.Context) (func(), error) {
+	slots := settings().adaptSlots
+	if slots == nil {
+		return func() {}, nil
+	}
+	release := func() { <-slots }
+
+	select {
+	case slots <- struct{}{}:
+		return release, nil
+	default:
+	}
+	if jobID(ctx) == "" {
+		return nil, caddy.APIError{
+			HTTPStatus: http.StatusTooManyRequests,
+			Err:        errorf("too many configs are being adapted; the maximum is %d at a time", cap(slots)),
+		}
+	}
+	select {
+	case slots <- struct{}{}:
+		return release, nil
+	case <-ctx.Done():
+		return nil, contextError(ctx)
+	}
+}
+
+// statusClientClosedRequest is the status, borrowed from nginx,
+// that requests whose clients went away are recorded with.
+const statusClientClosedRequest = 499
+
+// contextError returns the error for a request whose
+// context ended before its config was adapted.
+func contextError(ctx context.Context) error {
+	if ctx.Err() == context.DeadlineExceeded {
+		timeout := time.Duration(settings().Timeout)
+		if jobID(ctx) != "" {
+			timeout = jobTimeout()
+		}
+		return caddy.APIError{
+			HTTPStatus: http.StatusGatewayTimeout,
+			Err:        errorf("adapting config took longer than the timeout of %s", timeout),
+		}
+	}
+	return caddy.APIError{
+		HTTPStatus: statusClientClosedRequest,
+		Err:        errorf("request canceled before its config was adapted"),
+	}
+}
+
+// contextBodyChunk is the most of a body that contextBody
+// reads at a time.
+const contextBodyChunk = 32 << 10
+
+// contextBody is a request body that stops being read once the
+// context of its request ends, even in the middle of a read that
+// is waiting for a slow client. Such a read is left to finish in
+// the background, into a buffer of its own, and the body isn't
+// read from again.
+type contextBody struct {
+	io.ReadCloser
+	ctx context.Context
+	buf []byte
+}
+
+// newContextBody returns body, read as part of the request with
+// the given context, as a contextBody.
+func newContextBody(body io.ReadCloser, ctx context.Context) *contextBody {
+	return &contextBody{ReadCloser: body, ctx: ctx}
+}
+
+func (cb *contextBody) Read(p []byte) (int, error) {
+	if cb.ctx.Err() != nil {
+		return 0, contextError(cb.ctx)
+	}
+	if cb.ctx.Done() == nil {
+		// the context never ends
+		return cb.ReadCloser.Read(p)
+	}
+	if cb.buf == nil {
+		cb.buf = make([]byte, contextBodyChunk)
+	}
+	chunk := cb.buf
+	if len(p) < len(chunk) {
+		chunk = chunk[:len(p)]
+	}
+
+	type outcome struct {
+		n   int
+		err error
+	}
+	done := make(chan outcome, 1)
+	go func() {
+		n, err := cb.ReadCloser.Read(chunk)
+		done <- outcome{n, err}
+	}()
+	select {
+	case out := <-done:
+		return copy(p, chunk[:out.n]), out.err
+	case <-cb.ctx.Done():
+		// the read still owns buf
+		cb.buf = nil
+		return 0, contextError(cb.ctx)
+	}
+}
@@ -0,0 +1,109 @@
+package adapt
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+)
+
+// CORS configures cross-origin resource sharing for the /adapt
+// endpoints, so that browser-based clients, such as config editors,
+// can call them directly. It is in addition to the admin endpoint's
+// own origin checks (its "origins" and "enforce_origin"), which
+// still apply.
+type CORS struct {
+	// The origins that may make requests, such as
+	// "https://editor.example.com", or "*" for any.
+	AllowedOrigins []string `json:"allowed_origins,omitempty"`
+
+	// The request headers that may be sent. Default: the headers
+	// that the /adapt endpoints read.
+	AllowedHeaders []string `json:"allowed_headers,omitempty"`
+
+	// The methods that may be used. Default: GET, POST,
+	// PUT, PATCH and DELETE.
+	AllowedMethods []string `json:"allowed_methods,omitempty"`
+
+	// How long browsers may cache the response to a preflight
+	// request. Default: 0 (the browser's default)
+	MaxAge caddy.Duration `json:"max_age,omitempty"`
+}
+
+var (
+	defaultCORSHeaders = []string{
+		"Authorization", "Cache-Control", "Content-Encoding", "Content-Type",
+		"If-Match", "If-None-Match", "X-Adapt-Options", "X-Adapter-Chain", "X-Encryption",
+		"X-Filename", "X-Request-ID", "X-Signature",
+	}
+	defaultCORSMethods = []string{"GET", "POST", "PUT", "PATCH", "DELETE"}
+
+	// corsExposedHeaders are the response headers
+	// that browsers let cross-origin clients read.
+	corsExposedHeaders = []string{
+		"ETag", "X-Adapt-Module-Version", "X-Adapt-Requester", "X-Adapt-Source-Sha256",
+		"X-Adapt-Timestamp", "X-Adapter", "X-Caddy-Version", "X-Request-ID",
+		"X-Signature", "X-Signature-Key", "Location", "X-Adapt-Duration-Ms",
+		"X-Input-Bytes", "X-Output-Bytes", "X-Warning-Count", "X-Adapt-Source-File",
+	}
+)
+
+// withCORS wraps h so that OPTIONS requests are answered, as CORS
+// preflight requests if they are, and responses to allowed origins
+// carry the CORS headers.
+func withCORS(h caddy.AdminHandler) caddy.AdminHandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		cors := settings().CORS
+		origin := r.Header.Get("Origin")
+		allowed := cors != nil && origin != "" && cors.originAllowed(origin)
+		if allowed {
+			w.Header().Set("Access-Control-Allow-Origin", origin)
+			w.Header().Set("Access-Control-Expose-Headers", strings.Join(corsExposedHeaders, ", "))
+		}
+		if cors != nil {
+			w.Header().Add("Vary", "Origin")
+		}
+
+		if r.Method != http.MethodOptions {
+			return h.ServeHTTP(w, r)
+		}
+
+		methods := defaultCORSMethods
+		if cors != nil && len(cors.AllowedMethods) > 0 {
+			methods = cors.AllowedMethods
+		}
+		w.Header().Set("Allow", strings.Join(append([]string{http.MethodOptions}, methods...), ", "))
+
+		if r.Header.Get("Access-Control-Request-Method") != "" {
+			if !allowed {
+				return caddy.APIError{
+					HTTPStatus: http.StatusForbidden,
+					Err:        errorf("origin %s is not allowed", origin),
+				}
+			}
+			headers := defaultCORSHeaders
+			if len(cors.AllowedHeaders) > 0 {
+				headers = cors.AllowedHeaders
+			}
+			w.Header().Set("Access-Control-Allow-Methods", strings.Join(methods, ", "))
+			w.Header().Set("Access-Control-Allow-Headers", strings.Join(headers, ", "))
+			if cors.MaxAge > 0 {
+				w.Header().Set("Access-Control-Max-Age", strconv.Itoa(int(time.Duration(cors.MaxAge).Seconds())))
+			}
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return nil
+	}
+}
+
+// originAllowed returns true if origin may make requests.
+func (c *CORS) originAllowed(origin string) bool {
+	for _, allowed := range c.AllowedOrigins {
+		if allowed == "*" || strings.EqualFold(allowed, origin) {
+			return true
+		}
+	}
+	return false
+}
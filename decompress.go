@@ -0,0 +1,80 @@
+package adapt
+
+import (
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"github.com/andybalholm/brotli"
+	"github.com/caddyserver/caddy/v2"
+	"github.com/klauspost/compress/zstd"
+)
+
+// decompressBody wraps h so that request bodies sent with a
+// Content-Encoding are decompressed before h reads them. Any
+// limit on the body size applies to it once decompressed.
+func decompressBody(h caddy.AdminHandlerFunc) caddy.AdminHandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		header := r.Header.Get("Content-Encoding")
+		if header == "" {
+			return h(w, r)
+		}
+		codings := strings.Split(header, ",")
+
+		// codings are listed in the order they were applied,
+		// so they are undone from last to first
+		body := r.Body
+		for i := len(codings) - 1; i >= 0; i-- {
+			coding := strings.ToLower(strings.TrimSpace(codings[i]))
+			if coding == "identity" {
+				continue
+			}
+			decode, ok := decoders[coding]
+			if !ok {
+				w.Header().Set("Accept-Encoding", "gzip, zstd, br")
+				return caddy.APIError{
+					HTTPStatus: http.StatusUnsupportedMediaType,
+					Err:        errorf("unsupported Content-Encoding: %s", coding),
+				}
+			}
+			decoded, err := decode(body)
+			if err != nil {
+				return caddy.APIError{
+					HTTPStatus: http.StatusBadRequest,
+					Err:        errorf("decoding %s request body: %v", coding, err),
+				}
+			}
+			defer decoded.Close()
+			body = decoded
+		}
+
+		r.Body = body
+		r.Header.Del("Content-Encoding")
+		r.Header.Del("Content-Length")
+		r.ContentLength = -1
+		return h(w, r)
+	}
+}
+
+// decoders maps content codings to functions that return
+// readers which decode bodies in that coding.
+var decoders = map[string]func(io.ReadCloser) (io.ReadCloser, error){
+	"gzip": func(body io.ReadCloser) (io.ReadCloser, error) {
+		return gzip.NewReader(body)
+	},
+	"x-gzip": func(body io.ReadCloser) (io.ReadCloser, error) {
+		return gzip.NewReader(body)
+	},
+	"zstd": func(body io.ReadCloser) (io.ReadCloser, error) {
+		dec, err := zstd.NewReader(body, zstd.WithDecoderConcurrency(1))
+		if err != nil {
+			return nil, err
+		}
+		return dec.IOReadCloser(), nil
+	},
+	"br": func(body io.ReadCloser) (io.ReadCloser, error) {
+		return ioutil.NopCloser(brotli.NewReader(body)), nil
+	},
+}
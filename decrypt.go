@@ -0,0 +1,129 @@
+package adapt
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/base64"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+
+	"filippo.io/age"
+	"filippo.io/age/armor"
+	"github.com/caddyserver/caddy/v2"
+)
+
+// decryptBody decrypts body as specified by the value of the
+// X-Encryption header, which may be empty for plaintext bodies.
+// With "aes-gcm", the body is a 12-byte nonce followed by the
+// ciphertext and tag, sealed with the configured AES key and no
+// additional data. With "age", it is an age file, armored or not,
+// for one of the configured age identities.
+func decryptBody(scheme string, body []byte) ([]byte, error) {
+	if err := checkEncryption(scheme); err != nil {
+		return nil, err
+	}
+	switch strings.ToLower(scheme) {
+	case "aes-gcm":
+		aead := settings().aead
+		if len(body) < aead.NonceSize()+aead.Overhead() {
+			return nil, caddy.APIError{
+				HTTPStatus: http.StatusBadRequest,
+				Err:        errorf("encrypted body is too short"),
+			}
+		}
+		nonce, ciphertext := body[:aead.NonceSize()], body[aead.NonceSize():]
+		plaintext, err := aead.Open(nil, nonce, ciphertext, nil)
+		if err != nil {
+			return nil, caddy.APIError{
+				HTTPStatus: http.StatusBadRequest,
+				Err:        errorf("decrypting body: %v", err),
+			}
+		}
+		return plaintext, nil
+	case "age":
+		var src io.Reader = bytes.NewReader(body)
+		if bytes.HasPrefix(bytes.TrimSpace(body), []byte(armor.Header)) {
+			src = armor.NewReader(bytes.NewReader(bytes.TrimSpace(body)))
+		}
+		r, err := age.Decrypt(src, settings().ageIdentities...)
+		if err == nil {
+			body, err = ioutil.ReadAll(r)
+		}
+		if err != nil {
+			return nil, caddy.APIError{
+				HTTPStatus: http.StatusBadRequest,
+				Err:        errorf("decrypting body: %v", err),
+			}
+		}
+		return body, nil
+	}
+	return body, nil
+}
+
+// checkEncryption returns an error if bodies encrypted as the value
+// of the X-Encryption header says can't be decrypted, so that they
+// can be rejected before they are read.
+func checkEncryption(scheme string) error {
+	switch strings.ToLower(scheme) {
+	case "":
+		return nil
+	case "aes-gcm":
+		if settings().aead == nil {
+			return caddy.APIError{
+				HTTPStatus: http.StatusBadRequest,
+				Err:        errorf("encrypted bodies are not accepted; no decryption_key_file is configured"),
+			}
+		}
+		return nil
+	case "age":
+		if len(settings().ageIdentities) == 0 {
+			return caddy.APIError{
+				HTTPStatus: http.StatusBadRequest,
+				Err:        errorf("age encrypted bodies are not accepted; no age_identity_file is configured"),
+			}
+		}
+		return nil
+	}
+	return caddy.APIError{
+		HTTPStatus: http.StatusBadRequest,
+		Err:        errorf("unsupported X-Encryption '%s'", scheme),
+	}
+}
+
+// loadAEAD loads a base64-encoded AES-128, AES-192 or AES-256 key
+// from the given file and returns an AES-GCM cipher using it.
+func loadAEAD(filename string) (cipher.AEAD, error) {
+	encoded, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	return newAEAD(string(encoded))
+}
+
+// loadAgeIdentities loads the age identities in the given file.
+func loadAgeIdentities(filename string) ([]age.Identity, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return age.ParseIdentities(f)
+}
+
+// newAEAD returns an AES-GCM cipher using the base64-encoded
+// AES-128, AES-192 or AES-256 key encoded.
+func newAEAD(encoded string) (cipher.AEAD, error) {
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
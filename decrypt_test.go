@@ -0,0 +1,65 @@
+package adapt
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"filippo.io/age"
+	"filippo.io/age/armor"
+)
+
+func TestDecryptAge(t *testing.T) {
+	identity, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir, err := ioutil.TempDir("", "adapt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "identity")
+	if err := ioutil.WriteFile(file, []byte(identity.String()+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	encrypt := func(armored bool) string {
+		var buf bytes.Buffer
+		var dst io.WriteCloser = nopWriteCloser{&buf}
+		if armored {
+			dst = armor.NewWriter(&buf)
+		}
+		w, err := age.Encrypt(dst, identity.Recipient())
+		if err != nil {
+			t.Fatal(err)
+		}
+		io.WriteString(w, "hi")
+		w.Close()
+		dst.Close()
+		return buf.String()
+	}
+
+	// not accepted until there are identities to decrypt with
+	startApp(t, &App{})
+	expectStatus(t, post("/adapt", "text/test-echo", encrypt(false), "X-Encryption", "age"), http.StatusBadRequest)
+
+	startApp(t, &App{AgeIdentityFile: file})
+	for _, armored := range []bool{false, true} {
+		w := post("/adapt", "text/test-echo", encrypt(armored), "X-Encryption", "age")
+		expectStatus(t, w, http.StatusOK)
+		if !strings.Contains(w.Body.String(), `"body":"hi"`) {
+			t.Fatalf("expected the decrypted config, got %s", w.Body)
+		}
+	}
+	expectStatus(t, post("/adapt", "text/test-echo", "hi", "X-Encryption", "age"), http.StatusBadRequest)
+}
+
+type nopWriteCloser struct{ io.Writer }
+
+func (nopWriteCloser) Close() error { return nil }
@@ -0,0 +1,127 @@
+package adapt
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// DialPolicy limits the addresses that adapting a config may connect
+// to, for ?source URLs and by /adapt/upstreams, so that requests
+// can't use them to reach into the network Caddy is in. Addresses
+// are checked as they are connected to, once resolved, so a name
+// that resolves to a denied address the next time gets no further.
+type DialPolicy struct {
+	// CIDR ranges that may be dialed, even though loopback, private,
+	// link-local (which has cloud metadata endpoints), multicast and
+	// other non-public addresses are denied by default.
+	Allow []string `json:"allow,omitempty"`
+
+	// Whether /adapt/upstreams may dial unix sockets.
+	AllowUnix bool `json:"allow_unix,omitempty"`
+
+	allow []*net.IPNet
+}
+
+// deniedNetworks are the address ranges that aren't dialed, unless
+// the dial policy allows them.
+var deniedNetworks = parseCIDRs(
+	"0.0.0.0/8",      // this network
+	"10.0.0.0/8",     // private
+	"100.64.0.0/10",  // shared address space, and some metadata endpoints
+	"127.0.0.0/8",    // loopback
+	"169.254.0.0/16", // link-local, and most metadata endpoints
+	"172.16.0.0/12",  // private
+	"192.0.0.0/24",   // protocol assignments
+	"192.168.0.0/16", // private
+	"198.18.0.0/15",  // benchmarking
+	"224.0.0.0/4",    // multicast
+	"240.0.0.0/4",    // reserved, and broadcast
+	"::/128",         // unspecified
+	"::1/128",        // loopback
+	"64:ff9b::/96",   // IPv4 translation, which could reach any of the above
+	"fc00::/7",       // unique local, and some metadata endpoints
+	"fe80::/10",      // link-local
+	"ff00::/8",       // multicast
+)
+
+// parseCIDRs parses CIDR ranges that are known to be valid.
+func parseCIDRs(cidrs ...string) []*net.IPNet {
+	nets := make([]*net.IPNet, len(cidrs))
+	for i, cidr := range cidrs {
+		_, ipNet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			panic(err)
+		}
+		nets[i] = ipNet
+	}
+	return nets
+}
+
+// provision parses p's settings.
+func (p *DialPolicy) provision() error {
+	for _, cidr := range p.Allow {
+		if !strings.Contains(cidr, "/") {
+			// a single address
+			if ip := net.ParseIP(cidr); ip != nil && ip.To4() != nil {
+				cidr += "/32"
+			} else {
+				cidr += "/128"
+			}
+		}
+		_, ipNet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return fmt.Errorf("allow: %v", err)
+		}
+		p.allow = append(p.allow, ipNet)
+	}
+	return nil
+}
+
+// checkDial returns an error if p doesn't allow connecting to address
+// over network. A nil policy allows only public addresses.
+func (p *DialPolicy) checkDial(network, address string) error {
+	if strings.HasPrefix(network, "unix") {
+		if p == nil || !p.AllowUnix {
+			return errorf("dialing unix socket %s is not allowed; the dial_policy doesn't allow_unix", address)
+		}
+		return nil
+	}
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		host = address
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return errorf("dialing %s is not allowed; it is not an IP address", address)
+	}
+	if p != nil {
+		for _, ipNet := range p.allow {
+			if ipNet.Contains(ip) {
+				return nil
+			}
+		}
+	}
+	for _, ipNet := range deniedNetworks {
+		if ipNet.Contains(ip) {
+			return errorf("dialing %s is not allowed; it is not a public address, and the dial_policy doesn't allow it", ip)
+		}
+	}
+	return nil
+}
+
+// newDialer returns a dialer that only connects to the addresses
+// the dial policy allows, giving up after timeout, if not zero.
+func newDialer(timeout time.Duration) *net.Dialer {
+	policy := settings().DialPolicy
+	return &net.Dialer{
+		Timeout: timeout,
+		// called with the address actually being connected to,
+		// for each one a name resolves to
+		Control: func(network, address string, _ syscall.RawConn) error {
+			return policy.checkDial(network, address)
+		},
+	}
+}
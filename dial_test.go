@@ -0,0 +1,92 @@
+package adapt
+
+import (
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCheckDial(t *testing.T) {
+	allowed := &DialPolicy{Allow: []string{"10.1.0.0/16", "fd00::1"}}
+	if err := allowed.provision(); err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range []struct {
+		policy  *DialPolicy
+		network string
+		address string
+		allowed bool
+	}{
+		{nil, "tcp", "93.184.216.34:80", true},
+		{nil, "tcp6", "[2606:2800:220:1::1]:443", true},
+		{nil, "tcp", "127.0.0.1:2019", false},
+		{nil, "tcp", "127.8.8.8:80", false},
+		{nil, "tcp6", "[::1]:2019", false},
+		{nil, "tcp", "[::ffff:127.0.0.1]:80", false},
+		{nil, "tcp", "169.254.169.254:80", false},
+		{nil, "tcp6", "[fe80::1]:80", false},
+		{nil, "tcp", "10.0.0.1:80", false},
+		{nil, "tcp", "172.16.0.1:80", false},
+		{nil, "tcp", "192.168.1.1:80", false},
+		{nil, "tcp", "100.100.100.200:80", false},
+		{nil, "tcp6", "[fd00::1]:80", false},
+		{nil, "tcp6", "[64:ff9b::7f00:1]:80", false},
+		{nil, "tcp", "0.0.0.0:80", false},
+		{nil, "tcp", "localhost:80", false},
+		{nil, "unix", "/run/app.sock", false},
+		{allowed, "tcp", "10.1.2.3:80", true},
+		{allowed, "tcp", "10.2.0.1:80", false},
+		{allowed, "tcp6", "[fd00::1]:80", true},
+		{allowed, "tcp6", "[fd00::2]:80", false},
+		{allowed, "unix", "/run/app.sock", false},
+		{&DialPolicy{AllowUnix: true}, "unix", "/run/app.sock", true},
+	} {
+		err := test.policy.checkDial(test.network, test.address)
+		if test.allowed && err != nil {
+			t.Errorf("%s %s: expected it to be allowed, got %v", test.network, test.address, err)
+		}
+		if !test.allowed && (err == nil || !strings.Contains(err.Error(), "not allowed")) {
+			t.Errorf("%s %s: expected it to be denied, got %v", test.network, test.address, err)
+		}
+	}
+}
+
+func TestDialPolicyInvalidAllow(t *testing.T) {
+	if err := (&DialPolicy{Allow: []string{"10.0.0.0/33"}}).provision(); err == nil {
+		t.Fatal("expected an invalid CIDR range to be rejected")
+	}
+}
+
+func TestDialerChecksResolvedAddresses(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			conn.Close()
+		}
+	}()
+	_, port, _ := net.SplitHostPort(ln.Addr().String())
+
+	// a name that resolves to a loopback address
+	// is denied once it is resolved
+	startApp(t, &App{})
+	_, err = newDialer(time.Second).Dial("tcp", net.JoinHostPort("localhost", port))
+	if err == nil || !strings.Contains(err.Error(), "not allowed") {
+		t.Fatalf("expected dialing localhost to be denied, got %v", err)
+	}
+
+	startApp(t, &App{DialPolicy: &DialPolicy{Allow: []string{"127.0.0.0/8", "::1"}}})
+	conn, err := newDialer(time.Second).Dial("tcp4", net.JoinHostPort("localhost", port))
+	if err != nil {
+		t.Fatalf("expected dialing localhost to be allowed, got %v", err)
+	}
+	conn.Close()
+}
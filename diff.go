@@ -0,0 +1,423 @@
+package adapt
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/caddyserver/caddy/v2"
+)
+
+// patchOp is a JSON Patch (RFC 6902) operation.
+type patchOp struct {
+	Op    string      `json:"op"`
+	Path  string      `json:"path"`
+	Value interface{} `json:"value"`
+}
+
+// Formats of the responses of /adapt/diff.
+const (
+	diffJSONPatch  = "json-patch"
+	diffMergePatch = "merge-patch"
+	diffUnified    = "unified"
+)
+
+// MarshalJSON encodes op, leaving out the value of a "remove",
+// which has none. Other operations keep it even if it is null.
+func (op patchOp) MarshalJSON() ([]byte, error) {
+	if op.Op == "remove" {
+		return json.Marshal(struct {
+			Op   string `json:"op"`
+			Path string `json:"path"`
+		}{op.Op, op.Path})
+	}
+	type plain patchOp
+	return json.Marshal(plain(op))
+}
+
+// handleDiff adapts the config in the request like handleAdapt, and
+// responds with the JSON Patch that turns the running config into the
+// result, which is what loading it would change, or, with ?base, the
+// config of the snapshot with that name or hash. If the request is
+// multipart with a base and a new config, it instead compares those,
+// each adapted per its own Content-Type. With ?format=merge-patch, the
+// response is a JSON Merge Patch instead, and with ?format=unified (or
+// ?unified=true) a unified diff of the two as indented JSON.
+func (adminAdapt) handleDiff(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != http.MethodPost {
+		return caddy.APIError{
+			HTTPStatus: http.StatusMethodNotAllowed,
+			Err:        errorf("method not allowed"),
+		}
+	}
+	format, err := diffFormat(r)
+	if err != nil {
+		return err
+	}
+
+	buf := getBuffer()
+	defer putBuffer(buf)
+
+	var from, to interface{}
+	fromName, toName := "running", "adapted"
+	if isMultipart(r.Header.Get("Content-Type")) {
+		if _, err := buf.ReadFrom(r.Body); err != nil {
+			return caddy.APIError{
+				HTTPStatus: http.StatusBadRequest,
+				Err:        errorf("reading request body: %v", err),
+			}
+		}
+		parts, err := readDiffParts(r, buf.Bytes())
+		if err != nil {
+			return err
+		}
+		if parts != nil && r.URL.Query().Get("base") != "" {
+			return caddy.APIError{
+				HTTPStatus: http.StatusBadRequest,
+				Err:        errorf("?base can't be used with a base config in the body"),
+			}
+		}
+		if parts != nil {
+			return respondDiff(w, r, parts, format, buf.Bytes())
+		}
+
+		// the config and the files it imports, for adaptRequest
+		r.Body = ioutil.NopCloser(bytes.NewReader(buf.Bytes()))
+		buf = getBuffer()
+		defer putBuffer(buf)
+	}
+
+	a, err := adaptRequest(r, buf)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(a.result, &to); err != nil {
+		return caddy.APIError{
+			HTTPStatus: http.StatusBadRequest,
+			Err:        errorf("adapted config is not valid JSON: %v", err),
+		}
+	}
+
+	if ref := r.URL.Query().Get("base"); ref != "" {
+		snap, err := findSnapshot(ref)
+		if err != nil {
+			return err
+		}
+		if err := json.Unmarshal(snap.Config, &from); err != nil {
+			return errorf("snapshot %s is not valid JSON: %v", snap.Name, err)
+		}
+		fromName = snap.Name
+	} else {
+		runningJSON, err := runningConfig(r)
+		if err != nil {
+			return err
+		}
+		if err := json.Unmarshal(runningJSON, &from); err != nil {
+			return errorf("running config is not valid JSON: %v", err)
+		}
+	}
+
+	newProvenance(r, a.adapter, a.source).setHeaders(w.Header())
+	return writeDiff(w, format, fromName, toName, from, to)
+}
+
+// diffFormat returns the format that the response to r, a request
+// to /adapt/diff, is asked for in, by ?format or ?unified.
+func diffFormat(r *http.Request) (string, error) {
+	unified, err := queryBool(r, "unified")
+	if err != nil {
+		return "", err
+	}
+	format := r.URL.Query().Get("format")
+	switch {
+	case format == "" && unified:
+		return diffUnified, nil
+	case format == "":
+		return diffJSONPatch, nil
+	case unified && format != diffUnified:
+		return "", caddy.APIError{
+			HTTPStatus: http.StatusBadRequest,
+			Err:        errorf("?unified=true conflicts with ?format=%s", format),
+		}
+	}
+	switch format {
+	case diffJSONPatch, diffMergePatch, diffUnified:
+		return format, nil
+	}
+	return "", caddy.APIError{
+		HTTPStatus: http.StatusBadRequest,
+		Err:        errorf("invalid value for format: %s; it must be json-patch, merge-patch or unified", format),
+	}
+}
+
+// diffPart is the base or new config of a request
+// to /adapt/diff that compares two configs.
+type diffPart struct {
+	name    string // its file name, else base or new
+	adapter string
+	config  interface{}
+}
+
+// readDiffParts adapts the base and new configs in body, the multipart
+// body of r, each by its own Content-Type or file name. It returns nil
+// if body has neither, as when it is a config and the files it imports.
+func readDiffParts(r *http.Request, body []byte) ([]*diffPart, error) {
+	_, params, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	mr := multipart.NewReader(bytes.NewReader(body), params["boundary"])
+	checks, err := requestChecks(r)
+	if err != nil {
+		return nil, err
+	}
+
+	var base, updated *diffPart
+	var others []string
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, caddy.APIError{
+				HTTPStatus: http.StatusBadRequest,
+				Err:        errorf("reading multipart body: %v", err),
+			}
+		}
+		field := part.FormName()
+		if field != "base" && field != "new" {
+			others = append(others, field)
+			continue
+		}
+		if (field == "base" && base != nil) || (field == "new" && updated != nil) {
+			return nil, caddy.APIError{
+				HTTPStatus: http.StatusBadRequest,
+				Err:        errorf("more than one %s config", field),
+			}
+		}
+		dp, err := adaptDiffPart(r, part, checks)
+		if err != nil {
+			return nil, err
+		}
+		if field == "base" {
+			base = dp
+		} else {
+			updated = dp
+		}
+	}
+	if base == nil && updated == nil {
+		return nil, nil
+	}
+	if base == nil || updated == nil {
+		return nil, caddy.APIError{
+			HTTPStatus: http.StatusBadRequest,
+			Err:        errorf("comparing configs needs both a base and a new config"),
+		}
+	}
+	if len(others) > 0 {
+		return nil, caddy.APIError{
+			HTTPStatus: http.StatusBadRequest,
+			Err:        errorf("unexpected part %s; comparing configs takes only base and new", others[0]),
+		}
+	}
+	return []*diffPart{base, updated}, nil
+}
+
+// adaptDiffPart adapts the config in part, which is the base
+// or new config of r, by way of checks along with the part's
+// own signature and encryption.
+func adaptDiffPart(r *http.Request, part *multipart.Part, checks adaptChecks) (*diffPart, error) {
+	field := part.FormName()
+	dp := &diffPart{name: part.FileName()}
+	if dp.name == "" {
+		dp.name = field
+	}
+	body, err := ioutil.ReadAll(part)
+	if err != nil {
+		return nil, caddy.APIError{
+			HTTPStatus: http.StatusBadRequest,
+			Err:        errorf("reading %s config: %v", field, err),
+		}
+	}
+	contentType := part.Header.Get("Content-Type")
+	adapter, err := batchItemAdapter(r, batchItem{Name: part.FileName(), ContentType: contentType})
+	if err != nil {
+		return nil, caddy.APIError{
+			HTTPStatus: http.StatusBadRequest,
+			Err:        errorf("%s config: %v", field, err),
+		}
+	}
+	dp.adapter = adapter.Name()
+	var options map[string]interface{}
+	if filename := part.FileName(); filename != "" {
+		options = map[string]interface{}{"filename": filename}
+	}
+
+	checks.signature = part.Header.Get("X-Signature")
+	checks.encryption = part.Header.Get("X-Encryption")
+	checks.contentType = contentType
+	result, _, err := adaptChecked(r.Context(), r.URL.Path, adapter, body, options, checks)
+	if apiErr, ok := err.(caddy.APIError); ok {
+		return nil, apiErr
+	}
+	if err != nil {
+		return nil, caddy.APIError{
+			HTTPStatus: http.StatusBadRequest,
+			Err:        errorf("%s config: %v", field, err),
+		}
+	}
+	if err := json.Unmarshal(result, &dp.config); err != nil {
+		return nil, caddy.APIError{
+			HTTPStatus: http.StatusBadRequest,
+			Err:        errorf("%s config: adapted config is not valid JSON: %v", field, err),
+		}
+	}
+	return dp, nil
+}
+
+// respondDiff responds to r with the difference between the
+// base and new configs in parts, from the request body.
+func respondDiff(w http.ResponseWriter, r *http.Request, parts []*diffPart, format string, body []byte) error {
+	base, updated := parts[0], parts[1]
+	newProvenance(r, base.adapter+","+updated.adapter, body).setHeaders(w.Header())
+	return writeDiff(w, format, base.name, updated.name, base.config, updated.config)
+}
+
+// writeDiff responds with the difference between the config from and
+// the config to, labeled with their names, in the given format.
+func writeDiff(w http.ResponseWriter, format, fromName, toName string, from, to interface{}) error {
+	switch format {
+	case diffUnified:
+		return writeUnifiedDiff(w, fromName, toName, from, to)
+	case diffMergePatch:
+		w.Header().Set("Content-Type", "application/merge-patch+json")
+		return json.NewEncoder(w).Encode(mergePatchDiff(from, to))
+	}
+	w.Header().Set("Content-Type", "application/json-patch+json")
+	return json.NewEncoder(w).Encode(jsonPatch("", from, to, []patchOp{}))
+}
+
+// writeUnifiedDiff responds with the unified diff from the
+// config from to the config to, labeled with their names.
+func writeUnifiedDiff(w http.ResponseWriter, fromName, toName string, from, to interface{}) error {
+	diff, err := unifiedJSONDiff(fromName, toName, from, to)
+	if err != nil {
+		return err
+	}
+	w.Header().Set("Content-Type", "text/x-diff; charset=utf-8")
+	w.Write(diff)
+	return nil
+}
+
+// jsonPatch appends to ops the operations that turn from into to,
+// which are at path in the documents being compared.
+func jsonPatch(path string, from, to interface{}, ops []patchOp) []patchOp {
+	switch fromVal := from.(type) {
+	case map[string]interface{}:
+		toVal, ok := to.(map[string]interface{})
+		if !ok {
+			break
+		}
+		for _, key := range sortedKeys(fromVal) {
+			if _, ok := toVal[key]; !ok {
+				ops = append(ops, patchOp{Op: "remove", Path: path + "/" + escapePointer(key)})
+			}
+		}
+		for _, key := range sortedKeys(toVal) {
+			keyPath := path + "/" + escapePointer(key)
+			if fromElem, ok := fromVal[key]; ok {
+				ops = jsonPatch(keyPath, fromElem, toVal[key], ops)
+			} else {
+				ops = append(ops, patchOp{Op: "add", Path: keyPath, Value: toVal[key]})
+			}
+		}
+		return ops
+
+	case []interface{}:
+		toVal, ok := to.([]interface{})
+		if !ok {
+			break
+		}
+		common := len(fromVal)
+		if len(toVal) < common {
+			common = len(toVal)
+		}
+		for i := 0; i < common; i++ {
+			ops = jsonPatch(path+"/"+strconv.Itoa(i), fromVal[i], toVal[i], ops)
+		}
+		// remove from the end, so the indexes stay valid
+		for i := len(fromVal) - 1; i >= common; i-- {
+			ops = append(ops, patchOp{Op: "remove", Path: path + "/" + strconv.Itoa(i)})
+		}
+		for i := common; i < len(toVal); i++ {
+			ops = append(ops, patchOp{Op: "add", Path: path + "/" + strconv.Itoa(i), Value: toVal[i]})
+		}
+		return ops
+	}
+
+	if reflect.DeepEqual(from, to) {
+		return ops
+	}
+	return append(ops, patchOp{Op: "replace", Path: path, Value: to})
+}
+
+// mergePatchDiff returns the JSON Merge Patch (RFC 7386) that turns
+// from into to, the inverse of mergePatch. A merge patch can't set a
+// field to null, which removes it instead, so such fields in to are
+// left out of the patch if they are new, and removed if they aren't.
+func mergePatchDiff(from, to interface{}) interface{} {
+	fromObj, ok := from.(map[string]interface{})
+	if !ok {
+		return to
+	}
+	toObj, ok := to.(map[string]interface{})
+	if !ok {
+		return to
+	}
+	patch := make(map[string]interface{})
+	for key := range fromObj {
+		if _, ok := toObj[key]; !ok {
+			patch[key] = nil
+		}
+	}
+	for key, toVal := range toObj {
+		fromVal, ok := fromObj[key]
+		if !ok {
+			if toVal != nil {
+				patch[key] = toVal
+			}
+			continue
+		}
+		_, fromIsObj := fromVal.(map[string]interface{})
+		_, toIsObj := toVal.(map[string]interface{})
+		if fromIsObj && toIsObj {
+			if sub := mergePatchDiff(fromVal, toVal).(map[string]interface{}); len(sub) > 0 {
+				patch[key] = sub
+			}
+		} else if !reflect.DeepEqual(fromVal, toVal) {
+			patch[key] = toVal
+		}
+	}
+	return patch
+}
+
+// sortedKeys returns the keys of m in sorted order.
+func sortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// escapePointer escapes key for use in a JSON Pointer (RFC 6901).
+func escapePointer(key string) string {
+	return strings.NewReplacer("~", "~0", "/", "~1").Replace(key)
+}
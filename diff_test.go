@@ -0,0 +1,76 @@
+package adapt
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// diffBody returns a multipart/form-data body with base and
+// new JSON configs, for /adapt/diff to compare.
+func diffBody(base, updated string) (contentType, body string) {
+	var b strings.Builder
+	for _, part := range [][2]string{{"base", base}, {"new", updated}} {
+		b.WriteString("--B\r\nContent-Disposition: form-data; name=\"" + part[0] + "\"\r\n")
+		b.WriteString("Content-Type: application/json\r\n\r\n" + part[1] + "\r\n")
+	}
+	b.WriteString("--B--\r\n")
+	return "multipart/form-data; boundary=B", b.String()
+}
+
+func TestDiffMergePatch(t *testing.T) {
+	startApp(t, &App{})
+	base := `{"a":1,"b":{"c":2,"d":3},"f":[1,2]}`
+	updated := `{"b":{"c":2,"d":4},"e":5,"f":[1]}`
+	contentType, body := diffBody(base, updated)
+	w := post("/adapt/diff?format=merge-patch", contentType, body)
+	expectStatus(t, w, http.StatusOK)
+	if ct := w.Header().Get("Content-Type"); ct != "application/merge-patch+json" {
+		t.Fatalf("expected a merge patch, got %s", ct)
+	}
+
+	var patch, from, to interface{}
+	for doc, v := range map[string]*interface{}{w.Body.String(): &patch, base: &from, updated: &to} {
+		if err := json.Unmarshal([]byte(doc), v); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want := map[string]interface{}{"a": nil, "b": map[string]interface{}{"d": 4.0}, "e": 5.0, "f": []interface{}{1.0}}
+	if !reflect.DeepEqual(patch, want) {
+		t.Fatalf("expected merge patch %v, got %s", want, w.Body)
+	}
+	if merged := mergePatch(from, patch); !reflect.DeepEqual(merged, to) {
+		t.Fatalf("expected the patch to turn the base into the new config, got %v", merged)
+	}
+
+	expectStatus(t, post("/adapt/diff?format=nope", contentType, body), http.StatusBadRequest)
+	expectStatus(t, post("/adapt/diff?format=merge-patch&unified=true", contentType, body), http.StatusBadRequest)
+}
+
+func TestDiffBaseSnapshot(t *testing.T) {
+	startApp(t, &App{})
+	base := `{"apps":{"old":{}}}`
+	expectStatus(t, post("/adapt/snapshots/before", "application/json", base), http.StatusCreated)
+	hash, err := configHash([]byte(base))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, ref := range []string{"before", hash} {
+		w := post("/adapt/diff?base="+ref, "application/json", `{"apps":{"new":{}}}`)
+		expectStatus(t, w, http.StatusOK)
+		var ops []patchOp
+		if err := json.Unmarshal(w.Body.Bytes(), &ops); err != nil {
+			t.Fatal(err)
+		}
+		if len(ops) != 2 || ops[0].Path != "/apps/old" || ops[1].Path != "/apps/new" {
+			t.Fatalf("base %s: expected the snapshot to be replaced, got %s", ref, w.Body)
+		}
+	}
+
+	expectStatus(t, post("/adapt/diff?base=nope", "application/json", "{}"), http.StatusNotFound)
+	contentType, body := diffBody("{}", "{}")
+	expectStatus(t, post("/adapt/diff?base=before", contentType, body), http.StatusBadRequest)
+}
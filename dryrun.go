@@ -0,0 +1,216 @@
+package adapt
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/caddyserver/caddy/v2"
+)
+
+// moduleKeys are the keys that name the module an object in a config
+// is, such as "handler" for HTTP handlers, in the order they are
+// looked for.
+var moduleKeys = []string{"handler", "module", "provider", "source", "via", "protocol", "policy", "output", "format"}
+
+// dryRunResult is the response body of /adapt/dry-run.
+type dryRunResult struct {
+	Valid    bool           `json:"valid"`
+	Error    string         `json:"error,omitempty"`
+	Warnings []adaptWarning `json:"warnings"`
+	Changed  bool           `json:"changed"`
+	Apps     changeSet      `json:"apps"`
+	Other    changeSet      `json:"other"` // top-level keys but apps
+	Modules  []moduleChange `json:"modules"`
+}
+
+// changeSet lists the names of what would be added,
+// removed and changed by loading a config.
+type changeSet struct {
+	Added   []string `json:"added"`
+	Removed []string `json:"removed"`
+	Changed []string `json:"changed"`
+}
+
+// moduleChange is a module that loading a config would add,
+// remove or change, at path in the config.
+type moduleChange struct {
+	Path   string `json:"path"`
+	Module string `json:"module"`
+	Change string `json:"change"`
+}
+
+// handleDryRun adapts the config in the request like handleAdapt, and
+// validates the result like handleValidate, provisioning every module
+// in it without starting any apps. It then reports what loading it
+// would change in the running config, which it never replaces.
+func (adminAdapt) handleDryRun(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != http.MethodPost {
+		return caddy.APIError{
+			HTTPStatus: http.StatusMethodNotAllowed,
+			Err:        errorf("method not allowed"),
+		}
+	}
+
+	buf := getBuffer()
+	defer putBuffer(buf)
+
+	a, err := adaptRequest(r, buf)
+	if err != nil {
+		return err
+	}
+	var adapted interface{}
+	if err := json.Unmarshal(a.result, &adapted); err != nil {
+		return caddy.APIError{
+			HTTPStatus: http.StatusBadRequest,
+			Err:        errorf("adapted config is not valid JSON: %v", err),
+		}
+	}
+
+	runningJSON, err := runningConfig(r)
+	if err != nil {
+		return err
+	}
+	var running interface{}
+	if err := json.Unmarshal(runningJSON, &running); err != nil {
+		return errorf("running config is not valid JSON: %v", err)
+	}
+
+	validated := validateConfig(a.result, a.warnings)
+	res := dryRunResult{Valid: validated.Valid, Error: validated.Error, Warnings: validated.Warnings}
+
+	ops := jsonPatch("", running, adapted, []patchOp{})
+	res.Changed = len(ops) > 0
+	res.Modules = changedModules(ops, running, adapted)
+
+	runningCfg, _ := running.(map[string]interface{})
+	adaptedCfg, _ := adapted.(map[string]interface{})
+	runningApps, _ := runningCfg["apps"].(map[string]interface{})
+	adaptedApps, _ := adaptedCfg["apps"].(map[string]interface{})
+	res.Apps = compareKeys(runningApps, adaptedApps)
+	delete(runningCfg, "apps")
+	delete(adaptedCfg, "apps")
+	res.Other = compareKeys(runningCfg, adaptedCfg)
+
+	w.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(w).Encode(res)
+}
+
+// compareKeys returns the keys that are in to but not from, in from but
+// not to, and in both but with different values.
+func compareKeys(from, to map[string]interface{}) changeSet {
+	set := changeSet{Added: []string{}, Removed: []string{}, Changed: []string{}}
+	for _, key := range sortedKeys(from) {
+		if _, ok := to[key]; !ok {
+			set.Removed = append(set.Removed, key)
+		}
+	}
+	for _, key := range sortedKeys(to) {
+		fromVal, ok := from[key]
+		if !ok {
+			set.Added = append(set.Added, key)
+		} else if len(jsonPatch("", fromVal, to[key], nil)) > 0 {
+			set.Changed = append(set.Changed, key)
+		}
+	}
+	return set
+}
+
+// changedModules returns the modules that the JSON Patch ops, which
+// turn from into to, add, remove or change: for each operation, the
+// innermost module that encloses what it changes.
+func changedModules(ops []patchOp, from, to interface{}) []moduleChange {
+	changes := make(map[string]moduleChange)
+	for _, op := range ops {
+		tokens, _ := parsePointer(op.Path)
+		doc := to
+		if op.Op == "remove" {
+			doc = from
+		}
+		path, module, ok := enclosingModule(doc, tokens)
+		if !ok {
+			continue
+		}
+		if _, ok := changes[path]; ok {
+			continue
+		}
+		pathTokens, _ := parsePointer(path)
+		change := "changed"
+		if !isModuleAt(from, pathTokens) {
+			change = "added"
+		} else if !isModuleAt(to, pathTokens) {
+			change = "removed"
+		}
+		changes[path] = moduleChange{Path: path, Module: module, Change: change}
+	}
+
+	modules := make([]moduleChange, 0, len(changes))
+	for _, change := range changes {
+		modules = append(modules, change)
+	}
+	sort.Slice(modules, func(i, j int) bool { return modules[i].Path < modules[j].Path })
+	return modules
+}
+
+// enclosingModule returns the path and name of the innermost module
+// in doc that encloses the value at tokens, if there is one.
+func enclosingModule(doc interface{}, tokens []string) (string, string, bool) {
+	var path, module string
+	found := false
+	val := doc
+	for i := 0; ; i++ {
+		if name, ok := moduleName(val); ok {
+			path, module, found = "/"+strings.Join(escapeAll(tokens[:i]), "/"), name, true
+		}
+		if i == len(tokens) {
+			break
+		}
+		val = childValue(val, tokens[i])
+		if val == nil {
+			break
+		}
+	}
+	return path, module, found
+}
+
+// isModuleAt returns true if the value at tokens in doc is a module.
+func isModuleAt(doc interface{}, tokens []string) bool {
+	val := doc
+	for _, token := range tokens {
+		if val = childValue(val, token); val == nil {
+			return false
+		}
+	}
+	_, ok := moduleName(val)
+	return ok
+}
+
+// moduleName returns the name of the module that val is,
+// if it is an object that names one.
+func moduleName(val interface{}) (string, bool) {
+	obj, ok := val.(map[string]interface{})
+	if !ok {
+		return "", false
+	}
+	for _, key := range moduleKeys {
+		if name, ok := obj[key].(string); ok {
+			return name, true
+		}
+	}
+	return "", false
+}
+
+// childValue returns the value at token in val, which is an object
+// or array, or nil if there is none.
+func childValue(val interface{}, token string) interface{} {
+	switch container := val.(type) {
+	case map[string]interface{}:
+		return container[token]
+	case []interface{}:
+		if idx, ok := arrayIndex(token, len(container)); ok {
+			return container[idx]
+		}
+	}
+	return nil
+}
@@ -0,0 +1,270 @@
+package adapt
+
+import (
+	"bytes"
+	"encoding/json"
+	"mime"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig"
+	"github.com/fxamacker/cbor/v2"
+	"github.com/vmihailenco/msgpack/v5"
+	"gopkg.in/yaml.v2"
+)
+
+// configEncoding is an encoding, other than JSON, that Caddy's
+// native config structure can be posted and returned in.
+type configEncoding struct {
+	contentType string
+	decode      func(body []byte) (interface{}, error)
+	encode      func(val interface{}) ([]byte, error)
+}
+
+// configEncodings are the config encodings by name, which is also
+// the name of the adapter that decodes them, unless an adapter of
+// that name is registered.
+var configEncodings = map[string]configEncoding{
+	"cbor":    {"application/cbor", decodeCBOR, encodeCBOR},
+	"msgpack": {"application/msgpack", decodeMsgpack, encodeMsgpack},
+	"yaml":    {"application/yaml", decodeYAML, encodeYAML},
+	"toml":    {"application/toml", decodeTOML, encodeTOML},
+}
+
+// encodingMediaTypes maps the media types configs are posted and
+// accepted in to the names of their encodings.
+var encodingMediaTypes = map[string]string{
+	"application/cbor":      "cbor",
+	"application/msgpack":   "msgpack",
+	"application/x-msgpack": "msgpack",
+	"application/yaml":      "yaml",
+	"application/x-yaml":    "yaml",
+	"text/yaml":             "yaml",
+	"application/toml":      "toml",
+}
+
+// decodingAdapter adapts Caddy config in another encoding to
+// Caddy JSON, which is only a matter of transcoding it.
+type decodingAdapter struct {
+	decode func([]byte) (interface{}, error)
+}
+
+// Adapt transcodes body to JSON.
+func (da decodingAdapter) Adapt(body []byte, _ map[string]interface{}) ([]byte, []caddyconfig.Warning, error) {
+	val, err := da.decode(body)
+	if err != nil {
+		return nil, nil, err
+	}
+	result, err := json.Marshal(val)
+	return result, nil, err
+}
+
+func decodeCBOR(body []byte) (interface{}, error) {
+	dm, err := cbor.DecOptions{
+		DefaultMapType: reflect.TypeOf(map[string]interface{}(nil)),
+	}.DecMode()
+	if err != nil {
+		return nil, err
+	}
+	var val interface{}
+	err = dm.Unmarshal(body, &val)
+	return val, err
+}
+
+func decodeMsgpack(body []byte) (interface{}, error) {
+	var val interface{}
+	err := msgpack.Unmarshal(body, &val)
+	return val, err
+}
+
+func decodeYAML(body []byte) (interface{}, error) {
+	var val interface{}
+	if err := yaml.Unmarshal(body, &val); err != nil {
+		return nil, err
+	}
+	return stringKeys(val)
+}
+
+func decodeTOML(body []byte) (interface{}, error) {
+	var val map[string]interface{}
+	err := toml.Unmarshal(body, &val)
+	return val, err
+}
+
+// stringKeys replaces the maps in val, as decoded from YAML, which
+// may have keys of any type, with maps keyed by strings, as JSON
+// objects are.
+func stringKeys(val interface{}) (interface{}, error) {
+	switch v := val.(type) {
+	case map[interface{}]interface{}:
+		obj := make(map[string]interface{}, len(v))
+		for k, elem := range v {
+			key, ok := k.(string)
+			if !ok {
+				return nil, errorf("key %v is not a string", k)
+			}
+			var err error
+			if obj[key], err = stringKeys(elem); err != nil {
+				return nil, err
+			}
+		}
+		return obj, nil
+	case []interface{}:
+		for i, elem := range v {
+			var err error
+			if v[i], err = stringKeys(elem); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return val, nil
+}
+
+func encodeCBOR(val interface{}) ([]byte, error) {
+	em, err := cbor.CoreDetEncOptions().EncMode()
+	if err != nil {
+		return nil, err
+	}
+	return em.Marshal(val)
+}
+
+func encodeMsgpack(val interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := msgpack.NewEncoder(&buf)
+	enc.SetSortMapKeys(true)
+	err := enc.Encode(val)
+	return buf.Bytes(), err
+}
+
+func encodeYAML(val interface{}) ([]byte, error) {
+	return yaml.Marshal(val)
+}
+
+func encodeTOML(val interface{}) ([]byte, error) {
+	// TOML documents are tables; nulls, which TOML doesn't
+	// have, are left out by the encoder
+	if _, ok := val.(map[string]interface{}); !ok {
+		return nil, caddy.APIError{
+			HTTPStatus: http.StatusNotAcceptable,
+			Err:        errorf("config can not be represented in TOML: not an object"),
+		}
+	}
+	var buf bytes.Buffer
+	if err := toml.NewEncoder(&buf).Encode(val); err != nil {
+		return nil, caddy.APIError{
+			HTTPStatus: http.StatusNotAcceptable,
+			Err:        errorf("config can not be represented in TOML: %v", err),
+		}
+	}
+	return buf.Bytes(), nil
+}
+
+// negotiateEncoding returns the preferred config encoding
+// acceptable per the given Accept header, and false if that
+// is JSON.
+func negotiateEncoding(accept string) (configEncoding, bool) {
+	for _, mediaType := range acceptedMediaTypes(accept) {
+		if mediaType == "application/json" || mediaType == "*/*" || mediaType == "application/*" {
+			return configEncoding{}, false
+		}
+		if name, ok := encodingMediaTypes[mediaType]; ok {
+			return configEncodings[name], true
+		}
+	}
+	return configEncoding{}, false
+}
+
+// envelopeMediaTypes maps the media types that the encodings of the
+// /adapt response envelope are accepted as to their content types.
+var envelopeMediaTypes = map[string]string{
+	"application/x-protobuf": protobufContentType,
+	"application/protobuf":   protobufContentType,
+	"application/x-ndjson":   ndjsonContentType,
+	"application/ndjson":     ndjsonContentType,
+}
+
+// negotiateEnvelope returns the content type of the encoding of the
+// response envelope that is preferred over JSON and the config
+// encodings per the given Accept header, if any.
+func negotiateEnvelope(accept string) string {
+	for _, mediaType := range acceptedMediaTypes(accept) {
+		if contentType, ok := envelopeMediaTypes[mediaType]; ok {
+			return contentType
+		}
+		if mediaType == "application/json" || mediaType == "*/*" || mediaType == "application/*" || encodingMediaTypes[mediaType] != "" {
+			return ""
+		}
+	}
+	return ""
+}
+
+// acceptedMediaTypes returns the media types in the given Accept
+// header, most preferred first, leaving out those with q=0.
+func acceptedMediaTypes(accept string) []string {
+	type weighted struct {
+		mediaType string
+		q         float64
+	}
+	var accepted []weighted
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		q := 1.0
+		if qParam, ok := params["q"]; ok {
+			if q, err = strconv.ParseFloat(qParam, 64); err != nil {
+				continue
+			}
+		}
+		if q > 0 {
+			accepted = append(accepted, weighted{mediaType, q})
+		}
+	}
+	sort.SliceStable(accepted, func(i, j int) bool { return accepted[i].q > accepted[j].q })
+
+	mediaTypes := make([]string, len(accepted))
+	for i, a := range accepted {
+		mediaTypes[i] = a.mediaType
+	}
+	return mediaTypes
+}
+
+// reencode converts the JSON document in cfgJSON to enc.
+func reencode(cfgJSON []byte, enc configEncoding) ([]byte, error) {
+	dec := json.NewDecoder(bytes.NewReader(cfgJSON))
+	dec.UseNumber()
+	var val interface{}
+	if err := dec.Decode(&val); err != nil {
+		return nil, errorf("decoding adapted config: %v", err)
+	}
+	return enc.encode(jsonNumbers(val))
+}
+
+// jsonNumbers replaces the json.Number values in val, a decoded
+// JSON document, with int64 or float64 values, so that integers
+// keep their type in encodings that distinguish them.
+func jsonNumbers(val interface{}) interface{} {
+	switch v := val.(type) {
+	case map[string]interface{}:
+		for k, elem := range v {
+			v[k] = jsonNumbers(elem)
+		}
+	case []interface{}:
+		for i, elem := range v {
+			v[i] = jsonNumbers(elem)
+		}
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			return i
+		}
+		f, _ := v.Float64()
+		return f
+	}
+	return val
+}
@@ -0,0 +1,66 @@
+package adapt
+
+import (
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/caddyserver/caddy/v2"
+)
+
+// envPlaceholderRegexp matches the environment variable placeholders
+// that ?env=true expands: {env.VAR}, and {$VAR} with an optional
+// default, as in {$VAR:default}.
+var envPlaceholderRegexp = regexp.MustCompile(`\{(?:env\.([A-Za-z_][A-Za-z0-9_]*)|\$([A-Za-z_][A-Za-z0-9_]*)(?::([^}]*))?)\}`)
+
+// expandEnv replaces the environment variable placeholders in body
+// with the values of the variables, or their defaults if unset. Only
+// variables whose names start with one of the env_prefixes may be
+// expanded, so that a request can't read the whole environment.
+func expandEnv(body []byte) ([]byte, error) {
+	prefixes := settings().EnvPrefixes
+	if len(prefixes) == 0 {
+		return nil, caddy.APIError{
+			HTTPStatus: http.StatusForbidden,
+			Err:        errorf("expanding environment variables is disabled; no env_prefixes are configured"),
+		}
+	}
+
+	var err error
+	expanded := envPlaceholderRegexp.ReplaceAllFunc(body, func(placeholder []byte) []byte {
+		match := envPlaceholderRegexp.FindSubmatch(placeholder)
+		name, def := string(match[1]), string(match[3])
+		if name == "" {
+			name = string(match[2])
+		}
+		if !envAllowed(name, prefixes) {
+			if err == nil {
+				err = caddy.APIError{
+					HTTPStatus: http.StatusForbidden,
+					Err:        errorf("environment variable %s may not be expanded; it doesn't start with any of the env_prefixes", name),
+				}
+			}
+			return placeholder
+		}
+		if val, ok := os.LookupEnv(name); ok {
+			return []byte(val)
+		}
+		return []byte(def)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return expanded, nil
+}
+
+// envAllowed returns true if the environment variable
+// name starts with one of prefixes.
+func envAllowed(name string, prefixes []string) bool {
+	for _, prefix := range prefixes {
+		if strings.HasPrefix(name, prefix) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,58 @@
+package adapt
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/caddyserver/caddy/v2"
+)
+
+// equalResult is the response body of /adapt/equal.
+type equalResult struct {
+	Equal       bool   `json:"equal"`
+	RunningHash string `json:"running_hash"`
+	AdaptedHash string `json:"adapted_hash"`
+}
+
+// handleEqual adapts the config in the request like handleAdapt,
+// but only reports whether the result equals the running config,
+// which tells whether loading it would cause a reload.
+func (adminAdapt) handleEqual(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != http.MethodPost {
+		return caddy.APIError{
+			HTTPStatus: http.StatusMethodNotAllowed,
+			Err:        errorf("method not allowed"),
+		}
+	}
+
+	buf := getBuffer()
+	defer putBuffer(buf)
+
+	a, err := adaptRequest(r, buf)
+	if err != nil {
+		return err
+	}
+	adaptedHash, err := configHash(a.result)
+	if err != nil {
+		return caddy.APIError{
+			HTTPStatus: http.StatusBadRequest,
+			Err:        errorf("adapted config is not valid JSON: %v", err),
+		}
+	}
+
+	running, err := runningConfig(r)
+	if err != nil {
+		return err
+	}
+	runningHash, err := configHash(running)
+	if err != nil {
+		return errorf("running config is not valid JSON: %v", err)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(w).Encode(equalResult{
+		Equal:       adaptedHash == runningHash,
+		RunningHash: runningHash,
+		AdaptedHash: adaptedHash,
+	})
+}
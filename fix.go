@@ -0,0 +1,242 @@
+package adapt
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+)
+
+// fixResult is the response body of /adapt/fix.
+type fixResult struct {
+	Changed bool   `json:"changed"`
+	Fixed   string `json:"fixed"`
+	Fixes   []fix  `json:"fixes"`
+}
+
+// fix describes a correction made to a Caddyfile.
+type fix struct {
+	Line        int    `json:"line,omitempty"`
+	Description string `json:"description"`
+}
+
+// handleFix corrects common mistakes in the posted Caddyfile and
+// responds with the corrected Caddyfile and a list of the fixes
+// that were applied. It does not adapt the config.
+func (adminAdapt) handleFix(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != http.MethodPost {
+		return caddy.APIError{
+			HTTPStatus: http.StatusMethodNotAllowed,
+			Err:        errorf("method not allowed"),
+		}
+	}
+	if ct := r.Header.Get("Content-Type"); ct != "" {
+		mediaType, _, err := mime.ParseMediaType(ct)
+		if err != nil || !strings.HasSuffix(mediaType, "/caddyfile") {
+			return caddy.APIError{
+				HTTPStatus: http.StatusUnsupportedMediaType,
+				Err:        errorf("only Caddyfiles can be fixed"),
+			}
+		}
+	}
+
+	buf := getBuffer()
+	defer putBuffer(buf)
+
+	_, err := io.Copy(buf, r.Body)
+	if err != nil {
+		return caddy.APIError{
+			HTTPStatus: http.StatusBadRequest,
+			Err:        errorf("reading request body: %v", err),
+		}
+	}
+
+	fixed, fixes := fixCaddyfile(buf.Bytes(), acceptedLanguages(r.Header.Get("Accept-Language")))
+
+	w.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(w).Encode(fixResult{
+		Changed: len(fixes) > 0,
+		Fixed:   string(fixed),
+		Fixes:   fixes,
+	})
+}
+
+// fixCaddyfile corrects the mistakes in a Caddyfile that can be
+// corrected mechanically: deprecated directive names, unbalanced
+// braces, a global options block that isn't first, and formatting.
+// The fixes are described in one of langs.
+func fixCaddyfile(input []byte, langs []string) ([]byte, []fix) {
+	normalized := bytes.ReplaceAll(input, []byte("\r\n"), []byte("\n"))
+	lines := strings.Split(string(normalized), "\n")
+	fixes := []fix{}
+
+	// lineNums maps each of lines to its line number in the
+	// input, which changes as lines are removed or moved
+	lineNums := make([]int, len(lines))
+	for i := range lines {
+		lineNums[i] = i + 1
+	}
+
+	for i, line := range lines {
+		trimmed := strings.TrimLeft(line, " \t")
+		indent := line[:len(line)-len(trimmed)]
+		fields := strings.Fields(trimmed)
+		if len(fields) == 0 {
+			continue
+		}
+		renamed, ok := deprecatedDirectives[fields[0]]
+		if !ok || (renamed.bare && len(fields) > 1) {
+			continue
+		}
+		lines[i] = indent + renamed.name + trimmed[len(fields[0]):]
+		fixes = append(fixes, fix{
+			Line:        lineNums[i],
+			Description: messagef("replaced deprecated '%s' with '%s'", fields[0], renamed.name).localize(langs),
+		})
+	}
+
+	// drop closing braces that close nothing
+	depth := 0
+	for i := 0; i < len(lines); i++ {
+		opens, closes := countBraces(lines[i])
+		if depth+opens-closes >= 0 {
+			depth += opens - closes
+			continue
+		}
+		excess := closes - opens - depth
+		lines[i] = removeClosingBraces(lines[i], excess)
+		fixes = append(fixes, fix{
+			Line:        lineNums[i],
+			Description: messagef("removed unmatched closing brace").localize(langs),
+		})
+		depth = 0
+	}
+	if depth > 0 {
+		fixes = append(fixes, fix{
+			Line:        lineNums[len(lines)-1],
+			Description: messagef("added missing closing brace").localize(langs),
+		})
+		for ; depth > 0; depth-- {
+			lines = append(lines, "}")
+			lineNums = append(lineNums, 0)
+		}
+	}
+
+	// the global options block must come first
+	if start, end, ok := globalOptionsBlock(lines); ok && hasContent(lines[:start]) {
+		block := append([]string{}, lines[start:end+1]...)
+		blockNums := append([]int{}, lineNums[start:end+1]...)
+		lines = append(block, append(lines[:start:start], lines[end+1:]...)...)
+		lineNums = append(blockNums, append(lineNums[:start:start], lineNums[end+1:]...)...)
+		fixes = append(fixes, fix{
+			Line:        blockNums[0],
+			Description: messagef("moved the global options block to the top").localize(langs),
+		})
+	}
+
+	joined := []byte(strings.Join(lines, "\n"))
+	formatted := caddyfile.Format(joined)
+	if !bytes.Equal(bytes.TrimSpace(formatted), bytes.TrimSpace(joined)) {
+		fixes = append(fixes, fix{Description: messagef("formatted").localize(langs)})
+	}
+	if len(formatted) > 0 {
+		formatted = append(formatted, '\n')
+	}
+
+	return formatted, fixes
+}
+
+// deprecatedDirective is the replacement for a deprecated
+// directive, subdirective or option name.
+type deprecatedDirective struct {
+	name string
+	bare bool // only replace if given no arguments
+}
+
+// deprecatedDirectives maps names from Caddy v1 and early v2
+// Caddyfiles to their current equivalents.
+var deprecatedDirectives = map[string]deprecatedDirective{
+	"proxy":             {name: "reverse_proxy"},
+	"header_upstream":   {name: "header_up"},
+	"header_downstream": {name: "header_down"},
+	"health_path":       {name: "health_uri"},
+	"gzip":              {name: "encode gzip", bare: true},
+}
+
+// countBraces counts the opening and closing braces in a
+// Caddyfile line that are tokens, i.e. not quoted or in a
+// comment.
+func countBraces(line string) (opens, closes int) {
+	quoted := false
+	for i, ch := range line {
+		switch {
+		case ch == '"' && (i == 0 || line[i-1] != '\\'):
+			quoted = !quoted
+		case quoted:
+		case ch == '#' && (i == 0 || line[i-1] == ' ' || line[i-1] == '\t'):
+			return
+		case ch == '{' && braceToken(line, i):
+			opens++
+		case ch == '}' && braceToken(line, i):
+			closes++
+		}
+	}
+	return
+}
+
+// braceToken returns true if the brace at line[i] stands alone
+// as a token, as opposed to being part of a placeholder.
+func braceToken(line string, i int) bool {
+	before := i == 0 || line[i-1] == ' ' || line[i-1] == '\t'
+	after := i == len(line)-1 || line[i+1] == ' ' || line[i+1] == '\t' || line[i+1] == '#'
+	return before && after
+}
+
+// removeClosingBraces removes the last n closing-brace tokens from line.
+func removeClosingBraces(line string, n int) string {
+	for i := len(line) - 1; i >= 0 && n > 0; i-- {
+		if line[i] == '}' && braceToken(line, i) {
+			line = line[:i] + line[i+1:]
+			n--
+		}
+	}
+	if strings.TrimSpace(line) == "" {
+		return ""
+	}
+	return line
+}
+
+// globalOptionsBlock finds the global options block among lines, which
+// is a top-level block opened by a brace with no address before it.
+// It returns the indexes of its first and last lines.
+func globalOptionsBlock(lines []string) (start, end int, ok bool) {
+	depth := 0
+	for i, line := range lines {
+		opens, closes := countBraces(line)
+		if depth == 0 && strings.TrimSpace(line) == "{" {
+			start, ok = i, true
+		}
+		depth += opens - closes
+		if ok && depth == 0 {
+			return start, i, true
+		}
+	}
+	return 0, 0, false
+}
+
+// hasContent returns true if any of lines has something
+// other than whitespace or comments.
+func hasContent(lines []string) bool {
+	for _, line := range lines {
+		trimmed := strings.TrimSpace(line)
+		if trimmed != "" && !strings.HasPrefix(trimmed, "#") {
+			return true
+		}
+	}
+	return false
+}
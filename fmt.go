@@ -0,0 +1,83 @@
+package adapt
+
+import (
+	"bytes"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+
+	"github.com/caddyserver/caddy/v2"
+)
+
+// handleFmt formats the config in the request body, which is a
+// Caddyfile unless ?adapter or the Content-Type says otherwise, and
+// responds with the result, like `caddy fmt`. With ?check=true, it
+// responds with only 200 if the config is formatted already, or
+// 409 if formatting would change it.
+func (adminAdapt) handleFmt(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != http.MethodPost {
+		return caddy.APIError{
+			HTTPStatus: http.StatusMethodNotAllowed,
+			Err:        errorf("method not allowed"),
+		}
+	}
+
+	check, err := queryBool(r, "check")
+	if err != nil {
+		return err
+	}
+
+	name := r.URL.Query().Get("adapter")
+	contentType := "text/" + name
+	if name == "" {
+		name, contentType = "caddyfile", "text/caddyfile"
+		if ct := r.Header.Get("Content-Type"); ct != "" {
+			mediaType, _, err := mime.ParseMediaType(ct)
+			if err != nil {
+				return caddy.APIError{
+					HTTPStatus: http.StatusBadRequest,
+					Err:        errorf("invalid Content-Type: %v", err),
+				}
+			}
+			name = mediaType[strings.Index(mediaType, "/")+1:]
+			contentType = mediaType
+		}
+	}
+	format, ok := formatters[name]
+	if !ok {
+		return caddy.APIError{
+			HTTPStatus: http.StatusUnsupportedMediaType,
+			Err:        errorf("%s configs can not be formatted", name),
+		}
+	}
+
+	buf := getBuffer()
+	defer putBuffer(buf)
+
+	_, err = io.Copy(buf, r.Body)
+	if err != nil {
+		return caddy.APIError{
+			HTTPStatus: http.StatusBadRequest,
+			Err:        errorf("reading request body: %v", err),
+		}
+	}
+
+	formatted := format(buf.Bytes())
+	if check {
+		if !bytes.Equal(bytes.TrimSpace(formatted), bytes.TrimSpace(buf.Bytes())) {
+			return caddy.APIError{
+				HTTPStatus: http.StatusConflict,
+				Err:        errorf("config is not formatted"),
+			}
+		}
+		return nil
+	}
+	if len(formatted) > 0 {
+		formatted = append(formatted, '\n')
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	w.Write(formatted)
+	return nil
+}
@@ -2,4 +2,24 @@ module github.com/adamburgess/caddy-admin-adapt
 
 go 1.14
 
-require github.com/caddyserver/caddy/v2 v2.4.6
+require (
+	filippo.io/age v1.0.0-rc.3
+	github.com/BurntSushi/toml v0.4.1
+	github.com/Masterminds/sprig/v3 v3.2.2
+	github.com/andybalholm/brotli v1.0.3
+	github.com/caddyserver/caddy/v2 v2.4.6
+	github.com/caddyserver/certmagic v0.15.2
+	github.com/fxamacker/cbor/v2 v2.4.0
+	github.com/google/uuid v1.3.0
+	github.com/klauspost/compress v1.13.6
+	github.com/prometheus/client_golang v1.11.0
+	github.com/vmihailenco/msgpack/v5 v5.3.5
+	go.opentelemetry.io/otel v0.20.0
+	go.opentelemetry.io/otel/exporters/otlp v0.20.0
+	go.opentelemetry.io/otel/sdk v0.20.0
+	go.opentelemetry.io/otel/trace v0.20.0
+	go.uber.org/zap v1.19.0
+	golang.org/x/time v0.0.0-20210220033141-f8bda1e9f3ba
+	google.golang.org/protobuf v1.27.1
+	gopkg.in/yaml.v2 v2.4.0
+)
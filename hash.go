@@ -0,0 +1,49 @@
+package adapt
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/caddyserver/caddy/v2"
+)
+
+// hashResult is the response body of /adapt/hash.
+type hashResult struct {
+	SHA256   string `json:"sha256"`
+	Warnings int    `json:"warnings"`
+}
+
+// handleHash adapts the config in the request like handleAdapt, but
+// responds with only the hash of the result, normalized the way
+// /adapt/equal compares configs, and how many warnings there were.
+// Clients polling for drift don't have to transfer the config.
+func (adminAdapt) handleHash(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != http.MethodPost {
+		return caddy.APIError{
+			HTTPStatus: http.StatusMethodNotAllowed,
+			Err:        errorf("method not allowed"),
+		}
+	}
+
+	buf := getBuffer()
+	defer putBuffer(buf)
+
+	a, err := adaptRequest(r, buf)
+	if err != nil {
+		return err
+	}
+	hash, err := configHash(a.result)
+	if err != nil {
+		return caddy.APIError{
+			HTTPStatus: http.StatusBadRequest,
+			Err:        errorf("adapted config is not valid JSON: %v", err),
+		}
+	}
+
+	newProvenance(r, a.adapter, a.source).setHeaders(w.Header())
+	w.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(w).Encode(hashResult{
+		SHA256:   hash,
+		Warnings: len(a.warnings),
+	})
+}
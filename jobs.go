@@ -0,0 +1,342 @@
+package adapt
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+)
+
+// defaultJobRetention is how long a finished job is kept
+// if job_retention isn't set.
+const defaultJobRetention = 10 * time.Minute
+
+// defaultMaxPendingJobs is how many jobs may be pending at a time,
+// and defaultJobTimeout how long each may take, unless
+// max_pending_jobs and job_timeout say otherwise.
+const (
+	defaultMaxPendingJobs = 100
+	defaultJobTimeout     = 5 * time.Minute
+)
+
+// Job states, as reported by /adapt/jobs/<id>.
+const (
+	jobPending   = "pending"
+	jobSucceeded = "succeeded"
+	jobFailed    = "failed"
+	jobCanceled  = "canceled"
+)
+
+// adaptJobs holds the jobs started with ?async=true, until
+// their retention runs out after they finish.
+var adaptJobs = &jobStore{jobs: make(map[string]*adaptJob)}
+
+// adaptJob is a request to /adapt that is handled in
+// the background, so that a client can poll for it
+// instead of waiting for the response.
+type adaptJob struct {
+	id       string
+	created  time.Time
+	cancel   context.CancelFunc
+	done     chan struct{} // closed when the fields below are set
+	finished time.Time
+	rec      *responseRecorder
+	err      error
+	canceled bool // by DELETE /adapt/jobs/<id>, before it finished
+}
+
+// jobStatus is the response body of /adapt/jobs/<id>.
+type jobStatus struct {
+	ID         string     `json:"id"`
+	Status     string     `json:"status"`
+	CreatedAt  time.Time  `json:"created_at"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+	HTTPStatus int        `json:"http_status,omitempty"`
+	Error      string     `json:"error,omitempty"`
+	ResultURL  string     `json:"result_url,omitempty"`
+}
+
+// status returns the status of j.
+func (j *adaptJob) status() jobStatus {
+	st := jobStatus{ID: j.id, Status: jobPending, CreatedAt: j.created}
+	select {
+	case <-j.done:
+	default:
+		return st
+	}
+	st.FinishedAt = &j.finished
+	st.ResultURL = "/adapt/jobs/" + j.id + "/result"
+	if j.err != nil {
+		st.Status = jobFailed
+		if j.canceled {
+			st.Status = jobCanceled
+		}
+		st.HTTPStatus = errorStatus(j.err)
+		st.Error = j.err.Error()
+		return st
+	}
+	st.Status = jobSucceeded
+	st.HTTPStatus = j.rec.status
+	return st
+}
+
+// jobStore is the set of jobs that have not expired.
+type jobStore struct {
+	mu      sync.Mutex
+	jobs    map[string]*adaptJob
+	pending int // jobs that have been reserved and not finished
+}
+
+func (s *jobStore) get(id string) (*adaptJob, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	j, ok := s.jobs[id]
+	return j, ok
+}
+
+// list returns the jobs, oldest first.
+func (s *jobStore) list() []*adaptJob {
+	s.mu.Lock()
+	jobs := make([]*adaptJob, 0, len(s.jobs))
+	for _, j := range s.jobs {
+		jobs = append(jobs, j)
+	}
+	s.mu.Unlock()
+	sort.Slice(jobs, func(i, j int) bool { return jobs[i].created.Before(jobs[j].created) })
+	return jobs
+}
+
+func (s *jobStore) add(j *adaptJob) {
+	s.mu.Lock()
+	s.jobs[j.id] = j
+	s.mu.Unlock()
+}
+
+// reserve counts a job that is about to be started as pending,
+// unless max are already, in which case it returns false.
+func (s *jobStore) reserve(max int) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.pending >= max {
+		return false
+	}
+	s.pending++
+	return true
+}
+
+// release no longer counts a job reserved with reserve as pending.
+func (s *jobStore) release() {
+	s.mu.Lock()
+	s.pending--
+	s.mu.Unlock()
+}
+
+func (s *jobStore) remove(id string) {
+	s.mu.Lock()
+	delete(s.jobs, id)
+	s.mu.Unlock()
+}
+
+// startJob handles r, a request to /adapt with ?async=true, in the
+// background, and responds with 202 and the status of the new job.
+// The body is read first, since it can't outlive the request.
+func (al adminAdapt) startJob(w http.ResponseWriter, r *http.Request) error {
+	max := settings().MaxPendingJobs
+	if max == 0 {
+		max = defaultMaxPendingJobs
+	}
+	if !adaptJobs.reserve(max) {
+		w.Header().Set("Retry-After", "1")
+		return caddy.APIError{
+			HTTPStatus: http.StatusServiceUnavailable,
+			Err:        errorf("too many jobs are pending; the maximum is %d", max),
+		}
+	}
+
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		adaptJobs.release()
+		return caddy.APIError{
+			HTTPStatus: http.StatusBadRequest,
+			Err:        errorf("reading request body: %v", err),
+		}
+	}
+
+	// the job's request doesn't end with r, or get logged with it,
+	// but it does end in time, even if it is still waiting its turn
+	ctx, cancel := context.WithTimeout(context.Background(), jobTimeout())
+	j := &adaptJob{
+		id:      uuid.New().String(),
+		created: time.Now(),
+		cancel:  cancel,
+		done:    make(chan struct{}),
+	}
+	adaptJobs.add(j)
+	ctx = context.WithValue(ctx, requestIDCtxKey, requestID(r.Context()))
+	ctx = context.WithValue(ctx, jobIDCtxKey, j.id)
+	ctx = context.WithValue(ctx, adapterCtxKey, impliedAdapter(r.Context()))
+	stats := new(requestStats)
+	ctx = context.WithValue(ctx, requestStatsCtxKey, stats)
+	jr := r.Clone(ctx)
+	jr.Body = ioutil.NopCloser(bytes.NewReader(body))
+	jr.ContentLength = int64(len(body))
+	query := jr.URL.Query()
+	query.Del("async")
+	jr.URL.RawQuery = query.Encode()
+
+	retention := time.Duration(settings().JobRetention)
+	if retention == 0 {
+		retention = defaultJobRetention
+	}
+	go func() {
+		rec := &responseRecorder{header: make(http.Header), status: http.StatusOK}
+		err := runJob(al.handleAdapt, rec, jr)
+		j.canceled = err != nil && ctx.Err() == context.Canceled
+		cancel()
+		j.rec, j.err, j.finished = rec, err, time.Now()
+		close(j.done)
+		adaptJobs.release()
+
+		// the job is audited separately from the request that started it
+		status := rec.status
+		if err != nil {
+			status = errorStatus(err)
+			stats.err = err
+		}
+		if audited(jr) {
+			recordAudit(jr, requestID(jr.Context()), status, stats)
+		}
+
+		logger(jr).Debug("async job finished",
+			zap.String("job_id", j.id),
+			zap.Duration("duration", j.finished.Sub(j.created)),
+			zap.Error(err))
+		time.AfterFunc(retention, func() { adaptJobs.remove(j.id) })
+	}()
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Location", "/adapt/jobs/"+j.id)
+	w.WriteHeader(http.StatusAccepted)
+	return json.NewEncoder(w).Encode(j.status())
+}
+
+// jobTimeout returns how long a job may take.
+func jobTimeout() time.Duration {
+	if timeout := time.Duration(settings().JobTimeout); timeout > 0 {
+		return timeout
+	}
+	return defaultJobTimeout
+}
+
+// runJob runs h, turning a panic into an error, since
+// the admin server can't recover from it outside of
+// the request that started the job.
+func runJob(h caddy.AdminHandlerFunc, w http.ResponseWriter, r *http.Request) (err error) {
+	defer func() {
+		if rec := recover(); rec != nil {
+			err = errorf("panic: %v", rec)
+		}
+	}()
+	return h(w, r)
+}
+
+// handleJob manages the jobs started with ?async=true:
+//
+//	GET    /adapt/jobs              lists them, oldest first
+//	GET    /adapt/jobs/<id>         responds with its status
+//	GET    /adapt/jobs/<id>/result  responds as /adapt would have, once it has finished
+//	DELETE /adapt/jobs/<id>         cancels it, or forgets it if it has finished
+func (adminAdapt) handleJob(w http.ResponseWriter, r *http.Request) error {
+	id := strings.TrimPrefix(strings.TrimPrefix(r.URL.Path, "/adapt/jobs"), "/")
+	wantResult := strings.HasSuffix(id, "/result")
+	id = strings.TrimSuffix(id, "/result")
+	switch {
+	case r.Method == http.MethodGet:
+	case r.Method == http.MethodDelete && id != "" && !wantResult:
+	default:
+		return caddy.APIError{
+			HTTPStatus: http.StatusMethodNotAllowed,
+			Err:        errorf("method not allowed"),
+		}
+	}
+
+	if id == "" {
+		statuses := []jobStatus{}
+		for _, j := range adaptJobs.list() {
+			statuses = append(statuses, j.status())
+		}
+		w.Header().Set("Content-Type", "application/json")
+		return json.NewEncoder(w).Encode(statuses)
+	}
+
+	j, ok := adaptJobs.get(id)
+	if !ok {
+		return caddy.APIError{
+			HTTPStatus: http.StatusNotFound,
+			Err:        errorf("unknown job: %s", id),
+		}
+	}
+
+	if r.Method == http.MethodDelete {
+		return cancelJob(w, j)
+	}
+
+	if !wantResult {
+		w.Header().Set("Content-Type", "application/json")
+		return json.NewEncoder(w).Encode(j.status())
+	}
+
+	select {
+	case <-j.done:
+	default:
+		return caddy.APIError{
+			HTTPStatus: http.StatusConflict,
+			Err:        errorf("job %s has not finished", id),
+		}
+	}
+	if j.err != nil {
+		return j.err
+	}
+	for field, vals := range j.rec.header {
+		w.Header()[field] = vals
+	}
+	w.WriteHeader(j.rec.status)
+	w.Write(j.rec.body.Bytes())
+	return nil
+}
+
+// cancelJob cancels j, if it is pending, responding with 202 and its
+// status; it finishes as canceled unless it was about to finish anyway.
+// A job that has finished is forgotten, before its retention runs out.
+func cancelJob(w http.ResponseWriter, j *adaptJob) error {
+	select {
+	case <-j.done:
+		adaptJobs.remove(j.id)
+		w.WriteHeader(http.StatusNoContent)
+		return nil
+	default:
+	}
+	j.cancel()
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	return json.NewEncoder(w).Encode(j.status())
+}
+
+// jobID returns the ID of the job that the request with
+// the given context is run for, if any.
+func jobID(ctx context.Context) string {
+	id, _ := ctx.Value(jobIDCtxKey).(string)
+	return id
+}
+
+// jobIDCtxKey is the context key for the ID of an async job.
+const jobIDCtxKey caddy.CtxKey = "adapt_job_id"
@@ -0,0 +1,79 @@
+package adapt
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/caddyserver/caddy/v2/caddyconfig"
+)
+
+// waitAdapter adapts configs once its channel is closed.
+type waitAdapter chan struct{}
+
+func (wa waitAdapter) Adapt(body []byte, _ map[string]interface{}) ([]byte, []caddyconfig.Warning, error) {
+	<-wa
+	return []byte(`{}`), nil, nil
+}
+
+var testWait = make(waitAdapter)
+
+func init() {
+	caddyconfig.RegisterAdapter("test-wait", testWait)
+}
+
+// getJobs gets target, one of the /adapt/jobs endpoints, decoding
+// its response into v.
+func getJobs(t *testing.T, target string, v interface{}) {
+	t.Helper()
+	w := serve(httptest.NewRequest(http.MethodGet, target, nil))
+	expectStatus(t, w, http.StatusOK)
+	if err := json.Unmarshal(w.Body.Bytes(), v); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCancelJob(t *testing.T) {
+	startApp(t, &App{})
+	defer close(testWait)
+
+	w := post("/adapt?async=true", "text/test-wait", "hi")
+	expectStatus(t, w, http.StatusAccepted)
+	var started jobStatus
+	if err := json.Unmarshal(w.Body.Bytes(), &started); err != nil {
+		t.Fatal(err)
+	}
+
+	var listed []jobStatus
+	getJobs(t, "/adapt/jobs", &listed)
+	found := false
+	for _, st := range listed {
+		found = found || (st.ID == started.ID && st.Status == jobPending)
+	}
+	if !found {
+		t.Fatalf("expected job %s to be listed as pending, got %+v", started.ID, listed)
+	}
+
+	del := func() *httptest.ResponseRecorder {
+		return serve(httptest.NewRequest(http.MethodDelete, "/adapt/jobs/"+started.ID, nil))
+	}
+	expectStatus(t, del(), http.StatusAccepted)
+
+	var st jobStatus
+	for deadline := time.Now().Add(5 * time.Second); ; {
+		getJobs(t, "/adapt/jobs/"+started.ID, &st)
+		if st.Status != jobPending || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if st.Status != jobCanceled {
+		t.Fatalf("expected the job to be canceled, got %+v", st)
+	}
+
+	// once finished, it is forgotten
+	expectStatus(t, del(), http.StatusNoContent)
+	expectStatus(t, del(), http.StatusNotFound)
+}
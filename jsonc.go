@@ -0,0 +1,80 @@
+package adapt
+
+import (
+	"bytes"
+	"encoding/json"
+
+	"github.com/caddyserver/caddy/v2/caddyconfig"
+)
+
+// jsoncAdapter adapts JSON with comments, as in application/jsonc,
+// to Caddy JSON. Line and block comments are removed, as are
+// trailing commas in objects and arrays.
+type jsoncAdapter struct{}
+
+// Adapt returns body as strict JSON.
+func (jsoncAdapter) Adapt(body []byte, _ map[string]interface{}) ([]byte, []caddyconfig.Warning, error) {
+	result, err := stripJSONC(body)
+	if err != nil {
+		return nil, nil, err
+	}
+	var check json.RawMessage
+	if err := json.Unmarshal(result, &check); err != nil {
+		return nil, nil, err
+	}
+	return result, nil, nil
+}
+
+// stripJSONC returns body without its comments and trailing commas.
+// Comments are replaced with whitespace, keeping their newlines, so
+// that line numbers stay the same.
+func stripJSONC(body []byte) ([]byte, error) {
+	out := make([]byte, 0, len(body))
+	for i := 0; i < len(body); i++ {
+		c := body[i]
+		switch {
+		case c == '"':
+			// copy the string as it is, escapes and all
+			start := i
+			for i++; i < len(body) && body[i] != '"'; i++ {
+				if body[i] == '\\' {
+					i++
+				}
+			}
+			if i >= len(body) {
+				return append(out, body[start:]...), nil
+			}
+			out = append(out, body[start:i+1]...)
+
+		case c == '/' && i+1 < len(body) && body[i+1] == '/':
+			for i < len(body) && body[i] != '\n' {
+				i++
+			}
+			if i < len(body) {
+				out = append(out, '\n')
+			}
+
+		case c == '/' && i+1 < len(body) && body[i+1] == '*':
+			end := bytes.Index(body[i+2:], []byte("*/"))
+			if end < 0 {
+				return nil, errorf("unterminated comment")
+			}
+			comment := body[i : i+2+end+2]
+			out = append(out, ' ')
+			out = append(out, bytes.Repeat([]byte{'\n'}, bytes.Count(comment, []byte{'\n'}))...)
+			i += len(comment) - 1
+
+		case c == '}' || c == ']':
+			trimmed := bytes.TrimRight(out, " \t\r\n")
+			if len(trimmed) > 0 && trimmed[len(trimmed)-1] == ',' {
+				// keep the whitespace after the comma
+				out = append(trimmed[:len(trimmed)-1], out[len(trimmed):]...)
+			}
+			out = append(out, c)
+
+		default:
+			out = append(out, c)
+		}
+	}
+	return out, nil
+}
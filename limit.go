@@ -0,0 +1,55 @@
+package adapt
+
+import (
+	"io"
+	"net/http"
+
+	"github.com/caddyserver/caddy/v2"
+)
+
+// limitBody wraps h so that request bodies larger than the
+// max_body_size setting are cut off, and answered with 413.
+func limitBody(h caddy.AdminHandlerFunc) caddy.AdminHandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		max := settings().MaxBodySize
+		if max <= 0 {
+			return h(w, r)
+		}
+		// a body that says it's too large isn't read at all
+		if r.ContentLength > max {
+			return caddy.APIError{
+				HTTPStatus: http.StatusRequestEntityTooLarge,
+				Err:        errorf("request body is larger than the maximum of %d bytes", max),
+			}
+		}
+		body := &limitedBody{ReadCloser: http.MaxBytesReader(w, r.Body, max), max: max}
+		r.Body = body
+
+		err := h(w, r)
+		if body.exceeded {
+			return caddy.APIError{
+				HTTPStatus: http.StatusRequestEntityTooLarge,
+				Err:        errorf("request body is larger than the maximum of %d bytes", max),
+			}
+		}
+		return err
+	}
+}
+
+// limitedBody is a request body limited by http.MaxBytesReader,
+// which records whether the limit was exceeded, as opposed to the
+// body failing to read for some other reason.
+type limitedBody struct {
+	io.ReadCloser
+	read, max int64
+	exceeded  bool
+}
+
+func (lb *limitedBody) Read(p []byte) (int, error) {
+	n, err := lb.ReadCloser.Read(p)
+	lb.read += int64(n)
+	if err != nil && err != io.EOF && lb.read >= lb.max {
+		lb.exceeded = true
+	}
+	return n, err
+}
@@ -0,0 +1,100 @@
+package adapt
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/caddyserver/caddy/v2"
+)
+
+// handleLoad adapts the config in the request like handleAdapt, then
+// loads the result, in one round trip, like the stock /load endpoint.
+// A config that is identical to the running config will be a no-op
+// unless Cache-Control: must-revalidate is set, which PUT ignores, so
+// that putting the same config again is always a no-op. Whether it was
+// different from the running config is in the X-Adapt-Changed header,
+// and the adapter's warnings, if any, in the response body.
+func (adminAdapt) handleLoad(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != http.MethodPost && r.Method != http.MethodPut {
+		return caddy.APIError{
+			HTTPStatus: http.StatusMethodNotAllowed,
+			Err:        errorf("method not allowed"),
+		}
+	}
+
+	buf := getBuffer()
+	defer putBuffer(buf)
+
+	a, err := adaptRequest(r, buf)
+	if err != nil {
+		return err
+	}
+
+	// a request that was given up on doesn't change the config
+	if r.Context().Err() != nil {
+		return contextError(r.Context())
+	}
+
+	cfgJSON, err := applySecrets(r, a.result)
+	if err != nil {
+		return err
+	}
+
+	forceReload := r.Method == http.MethodPost && r.Header.Get("Cache-Control") == "must-revalidate"
+
+	var changed bool
+	err = applyConfig(w, r, func() error {
+		changed, err = loadConfig(r, cfgJSON, forceReload)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	w.Header().Set("X-Adapt-Changed", strconv.FormatBool(changed))
+
+	logger(r).Info("load complete")
+
+	newProvenance(r, a.adapter, a.source).setHeaders(w.Header())
+	if len(a.warnings) > 0 {
+		w.Header().Set("Content-Type", "application/json")
+		return json.NewEncoder(w).Encode(a.warnings)
+	}
+	return nil
+}
+
+// loadConfig loads cfgJSON, an adapted config, for r, like the stock
+// /load endpoint. It is a no-op if cfgJSON has the hash of the running
+// config, unless forceReload. It returns whether cfgJSON is different
+// from the running config.
+func loadConfig(r *http.Request, cfgJSON []byte, forceReload bool) (bool, error) {
+	changed := !isRunningConfig(r, cfgJSON)
+	if !changed && !forceReload {
+		return false, nil
+	}
+	_, span := startSpan(r.Context(), "load config")
+	err := caddy.Load(cfgJSON, forceReload)
+	endSpan(span, err)
+	if err != nil {
+		return false, caddy.APIError{
+			HTTPStatus: http.StatusBadRequest,
+			Err:        errorf("loading config: %v", err),
+		}
+	}
+	return changed, nil
+}
+
+// isRunningConfig returns true if cfgJSON has the hash of the
+// running config, as far as it can be read.
+func isRunningConfig(r *http.Request, cfgJSON []byte) bool {
+	running, err := runningConfig(r)
+	if err != nil {
+		return false
+	}
+	runningHash, err := configHash(running)
+	if err != nil {
+		return false
+	}
+	hash, err := configHash(cfgJSON)
+	return err == nil && hash == runningHash
+}
@@ -0,0 +1,105 @@
+package adapt
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// loadTestConfig is a config that keeps the adapt app running.
+const loadTestConfig = `{"admin":{"disabled":true,"config":{"persist":false}},"apps":{"adapt":{}}}`
+
+// load sends cfg to /adapt/load with method, as the running config
+// is, and returns the response and whether the config was reloaded.
+func load(t *testing.T, method, cfg string) (*httptest.ResponseRecorder, bool) {
+	t.Helper()
+	defer func(running []byte) { runningTestConfig = running }(runningTestConfig)
+	runningTestConfig = []byte(cfg)
+
+	generation := settings().generation
+	r := httptest.NewRequest(method, "/adapt/load", strings.NewReader(cfg))
+	r.Header.Set("Content-Type", "application/json")
+	r.Header.Set("Cache-Control", "must-revalidate")
+	w := serve(r)
+	return w, settings().generation != generation
+}
+
+func TestLoadPutIsIdempotent(t *testing.T) {
+	startApp(t, &App{})
+	w, reloaded := load(t, http.MethodPut, loadTestConfig)
+	expectStatus(t, w, http.StatusOK)
+	if reloaded {
+		t.Fatal("expected putting the running config to be a no-op")
+	}
+	w, reloaded = load(t, http.MethodPost, loadTestConfig)
+	expectStatus(t, w, http.StatusOK)
+	if !reloaded {
+		t.Fatal("expected must-revalidate to reload the running config")
+	}
+}
+
+func TestLoadChanged(t *testing.T) {
+	startApp(t, &App{})
+	w, _ := load(t, http.MethodPost, loadTestConfig)
+	if changed := w.Header().Get("X-Adapt-Changed"); changed != "false" {
+		t.Fatalf("expected the running config to be unchanged, got X-Adapt-Changed: %s", changed)
+	}
+
+	w = post("/adapt/load", "application/json", loadTestConfig)
+	expectStatus(t, w, http.StatusOK)
+	if changed := w.Header().Get("X-Adapt-Changed"); changed != "true" {
+		t.Fatalf("expected the running config to be changed, got X-Adapt-Changed: %s", changed)
+	}
+}
+
+func TestLoadDiffWebhook(t *testing.T) {
+	diffs := make(chan string, 1)
+	hook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		diffs <- r.Header.Get("Content-Type") + " " + r.Header.Get("X-Adapt-Event") + " " + string(body)
+	}))
+	defer hook.Close()
+	startApp(t, &App{Webhooks: []*Webhook{{URL: hook.URL, Diffs: true}}})
+
+	// the running config, as the admin endpoint reports it
+	// before and after the config is applied
+	defer func(running []byte) { runningTestConfig = running }(runningTestConfig)
+	runningTestConfig = []byte(`{"apps":{"echo":{"body":"before","token":"old"}}}`)
+	admin := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Write(runningTestConfig)
+	})}
+	r := httptest.NewRequest(http.MethodPost, "/adapt/load", nil)
+	r = r.WithContext(context.WithValue(r.Context(), http.ServerContextKey, admin))
+
+	_, err := applyConfigIf(r, "", func() error {
+		runningTestConfig = []byte(`{"apps":{"echo":{"body":"after","token":"new"}}}`)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case diff := <-diffs:
+		// the tokens are redacted, so they don't differ
+		expected := `application/json-patch+json load [{"op":"replace","path":"/apps/echo/body","value":"after"}]`
+		if diff != expected {
+			t.Fatalf("expected the diff %s, got %s", expected, diff)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the diff to be delivered")
+	}
+
+	// applying the same config again changes nothing to deliver
+	if _, err := applyConfigIf(r, "", func() error { return nil }); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case diff := <-diffs:
+		t.Fatalf("expected no diff for an unchanged config, got %s", diff)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
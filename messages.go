@@ -0,0 +1,179 @@
+package adapt
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/caddyserver/caddy/v2"
+)
+
+// RegisterMessages adds translations for the messages this module
+// produces (not those produced by config adapters) in the given
+// language, which should be a BCP 47 tag such as "de" or "pt-BR".
+// Translations are keyed by the English format string of the message
+// and have the same formatting verbs in the same order. Messages with
+// no translation fall back to English. This should usually be done
+// at init-time; registering the same language again merges into the
+// existing catalog.
+func RegisterMessages(lang string, translations map[string]string) {
+	lang = strings.ToLower(lang)
+	messagesMu.Lock()
+	defer messagesMu.Unlock()
+	catalog, ok := messages[lang]
+	if !ok {
+		catalog = make(map[string]string, len(translations))
+		messages[lang] = catalog
+	}
+	for format, translated := range translations {
+		catalog[format] = translated
+	}
+}
+
+// message is an error or warning produced by this module. Its
+// format is also its key in the message catalogs, so it renders
+// as English unless localized.
+type message struct {
+	format string
+	args   []interface{}
+}
+
+// errorf returns an error whose text can be localized. It should
+// be used in place of fmt.Errorf for messages that reach clients.
+func errorf(format string, args ...interface{}) error {
+	return message{format: format, args: args}
+}
+
+// messagef returns a message that reaches clients other than as an
+// error, such as a warning, to be localized before it is sent.
+func messagef(format string, args ...interface{}) message {
+	return message{format: format, args: args}
+}
+
+// localizedText returns the text of err, localized into
+// one of langs if it is a message from this module.
+func localizedText(err error, langs []string) string {
+	if m, ok := err.(localizable); ok {
+		return m.localize(langs)
+	}
+	return err.Error()
+}
+
+// localizable is an error that can be localized, which is a message
+// or an error type that embeds one.
+type localizable interface {
+	error
+	localize(langs []string) string
+}
+
+func (m message) Error() string { return fmt.Sprintf(m.format, m.args...) }
+
+// localize renders m in the first of langs that has a translation
+// for it, localizing any arguments that are messages themselves.
+func (m message) localize(langs []string) string {
+	format := m.format
+	messagesMu.RLock()
+	for _, lang := range langs {
+		if translated, ok := messages[lang][m.format]; ok {
+			format = translated
+			break
+		}
+	}
+	messagesMu.RUnlock()
+
+	args := make([]interface{}, len(m.args))
+	for i, arg := range m.args {
+		if nested, ok := arg.(message); ok {
+			arg = nested.localize(langs)
+		}
+		args[i] = arg
+	}
+	return fmt.Sprintf(format, args...)
+}
+
+// localized wraps h so that module-produced error messages are
+// rendered in the client's preferred language per Accept-Language.
+func localized(h caddy.AdminHandlerFunc) caddy.AdminHandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		err := h(w, r)
+		if err == nil {
+			return nil
+		}
+		langs := acceptedLanguages(r.Header.Get("Accept-Language"))
+		if len(langs) == 0 {
+			return err
+		}
+		return localizeError(err, langs)
+	}
+}
+
+// localizeError returns err with its client-facing message localized,
+// if it is (or wraps, as an APIError) a message from this module.
+func localizeError(err error, langs []string) error {
+	switch e := err.(type) {
+	case localizable:
+		return caddy.APIError{
+			HTTPStatus: http.StatusInternalServerError,
+			Err:        e,
+			Message:    e.localize(langs),
+		}
+	case caddy.APIError:
+		if e.Message != "" || e.Err == nil {
+			return e
+		}
+		if inner, ok := localizeError(e.Err, langs).(caddy.APIError); ok && inner.Message != "" {
+			e.Message = inner.Message
+		}
+		return e
+	}
+	return err
+}
+
+// acceptedLanguages parses an Accept-Language header value and returns
+// the lower-cased language tags in order of preference. Each regional
+// tag is followed by its base language as a fallback (e.g. "de-ch"
+// implies "de"), and the wildcard is omitted.
+func acceptedLanguages(header string) []string {
+	type weighted struct {
+		tag string
+		q   float64
+	}
+	var tags []weighted
+	for _, part := range strings.Split(header, ",") {
+		tag := strings.TrimSpace(part)
+		q := 1.0
+		if semi := strings.Index(tag, ";"); semi >= 0 {
+			params := strings.TrimSpace(tag[semi+1:])
+			tag = strings.TrimSpace(tag[:semi])
+			if strings.HasPrefix(params, "q=") {
+				parsed, err := strconv.ParseFloat(params[2:], 64)
+				if err != nil {
+					continue
+				}
+				q = parsed
+			}
+		}
+		if tag == "" || tag == "*" || q <= 0 {
+			continue
+		}
+		tags = append(tags, weighted{strings.ToLower(tag), q})
+	}
+	sort.SliceStable(tags, func(i, j int) bool { return tags[i].q > tags[j].q })
+
+	langs := make([]string, 0, len(tags))
+	for _, t := range tags {
+		langs = append(langs, t.tag)
+		if dash := strings.Index(t.tag, "-"); dash > 0 {
+			langs = append(langs, t.tag[:dash])
+		}
+	}
+	return langs
+}
+
+var (
+	messages   = make(map[string]map[string]string)
+	messagesMu sync.RWMutex
+)
@@ -0,0 +1,179 @@
+package adapt
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// The metrics are registered with the default registry, like
+// Caddy's own admin metrics, so they are served alongside them,
+// unless disable_metrics is set.
+var adaptMetrics = struct {
+	requests    *prometheus.CounterVec
+	duration    *prometheus.HistogramVec
+	sourceBytes *prometheus.HistogramVec
+	resultBytes *prometheus.HistogramVec
+}{
+	requests: promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "caddy",
+		Subsystem: "admin_adapt",
+		Name:      "requests_total",
+		Help:      "Counter of requests to the /adapt endpoints, by endpoint, adapter and status code.",
+	}, []string{"endpoint", "adapter", "code"}),
+	duration: promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "caddy",
+		Subsystem: "admin_adapt",
+		Name:      "adaptation_duration_seconds",
+		Help:      "Histogram of the time spent running config adapters.",
+		Buckets:   []float64{.001, .005, .01, .05, .1, .25, .5, 1, 2.5, 5, 10},
+	}, []string{"adapter"}),
+	sourceBytes: promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "caddy",
+		Subsystem: "admin_adapt",
+		Name:      "source_size_bytes",
+		Help:      "Histogram of the size of the configs given to config adapters.",
+		Buckets:   prometheus.ExponentialBuckets(256, 4, 8), // 256B to 4MiB
+	}, []string{"adapter"}),
+	resultBytes: promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "caddy",
+		Subsystem: "admin_adapt",
+		Name:      "result_size_bytes",
+		Help:      "Histogram of the size of the configs that config adapters output.",
+		Buckets:   prometheus.ExponentialBuckets(256, 4, 8), // 256B to 4MiB
+	}, []string{"adapter"}),
+}
+
+// recordMetrics returns true unless the metrics are disabled.
+func recordMetrics() bool {
+	return !settings().DisableMetrics
+}
+
+// resetMetrics drops the metrics recorded so far, so that
+// disabled metrics aren't exported either.
+func resetMetrics() {
+	adaptMetrics.requests.Reset()
+	adaptMetrics.duration.Reset()
+	adaptMetrics.sourceBytes.Reset()
+	adaptMetrics.resultBytes.Reset()
+}
+
+// instrumented wraps h, the handler of the route with the given
+// pattern, so that its requests are counted and logged.
+func instrumented(pattern string, h caddy.AdminHandler) caddy.AdminHandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		stats := new(requestStats)
+		r = r.WithContext(context.WithValue(r.Context(), requestStatsCtxKey, stats))
+		sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+		start := time.Now()
+
+		err := h.ServeHTTP(sw, r)
+
+		status := sw.status
+		if err != nil {
+			// the admin server writes the error
+			status = errorStatus(err)
+			stats.err = err
+		}
+		if recordMetrics() {
+			adaptMetrics.requests.WithLabelValues(pattern, stats.adapterName(), strconv.Itoa(status)).Inc()
+		}
+		id := sw.Header().Get("X-Request-ID")
+		logRequest(r, id, pattern, status, time.Since(start), stats)
+		if audited(r) {
+			recordAudit(r, id, status, stats)
+		}
+		return err
+	}
+}
+
+// errorStatus returns the status code of the
+// response that the admin server gives for err.
+func errorStatus(err error) int {
+	if apiErr, ok := err.(caddy.APIError); ok && apiErr.HTTPStatus != 0 {
+		return apiErr.HTTPStatus
+	}
+	return http.StatusInternalServerError
+}
+
+// requestStats is what is known about a request for its
+// metrics and log.
+type requestStats struct {
+	adapter      string
+	sourceSize   int
+	sourceSHA256 string // only if auditing
+	resultSHA256 string // only if auditing
+	warnings     int
+	adapted      bool
+	err          error
+}
+
+// requestStatsFrom returns the stats of the request with the given
+// context, or nil if it isn't instrumented.
+func requestStatsFrom(ctx context.Context) *requestStats {
+	stats, _ := ctx.Value(requestStatsCtxKey).(*requestStats)
+	return stats
+}
+
+// noteAdapter records that the request with the given
+// context uses the named adapter.
+func noteAdapter(ctx context.Context, name string) {
+	if stats := requestStatsFrom(ctx); stats != nil {
+		stats.adapter = name
+	}
+}
+
+// noteAdaptation records that the request with the given context
+// adapted source to result, with that many warnings.
+func noteAdaptation(ctx context.Context, source, result []byte, warnings int) {
+	if stats := requestStatsFrom(ctx); stats != nil {
+		stats.sourceSize = len(source)
+		stats.warnings = warnings
+		stats.adapted = true
+		if settings().auditing() {
+			stats.sourceSHA256 = sha256Hex(source)
+			stats.resultSHA256 = sha256Hex(result)
+		}
+	}
+}
+
+// noteError records the error that the request with the
+// given context failed with.
+func noteError(ctx context.Context, err error) {
+	if stats := requestStatsFrom(ctx); stats != nil {
+		stats.err = err
+	}
+}
+
+// adapterName returns the name of the adapter the request
+// used, or "none" if it didn't get as far as choosing one.
+func (s *requestStats) adapterName() string {
+	if s.adapter == "" {
+		return "none"
+	}
+	return s.adapter
+}
+
+// statusWriter is an http.ResponseWriter that
+// records the status code of the response.
+type statusWriter struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+}
+
+func (sw *statusWriter) WriteHeader(status int) {
+	if !sw.wroteHeader {
+		sw.status = status
+		sw.wroteHeader = true
+	}
+	sw.ResponseWriter.WriteHeader(status)
+}
+
+// requestStatsCtxKey is the context key for a request's stats.
+const requestStatsCtxKey caddy.CtxKey = "adapt_request_stats"
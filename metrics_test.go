@@ -0,0 +1,55 @@
+package adapt
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestDisableMetrics(t *testing.T) {
+	startApp(t, &App{})
+	expectStatus(t, post("/adapt?adapter=test-echo", "", "metrics"), http.StatusOK)
+	if testutil.CollectAndCount(adaptMetrics.requests) == 0 {
+		t.Fatal("expected the request to be counted")
+	}
+
+	startApp(t, &App{DisableMetrics: true})
+	if n := testutil.CollectAndCount(adaptMetrics.requests); n != 0 {
+		t.Fatalf("expected the metrics recorded before to be dropped, got %d series", n)
+	}
+	expectStatus(t, post("/adapt?adapter=test-echo", "", "metrics"), http.StatusOK)
+	for name, n := range map[string]int{
+		"requests":     testutil.CollectAndCount(adaptMetrics.requests),
+		"duration":     testutil.CollectAndCount(adaptMetrics.duration),
+		"source bytes": testutil.CollectAndCount(adaptMetrics.sourceBytes),
+		"result bytes": testutil.CollectAndCount(adaptMetrics.resultBytes),
+	} {
+		if n != 0 {
+			t.Fatalf("expected no %s metrics with disable_metrics, got %d series", name, n)
+		}
+	}
+}
+
+func TestResultSizeMetric(t *testing.T) {
+	startApp(t, &App{DisableCache: true})
+	resetMetrics()
+	w := post("/adapt?adapter=test-echo", "", "sized")
+	expectStatus(t, w, http.StatusOK)
+
+	size := len(`{"apps":{"echo":{"body":"sized"}}}`)
+	expected := `
+# HELP caddy_admin_adapt_result_size_bytes Histogram of the size of the configs that config adapters output.
+# TYPE caddy_admin_adapt_result_size_bytes histogram
+`
+	for _, bound := range []string{"256", "1024", "4096", "16384", "65536", "262144", "1.048576e+06", "4.194304e+06", "+Inf"} {
+		expected += `caddy_admin_adapt_result_size_bytes_bucket{adapter="test-echo",le="` + bound + `"} 1` + "\n"
+	}
+	expected += `caddy_admin_adapt_result_size_bytes_sum{adapter="test-echo"} ` + strconv.Itoa(size) + "\n"
+	expected += `caddy_admin_adapt_result_size_bytes_count{adapter="test-echo"} 1` + "\n"
+	if err := testutil.CollectAndCompare(adaptMetrics.resultBytes, strings.NewReader(expected)); err != nil {
+		t.Fatal(err)
+	}
+}
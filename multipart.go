@@ -0,0 +1,282 @@
+package adapt
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig"
+)
+
+// configField is the name of the multipart form field that
+// holds the main config; every other field is a file it imports.
+const configField = "config"
+
+// adapterField is the name of the multipart form field that names
+// the adapter, for HTML forms, which can't set a Content-Type or
+// query string of their own. A file uploaded as it is an import.
+const adapterField = "adapter"
+
+// includes are the files uploaded along with a config in a
+// multipart request, for it to import.
+type includes struct {
+	main    string            // the name the main config is adapted as
+	adapter string            // from the adapter field, if any
+	files   map[string][]byte // by slash-separated relative path
+}
+
+// isMultipart returns true if contentType is multipart/form-data.
+func isMultipart(contentType string) bool {
+	ct, _, err := mime.ParseMediaType(contentType)
+	return err == nil && ct == "multipart/form-data"
+}
+
+// readMultipart reads the main config of the multipart/form-data
+// request r into buf, and returns the files it includes. The main
+// config is adapted under the file name it was uploaded with, or
+// "Caddyfile", and the includes under their field names, which
+// are paths relative to it. The adapter field, if not a file,
+// names the adapter instead.
+func readMultipart(r *http.Request, buf *bytes.Buffer) (*includes, error) {
+	mr, err := r.MultipartReader()
+	if err != nil {
+		return nil, caddy.APIError{
+			HTTPStatus: http.StatusBadRequest,
+			Err:        errorf("reading multipart body: %v", err),
+		}
+	}
+
+	inc := &includes{files: make(map[string][]byte)}
+	found := false
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, caddy.APIError{
+				HTTPStatus: http.StatusBadRequest,
+				Err:        errorf("reading multipart body: %v", err),
+			}
+		}
+
+		name := part.FormName()
+		if name == configField {
+			if found {
+				return nil, caddy.APIError{
+					HTTPStatus: http.StatusBadRequest,
+					Err:        errorf("multipart body has more than one %s field", configField),
+				}
+			}
+			found = true
+			inc.main = path.Base("/" + filepath.ToSlash(part.FileName()))
+			if inc.main == "/" {
+				inc.main = "Caddyfile"
+			}
+			_, err = io.Copy(buf, part)
+			if err == nil {
+				var main []byte
+				main, err = toUTF8(buf.Bytes(), part.Header.Get("Content-Type"))
+				if err != nil {
+					return nil, err
+				}
+				buf.Reset()
+				buf.Write(main)
+			}
+		} else if name == adapterField && part.FileName() == "" {
+			if inc.adapter != "" {
+				return nil, caddy.APIError{
+					HTTPStatus: http.StatusBadRequest,
+					Err:        errorf("multipart body has more than one %s field", adapterField),
+				}
+			}
+			var value []byte
+			value, err = ioutil.ReadAll(io.LimitReader(part, 1024))
+			inc.adapter = strings.TrimSpace(string(value))
+			if err == nil && inc.adapter == "" {
+				return nil, caddy.APIError{
+					HTTPStatus: http.StatusBadRequest,
+					Err:        errorf("%s field is empty", adapterField),
+				}
+			}
+		} else {
+			name, err = includePath(name)
+			if err != nil {
+				return nil, err
+			}
+			if _, ok := inc.files[name]; ok {
+				return nil, caddy.APIError{
+					HTTPStatus: http.StatusBadRequest,
+					Err:        errorf("multipart body has more than one file named %s", name),
+				}
+			}
+			var contents []byte
+			contents, err = ioutil.ReadAll(part)
+			if err == nil {
+				inc.files[name], err = toUTF8(contents, part.Header.Get("Content-Type"))
+				if err != nil {
+					return nil, err
+				}
+			}
+		}
+		if err != nil {
+			return nil, caddy.APIError{
+				HTTPStatus: http.StatusBadRequest,
+				Err:        errorf("reading multipart body: %v", err),
+			}
+		}
+	}
+
+	if !found {
+		return nil, caddy.APIError{
+			HTTPStatus: http.StatusBadRequest,
+			Err:        errorf("multipart body has no %s field", configField),
+		}
+	}
+	if _, ok := inc.files[inc.main]; ok {
+		return nil, caddy.APIError{
+			HTTPStatus: http.StatusBadRequest,
+			Err:        errorf("included file %s has the same name as the config", inc.main),
+		}
+	}
+	return inc, nil
+}
+
+// formAdapter returns the adapter named by the adapter field of a
+// multipart body, in place of current, which r would otherwise be
+// adapted with. Only a default may be replaced; an adapter that r
+// asks for some other way has to be the same one.
+func formAdapter(r *http.Request, current Adapter, name string) (Adapter, error) {
+	adapter, err := AdapterByName(name)
+	if err != nil {
+		return Adapter{}, err
+	}
+	query := r.URL.Query()
+	explicit := impliedAdapter(r.Context()) != "" || query.Get("adapter") != "" ||
+		query.Get("chain") != "" || r.Header.Get("X-Adapter-Chain") != ""
+	if explicit && adapter.Name() != current.Name() {
+		return Adapter{}, errorf("%s field %s conflicts with adapter %s", adapterField, adapter.Name(), current.Name())
+	}
+	return adapter, nil
+}
+
+// includePath returns the cleaned relative path of an included file
+// named name, which may not be absolute or traverse upwards.
+func includePath(name string) (string, error) {
+	name = filepath.ToSlash(name)
+	for _, elem := range strings.Split(name, "/") {
+		if elem == ".." {
+			return "", caddy.APIError{
+				HTTPStatus: http.StatusBadRequest,
+				Err:        errorf("included file %s may not traverse outside of the config's directory", name),
+			}
+		}
+	}
+	cleaned := path.Clean(name)
+	if name == "" || path.IsAbs(cleaned) || cleaned == "." {
+		return "", caddy.APIError{
+			HTTPStatus: http.StatusBadRequest,
+			Err:        errorf("included file name '%s' is not a relative path", name),
+		}
+	}
+	return cleaned, nil
+}
+
+// keyInput returns what to key the adaptation of body with the
+// includes by, since body alone doesn't determine the result.
+func (inc *includes) keyInput(body []byte) []byte {
+	names := make([]string, 0, len(inc.files))
+	for name := range inc.files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var b bytes.Buffer
+	b.WriteString(inc.main)
+	for _, name := range names {
+		b.WriteByte(0)
+		b.WriteString(name)
+		b.WriteByte(0)
+		b.Write(inc.files[name])
+	}
+	b.WriteByte(0)
+	b.Write(body)
+	return b.Bytes()
+}
+
+// adapt adapts body, the main config, with its includes written
+// to a temporary directory for the adapter to import them from.
+// File names in the warnings and error are relative to it.
+func (inc *includes) adapt(adaptFunc func(options map[string]interface{}) ([]byte, []caddyconfig.Warning, error), body []byte, options map[string]interface{}) ([]byte, []caddyconfig.Warning, error) {
+	dir, err := ioutil.TempDir("", "caddy-adapt-")
+	if err != nil {
+		return nil, nil, err
+	}
+	defer os.RemoveAll(dir)
+
+	for name, contents := range inc.files {
+		filename := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(filename), 0700); err != nil {
+			return nil, nil, err
+		}
+		if err := ioutil.WriteFile(filename, contents, 0600); err != nil {
+			return nil, nil, err
+		}
+	}
+	mainFile := filepath.Join(dir, inc.main)
+	if err := ioutil.WriteFile(mainFile, body, 0600); err != nil {
+		return nil, nil, err
+	}
+
+	dirOptions := make(map[string]interface{}, len(options)+1)
+	for k, v := range options {
+		dirOptions[k] = v
+	}
+	dirOptions["filename"] = mainFile
+
+	prefix := dir + string(filepath.Separator)
+	result, warnings, err := adaptFunc(dirOptions)
+	for i := range warnings {
+		warnings[i].File = strings.TrimPrefix(warnings[i].File, prefix)
+	}
+	if err != nil {
+		return nil, nil, trimPrefixError(err, prefix)
+	}
+	return result, warnings, nil
+}
+
+// trimPrefixError removes prefix from the file names in err.
+func trimPrefixError(err error, prefix string) error {
+	switch e := err.(type) {
+	case sourceError:
+		pos := *e.sourcePosition
+		pos.File = strings.TrimPrefix(pos.File, prefix)
+		pos.Message = strings.Replace(pos.Message, prefix, "", -1)
+		return sourceError{message: trimPrefixMessage(e.message, prefix), sourcePosition: &pos}
+	case message:
+		return trimPrefixMessage(e, prefix)
+	}
+	return err
+}
+
+func trimPrefixMessage(m message, prefix string) message {
+	args := make([]interface{}, len(m.args))
+	for i, arg := range m.args {
+		switch a := arg.(type) {
+		case message:
+			arg = trimPrefixMessage(a, prefix)
+		case error:
+			arg = strings.Replace(a.Error(), prefix, "", -1)
+		}
+		args[i] = arg
+	}
+	return message{format: m.format, args: args}
+}
@@ -0,0 +1,48 @@
+package adapt
+
+import (
+	"bytes"
+	"encoding/json"
+)
+
+// ndjsonContentType is the media type of the NDJSON encoding of the
+// /adapt response envelope: a line for each warning, then one with
+// the rest of the envelope.
+const ndjsonContentType = "application/x-ndjson"
+
+// ndjsonWarning is a line of an NDJSON envelope for a warning.
+type ndjsonWarning struct {
+	Warning adaptWarning `json:"warning"`
+}
+
+// ndjsonResult is the last line of an NDJSON envelope, which
+// is the rest of it after the warnings.
+type ndjsonResult struct {
+	Result     json.RawMessage `json:"result"`
+	Provenance provenance      `json:"provenance"`
+	Report     *adaptReport    `json:"report,omitempty"`
+	Stats      *configStats    `json:"stats,omitempty"`
+	Source     *string         `json:"source,omitempty"`
+}
+
+// encodeNDJSONEnvelope encodes envelope as NDJSON, so that clients can
+// act on each of many warnings without decoding all of them at once.
+// The adapters only return their warnings once they are done, so
+// they are all written together.
+func encodeNDJSONEnvelope(envelope adaptEnvelope) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	for _, w := range envelope.Warnings {
+		if err := enc.Encode(ndjsonWarning{w}); err != nil {
+			return nil, err
+		}
+	}
+	err := enc.Encode(ndjsonResult{
+		Result:     envelope.Result,
+		Provenance: envelope.Provenance,
+		Report:     envelope.Report,
+		Stats:      envelope.Stats,
+		Source:     envelope.Source,
+	})
+	return buf.Bytes(), err
+}
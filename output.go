@@ -0,0 +1,89 @@
+package adapt
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+
+	"github.com/caddyserver/caddy/v2"
+)
+
+// JSON output formats, for ?pretty, ?minify and
+// the output_format setting.
+const (
+	outputAsIs   = ""
+	outputPretty = "pretty"
+	outputMinify = "minify"
+)
+
+// outputFormat returns the format that JSON responses to r should be
+// in: pretty-printed with ?pretty=true, compacted with ?minify=true,
+// and otherwise as configured, which by default is as the adapter
+// emitted it.
+func outputFormat(r *http.Request) (string, error) {
+	pretty, err := queryBool(r, "pretty")
+	if err != nil {
+		return "", err
+	}
+	minify, err := queryBool(r, "minify")
+	if err != nil {
+		return "", err
+	}
+	switch {
+	case pretty && minify:
+		return "", caddy.APIError{
+			HTTPStatus: http.StatusBadRequest,
+			Err:        errorf("pretty and minify are mutually exclusive"),
+		}
+	case pretty:
+		return outputPretty, nil
+	case minify:
+		return outputMinify, nil
+	}
+	return settings().OutputFormat, nil
+}
+
+// canonicalJSON returns the JSON document in cfgJSON in canonical
+// form: compact, with object keys in sorted order, and numbers and
+// strings as they were, so that configs that are equal in content
+// are equal byte for byte.
+func canonicalJSON(cfgJSON []byte) ([]byte, error) {
+	dec := json.NewDecoder(bytes.NewReader(cfgJSON))
+	dec.UseNumber()
+	var val interface{}
+	if err := dec.Decode(&val); err != nil {
+		return nil, caddy.APIError{
+			HTTPStatus: http.StatusBadRequest,
+			Err:        errorf("adapted config is not valid JSON: %v", err),
+		}
+	}
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(val); err != nil {
+		return nil, err
+	}
+	return bytes.TrimSuffix(buf.Bytes(), []byte("\n")), nil
+}
+
+// formatJSON returns the JSON document in cfgJSON in the given format.
+func formatJSON(cfgJSON []byte, format string) ([]byte, error) {
+	var buf bytes.Buffer
+	var err error
+	switch format {
+	case outputPretty:
+		err = json.Indent(&buf, cfgJSON, "", "\t")
+		buf.WriteByte('\n')
+	case outputMinify:
+		err = json.Compact(&buf, cfgJSON)
+	default:
+		return cfgJSON, nil
+	}
+	if err != nil {
+		return nil, caddy.APIError{
+			HTTPStatus: http.StatusBadRequest,
+			Err:        errorf("adapted config is not valid JSON: %v", err),
+		}
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,160 @@
+package adapt
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"strings"
+
+	"github.com/caddyserver/caddy/v2"
+)
+
+// handleOverlay composes a config from a base and overlays, posted
+// as the parts of a multipart body, in that order. Each part is
+// adapted according to its own Content-Type and then merged into
+// the result so far as a JSON Merge Patch (RFC 7386): objects are
+// merged recursively, null removes a field, and anything else
+// replaces what was there.
+func (adminAdapt) handleOverlay(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != http.MethodPost {
+		return caddy.APIError{
+			HTTPStatus: http.StatusMethodNotAllowed,
+			Err:        errorf("method not allowed"),
+		}
+	}
+
+	mediaType, params, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil || !strings.HasPrefix(mediaType, "multipart/") || params["boundary"] == "" {
+		return caddy.APIError{
+			HTTPStatus: http.StatusUnsupportedMediaType,
+			Err:        errorf("overlays must be posted as a multipart body"),
+		}
+	}
+
+	buf := getBuffer()
+	defer putBuffer(buf)
+
+	_, err = io.Copy(buf, r.Body)
+	if err != nil {
+		return caddy.APIError{
+			HTTPStatus: http.StatusBadRequest,
+			Err:        errorf("reading request body: %v", err),
+		}
+	}
+	mr := multipart.NewReader(bytes.NewReader(buf.Bytes()), params["boundary"])
+
+	// each part is checked on its own, and the
+	// result is transformed once they are merged
+	checks, err := requestChecks(r)
+	if err != nil {
+		return err
+	}
+	checks.partial = true
+
+	var merged interface{}
+	var adapters []string
+	for i := 0; ; i++ {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return caddy.APIError{
+				HTTPStatus: http.StatusBadRequest,
+				Err:        errorf("reading part %d: %v", i, err),
+			}
+		}
+
+		adapter, err := AdapterByContentType(part.Header.Get("Content-Type"))
+		if err != nil {
+			return caddy.APIError{
+				HTTPStatus: http.StatusBadRequest,
+				Err:        errorf("part %d: %v", i, err),
+			}
+		}
+		body, err := ioutil.ReadAll(part)
+		if err != nil {
+			return caddy.APIError{
+				HTTPStatus: http.StatusBadRequest,
+				Err:        errorf("reading part %d: %v", i, err),
+			}
+		}
+		var options map[string]interface{}
+		if filename := part.FileName(); filename != "" {
+			options = map[string]interface{}{"filename": filename}
+		}
+
+		checks.signature = part.Header.Get("X-Signature")
+		checks.encryption = part.Header.Get("X-Encryption")
+		checks.contentType = part.Header.Get("Content-Type")
+		result, _, err := adaptChecked(r.Context(), r.URL.Path, adapter, body, options, checks)
+		if apiErr, ok := err.(caddy.APIError); ok {
+			return apiErr
+		}
+		if err != nil {
+			return caddy.APIError{
+				HTTPStatus: http.StatusBadRequest,
+				Err:        errorf("part %d: %v", i, err),
+			}
+		}
+		var overlay interface{}
+		if err := json.Unmarshal(result, &overlay); err != nil {
+			return caddy.APIError{
+				HTTPStatus: http.StatusBadRequest,
+				Err:        errorf("part %d: adapted config is not valid JSON: %v", i, err),
+			}
+		}
+
+		if i == 0 {
+			merged = overlay
+		} else {
+			merged = mergePatch(merged, overlay)
+		}
+		adapters = append(adapters, adapter.Name())
+	}
+	if len(adapters) == 0 {
+		return caddy.APIError{
+			HTTPStatus: http.StatusBadRequest,
+			Err:        errorf("no base config"),
+		}
+	}
+
+	result, err := json.Marshal(merged)
+	if err != nil {
+		return err
+	}
+	if result, err = transformConfig(r.Context(), result); err != nil {
+		return err
+	}
+
+	newProvenance(r, strings.Join(adapters, ","), buf.Bytes()).setHeaders(w.Header())
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(result)
+
+	return nil
+}
+
+// mergePatch applies patch to target per JSON Merge Patch (RFC 7386),
+// modifying and returning target.
+func mergePatch(target, patch interface{}) interface{} {
+	patchObj, ok := patch.(map[string]interface{})
+	if !ok {
+		return patch
+	}
+	targetObj, ok := target.(map[string]interface{})
+	if !ok {
+		targetObj = make(map[string]interface{}, len(patchObj))
+	}
+	for key, val := range patchObj {
+		if val == nil {
+			delete(targetObj, key)
+			continue
+		}
+		targetObj[key] = mergePatch(targetObj[key], val)
+	}
+	return targetObj
+}
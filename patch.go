@@ -0,0 +1,93 @@
+package adapt
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/caddyserver/caddy/v2"
+)
+
+// handlePatch adapts the config in the request like handleAdapt,
+// then changes the running config at the path after /adapt/patch
+// to the result (or the part of it at ?path), the way the same
+// method does at that path under /config/: POST appends to arrays
+// or creates, PUT inserts or creates strictly, and PATCH replaces.
+// This lets a client manage one part of the config, such as a
+// site, without owning the rest of it.
+func (adminAdapt) handlePatch(w http.ResponseWriter, r *http.Request) error {
+	switch r.Method {
+	case http.MethodPost, http.MethodPut, http.MethodPatch:
+	default:
+		return caddy.APIError{
+			HTTPStatus: http.StatusMethodNotAllowed,
+			Err:        errorf("method not allowed"),
+		}
+	}
+
+	target := strings.TrimPrefix(r.URL.Path, "/adapt/patch")
+	if strings.Trim(target, "/") == "" {
+		return caddy.APIError{
+			HTTPStatus: http.StatusBadRequest,
+			Err:        errorf("no config path to patch; use /adapt/patch/<path>, or /adapt/load for the whole config"),
+		}
+	}
+	pointer := r.URL.Query().Get("path")
+	if _, err := parsePointer(pointer); err != nil {
+		return err
+	}
+
+	buf := getBuffer()
+	defer putBuffer(buf)
+
+	a, err := adaptRequest(r, buf)
+	if err != nil {
+		return err
+	}
+	fragment, err := extractPointer(a.result, pointer)
+	if err != nil {
+		return err
+	}
+	fragment, err = applySecrets(r, fragment)
+	if err != nil {
+		return err
+	}
+
+	err = applyConfig(w, r, func() error {
+		rec, err := adminRequest(r, r.Method, "/config"+target, fragment)
+		if err != nil {
+			return err
+		}
+		if rec.status != http.StatusOK {
+			return caddy.APIError{
+				HTTPStatus: rec.status,
+				Err:        errorf("patching config at %s: %s", target, adminErrorMessage(rec.body.Bytes())),
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	logger(r).Info("patch complete")
+
+	newProvenance(r, a.adapter, a.source).setHeaders(w.Header())
+	if len(a.warnings) > 0 {
+		w.Header().Set("Content-Type", "application/json")
+		return json.NewEncoder(w).Encode(a.warnings)
+	}
+	return nil
+}
+
+// adminErrorMessage returns the message in an error response body
+// from the admin API, or the body itself if it has none.
+func adminErrorMessage(body []byte) string {
+	var resp struct {
+		Error string `json:"error"`
+	}
+	if err := json.Unmarshal(body, &resp); err == nil && resp.Error != "" {
+		return resp.Error
+	}
+	return strings.TrimSpace(string(body))
+}
@@ -0,0 +1,98 @@
+package adapt
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/caddyserver/caddy/v2"
+)
+
+// parsePointer splits the JSON Pointer (RFC 6901) pointer into
+// its unescaped reference tokens. The empty pointer refers to
+// the whole document, and has none.
+func parsePointer(pointer string) ([]string, error) {
+	if pointer == "" {
+		return nil, nil
+	}
+	if !strings.HasPrefix(pointer, "/") {
+		return nil, caddy.APIError{
+			HTTPStatus: http.StatusBadRequest,
+			Err:        errorf("path %s is not a JSON pointer; it must start with /", pointer),
+		}
+	}
+	tokens := strings.Split(pointer[1:], "/")
+	for i, token := range tokens {
+		tokens[i] = strings.NewReplacer("~1", "/", "~0", "~").Replace(token)
+	}
+	return tokens, nil
+}
+
+// extractPointer returns the value at pointer in cfgJSON.
+func extractPointer(cfgJSON []byte, pointer string) ([]byte, error) {
+	tokens, err := parsePointer(pointer)
+	if err != nil {
+		return nil, err
+	}
+	if len(tokens) == 0 {
+		return cfgJSON, nil
+	}
+
+	// numbers are kept as they are, however large
+	dec := json.NewDecoder(bytes.NewReader(cfgJSON))
+	dec.UseNumber()
+	var val interface{}
+	if err := dec.Decode(&val); err != nil {
+		return nil, errorf("decoding adapted config: %v", err)
+	}
+
+	for i, token := range tokens {
+		var ok bool
+		switch container := val.(type) {
+		case map[string]interface{}:
+			val, ok = container[token]
+		case []interface{}:
+			var idx int
+			idx, ok = arrayIndex(token, len(container))
+			if ok {
+				val = container[idx]
+			}
+		}
+		if !ok {
+			return nil, caddy.APIError{
+				HTTPStatus: http.StatusNotFound,
+				Err:        errorf("path %s does not exist in the adapted config", "/"+strings.Join(escapeAll(tokens[:i+1]), "/")),
+			}
+		}
+	}
+	return json.Marshal(val)
+}
+
+// arrayIndex returns the index referred to by token in an array
+// of length n, which must be in range and have no leading zeros.
+func arrayIndex(token string, n int) (int, bool) {
+	if token == "" || (len(token) > 1 && token[0] == '0') {
+		return 0, false
+	}
+	for _, c := range token {
+		if c < '0' || c > '9' {
+			return 0, false
+		}
+	}
+	idx, err := strconv.Atoi(token)
+	if err != nil || idx >= n {
+		return 0, false
+	}
+	return idx, true
+}
+
+// escapeAll escapes each of tokens for use in a JSON Pointer.
+func escapeAll(tokens []string) []string {
+	escaped := make([]string, len(tokens))
+	for i, token := range tokens {
+		escaped[i] = escapePointer(token)
+	}
+	return escaped
+}
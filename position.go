@@ -0,0 +1,45 @@
+package adapt
+
+import (
+	"regexp"
+	"strconv"
+)
+
+// sourcePosition is where in the config being adapted an error is.
+type sourcePosition struct {
+	File    string `json:"file"`
+	Line    int    `json:"line"`
+	Message string `json:"message"`
+}
+
+// sourceError is an error from an adapter that refers to a position
+// in the config being adapted, which is included in the error
+// response so that editors can point at it.
+type sourceError struct {
+	message
+	*sourcePosition
+}
+
+// sourcePositionRegexp matches the positions in errors from the
+// Caddyfile adapter, which come as "file:line - Error during
+// parsing: message" (or "Syntax error") or "file:line: message".
+var sourcePositionRegexp = regexp.MustCompile(`(?s)(?:^|\s)(\S+?):(\d+)(?: - (?:Error during parsing|Syntax error))?: (.*)`)
+
+// parseSourcePosition returns the first position mentioned in the
+// error text errText, or nil if there is none. Adapters give errors
+// as text, so this is the only way to recover it.
+func parseSourcePosition(errText string) *sourcePosition {
+	match := sourcePositionRegexp.FindStringSubmatch(errText)
+	if match == nil {
+		return nil
+	}
+	line, err := strconv.Atoi(match[2])
+	if err != nil {
+		return nil
+	}
+	return &sourcePosition{
+		File:    match[1],
+		Line:    line,
+		Message: match[3],
+	}
+}
@@ -0,0 +1,109 @@
+package adapt
+
+import (
+	"context"
+	"net/http"
+	"runtime/pprof"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// adaptProfiled is like Adapter.Adapt, but labels the work for CPU and heap
+// profiles with the endpoint, adapter and size of the input, so the
+// cost of adapting shows up per adapter in profiles of the process.
+// The time it takes and the size of the input are also measured.
+// Adapters run within the max_concurrent_adaptations limit, and
+// are no longer waited for once ctx ends.
+func adaptProfiled(ctx context.Context, endpoint string, adapter Adapter, body []byte, options map[string]interface{}) (result []byte, warnings []caddyconfig.Warning, err error) {
+	noteAdapter(ctx, adapter.Name())
+	if adapter.passthrough() {
+		noteAdaptation(ctx, body, body, 0)
+		return body, nil, nil
+	}
+
+	ctx, span := startSpan(ctx, "adapt",
+		attribute.String("adapt.adapter", adapter.Name()),
+		attribute.Int("adapt.source_size", len(body)))
+	defer func() {
+		span.SetAttributes(
+			attribute.Int("adapt.result_size", len(result)),
+			attribute.Int("adapt.warnings", len(warnings)))
+		endSpan(span, err)
+	}()
+
+	release, err := acquireAdaptSlot(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	// the adapter may outlive the request, and body is usually
+	// borrowed from a buffer the request gives back when it ends
+	body = append([]byte(nil), body...)
+
+	labels := pprof.Labels(
+		"endpoint", endpoint,
+		"adapter", adapter.Name(),
+		"body_size", sizeBucket(len(body)),
+	)
+	start := time.Now()
+
+	// an adapter can't be interrupted, so it keeps its slot
+	// until it finishes, even if the request has given up
+	type outcome struct {
+		result   []byte
+		warnings []caddyconfig.Warning
+		err      error
+	}
+	done := make(chan outcome, 1)
+	go func() {
+		defer release()
+		var out outcome
+		defer func() {
+			if rec := recover(); rec != nil {
+				out.err = caddy.APIError{
+					HTTPStatus: http.StatusInternalServerError,
+					Err:        errorf("%s adapter panicked: %v", adapter.Name(), rec),
+				}
+			}
+			done <- out
+		}()
+		pprof.Do(ctx, labels, func(context.Context) {
+			out.result, out.warnings, out.err = adapter.Adapt(body, options)
+		})
+	}()
+	select {
+	case out := <-done:
+		result, warnings, err = out.result, out.warnings, out.err
+	case <-ctx.Done():
+		return nil, nil, contextError(ctx)
+	}
+
+	if err == nil {
+		noteAdaptation(ctx, body, result, len(warnings))
+	}
+	if recordMetrics() {
+		adaptMetrics.duration.WithLabelValues(adapter.Name()).Observe(time.Since(start).Seconds())
+		adaptMetrics.sourceBytes.WithLabelValues(adapter.Name()).Observe(float64(len(body)))
+		if err == nil {
+			adaptMetrics.resultBytes.WithLabelValues(adapter.Name()).Observe(float64(len(result)))
+		}
+	}
+	return
+}
+
+// sizeBucket returns a coarse, low-cardinality label for a size in bytes.
+func sizeBucket(size int) string {
+	switch {
+	case size < 1<<10:
+		return "<1KiB"
+	case size < 64<<10:
+		return "<64KiB"
+	case size < 1<<20:
+		return "<1MiB"
+	case size < 16<<20:
+		return "<16MiB"
+	}
+	return ">=16MiB"
+}
@@ -0,0 +1,51 @@
+package adapt
+
+import (
+	"context"
+	"testing"
+
+	"github.com/caddyserver/caddy/v2/caddyconfig"
+)
+
+// blockingAdapter reports the body it is given once it is released.
+type blockingAdapter struct {
+	started chan struct{}
+	release chan struct{}
+	got     chan string
+}
+
+func (ba blockingAdapter) Adapt(body []byte, _ map[string]interface{}) ([]byte, []caddyconfig.Warning, error) {
+	close(ba.started)
+	<-ba.release
+	ba.got <- string(body)
+	return []byte(`{}`), nil, nil
+}
+
+func TestAdaptProfiledOutlivesRequest(t *testing.T) {
+	ba := blockingAdapter{
+		started: make(chan struct{}),
+		release: make(chan struct{}),
+		got:     make(chan string, 1),
+	}
+	adapter := Adapter{name: "blocking", cfgAdapter: ba}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	body := []byte("original")
+	errs := make(chan error, 1)
+	go func() {
+		_, _, err := adaptProfiled(ctx, "/adapt", adapter, body, nil)
+		errs <- err
+	}()
+	<-ba.started
+	cancel()
+	if err := <-errs; err == nil {
+		t.Fatal("expected an error once the request gave up")
+	}
+
+	// the request's buffer is reused once it has ended
+	copy(body, "reused!!")
+	close(ba.release)
+	if got := <-ba.got; got != "original" {
+		t.Fatalf("expected the adapter to get %q, got %q", "original", got)
+	}
+}
@@ -0,0 +1,69 @@
+package adapt
+
+import (
+	"time"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// protobufContentType is the media type of the protobuf encoding of
+// the /adapt response envelope, the AdaptResponse in adapt.proto.
+const protobufContentType = "application/x-protobuf"
+
+// encodeProtobufEnvelope encodes the envelope of the adapted config
+// result, as an AdaptResponse. The source is left out if nil.
+func encodeProtobufEnvelope(result []byte, warnings []adaptWarning, prov provenance, source *string) []byte {
+	var b []byte
+	b = protowire.AppendTag(b, 1, protowire.BytesType)
+	b = protowire.AppendBytes(b, result)
+	for _, w := range warnings {
+		b = protowire.AppendTag(b, 2, protowire.BytesType)
+		b = protowire.AppendBytes(b, encodeProtobufWarning(w))
+	}
+	b = protowire.AppendTag(b, 3, protowire.BytesType)
+	b = protowire.AppendBytes(b, encodeProtobufProvenance(prov))
+	if source != nil {
+		// optional, so it is there even if empty
+		b = protowire.AppendTag(b, 4, protowire.BytesType)
+		b = protowire.AppendString(b, *source)
+	}
+	return b
+}
+
+// encodeProtobufWarning encodes w as a Warning.
+func encodeProtobufWarning(w adaptWarning) []byte {
+	var b []byte
+	b = appendProtobufString(b, 1, w.File)
+	if w.Line != 0 {
+		b = protowire.AppendTag(b, 2, protowire.VarintType)
+		b = protowire.AppendVarint(b, uint64(int64(w.Line)))
+	}
+	b = appendProtobufString(b, 3, w.Directive)
+	b = appendProtobufString(b, 4, w.Message)
+	b = appendProtobufString(b, 5, w.Code)
+	b = appendProtobufString(b, 6, w.Severity)
+	return b
+}
+
+// encodeProtobufProvenance encodes p as a Provenance.
+func encodeProtobufProvenance(p provenance) []byte {
+	var b []byte
+	b = appendProtobufString(b, 1, p.CaddyVersion)
+	b = appendProtobufString(b, 2, p.ModuleVersion)
+	b = appendProtobufString(b, 3, p.Adapter)
+	b = appendProtobufString(b, 4, p.AdapterModule)
+	b = appendProtobufString(b, 5, p.SourceSHA256)
+	b = appendProtobufString(b, 6, p.Timestamp.Format(time.RFC3339))
+	b = appendProtobufString(b, 7, p.Requester)
+	return b
+}
+
+// appendProtobufString appends the string field num to b, unless
+// it is empty, which proto3 leaves out.
+func appendProtobufString(b []byte, num protowire.Number, s string) []byte {
+	if s == "" {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendString(b, s)
+}
@@ -0,0 +1,72 @@
+package adapt
+
+import (
+	"net/http"
+	"testing"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// protobufFields returns the length-delimited fields of the
+// protobuf message b, by number.
+func protobufFields(t *testing.T, b []byte) map[protowire.Number][][]byte {
+	t.Helper()
+	fields := make(map[protowire.Number][][]byte)
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			t.Fatalf("decoding tag: %v", protowire.ParseError(n))
+		}
+		b = b[n:]
+		if typ != protowire.BytesType {
+			n = protowire.ConsumeFieldValue(num, typ, b)
+			if n < 0 {
+				t.Fatalf("decoding field %d: %v", num, protowire.ParseError(n))
+			}
+			b = b[n:]
+			continue
+		}
+		val, n := protowire.ConsumeBytes(b)
+		if n < 0 {
+			t.Fatalf("decoding field %d: %v", num, protowire.ParseError(n))
+		}
+		fields[num] = append(fields[num], val)
+		b = b[n:]
+	}
+	return fields
+}
+
+func TestAdaptProtobuf(t *testing.T) {
+	startApp(t, &App{})
+	w := post("/adapt?adapter=test-echo&include_source=true", "", "proto", "Accept", "application/x-protobuf, application/json;q=0.5")
+	expectStatus(t, w, http.StatusOK)
+	if ct := w.Header().Get("Content-Type"); ct != protobufContentType {
+		t.Fatalf("expected Content-Type %s, got %s", protobufContentType, ct)
+	}
+
+	resp := protobufFields(t, w.Body.Bytes())
+	if result := string(resp[1][0]); result != `{"apps":{"echo":{"body":"proto"}}}` {
+		t.Fatalf("expected the adapted config as the result, got %s", result)
+	}
+	if len(resp[2]) != 1 {
+		t.Fatalf("expected 1 warning, got %d", len(resp[2]))
+	}
+	if msg := string(protobufFields(t, resp[2][0])[4][0]); msg != "echoed" {
+		t.Fatalf("expected the warning's message, got %q", msg)
+	}
+	if adapter := string(protobufFields(t, resp[3][0])[3][0]); adapter != "test-echo" {
+		t.Fatalf("expected the provenance's adapter, got %q", adapter)
+	}
+	if source := string(resp[4][0]); source != "proto" {
+		t.Fatalf("expected the source, got %q", source)
+	}
+
+	w = post("/adapt?adapter=test-echo", "", "proto", "Accept", "application/json, application/x-protobuf;q=0.5")
+	expectStatus(t, w, http.StatusOK)
+	if ct := w.Header().Get("Content-Type"); ct != "application/json" {
+		t.Fatalf("expected JSON, which is preferred, got %s", ct)
+	}
+
+	w = post("/adapt?adapter=test-echo&stats=true", "", "proto", "Accept", "application/x-protobuf")
+	expectStatus(t, w, http.StatusNotAcceptable)
+}
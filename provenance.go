@@ -0,0 +1,148 @@
+package adapt
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"reflect"
+	"runtime/debug"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+)
+
+// provenance describes how an adapted config was produced, so that
+// artifacts stored from a response are self-describing. The adapter
+// module is that of each adapter, for a chain.
+type provenance struct {
+	CaddyVersion  string    `json:"caddy_version"`
+	ModuleVersion string    `json:"module_version"`
+	Adapter       string    `json:"adapter"`
+	AdapterModule string    `json:"adapter_module,omitempty"`
+	SourceSHA256  string    `json:"source_sha256"`
+	Timestamp     time.Time `json:"timestamp"`
+	Requester     string    `json:"requester,omitempty"`
+}
+
+// newProvenance returns the provenance of adapting source with
+// the named adapter on behalf of r.
+func newProvenance(r *http.Request, adapterName string, source []byte) provenance {
+	sum := sha256.Sum256(source)
+	caddyVersion, moduleVersion := buildVersions()
+	return provenance{
+		CaddyVersion:  caddyVersion,
+		ModuleVersion: moduleVersion,
+		Adapter:       adapterName,
+		AdapterModule: adapterModules(adapterName),
+		SourceSHA256:  hex.EncodeToString(sum[:]),
+		Timestamp:     time.Now().UTC(),
+		Requester:     requester(r),
+	}
+}
+
+// setHeaders adds p to h as response headers.
+func (p provenance) setHeaders(h http.Header) {
+	h.Set("X-Caddy-Version", p.CaddyVersion)
+	h.Set("X-Adapt-Module-Version", p.ModuleVersion)
+	h.Set("X-Adapter", p.Adapter)
+	if p.AdapterModule != "" {
+		h.Set("X-Adapter-Module", p.AdapterModule)
+	}
+	h.Set("X-Adapt-Source-Sha256", p.SourceSHA256)
+	h.Set("X-Adapt-Timestamp", p.Timestamp.Format(time.RFC3339))
+	if p.Requester != "" {
+		h.Set("X-Adapt-Requester", p.Requester)
+	}
+}
+
+// requester identifies the client that made r: the subject of its
+// client certificate on the (mutually authenticated) remote admin
+// endpoint, otherwise its remote address.
+func requester(r *http.Request) string {
+	if r.TLS != nil && len(r.TLS.PeerCertificates) > 0 {
+		return r.TLS.PeerCertificates[0].Subject.String()
+	}
+	return r.RemoteAddr
+}
+
+// buildVersions returns the versions of Caddy and of this module
+// that are compiled into the running binary.
+func buildVersions() (caddyVersion, moduleVersion string) {
+	readBuildInfo()
+	return cachedCaddyVersion, cachedModuleVersion
+}
+
+// readBuildInfo reads the versions of the modules compiled into
+// the running binary, once.
+func readBuildInfo() {
+	versionsOnce.Do(func() {
+		cachedCaddyVersion, cachedModuleVersion = "unknown", "unknown"
+		if mod := caddy.GoModule(); mod != nil && mod.Version != "" {
+			cachedCaddyVersion = mod.Version
+		}
+		bi, ok := debug.ReadBuildInfo()
+		if !ok {
+			return
+		}
+		for _, mod := range append([]*debug.Module{&bi.Main}, bi.Deps...) {
+			if mod.Replace != nil {
+				mod = mod.Replace
+			}
+			buildModules = append(buildModules, mod)
+			if mod.Path == modulePath && mod.Version != "" {
+				cachedModuleVersion = mod.Version
+			}
+		}
+	})
+}
+
+// adapterModules returns the Go modules that the named config
+// adapters are from, as path@version, separated by commas like
+// their names, so that a config can be traced to the code that
+// adapted it. It returns "" for Caddy JSON, which isn't adapted.
+func adapterModules(adapterName string) string {
+	if adapterName == "" || adapterName == "json" {
+		return ""
+	}
+	readBuildInfo()
+	names := strings.Split(adapterName, ",")
+	mods := make([]string, len(names))
+	for i, name := range names {
+		mods[i] = "unknown"
+		adapter, err := AdapterByName(name)
+		if err != nil {
+			continue
+		}
+		pkg := modulePath
+		if !adapter.passthrough() {
+			typ := reflect.TypeOf(adapter.cfgAdapter)
+			if typ.Kind() == reflect.Ptr {
+				typ = typ.Elem()
+			}
+			pkg = typ.PkgPath()
+		}
+		// the module with the longest path that the package is in
+		var found *debug.Module
+		for _, mod := range buildModules {
+			if (pkg == mod.Path || strings.HasPrefix(pkg, mod.Path+"/")) &&
+				(found == nil || len(mod.Path) > len(found.Path)) {
+				found = mod
+			}
+		}
+		if found != nil {
+			mods[i] = found.Path + "@" + found.Version
+		}
+	}
+	return strings.Join(mods, ",")
+}
+
+// modulePath is the Go module path of this package.
+const modulePath = "github.com/adamburgess/caddy-admin-adapt"
+
+var (
+	versionsOnce                            sync.Once
+	cachedCaddyVersion, cachedModuleVersion string
+	buildModules                            []*debug.Module
+)
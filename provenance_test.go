@@ -0,0 +1,40 @@
+package adapt
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestAdapterModules(t *testing.T) {
+	for _, tc := range []struct {
+		adapter string
+		want    string
+	}{
+		{"json", ""},
+		{"jsonc", modulePath + "@"},
+		{"test-echo", modulePath + "@"},
+		{"jsonc,test-echo", modulePath + "@"},
+		{"nope", "unknown"},
+	} {
+		got := adapterModules(tc.adapter)
+		if !strings.HasPrefix(got, tc.want) || (tc.want == "") != (got == "") {
+			t.Errorf("%s: expected %s..., got %s", tc.adapter, tc.want, got)
+		}
+		if n := strings.Count(tc.adapter, ","); got != "" && strings.Count(got, ",") != n {
+			t.Errorf("%s: expected a module for each adapter, got %s", tc.adapter, got)
+		}
+	}
+}
+
+func TestProvenanceHeaders(t *testing.T) {
+	startApp(t, &App{})
+	w := post("/adapt", "text/test-echo", "hi")
+	expectStatus(t, w, http.StatusOK)
+	if got := w.Header().Get("X-Adapter"); got != "test-echo" {
+		t.Fatalf("expected X-Adapter test-echo, got %s", got)
+	}
+	if got := w.Header().Get("X-Adapter-Module"); !strings.HasPrefix(got, modulePath+"@") {
+		t.Fatalf("expected X-Adapter-Module of this module, got %s", got)
+	}
+}
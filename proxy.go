@@ -0,0 +1,89 @@
+package adapt
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+)
+
+// OutboundProxy is the HTTP proxy that the requests this module makes,
+// fetching `?source` URLs and delivering to webhooks, go through. The
+// dial policy can't check the addresses the proxy connects to, so
+// `?source` fetches through it are only limited to the source_hosts.
+type OutboundProxy struct {
+	// The http or https URL of the proxy, which may have a username
+	// and password in it. It may be a placeholder, such as
+	// {env.HTTPS_PROXY}.
+	URL string `json:"url"`
+
+	// Hosts that are connected to directly, not through the proxy.
+	// One that starts with a dot, like ".internal", matches the
+	// names under it.
+	NoProxy []string `json:"no_proxy,omitempty"`
+
+	url  *url.URL
+	addr string // host and port, as the proxy is dialed
+}
+
+// provision parses p's URL.
+func (p *OutboundProxy) provision(repl *caddy.Replacer) error {
+	u, err := url.Parse(repl.ReplaceAll(p.URL, ""))
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("url %s is not an http or https URL", p.URL)
+	}
+	port := u.Port()
+	if port == "" {
+		port = map[string]string{"http": "80", "https": "443"}[u.Scheme]
+	}
+	p.url = u
+	p.addr = net.JoinHostPort(u.Hostname(), port)
+	return nil
+}
+
+// proxy returns the URL of the proxy that req goes through,
+// or nil if it doesn't, for http.Transport.Proxy.
+func (p *OutboundProxy) proxy(req *http.Request) (*url.URL, error) {
+	host := strings.ToLower(req.URL.Hostname())
+	for _, noProxy := range p.NoProxy {
+		noProxy = strings.ToLower(noProxy)
+		if host == noProxy || (strings.HasPrefix(noProxy, ".") && strings.HasSuffix(host, noProxy)) {
+			return nil, nil
+		}
+	}
+	return p.url, nil
+}
+
+// outboundTransport returns the transport for the requests this module
+// makes, giving up on connecting after timeout, if not zero. If
+// checkDial is true, the addresses connected to directly must be
+// allowed by the dial policy. The environment's HTTP_PROXY and
+// such are not used; only the outbound_proxy is, if one is set.
+func outboundTransport(timeout time.Duration, checkDial bool) *http.Transport {
+	direct := &net.Dialer{Timeout: timeout}
+	dialer := direct
+	if checkDial {
+		dialer = newDialer(timeout)
+	}
+	transport := &http.Transport{
+		DialContext:       dialer.DialContext,
+		DisableKeepAlives: true,
+	}
+	if p := settings().OutboundProxy; p != nil {
+		transport.Proxy = p.proxy
+		transport.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+			// the proxy is configured, so it is connected
+			// to whatever the dial policy says
+			if addr == p.addr {
+				return direct.DialContext(ctx, network, addr)
+			}
+			return dialer.DialContext(ctx, network, addr)
+		}
+	}
+	return transport
+}
@@ -0,0 +1,219 @@
+package adapt
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+)
+
+// Quotas limit how much each client may use the /adapt endpoints in a
+// day, in UTC. Once it has used up any of them, it is turned away with
+// 429 until the day is over. Clients are told apart like by the
+// rate_limit, and what they used is kept across config reloads.
+type Quotas struct {
+	// How many requests a client may make a day. If 0, there
+	// is no limit.
+	Requests int64 `json:"requests,omitempty"`
+
+	// How many bytes of request bodies a client may send a day,
+	// as they are sent, before decompressing them. If 0, there is
+	// no limit.
+	Bytes int64 `json:"bytes,omitempty"`
+
+	// How many configs a client may load a day, by /adapt/load,
+	// /adapt/patch, loading snapshots or the rpc load method. If 0,
+	// there is no limit.
+	Loads int64 `json:"loads,omitempty"`
+
+	// What tells clients apart: "remote_addr", their IP address, or
+	// "auth_token", the bearer token they authenticate with if
+	// auth_tokens are configured, or else their IP address.
+	// Default: remote_addr
+	Key string `json:"key,omitempty"`
+}
+
+// provision checks q's settings.
+func (q *Quotas) provision() error {
+	if q.Requests < 0 || q.Bytes < 0 || q.Loads < 0 {
+		return fmt.Errorf("requests, bytes and loads may not be negative")
+	}
+	switch q.Key {
+	case "":
+		q.Key = rateLimitByRemoteAddr
+	case rateLimitByRemoteAddr, rateLimitByAuthToken:
+	default:
+		return fmt.Errorf("unrecognized key: %s", q.Key)
+	}
+	return nil
+}
+
+// quotaUsage is what a client has used of its quotas today.
+type quotaUsage struct {
+	Day      string `json:"day"`
+	Requests int64  `json:"requests"`
+	Bytes    int64  `json:"bytes"`
+	Loads    int64  `json:"loads"`
+}
+
+// quotaUsages is what each client has used today, by the key that
+// tells it apart. It outlives the app, which a load replaces.
+var quotaUsages = struct {
+	sync.Mutex
+	day     string
+	clients map[string]*quotaUsage
+}{clients: make(map[string]*quotaUsage)}
+
+// clientUsage returns the usage of the client with the given key
+// today, forgetting every client's usage of the days before. It
+// must be called with quotaUsages locked.
+func clientUsage(key string, now time.Time) *quotaUsage {
+	day := now.UTC().Format("2006-01-02")
+	if quotaUsages.day != day {
+		quotaUsages.day = day
+		quotaUsages.clients = make(map[string]*quotaUsage)
+	}
+	u, ok := quotaUsages.clients[key]
+	if !ok {
+		u = &quotaUsage{Day: day}
+		quotaUsages.clients[key] = u
+	}
+	return u
+}
+
+// exceeded returns which of the quotas u has used up, if any,
+// for a request that would load that many configs.
+func (q *Quotas) exceeded(u *quotaUsage, loads int64) string {
+	switch {
+	case q.Requests > 0 && u.Requests >= q.Requests:
+		return "requests"
+	case q.Bytes > 0 && u.Bytes >= q.Bytes:
+		return "bytes"
+	case q.Loads > 0 && u.Loads+loads > q.Loads:
+		return "loads"
+	}
+	return ""
+}
+
+// quotaError is the error that a client that has
+// used up the named quota is turned away with.
+func quotaError(quota string) error {
+	return caddy.APIError{
+		HTTPStatus: http.StatusTooManyRequests,
+		Err:        errorf("the daily quota of %s is used up; it resets at midnight UTC", quota),
+	}
+}
+
+// setQuotaRetryAfter sets the Retry-After header of a response that
+// turns a client away for using up a quota, to when they reset.
+func setQuotaRetryAfter(w http.ResponseWriter, now time.Time) {
+	day := now.UTC().Truncate(24 * time.Hour)
+	wait := day.Add(24 * time.Hour).Sub(now)
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+}
+
+// withinQuota turns away the request r with 429 if its client has
+// used up its quotas, if there are any, or else calls h, counting
+// the request and the bytes of its body against them.
+func withinQuota(w http.ResponseWriter, r *http.Request, h caddy.AdminHandlerFunc) error {
+	q := settings().Quotas
+	if q == nil {
+		return h(w, r)
+	}
+	key := rateLimitKey(r, q.Key)
+	now := time.Now()
+	quotaUsages.Lock()
+	u := clientUsage(key, now)
+	if quota := q.exceeded(u, 0); quota != "" {
+		quotaUsages.Unlock()
+		setQuotaRetryAfter(w, now)
+		return quotaError(quota)
+	}
+	u.Requests++
+	quotaUsages.Unlock()
+
+	body := &countingBody{ReadCloser: r.Body}
+	r.Body = body
+	err := h(w, r)
+
+	quotaUsages.Lock()
+	// the day may be over by now, which counts it for the next
+	clientUsage(key, time.Now()).Bytes += body.n
+	quotaUsages.Unlock()
+	return err
+}
+
+// checkLoadQuota returns an error if the client of r has
+// used up its quota of loads, if there is one.
+func checkLoadQuota(r *http.Request) error {
+	q := settings().Quotas
+	if q == nil || q.Loads == 0 {
+		return nil
+	}
+	quotaUsages.Lock()
+	defer quotaUsages.Unlock()
+	if q.exceeded(clientUsage(rateLimitKey(r, q.Key), time.Now()), 1) != "" {
+		return quotaError("loads")
+	}
+	return nil
+}
+
+// countLoad counts a config loaded for r against
+// its client's quota of loads, if there is one.
+func countLoad(r *http.Request) {
+	q := settings().Quotas
+	if q == nil {
+		return
+	}
+	quotaUsages.Lock()
+	defer quotaUsages.Unlock()
+	clientUsage(rateLimitKey(r, q.Key), time.Now()).Loads++
+}
+
+// countingBody is a request body that counts the bytes read from it.
+type countingBody struct {
+	io.ReadCloser
+	n int64
+}
+
+func (b *countingBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.n += int64(n)
+	return n, err
+}
+
+// quotaResult is the response body of /adapt/quota.
+type quotaResult struct {
+	quotaUsage
+	Limits *Quotas `json:"limits"`
+}
+
+// handleQuota responds with what the client has used of its quotas
+// today, and what they are. It isn't counted against them, so that
+// a client that has used them up can still see that it has.
+func (adminAdapt) handleQuota(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != http.MethodGet {
+		return caddy.APIError{
+			HTTPStatus: http.StatusMethodNotAllowed,
+			Err:        errorf("method not allowed"),
+		}
+	}
+	q := settings().Quotas
+	if q == nil {
+		return caddy.APIError{
+			HTTPStatus: http.StatusNotFound,
+			Err:        errorf("no quotas are configured"),
+		}
+	}
+	quotaUsages.Lock()
+	res := quotaResult{quotaUsage: *clientUsage(rateLimitKey(r, q.Key), time.Now()), Limits: q}
+	quotaUsages.Unlock()
+	w.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(w).Encode(res)
+}
@@ -0,0 +1,64 @@
+package adapt
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+)
+
+// resetQuotaUsages forgets what every client has used of its quotas.
+func resetQuotaUsages() {
+	quotaUsages.Lock()
+	quotaUsages.day = ""
+	quotaUsages.Unlock()
+}
+
+func TestQuotaRequests(t *testing.T) {
+	resetQuotaUsages()
+	startApp(t, &App{
+		AuthTokens: []string{"alice", "bob"},
+		Quotas:     &Quotas{Requests: 2, Key: "auth_token"},
+	})
+	for i := 0; i < 2; i++ {
+		expectStatus(t, post("/adapt?adapter=test-echo", "", "quota", "Authorization", "Bearer alice"), http.StatusOK)
+	}
+	w := post("/adapt?adapter=test-echo", "", "quota", "Authorization", "Bearer alice")
+	expectStatus(t, w, http.StatusTooManyRequests)
+	if retry, err := strconv.Atoi(w.Header().Get("Retry-After")); err != nil || retry <= 0 || retry > 24*60*60 {
+		t.Fatalf("expected Retry-After until the end of the day, got %q", w.Header().Get("Retry-After"))
+	}
+
+	// each token has its own quota
+	expectStatus(t, post("/adapt?adapter=test-echo", "", "quota", "Authorization", "Bearer bob"), http.StatusOK)
+
+	// and the usage can be read once it is used up
+	r := httptest.NewRequest(http.MethodGet, "/adapt/quota", nil)
+	r.Header.Set("Authorization", "Bearer alice")
+	w = serve(r)
+	expectStatus(t, w, http.StatusOK)
+	var usage quotaResult
+	if err := json.Unmarshal(w.Body.Bytes(), &usage); err != nil {
+		t.Fatal(err)
+	}
+	if usage.Requests != 2 || usage.Limits == nil || usage.Limits.Requests != 2 {
+		t.Fatalf("expected 2 of 2 requests used, got %s", w.Body)
+	}
+}
+
+func TestQuotaLoads(t *testing.T) {
+	resetQuotaUsages()
+	startApp(t, &App{Quotas: &Quotas{Loads: 1}})
+	// which keeps the quota, and the usage outlives the reload
+	cfg := `{"admin":{"disabled":true,"config":{"persist":false}},"apps":{"adapt":{"quotas":{"loads":1}}}}`
+	w := post("/adapt/load", "application/json", cfg)
+	expectStatus(t, w, http.StatusOK)
+	w = post("/adapt/load", "application/json", cfg)
+	expectStatus(t, w, http.StatusTooManyRequests)
+	if w.Header().Get("Retry-After") == "" {
+		t.Fatal("expected Retry-After")
+	}
+	// adapting without loading is still allowed
+	expectStatus(t, post("/adapt?adapter=test-echo", "", "quota"), http.StatusOK)
+}
@@ -0,0 +1,158 @@
+package adapt
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"golang.org/x/time/rate"
+)
+
+// What rate limits can be keyed by.
+const (
+	rateLimitByRemoteAddr = "remote_addr"
+	rateLimitByAuthToken  = "auth_token"
+)
+
+// rateLimitSweepInterval is how often clients that have been idle long
+// enough for their buckets to fill up again are forgotten.
+const rateLimitSweepInterval = time.Minute
+
+// RateLimit limits how often each client may make requests to the
+// /adapt endpoints, by a token bucket per client.
+type RateLimit struct {
+	// How many requests per second each client may make, on average.
+	Rate float64 `json:"rate"`
+
+	// How many requests a client may make at once, before the rate
+	// applies. Default: the rate, rounded up, or 1
+	Burst int `json:"burst,omitempty"`
+
+	// What tells clients apart: "remote_addr", their IP address, or
+	// "auth_token", the bearer token they authenticate with if
+	// auth_tokens are configured, or else their IP address.
+	// Default: remote_addr
+	Key string `json:"key,omitempty"`
+
+	limiters *rateLimiters
+}
+
+// rateLimiters are the token buckets of the clients of a rate limit.
+type rateLimiters struct {
+	mu        sync.Mutex
+	buckets   map[string]*rateBucket
+	lastSweep time.Time
+}
+
+// rateBucket is the token bucket of one client.
+type rateBucket struct {
+	limiter  *rate.Limiter
+	lastSeen time.Time
+}
+
+// provision checks l's settings.
+func (l *RateLimit) provision() error {
+	if l.Rate <= 0 || math.IsInf(l.Rate, 0) {
+		return fmt.Errorf("rate must be positive")
+	}
+	if l.Burst < 0 {
+		return fmt.Errorf("burst may not be negative")
+	}
+	if l.Burst == 0 {
+		l.Burst = int(math.Ceil(l.Rate))
+	}
+	switch l.Key {
+	case "":
+		l.Key = rateLimitByRemoteAddr
+	case rateLimitByRemoteAddr, rateLimitByAuthToken:
+	default:
+		return fmt.Errorf("unrecognized key: %s", l.Key)
+	}
+	l.limiters = &rateLimiters{buckets: make(map[string]*rateBucket), lastSweep: time.Now()}
+	return nil
+}
+
+// reserve takes a token from the bucket of the client with the
+// given key, and returns how long it must wait if there is none.
+func (l *RateLimit) reserve(key string) time.Duration {
+	now := time.Now()
+	ls := l.limiters
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+
+	if now.Sub(ls.lastSweep) >= rateLimitSweepInterval {
+		refill := time.Duration(float64(l.Burst) / l.Rate * float64(time.Second))
+		for k, b := range ls.buckets {
+			if now.Sub(b.lastSeen) > refill {
+				delete(ls.buckets, k)
+			}
+		}
+		ls.lastSweep = now
+	}
+
+	b, ok := ls.buckets[key]
+	if !ok {
+		b = &rateBucket{limiter: rate.NewLimiter(rate.Limit(l.Rate), l.Burst)}
+		ls.buckets[key] = b
+	}
+	b.lastSeen = now
+	res := b.limiter.ReserveN(now, 1)
+	delay := res.DelayFrom(now)
+	if delay > 0 {
+		// a request that is turned away doesn't use up a token
+		res.CancelAt(now)
+	}
+	return delay
+}
+
+// rateLimited wraps h so that clients that make requests faster than
+// the rate limit allows, if there is one, or that have used up their
+// quotas, are turned away with 429. It goes after authenticated, so
+// tokens it keys by are valid.
+func rateLimited(h caddy.AdminHandlerFunc) caddy.AdminHandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		if limit := settings().RateLimit; limit != nil {
+			delay := limit.reserve(rateLimitKey(r, limit.Key))
+			if delay > 0 {
+				w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(delay.Seconds()))))
+				return caddy.APIError{
+					HTTPStatus: http.StatusTooManyRequests,
+					Err:        errorf("too many requests; try again in %s", delay.Round(time.Millisecond)),
+				}
+			}
+		}
+		// what a client has used of its quotas can be
+		// read even once it has used them up
+		if r.URL.Path == "/adapt/quota" {
+			return h(w, r)
+		}
+		return withinQuota(w, r, h)
+	}
+}
+
+// rateLimitKey returns the key that tells the client of r apart
+// from others, as the given kind of key.
+func rateLimitKey(r *http.Request, kind string) string {
+	// without auth_tokens, tokens aren't checked, and a client could
+	// get a new bucket for every request by making one up
+	if kind == rateLimitByAuthToken && len(settings().authTokens) > 0 {
+		_, token := splitAuthorization(r.Header.Get("Authorization"))
+		if token != "" {
+			// the token itself isn't kept around
+			sum := sha256.Sum256([]byte(token))
+			return "token:" + hex.EncodeToString(sum[:])
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return "addr:" + host
+}
@@ -0,0 +1,71 @@
+package adapt
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// postFrom posts a config to /adapt from the client at remoteAddr,
+// with the given Authorization header, if any.
+func postFrom(remoteAddr, authorization string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodPost, "/adapt?adapter=test-echo", strings.NewReader("limited"))
+	r.RemoteAddr = remoteAddr
+	if authorization != "" {
+		r.Header.Set("Authorization", authorization)
+	}
+	return serve(r)
+}
+
+func TestRateLimit(t *testing.T) {
+	// a token every 4 seconds, which the test won't wait for
+	startApp(t, &App{RateLimit: &RateLimit{Rate: 0.25, Burst: 2}})
+	for i := 0; i < 2; i++ {
+		expectStatus(t, postFrom("192.0.2.1:1234", ""), http.StatusOK)
+	}
+	for i := 0; i < 2; i++ {
+		// turned away requests don't use up tokens, so
+		// the wait doesn't grow with each of them
+		w := postFrom("192.0.2.1:5678", "")
+		expectStatus(t, w, http.StatusTooManyRequests)
+		if retry, err := strconv.Atoi(w.Header().Get("Retry-After")); err != nil || retry < 1 || retry > 4 {
+			t.Fatalf("expected Retry-After of at most 4 seconds, got %q", w.Header().Get("Retry-After"))
+		}
+	}
+
+	// another address has its own bucket
+	expectStatus(t, postFrom("192.0.2.2:1234", ""), http.StatusOK)
+}
+
+func TestRateLimitByAuthToken(t *testing.T) {
+	startApp(t, &App{
+		AuthTokens: []string{"alice", "bob"},
+		RateLimit:  &RateLimit{Rate: 0.25, Burst: 1, Key: "auth_token"},
+	})
+	expectStatus(t, postFrom("192.0.2.1:1234", "Bearer alice"), http.StatusOK)
+	w := postFrom("192.0.2.2:1234", "Bearer alice")
+	expectStatus(t, w, http.StatusTooManyRequests)
+	if w.Header().Get("Retry-After") == "" {
+		t.Fatal("expected a Retry-After header")
+	}
+	expectStatus(t, postFrom("192.0.2.1:1234", "Bearer bob"), http.StatusOK)
+
+	// a request that isn't authenticated isn't rate limited
+	// by a token it could have made up
+	expectStatus(t, postFrom("192.0.2.1:1234", "Bearer mallory"), http.StatusForbidden)
+}
+
+func TestRateLimitInvalid(t *testing.T) {
+	for _, limit := range []*RateLimit{
+		{Rate: 0},
+		{Rate: -1},
+		{Rate: 1, Burst: -1},
+		{Rate: 1, Key: "header"},
+	} {
+		if err := limit.provision(); err == nil {
+			t.Fatalf("expected %+v to be rejected", *limit)
+		}
+	}
+}
@@ -0,0 +1,121 @@
+package adapt
+
+import (
+	"encoding/json"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/caddyserver/caddy/v2"
+)
+
+// RedactionProfile describes what to strip from adapted configs
+// before they are shared outside of the organization, such as in
+// bug reports. Redacted values are replaced with "REDACTED".
+type RedactionProfile struct {
+	// Object keys whose values are redacted. A key matches if it
+	// contains any of these, case-insensitively; e.g. "token"
+	// matches "api_token".
+	Keys []string `json:"keys,omitempty"`
+
+	// Regular expressions for text to redact from string values
+	// anywhere in the config, such as internal hostnames.
+	Patterns []string `json:"patterns,omitempty"`
+
+	patterns []*regexp.Regexp
+}
+
+// provision compiles the profile's patterns.
+func (p *RedactionProfile) provision() error {
+	p.patterns = p.patterns[:0]
+	for _, pattern := range p.Patterns {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return err
+		}
+		p.patterns = append(p.patterns, re)
+	}
+	return nil
+}
+
+// redact returns val, a decoded JSON document, with the
+// values the profile describes replaced.
+func (p *RedactionProfile) redact(val interface{}) interface{} {
+	switch v := val.(type) {
+	case map[string]interface{}:
+		for key, elem := range v {
+			if p.sensitiveKey(key) {
+				v[key] = redacted
+				continue
+			}
+			v[key] = p.redact(elem)
+		}
+	case []interface{}:
+		for i, elem := range v {
+			v[i] = p.redact(elem)
+		}
+	case string:
+		for _, re := range p.patterns {
+			v = re.ReplaceAllLiteralString(v, redacted)
+		}
+		return v
+	}
+	return val
+}
+
+func (p *RedactionProfile) sensitiveKey(key string) bool {
+	key = strings.ToLower(key)
+	for _, k := range p.Keys {
+		if strings.Contains(key, strings.ToLower(k)) {
+			return true
+		}
+	}
+	return false
+}
+
+// redactionProfile returns the named redaction profile, looking
+// first at the configured profiles, then the built-in ones.
+func redactionProfile(name string) (*RedactionProfile, error) {
+	if p, ok := settings().RedactionProfiles[name]; ok {
+		return p, nil
+	}
+	if p, ok := builtinRedactionProfiles[name]; ok {
+		return p, nil
+	}
+	return nil, caddy.APIError{
+		HTTPStatus: http.StatusBadRequest,
+		Err:        errorf("unknown redaction profile '%s'", name),
+	}
+}
+
+// redactConfig applies the named redaction profile to cfgJSON.
+func redactConfig(cfgJSON []byte, profileName string) ([]byte, error) {
+	profile, err := redactionProfile(profileName)
+	if err != nil {
+		return nil, err
+	}
+	var val interface{}
+	if err := json.Unmarshal(cfgJSON, &val); err != nil {
+		return nil, errorf("decoding adapted config: %v", err)
+	}
+	return json.Marshal(profile.redact(val))
+}
+
+const redacted = "REDACTED"
+
+// builtinRedactionProfiles are the redaction profiles that are
+// always available, unless overridden in the config.
+var builtinRedactionProfiles = map[string]*RedactionProfile{
+	"support-bundle": {
+		Keys: []string{
+			"password", "secret", "token", "api_key", "private_key",
+			"credential", "hmac", "authorization", "email",
+		},
+		patterns: []*regexp.Regexp{
+			// hostnames under private-use domains
+			regexp.MustCompile(`(?i)\b(?:[a-z0-9-]+\.)+(?:internal|local|localdomain|lan|corp|intranet|home\.arpa)\b`),
+			// private IPv4 addresses
+			regexp.MustCompile(`\b(?:10\.\d{1,3}|172\.(?:1[6-9]|2\d|3[01])|192\.168)\.\d{1,3}\.\d{1,3}\b`),
+		},
+	},
+}
@@ -0,0 +1,76 @@
+package adapt
+
+import "sort"
+
+// adaptReport is the report of /adapt with ?report=true, of the
+// constructs in a config that the adapter skipped or doesn't support,
+// as its warnings tell, for auditing migrations from other formats.
+type adaptReport struct {
+	Adapter string       `json:"adapter"`
+	Total   int          `json:"total"`
+	Files   []reportFile `json:"files"`
+}
+
+// reportFile is what an adaptReport has on one source file,
+// which is empty for warnings that name none.
+type reportFile struct {
+	File       string            `json:"file"`
+	Directives []reportDirective `json:"directives"`
+}
+
+// reportDirective is what an adaptReport has on one directive in a
+// file, which is empty for warnings that name none.
+type reportDirective struct {
+	Directive string   `json:"directive"`
+	Count     int      `json:"count"`
+	Lines     []int    `json:"lines"`
+	Codes     []string `json:"codes"`
+	Messages  []string `json:"messages"`
+}
+
+// newReport returns the report on the warnings of a config adapted
+// by the named adapter, grouped by file and then by directive, in
+// sorted order. Warnings that are only informational, such as that
+// a Caddyfile isn't formatted, are left out.
+func newReport(adapter string, warnings []adaptWarning) adaptReport {
+	report := adaptReport{Adapter: adapter, Files: []reportFile{}}
+	grouped := make(map[string]map[string]*reportDirective)
+	for _, w := range warnings {
+		if w.Severity == severityInfo {
+			continue
+		}
+		report.Total++
+		directives, ok := grouped[w.File]
+		if !ok {
+			directives = make(map[string]*reportDirective)
+			grouped[w.File] = directives
+		}
+		d, ok := directives[w.Directive]
+		if !ok {
+			d = &reportDirective{Directive: w.Directive, Lines: []int{}, Codes: []string{}, Messages: []string{}}
+			directives[w.Directive] = d
+		}
+		d.Count++
+		if w.Line > 0 {
+			d.Lines = append(d.Lines, w.Line)
+		}
+		if !containsString(d.Codes, w.Code) {
+			d.Codes = append(d.Codes, w.Code)
+		}
+		if !containsString(d.Messages, w.Message) {
+			d.Messages = append(d.Messages, w.Message)
+		}
+	}
+
+	for file, directives := range grouped {
+		rf := reportFile{File: file}
+		for _, d := range directives {
+			sort.Ints(d.Lines)
+			rf.Directives = append(rf.Directives, *d)
+		}
+		sort.Slice(rf.Directives, func(i, j int) bool { return rf.Directives[i].Directive < rf.Directives[j].Directive })
+		report.Files = append(report.Files, rf)
+	}
+	sort.Slice(report.Files, func(i, j int) bool { return report.Files[i].File < report.Files[j].File })
+	return report
+}
@@ -0,0 +1,155 @@
+package adapt
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// withRequestID wraps h so that every request has an ID: the one in
+// its X-Request-ID header, if valid, or else a newly generated one.
+// The ID is echoed in the response headers and included in logs and
+// in error responses, so a config push can be correlated across
+// systems.
+func withRequestID(h caddy.AdminHandlerFunc) caddy.AdminHandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		id := r.Header.Get("X-Request-ID")
+		if !validRequestID(id) {
+			id = uuid.New().String()
+		}
+		w.Header().Set("X-Request-ID", id)
+		r = r.WithContext(context.WithValue(r.Context(), requestIDCtxKey, id))
+
+		err := h(w, r)
+		if err == nil {
+			return nil
+		}
+
+		// the error is written here rather than by the admin
+		// server, since its error responses can't carry the ID
+		apiErr, ok := err.(caddy.APIError)
+		if !ok {
+			apiErr = caddy.APIError{Err: err}
+		}
+		if apiErr.HTTPStatus == 0 {
+			apiErr.HTTPStatus = http.StatusInternalServerError
+		}
+		if apiErr.Message == "" && apiErr.Err != nil {
+			apiErr.Message = apiErr.Err.Error()
+		}
+
+		// the error is logged with the rest of the request
+		noteError(r.Context(), err)
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(apiErr.HTTPStatus)
+		resp := errorResponse{
+			Error:     apiErr.Message,
+			RequestID: id,
+		}
+		switch e := apiErr.Err.(type) {
+		case warningsError:
+			resp.Warnings = e.warnings
+		case sourceError:
+			resp.sourcePosition = e.sourcePosition
+		}
+		encErr := json.NewEncoder(w).Encode(resp)
+		if encErr != nil {
+			logger(r).Error("failed to encode error response", zap.Error(encErr))
+		}
+		return nil
+	}
+}
+
+// errorResponse is the body of an error response.
+type errorResponse struct {
+	Error     string         `json:"error"`
+	RequestID string         `json:"request_id,omitempty"`
+	Warnings  []adaptWarning `json:"warnings,omitempty"`
+	*sourcePosition
+}
+
+// validRequestID returns true if id is acceptable as a
+// client-provided request ID: short and printable, so it
+// can be safely echoed and logged.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > 128 {
+		return false
+	}
+	for _, c := range id {
+		if c <= ' ' || c > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+// requestID returns the ID of the request with the given context.
+func requestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDCtxKey).(string)
+	return id
+}
+
+// logger returns the logger for messages about r.
+func logger(r *http.Request) *zap.Logger {
+	log := baseLogger()
+	if id := requestID(r.Context()); id != "" {
+		log = log.With(zap.String("request_id", id))
+	}
+	return log
+}
+
+// baseLogger returns the logger of the adapt app, which writes to
+// the logs configured along with it, or else the default logger.
+func baseLogger() *zap.Logger {
+	if log := settings().logger; log != nil {
+		return log
+	}
+	return caddy.Log().Named("admin.api.adapt")
+}
+
+// logRequest logs the outcome of r, a request to the route with the
+// given pattern, which was given the ID id. Requests that fail are
+// logged as errors, and the rest at the request_log_level.
+func logRequest(r *http.Request, id, pattern string, status int, duration time.Duration, stats *requestStats) {
+	level := zapcore.InfoLevel
+	if app := settings(); app.requestLogLevel != nil {
+		level = *app.requestLogLevel
+	}
+	if stats.err != nil {
+		level = zapcore.ErrorLevel
+	}
+
+	ce := baseLogger().Check(level, "handled request")
+	if ce == nil {
+		return
+	}
+
+	fields := []zap.Field{
+		zap.String("request_id", id),
+		zap.String("method", r.Method),
+		zap.String("endpoint", pattern),
+		zap.String("adapter", stats.adapterName()),
+		zap.Int("status", status),
+		zap.Duration("duration", duration),
+	}
+	if stats.adapted {
+		fields = append(fields,
+			zap.Int("source_size", stats.sourceSize),
+			zap.Int("warnings", stats.warnings),
+		)
+	}
+	if stats.err != nil {
+		fields = append(fields, zap.Error(stats.err))
+	}
+	ce.Write(fields...)
+}
+
+// requestIDCtxKey is the context key for the request ID.
+const requestIDCtxKey caddy.CtxKey = "adapt_request_id"
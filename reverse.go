@@ -0,0 +1,565 @@
+package adapt
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+)
+
+// reverseResult is the response body of /adapt/reverse.
+type reverseResult struct {
+	Caddyfile string                `json:"caddyfile"`
+	Warnings  []caddyconfig.Warning `json:"warnings"`
+}
+
+// handleReverse converts the Caddy JSON in the request (or any config
+// that adapts to it) to a Caddyfile, as far as it can. This is
+// experimental: it covers the http app's common handlers and matchers,
+// and warns about everything else, which is left out.
+func (adminAdapt) handleReverse(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != http.MethodPost {
+		return caddy.APIError{
+			HTTPStatus: http.StatusMethodNotAllowed,
+			Err:        errorf("method not allowed"),
+		}
+	}
+
+	buf := getBuffer()
+	defer putBuffer(buf)
+
+	a, err := adaptRequest(r, buf)
+	if err != nil {
+		return err
+	}
+	var cfg map[string]interface{}
+	if err := json.Unmarshal(a.result, &cfg); err != nil {
+		return caddy.APIError{
+			HTTPStatus: http.StatusBadRequest,
+			Err:        errorf("config is not a valid JSON object: %v", err),
+		}
+	}
+
+	rev := &reverser{
+		warnings: []caddyconfig.Warning{},
+		langs:    acceptedLanguages(r.Header.Get("Accept-Language")),
+	}
+	rev.config(cfg)
+	caddyfileText := caddyfile.Format([]byte(rev.out.String()))
+	if len(caddyfileText) > 0 && caddyfileText[len(caddyfileText)-1] != '\n' {
+		caddyfileText = append(caddyfileText, '\n')
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(w).Encode(reverseResult{
+		Caddyfile: string(caddyfileText),
+		Warnings:  rev.warnings,
+	})
+}
+
+// reverser writes a Caddyfile equivalent to a Caddy JSON config.
+// What it can't convert, it leaves out and warns about.
+type reverser struct {
+	out      strings.Builder
+	warnings []caddyconfig.Warning
+	matchers int      // named matchers defined so far
+	langs    []string // what warnings are localized into
+}
+
+func (rev *reverser) line(tokens ...string) {
+	rev.out.WriteString(strings.Join(tokens, " "))
+	rev.out.WriteByte('\n')
+}
+
+func (rev *reverser) warn(path, directive, format string, args ...interface{}) {
+	rev.warnings = append(rev.warnings, caddyconfig.Warning{
+		Directive: directive,
+		Message:   path + ": " + messagef(format, args...).localize(rev.langs),
+	})
+}
+
+// config writes cfg, which is a whole config.
+func (rev *reverser) config(cfg map[string]interface{}) {
+	apps, _ := cfg["apps"].(map[string]interface{})
+	for _, key := range sortedKeys(cfg) {
+		if key != "apps" && key != "admin" {
+			rev.warn("/"+key, "", "not converted")
+		}
+	}
+	for _, name := range sortedKeys(apps) {
+		if name != "http" && name != "tls" {
+			rev.warn("/apps/"+name, "", "app not converted")
+		}
+	}
+
+	rev.globalOptions(cfg)
+
+	httpApp, _ := apps["http"].(map[string]interface{})
+	servers, _ := httpApp["servers"].(map[string]interface{})
+	for _, key := range sortedKeys(httpApp) {
+		if key != "servers" {
+			rev.warn("/apps/http/"+key, "", "not converted")
+		}
+	}
+	for _, name := range sortedKeys(servers) {
+		srv, _ := servers[name].(map[string]interface{})
+		rev.server("/apps/http/servers/"+escapePointer(name), srv)
+	}
+}
+
+// globalOptions writes the global options block, if any are set.
+func (rev *reverser) globalOptions(cfg map[string]interface{}) {
+	var opts []string
+
+	admin, _ := cfg["admin"].(map[string]interface{})
+	if disabled, _ := admin["disabled"].(bool); disabled {
+		opts = append(opts, "admin off")
+	} else if listen, ok := admin["listen"].(string); ok {
+		opts = append(opts, "admin "+quote(listen))
+	}
+	for _, key := range sortedKeys(admin) {
+		if key != "disabled" && key != "listen" {
+			rev.warn("/admin/"+key, "admin", "not converted")
+		}
+	}
+
+	apps, _ := cfg["apps"].(map[string]interface{})
+	if tlsApp, ok := apps["tls"].(map[string]interface{}); ok {
+		email, ok := acmeEmail(tlsApp)
+		if ok && email != "" {
+			opts = append(opts, "email "+quote(email))
+		}
+		if !ok {
+			rev.warn("/apps/tls", "", "only a single ACME email is converted")
+		}
+	}
+
+	if len(opts) == 0 {
+		return
+	}
+	rev.line("{")
+	for _, opt := range opts {
+		rev.line(opt)
+	}
+	rev.line("}")
+}
+
+// acmeEmail returns the email of the tls app's ACME issuers, and
+// false if the app has anything else that would need converting.
+func acmeEmail(tlsApp map[string]interface{}) (string, bool) {
+	var email string
+	for _, key := range sortedKeys(tlsApp) {
+		if key != "automation" {
+			return "", false
+		}
+	}
+	automation, _ := tlsApp["automation"].(map[string]interface{})
+	policies, _ := automation["policies"].([]interface{})
+	for _, p := range policies {
+		policy, _ := p.(map[string]interface{})
+		issuers, _ := policy["issuers"].([]interface{})
+		for _, i := range issuers {
+			issuer, _ := i.(map[string]interface{})
+			issuerEmail, _ := issuer["email"].(string)
+			if issuerEmail == "" {
+				continue
+			}
+			if email != "" && email != issuerEmail {
+				return "", false
+			}
+			email = issuerEmail
+		}
+	}
+	return email, true
+}
+
+// server writes a site block for each of the top-level routes of srv.
+func (rev *reverser) server(path string, srv map[string]interface{}) {
+	for _, key := range sortedKeys(srv) {
+		switch key {
+		case "listen", "routes", "automatic_https", "tls_connection_policies":
+		default:
+			rev.warn(path+"/"+key, "", "not converted")
+		}
+	}
+
+	port := ""
+	listen := stringList(srv["listen"])
+	if len(listen) > 1 {
+		rev.warn(path+"/listen", "", "only the first address is converted")
+	}
+	if len(listen) > 0 {
+		if _, p, err := net.SplitHostPort(listen[0]); err == nil {
+			port = p
+		}
+	}
+
+	routes, _ := srv["routes"].([]interface{})
+	for i, rt := range routes {
+		routePath := path + "/routes/" + strconv.Itoa(i)
+		route, _ := rt.(map[string]interface{})
+
+		var hosts []string
+		if matchSets, ok := route["match"].([]interface{}); ok {
+			if len(matchSets) > 1 {
+				rev.warn(routePath+"/match", "", "only the first matcher set is converted")
+			}
+			matchSet, _ := matchSets[0].(map[string]interface{})
+			for _, key := range sortedKeys(matchSet) {
+				if key != "host" {
+					rev.warn(routePath+"/match/0/"+key, "", "site matchers other than host are not converted")
+				}
+			}
+			hosts = stringList(matchSet["host"])
+		}
+
+		if rev.out.Len() > 0 {
+			rev.line()
+		}
+		rev.line(strings.Join(siteAddresses(hosts, port), " "), "{")
+		rev.matchers = 0
+		handlers, _ := route["handle"].([]interface{})
+		if len(handlers) == 1 {
+			// the Caddyfile puts a site's routes in a subroute
+			if sub, _ := handlers[0].(map[string]interface{}); sub["handler"] == "subroute" {
+				subRoutes, _ := sub["routes"].([]interface{})
+				rev.routes(routePath+"/handle/0/routes", subRoutes)
+				rev.line("}")
+				continue
+			}
+		}
+		rev.handlers(routePath+"/handle", "", handlers)
+		rev.line("}")
+	}
+}
+
+// siteAddresses returns the addresses of a site for hosts on port.
+func siteAddresses(hosts []string, port string) []string {
+	if len(hosts) == 0 {
+		if port == "" {
+			port = "443"
+		}
+		return []string{":" + port}
+	}
+	addrs := make([]string, len(hosts))
+	for i, host := range hosts {
+		switch port {
+		case "", "443":
+			addrs[i] = host
+		case "80":
+			addrs[i] = "http://" + host
+		default:
+			addrs[i] = host + ":" + port
+		}
+	}
+	return addrs
+}
+
+// routes writes the directives for routes, within a site or block.
+func (rev *reverser) routes(path string, routes []interface{}) {
+	for i, rt := range routes {
+		routePath := path + "/" + strconv.Itoa(i)
+		route, _ := rt.(map[string]interface{})
+		for _, key := range sortedKeys(route) {
+			if key != "match" && key != "handle" && key != "terminal" && key != "group" {
+				rev.warn(routePath+"/"+key, "", "not converted")
+			}
+		}
+
+		matcher := ""
+		if matchSets, ok := route["match"].([]interface{}); ok {
+			matcher = rev.matcher(routePath+"/match", matchSets)
+		}
+		handlers, _ := route["handle"].([]interface{})
+
+		// a matched subroute is what handle blocks become
+		if matcher != "" && len(handlers) == 1 {
+			if sub, _ := handlers[0].(map[string]interface{}); sub["handler"] == "subroute" {
+				subRoutes, _ := sub["routes"].([]interface{})
+				rev.line("handle", matcher, "{")
+				rev.routes(routePath+"/handle/0/routes", subRoutes)
+				rev.line("}")
+				continue
+			}
+		}
+		rev.handlers(routePath+"/handle", matcher, handlers)
+	}
+}
+
+// matcher writes the named matcher for matchSets and returns its
+// token, or the path itself if that is all there is to match.
+func (rev *reverser) matcher(path string, matchSets []interface{}) string {
+	if len(matchSets) == 0 {
+		return ""
+	}
+	if len(matchSets) > 1 {
+		rev.warn(path, "", "only the first matcher set is converted")
+	}
+	matchSet, _ := matchSets[0].(map[string]interface{})
+
+	if paths := stringList(matchSet["path"]); len(matchSet) == 1 && len(paths) == 1 {
+		return paths[0]
+	}
+
+	rev.matchers++
+	name := "@m" + strconv.Itoa(rev.matchers)
+	rev.line(name, "{")
+	for _, key := range sortedKeys(matchSet) {
+		switch val := matchSet[key].(type) {
+		case []interface{}:
+			if key != "path" && key != "host" && key != "method" && key != "protocol" && key != "remote_ip" {
+				rev.warn(path+"/0/"+key, "", "matcher not converted")
+				continue
+			}
+			rev.line(append([]string{key}, quoteAll(stringList(val))...)...)
+		case map[string]interface{}:
+			if key == "remote_ip" {
+				rev.line(append([]string{key}, quoteAll(stringList(val["ranges"]))...)...)
+				continue
+			}
+			if key != "header" && key != "query" {
+				rev.warn(path+"/0/"+key, "", "matcher not converted")
+				continue
+			}
+			for _, field := range sortedKeys(val) {
+				for _, v := range stringList(val[field]) {
+					if key == "query" {
+						rev.line(key, quote(field+"="+v))
+					} else {
+						rev.line(key, quote(field), quote(v))
+					}
+				}
+			}
+		default:
+			rev.warn(path+"/0/"+key, "", "matcher not converted")
+		}
+	}
+	rev.line("}")
+	return name
+}
+
+// handlers writes the directive for each of handlers.
+func (rev *reverser) handlers(path, matcher string, handlers []interface{}) {
+	for i, h := range handlers {
+		handlerPath := path + "/" + strconv.Itoa(i)
+		handler, _ := h.(map[string]interface{})
+		name, _ := handler["handler"].(string)
+		convert, ok := reverseHandlers[name]
+		if !ok {
+			rev.warn(handlerPath, name, "handler not converted")
+			continue
+		}
+		convert(rev, handlerPath, matcher, handler)
+	}
+}
+
+// directive returns the tokens of a directive with the given name
+// and matcher, followed by args.
+func directive(name, matcher string, args ...string) []string {
+	tokens := []string{name}
+	if matcher != "" {
+		tokens = append(tokens, matcher)
+	}
+	return append(tokens, args...)
+}
+
+// reverseHandlers maps HTTP handler names to functions that write
+// the Caddyfile directives for them.
+var reverseHandlers map[string]func(rev *reverser, path, matcher string, handler map[string]interface{})
+
+func init() {
+	reverseHandlers = map[string]func(rev *reverser, path, matcher string, handler map[string]interface{}){
+		"reverse_proxy":   reverseProxy,
+		"file_server":     reverseFileServer,
+		"vars":            reverseVars,
+		"static_response": reverseStaticResponse,
+		"encode":          reverseEncode,
+		"headers":         reverseHeaders,
+		"rewrite":         reverseRewrite,
+		"subroute": func(rev *reverser, path, matcher string, handler map[string]interface{}) {
+			routes, _ := handler["routes"].([]interface{})
+			rev.line(directive("route", matcher, "{")...)
+			rev.routes(path+"/routes", routes)
+			rev.line("}")
+		},
+	}
+}
+
+// warnUnconverted warns about the keys of handler not in converted.
+func (rev *reverser) warnUnconverted(path string, handler map[string]interface{}, converted ...string) {
+	name, _ := handler["handler"].(string)
+	for _, key := range sortedKeys(handler) {
+		if key == "handler" {
+			continue
+		}
+		if obj, ok := handler[key].(map[string]interface{}); ok && len(obj) == 0 {
+			continue // an empty object means the defaults
+		}
+		found := false
+		for _, c := range converted {
+			found = found || c == key
+		}
+		if !found {
+			rev.warn(path+"/"+key, name, "not converted")
+		}
+	}
+}
+
+func reverseProxy(rev *reverser, path, matcher string, handler map[string]interface{}) {
+	rev.warnUnconverted(path, handler, "upstreams", "transport")
+	scheme := ""
+	if transport, ok := handler["transport"].(map[string]interface{}); ok {
+		for _, key := range sortedKeys(transport) {
+			if key != "protocol" && key != "tls" {
+				rev.warn(path+"/transport/"+key, "reverse_proxy", "not converted")
+			}
+		}
+		if _, ok := transport["tls"]; ok {
+			scheme = "https://"
+		}
+	}
+	var upstreams []string
+	list, _ := handler["upstreams"].([]interface{})
+	for _, u := range list {
+		upstream, _ := u.(map[string]interface{})
+		if dial, ok := upstream["dial"].(string); ok {
+			upstreams = append(upstreams, quote(scheme+dial))
+		}
+	}
+	rev.line(directive("reverse_proxy", matcher, upstreams...)...)
+}
+
+func reverseFileServer(rev *reverser, path, matcher string, handler map[string]interface{}) {
+	rev.warnUnconverted(path, handler, "root", "browse")
+	args := []string{}
+	if _, ok := handler["browse"]; ok {
+		args = append(args, "browse")
+	}
+	root, hasRoot := handler["root"].(string)
+	if !hasRoot {
+		rev.line(directive("file_server", matcher, args...)...)
+		return
+	}
+	rev.line(directive("file_server", matcher, append(args, "{")...)...)
+	rev.line("root", quote(root))
+	rev.line("}")
+}
+
+func reverseVars(rev *reverser, path, matcher string, handler map[string]interface{}) {
+	rev.warnUnconverted(path, handler, "root")
+	if root, ok := handler["root"].(string); ok {
+		if matcher == "" {
+			matcher = "*"
+		}
+		rev.line(directive("root", matcher, quote(root))...)
+	}
+}
+
+func reverseStaticResponse(rev *reverser, path, matcher string, handler map[string]interface{}) {
+	rev.warnUnconverted(path, handler, "status_code", "body", "headers", "close")
+	status := fmt.Sprint(handler["status_code"])
+	headers, _ := handler["headers"].(map[string]interface{})
+
+	if location := stringList(headers["Location"]); len(location) == 1 && strings.HasPrefix(status, "3") {
+		rev.line(directive("redir", matcher, quote(location[0]), status)...)
+		return
+	}
+	for _, field := range sortedKeys(headers) {
+		rev.warn(path+"/headers/"+escapePointer(field), "respond", "not converted")
+	}
+
+	args := []string{}
+	if body, ok := handler["body"].(string); ok {
+		args = append(args, quote(body))
+	}
+	if _, ok := handler["status_code"]; ok {
+		args = append(args, status)
+	}
+	if closeConn, _ := handler["close"].(bool); closeConn {
+		rev.line(directive("respond", matcher, append(args, "{")...)...)
+		rev.line("close")
+		rev.line("}")
+		return
+	}
+	rev.line(directive("respond", matcher, args...)...)
+}
+
+func reverseEncode(rev *reverser, path, matcher string, handler map[string]interface{}) {
+	rev.warnUnconverted(path, handler, "encodings", "prefer")
+	encodings, _ := handler["encodings"].(map[string]interface{})
+	rev.line(directive("encode", matcher, sortedKeys(encodings)...)...)
+}
+
+func reverseHeaders(rev *reverser, path, matcher string, handler map[string]interface{}) {
+	rev.warnUnconverted(path, handler, "request", "response")
+	for _, side := range []string{"request", "response"} {
+		ops, ok := handler[side].(map[string]interface{})
+		if !ok {
+			continue
+		}
+		name := "header"
+		if side == "request" {
+			name = "request_header"
+		}
+		for _, key := range sortedKeys(ops) {
+			if key != "set" && key != "add" && key != "delete" {
+				rev.warn(path+"/"+side+"/"+key, "header", "not converted")
+			}
+		}
+		set, _ := ops["set"].(map[string]interface{})
+		for _, field := range sortedKeys(set) {
+			for _, v := range stringList(set[field]) {
+				rev.line(directive(name, matcher, quote(field), quote(v))...)
+			}
+		}
+		add, _ := ops["add"].(map[string]interface{})
+		for _, field := range sortedKeys(add) {
+			for _, v := range stringList(add[field]) {
+				rev.line(directive(name, matcher, quote("+"+field), quote(v))...)
+			}
+		}
+		for _, field := range stringList(ops["delete"]) {
+			rev.line(directive(name, matcher, quote("-"+field))...)
+		}
+	}
+}
+
+func reverseRewrite(rev *reverser, path, matcher string, handler map[string]interface{}) {
+	rev.warnUnconverted(path, handler, "uri", "strip_path_prefix", "strip_path_suffix")
+	if uri, ok := handler["uri"].(string); ok {
+		if matcher == "" {
+			matcher = "*"
+		}
+		rev.line(directive("rewrite", matcher, quote(uri))...)
+	}
+	if prefix, ok := handler["strip_path_prefix"].(string); ok {
+		rev.line(directive("uri", matcher, "strip_prefix", quote(prefix))...)
+	}
+	if suffix, ok := handler["strip_path_suffix"].(string); ok {
+		rev.line(directive("uri", matcher, "strip_suffix", quote(suffix))...)
+	}
+}
+
+// quote returns s as a Caddyfile token, quoted if needed. The only
+// escape sequence within quotes is \", and quotes may span lines.
+func quote(s string) string {
+	if s != "" && !strings.ContainsAny(s, " \t\n\"`#") {
+		return s
+	}
+	return `"` + strings.Replace(s, `"`, `\"`, -1) + `"`
+}
+
+// quoteAll quotes each of tokens.
+func quoteAll(tokens []string) []string {
+	quoted := make([]string, len(tokens))
+	for i, t := range tokens {
+		quoted[i] = quote(t)
+	}
+	return quoted
+}
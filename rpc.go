@@ -0,0 +1,359 @@
+package adapt
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+
+	"github.com/caddyserver/caddy/v2"
+)
+
+// rpcRequest is a JSON-RPC 2.0 request object.
+type rpcRequest struct {
+	JSONRPC string          `json:"jsonrpc"`
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params,omitempty"`
+	ID      json.RawMessage `json:"id,omitempty"`
+}
+
+// rpcResponse is a JSON-RPC 2.0 response object.
+type rpcResponse struct {
+	JSONRPC string          `json:"jsonrpc"`
+	Result  interface{}     `json:"result,omitempty"`
+	Error   *rpcError       `json:"error,omitempty"`
+	ID      json.RawMessage `json:"id"`
+}
+
+// rpcError is a JSON-RPC 2.0 error object.
+type rpcError struct {
+	Code    int         `json:"code"`
+	Message string      `json:"message"`
+	Data    interface{} `json:"data,omitempty"`
+}
+
+// Error codes defined by the JSON-RPC 2.0 specification, and
+// the one used for operations that fail.
+const (
+	rpcParseError     = -32700
+	rpcInvalidRequest = -32600
+	rpcMethodNotFound = -32601
+	rpcInvalidParams  = -32602
+	rpcFailed         = -32000
+)
+
+// rpcMethods maps JSON-RPC method names to their implementations,
+// which take the HTTP request a call is in and the call's params,
+// and return its result.
+var rpcMethods = map[string]func(r *http.Request, params json.RawMessage) (interface{}, error){
+	"adapt":    rpcAdapt,
+	"validate": rpcValidate,
+	"diff":     rpcDiff,
+	"load":     rpcLoad,
+}
+
+// rpcAdaptParams are the params of the methods that adapt a config.
+type rpcAdaptParams struct {
+	// The config to adapt.
+	Body string `json:"body"`
+
+	// The name of the adapter to use. Default: json
+	Adapter string `json:"adapter,omitempty"`
+
+	// Options to pass to the adapter.
+	Options map[string]interface{} `json:"options,omitempty"`
+
+	// The signature of the config, as in the X-Signature header,
+	// which require_signature makes required.
+	Signature string `json:"signature,omitempty"`
+
+	// Whether to expand environment variables in the config, and
+	// to render it as a template, as with ?env and ?template.
+	Env      bool `json:"env,omitempty"`
+	Template bool `json:"template,omitempty"`
+
+	// Whether warnings are errors. Default: strict_warnings
+	Strict *bool `json:"strict,omitempty"`
+}
+
+// rpcAdaptResult is the result of the "adapt" method.
+type rpcAdaptResult struct {
+	Adapter  string          `json:"adapter"`
+	Config   json.RawMessage `json:"config"`
+	Warnings []adaptWarning  `json:"warnings,omitempty"`
+}
+
+func rpcAdapt(r *http.Request, params json.RawMessage) (interface{}, error) {
+	adapter, result, warnings, err := rpcAdaptConfig(r, params)
+	if err != nil {
+		return nil, err
+	}
+	return rpcAdaptResult{
+		Adapter:  adapter.Name(),
+		Config:   json.RawMessage(result),
+		Warnings: warnings,
+	}, nil
+}
+
+// rpcValidate adapts a config and validates it, with the same
+// result as /adapt/validate.
+func rpcValidate(r *http.Request, params json.RawMessage) (interface{}, error) {
+	_, result, warnings, err := rpcAdaptConfig(r, params)
+	if err != nil {
+		return nil, err
+	}
+	return validateConfig(result, warnings), nil
+}
+
+// rpcDiffParams are the params of the "diff" method.
+type rpcDiffParams struct {
+	// Whether the result is a unified diff of the two configs
+	// as indented JSON, rather than a JSON Patch.
+	Unified bool `json:"unified,omitempty"`
+}
+
+// rpcDiff adapts a config and returns the JSON Patch that turns the
+// running config into it, or the unified diff, like /adapt/diff.
+func rpcDiff(r *http.Request, params json.RawMessage) (interface{}, error) {
+	var p rpcDiffParams
+	if err := json.Unmarshal(params, &p); err != nil {
+		return nil, rpcParamsError{err}
+	}
+	_, result, _, err := rpcAdaptConfig(r, params)
+	if err != nil {
+		return nil, err
+	}
+
+	var from, to interface{}
+	if err := json.Unmarshal(result, &to); err != nil {
+		return nil, errorf("adapted config is not valid JSON: %v", err)
+	}
+	runningJSON, err := runningConfig(r)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(runningJSON, &from); err != nil {
+		return nil, errorf("running config is not valid JSON: %v", err)
+	}
+
+	if p.Unified {
+		diff, err := unifiedJSONDiff("running", "adapted", from, to)
+		if err != nil {
+			return nil, err
+		}
+		return string(diff), nil
+	}
+	return jsonPatch("", from, to, []patchOp{}), nil
+}
+
+// rpcAdaptConfig adapts the config in params, which are
+// rpcAdaptParams, by way of the same checks as /adapt.
+func rpcAdaptConfig(r *http.Request, params json.RawMessage) (Adapter, []byte, []adaptWarning, error) {
+	var p rpcAdaptParams
+	if err := json.Unmarshal(params, &p); err != nil {
+		return Adapter{}, nil, nil, rpcParamsError{err}
+	}
+	if p.Adapter == "" {
+		p.Adapter = "json"
+	}
+	adapter, err := AdapterByName(p.Adapter)
+	if err != nil {
+		return Adapter{}, nil, nil, rpcParamsError{err}
+	}
+	checks := adaptChecks{
+		signature: p.Signature,
+		decoded:   true,
+		env:       p.Env,
+		template:  p.Template,
+		strict:    settings().StrictWarnings,
+		langs:     acceptedLanguages(r.Header.Get("Accept-Language")),
+	}
+	if p.Strict != nil {
+		checks.strict = *p.Strict
+	}
+	result, warnings, err := adaptChecked(r.Context(), "/adapt/rpc", adapter, []byte(p.Body), p.Options, checks)
+	if err != nil {
+		return Adapter{}, nil, nil, err
+	}
+	return adapter, result, warnings, nil
+}
+
+// rpcLoadParams are the params of the "load" method.
+type rpcLoadParams struct {
+	// Whether to resolve the secrets in the config, as with ?apply.
+	Apply bool `json:"apply,omitempty"`
+
+	// Whether to load the config even if it is the running config,
+	// as with Cache-Control: must-revalidate.
+	ForceReload bool `json:"force_reload,omitempty"`
+
+	// Hashes of configs, as in an If-Match header, one of which
+	// must be running for the config to be loaded.
+	IfMatch string `json:"if_match,omitempty"`
+}
+
+// rpcLoadResult is the result of the "load" method.
+type rpcLoadResult struct {
+	Hash     string         `json:"hash,omitempty"`
+	Changed  bool           `json:"changed"`
+	Warnings []adaptWarning `json:"warnings,omitempty"`
+}
+
+// rpcLoad adapts a config and loads it, like /adapt/load, returning
+// the hash of the config that is running after it, and whether it is
+// different from the one before.
+func rpcLoad(r *http.Request, params json.RawMessage) (interface{}, error) {
+	var p rpcLoadParams
+	if err := json.Unmarshal(params, &p); err != nil {
+		return nil, rpcParamsError{err}
+	}
+	_, result, warnings, err := rpcAdaptConfig(r, params)
+	if err != nil {
+		return nil, err
+	}
+	// a request that was given up on doesn't change the config
+	if r.Context().Err() != nil {
+		return nil, contextError(r.Context())
+	}
+
+	if p.Apply {
+		_, span := startSpan(r.Context(), "resolve secrets")
+		result, err = resolveSecrets(r.Context(), result)
+		endSpan(span, err)
+		if err != nil {
+			return nil, err
+		}
+	}
+	var changed bool
+	hash, err := applyConfigIf(r, p.IfMatch, func() error {
+		changed, err = loadConfig(r, result, p.ForceReload)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	logger(r).Info("load complete")
+	return rpcLoadResult{Hash: hash, Changed: changed, Warnings: warnings}, nil
+}
+
+// rpcParamsError marks an error as caused by invalid params.
+type rpcParamsError struct{ error }
+
+// handleRPC serves JSON-RPC 2.0 calls, including batches, which
+// multiplex this module's operations over a single route.
+func (adminAdapt) handleRPC(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != http.MethodPost {
+		return caddy.APIError{
+			HTTPStatus: http.StatusMethodNotAllowed,
+			Err:        errorf("method not allowed"),
+		}
+	}
+
+	buf := getBuffer()
+	defer putBuffer(buf)
+
+	_, err := buf.ReadFrom(r.Body)
+	if err != nil {
+		return caddy.APIError{
+			HTTPStatus: http.StatusBadRequest,
+			Err:        errorf("reading request body: %v", err),
+		}
+	}
+
+	langs := acceptedLanguages(r.Header.Get("Accept-Language"))
+	body := bytes.TrimSpace(buf.Bytes())
+
+	var resp interface{}
+	if bytes.HasPrefix(body, []byte("[")) {
+		var batch []json.RawMessage
+		if err := json.Unmarshal(body, &batch); err != nil {
+			resp = rpcErrorResponse(nil, rpcParseError, errorf("parse error: %v", err), langs)
+		} else if len(batch) == 0 {
+			resp = rpcErrorResponse(nil, rpcInvalidRequest, errorf("invalid request: empty batch"), langs)
+		} else {
+			var responses []rpcResponse
+			for _, call := range batch {
+				if callResp := rpcCall(r, call, langs); callResp != nil {
+					responses = append(responses, *callResp)
+				}
+			}
+			if len(responses) > 0 {
+				resp = responses
+			}
+		}
+	} else if callResp := rpcCall(r, body, langs); callResp != nil {
+		resp = callResp
+	}
+
+	// a request consisting only of notifications gets no response
+	if resp == nil {
+		w.WriteHeader(http.StatusNoContent)
+		return nil
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(w).Encode(resp)
+}
+
+// rpcCall performs a single JSON-RPC call, one of those in r. It
+// returns nil if the call is a notification, which gets no response.
+func rpcCall(r *http.Request, raw json.RawMessage, langs []string) *rpcResponse {
+	var req rpcRequest
+	if err := json.Unmarshal(raw, &req); err != nil {
+		if !json.Valid(raw) {
+			return rpcErrorResponse(nil, rpcParseError, errorf("parse error: %v", err), langs)
+		}
+		return rpcErrorResponse(nil, rpcInvalidRequest, errorf("invalid request: %v", err), langs)
+	}
+	if req.JSONRPC != "2.0" || req.Method == "" {
+		return rpcErrorResponse(req.ID, rpcInvalidRequest, errorf("invalid request"), langs)
+	}
+
+	method, ok := rpcMethods[req.Method]
+	if !ok {
+		if req.ID == nil {
+			return nil
+		}
+		return rpcErrorResponse(req.ID, rpcMethodNotFound, errorf("method not found: %s", req.Method), langs)
+	}
+
+	result, err := method(r, req.Params)
+	if req.ID == nil {
+		return nil
+	}
+	if err != nil {
+		code := rpcFailed
+		if paramsErr, ok := err.(rpcParamsError); ok {
+			code, err = rpcInvalidParams, paramsErr.error
+		}
+		return rpcErrorResponse(req.ID, code, err, langs)
+	}
+	return &rpcResponse{JSONRPC: "2.0", Result: result, ID: req.ID}
+}
+
+// rpcErrorResponse returns a response for a call with the given
+// id that failed with err, localized into one of langs.
+func rpcErrorResponse(id json.RawMessage, code int, err error, langs []string) *rpcResponse {
+	if id == nil {
+		id = json.RawMessage("null")
+	}
+	msg := err.Error()
+	if m, ok := err.(localizable); ok {
+		msg = m.localize(langs)
+	}
+	rpcErr := &rpcError{Code: code, Message: msg}
+	if srcErr, ok := err.(sourceError); ok {
+		rpcErr.Data = srcErr.sourcePosition
+	}
+	// the warnings that failed the call, in strict mode or by
+	// the error_on warning rules
+	if apiErr, ok := err.(caddy.APIError); ok {
+		if warnErr, ok := apiErr.Err.(warningsError); ok {
+			rpcErr.Data = warnErr.warnings
+		}
+	}
+	return &rpcResponse{
+		JSONRPC: "2.0",
+		Error:   rpcErr,
+		ID:      id,
+	}
+}
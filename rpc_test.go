@@ -0,0 +1,125 @@
+package adapt
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// callRPC calls method with params over /adapt/rpc, and decodes
+// its result into result. It returns the call's error, if any.
+func callRPC(t *testing.T, method string, params interface{}, result interface{}) *rpcError {
+	t.Helper()
+	req, err := json.Marshal(map[string]interface{}{
+		"jsonrpc": "2.0",
+		"id":      1,
+		"method":  method,
+		"params":  params,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := post("/adapt/rpc", "application/json", string(req))
+	expectStatus(t, w, http.StatusOK)
+	var resp struct {
+		Result json.RawMessage `json:"result"`
+		Error  *rpcError       `json:"error"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.Error == nil && result != nil {
+		if err := json.Unmarshal(resp.Result, result); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return resp.Error
+}
+
+func TestRPCAdapt(t *testing.T) {
+	startApp(t, &App{})
+	var res rpcAdaptResult
+	if err := callRPC(t, "adapt", rpcAdaptParams{Body: "hi", Adapter: "test-echo"}, &res); err != nil {
+		t.Fatalf("adapt failed: %s", err.Message)
+	}
+	if res.Adapter != "test-echo" || len(res.Warnings) != 1 {
+		t.Fatalf("unexpected result %+v", res)
+	}
+
+	err := callRPC(t, "adapt", rpcAdaptParams{Body: "hi", Adapter: "nope"}, nil)
+	if err == nil || err.Code != rpcInvalidParams {
+		t.Fatalf("expected invalid params, got %+v", err)
+	}
+}
+
+func TestRPCValidate(t *testing.T) {
+	startApp(t, &App{})
+	var res validateResult
+	if err := callRPC(t, "validate", rpcAdaptParams{Body: `{"apps":{}}`}, &res); err != nil {
+		t.Fatalf("validate failed: %s", err.Message)
+	}
+	if !res.Valid {
+		t.Fatalf("expected the config to be valid, got %+v", res)
+	}
+
+	if err := callRPC(t, "validate", rpcAdaptParams{Body: `{"apps":{"nope":{}}}`}, &res); err != nil {
+		t.Fatalf("validate failed: %s", err.Message)
+	}
+	if res.Valid || res.Error == "" {
+		t.Fatalf("expected the config to be invalid, got %+v", res)
+	}
+
+	// it goes through the same checks as adapt
+	startApp(t, &App{StrictWarnings: true})
+	if err := callRPC(t, "validate", rpcAdaptParams{Body: "hi", Adapter: "test-echo"}, &res); err == nil {
+		t.Fatal("expected the warning to fail the call in strict mode")
+	}
+}
+
+func TestRPCDiff(t *testing.T) {
+	startApp(t, &App{})
+	runningTestConfig = []byte(`{"apps":{"old":{}}}`)
+	defer func() { runningTestConfig = []byte("null") }()
+
+	params := map[string]interface{}{"body": `{"apps":{"new":{}}}`}
+	var patch []patchOp
+	if err := callRPC(t, "diff", params, &patch); err != nil {
+		t.Fatalf("diff failed: %s", err.Message)
+	}
+	if len(patch) != 2 || patch[0].Op != "remove" || patch[0].Path != "/apps/old" ||
+		patch[1].Op != "add" || patch[1].Path != "/apps/new" {
+		t.Fatalf("unexpected patch %+v", patch)
+	}
+
+	params["unified"] = true
+	var diff string
+	if err := callRPC(t, "diff", params, &diff); err != nil {
+		t.Fatalf("diff failed: %s", err.Message)
+	}
+	if !strings.Contains(diff, "-\t\t\"old\": {}") || !strings.Contains(diff, "+\t\t\"new\": {}") {
+		t.Fatalf("unexpected diff %s", diff)
+	}
+}
+
+func TestRPCLoad(t *testing.T) {
+	startApp(t, &App{})
+	cfg := `{"admin":{"disabled":true,"config":{"persist":false}},"apps":{"adapt":{}}}`
+
+	params := map[string]interface{}{"body": cfg, "if_match": `"nope"`}
+	if err := callRPC(t, "load", params, nil); err == nil || !strings.Contains(err.Message, "has changed") {
+		t.Fatalf("expected the load to fail the if_match precondition, got %+v", err)
+	}
+
+	delete(params, "if_match")
+	var res rpcLoadResult
+	if err := callRPC(t, "load", params, &res); err != nil {
+		t.Fatalf("load failed: %s", err.Message)
+	}
+	if want, _ := configHash(runningTestConfig); res.Hash != want {
+		t.Fatalf("expected hash %s of the running config, got %s", want, res.Hash)
+	}
+	if !res.Changed {
+		t.Fatal("expected the config to differ from the running one")
+	}
+}
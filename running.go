@@ -0,0 +1,186 @@
+package adapt
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+)
+
+// runningConfig returns the config Caddy is currently running. There
+// is no API for this other than the admin endpoint itself, so this
+// makes an internal request to /config/ on the admin server that is
+// handling r, on behalf of the same client, subject to the same
+// access controls.
+func runningConfig(r *http.Request) ([]byte, error) {
+	rec, err := adminRequest(r, http.MethodGet, "/config/", nil)
+	if err != nil {
+		return nil, err
+	}
+	if rec.status != http.StatusOK {
+		return nil, errorf("reading running config: HTTP %d: %s", rec.status, strings.TrimSpace(rec.body.String()))
+	}
+	return rec.body.Bytes(), nil
+}
+
+// applyMu is held while applying a config, so that
+// two requests can't both meet the same precondition.
+var applyMu sync.Mutex
+
+// applyConfig calls apply, which applies a config for r, unless r
+// has an If-Match precondition that the running config doesn't meet:
+// that it is the config with one of the given hashes, as /adapt/equal
+// reports them, or any config for *. The ETag of the response is then
+// the hash of the config that is running after it.
+func applyConfig(w http.ResponseWriter, r *http.Request, apply func() error) error {
+	hash, err := applyConfigIf(r, r.Header.Get("If-Match"), apply)
+	if err != nil {
+		// which, applying, is from using up the quota of loads
+		if errorStatus(err) == http.StatusTooManyRequests {
+			setQuotaRetryAfter(w, time.Now())
+		}
+		return err
+	}
+	// without it, the client has to read the config to apply another
+	if hash != "" {
+		w.Header().Set("ETag", `"`+hash+`"`)
+	}
+	return nil
+}
+
+// applyConfigIf calls apply, which applies a config for r, unless
+// ifMatch, like an If-Match header, isn't met by the running config.
+// It returns the hash of the config that is running after it, or
+// "" if that can't be read. If that config is a different one, the
+// webhooks that want diffs are sent the changes.
+func applyConfigIf(r *http.Request, ifMatch string, apply func() error) (string, error) {
+	applyMu.Lock()
+	defer applyMu.Unlock()
+
+	// the config before is only needed to check the precondition
+	// against, or to tell the webhooks that want diffs what changed
+	diffs := wantDiffs()
+	var before []byte
+	var beforeHash string
+	if ifMatch != "" || diffs {
+		running, err := runningConfig(r)
+		if err != nil && ifMatch != "" {
+			return "", err
+		}
+		if err == nil {
+			hash, err := configHash(running)
+			if err != nil && ifMatch != "" {
+				return "", errorf("running config is not valid JSON: %v", err)
+			}
+			before, beforeHash = running, hash
+		}
+		if ifMatch != "" && !hashMatches(ifMatch, beforeHash) {
+			return "", caddy.APIError{
+				HTTPStatus: http.StatusPreconditionFailed,
+				Err:        errorf("the running config has changed; its hash is now %s", beforeHash),
+			}
+		}
+	}
+
+	if err := checkLoadQuota(r); err != nil {
+		return "", err
+	}
+	if err := apply(); err != nil {
+		return "", err
+	}
+	countLoad(r)
+
+	if running, err := runningConfig(r); err == nil {
+		if hash, err := configHash(running); err == nil {
+			if diffs && beforeHash != "" && hash != beforeHash {
+				notifyLoadDiff(r, before, running, beforeHash, hash)
+			}
+			return hash, nil
+		}
+	}
+	return "", nil
+}
+
+// hashMatches returns true if the If-Match header lists hash,
+// quoted like an entity tag or not, or is *. Weak tags don't match.
+func hashMatches(header, hash string) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.Trim(candidate, `"`) == hash {
+			return true
+		}
+	}
+	return false
+}
+
+// adminRequest makes an internal request to the admin server that is
+// handling r, on behalf of the same client, and returns the response.
+// A body, if any, is sent as JSON.
+func adminRequest(r *http.Request, method, path string, body []byte) (*responseRecorder, error) {
+	srv, ok := r.Context().Value(http.ServerContextKey).(*http.Server)
+	if !ok || srv.Handler == nil {
+		return nil, errorf("the running config is only accessible through the admin endpoint")
+	}
+
+	req, err := http.NewRequestWithContext(r.Context(), method, path, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	req.Host = r.Host
+	req.RemoteAddr = r.RemoteAddr
+	req.TLS = r.TLS
+	for _, field := range []string{"Origin", "Referer"} {
+		if val := r.Header.Get(field); val != "" {
+			req.Header.Set(field, val)
+		}
+	}
+
+	rec := &responseRecorder{header: make(http.Header), status: http.StatusOK}
+	srv.Handler.ServeHTTP(rec, req)
+	return rec, nil
+}
+
+// responseRecorder is a minimal http.ResponseWriter
+// which captures the response in memory.
+type responseRecorder struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (rr *responseRecorder) Header() http.Header { return rr.header }
+
+func (rr *responseRecorder) Write(p []byte) (int, error) { return rr.body.Write(p) }
+
+func (rr *responseRecorder) WriteHeader(status int) { rr.status = status }
+
+// normalizeJSON re-encodes a JSON document the way Caddy stores the
+// running config: compact, with object keys in sorted order. Configs
+// that are equal in content are then equal byte for byte.
+func normalizeJSON(doc []byte) ([]byte, error) {
+	var val interface{}
+	if err := json.Unmarshal(doc, &val); err != nil {
+		return nil, err
+	}
+	return json.Marshal(val)
+}
+
+// configHash returns the hex-encoded SHA-256 of the normalized
+// form of the given config.
+func configHash(cfgJSON []byte) (string, error) {
+	normalized, err := normalizeJSON(cfgJSON)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(normalized)
+	return hex.EncodeToString(sum[:]), nil
+}
@@ -0,0 +1,299 @@
+package adapt
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+)
+
+func init() {
+	caddy.RegisterModule(EnvSecrets{})
+	caddy.RegisterModule(FileSecrets{})
+	caddy.RegisterModule(ExecSecrets{})
+}
+
+// SecretResolver looks up secrets by path, for the placeholders
+// that are resolved in adapted configs when they are applied with
+// `?apply=true`. Modules in the admin.api.adapt.secrets namespace
+// implement it.
+type SecretResolver interface {
+	ResolveSecret(ctx context.Context, path string) (string, error)
+}
+
+// secretPlaceholderRegexp matches the placeholders of secrets, such
+// as {vault:secret/path}, which are named for the resolver that
+// looks them up.
+var secretPlaceholderRegexp = regexp.MustCompile(`\{([A-Za-z][A-Za-z0-9_-]*):([^{}]+)\}`)
+
+// defaultExecSecretsTimeout is how long the command of
+// an exec secret resolver may take, unless it sets its own.
+const defaultExecSecretsTimeout = 10 * time.Second
+
+// resolveSecrets returns cfgJSON with the secret placeholders in
+// its string values replaced by the secrets that the configured
+// resolvers look up for them. Placeholders that name no configured
+// resolver are left as they are, being some other placeholder.
+func resolveSecrets(ctx context.Context, cfgJSON []byte) ([]byte, error) {
+	resolvers := settings().secretResolvers
+	if len(resolvers) == 0 {
+		return nil, caddy.APIError{
+			HTTPStatus: http.StatusForbidden,
+			Err:        errorf("resolving secrets is disabled; no secret_resolvers are configured"),
+		}
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(cfgJSON))
+	dec.UseNumber()
+	var val interface{}
+	if err := dec.Decode(&val); err != nil {
+		return nil, errorf("decoding adapted config: %v", err)
+	}
+
+	// a secret used in many places is only looked up once
+	resolved := make(map[string]string)
+	var err error
+	var resolve func(val interface{}) interface{}
+	resolve = func(val interface{}) interface{} {
+		switch v := val.(type) {
+		case map[string]interface{}:
+			for key, elem := range v {
+				v[key] = resolve(elem)
+			}
+		case []interface{}:
+			for i, elem := range v {
+				v[i] = resolve(elem)
+			}
+		case string:
+			return secretPlaceholderRegexp.ReplaceAllStringFunc(v, func(placeholder string) string {
+				match := secretPlaceholderRegexp.FindStringSubmatch(placeholder)
+				resolver, ok := resolvers[match[1]]
+				if !ok || err != nil {
+					return placeholder
+				}
+				if secret, ok := resolved[placeholder]; ok {
+					return secret
+				}
+				secret, resolveErr := resolver.ResolveSecret(ctx, match[2])
+				if resolveErr != nil {
+					// the error is the resolver's, and says nothing of the secret
+					err = caddy.APIError{
+						HTTPStatus: http.StatusBadGateway,
+						Err:        errorf("resolving secret %s: %v", placeholder, resolveErr),
+					}
+					return placeholder
+				}
+				resolved[placeholder] = secret
+				return secret
+			})
+		}
+		return val
+	}
+	val = resolve(val)
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(val); err != nil {
+		return nil, err
+	}
+	return bytes.TrimSuffix(buf.Bytes(), []byte("\n")), nil
+}
+
+// applySecrets resolves the secrets in cfgJSON, the config that
+// r applies, if r asks for it with ?apply=true.
+func applySecrets(r *http.Request, cfgJSON []byte) ([]byte, error) {
+	apply, err := queryBool(r, "apply")
+	if err != nil || !apply {
+		return cfgJSON, err
+	}
+	_, span := startSpan(r.Context(), "resolve secrets")
+	cfgJSON, err = resolveSecrets(r.Context(), cfgJSON)
+	endSpan(span, err)
+	return cfgJSON, err
+}
+
+// EnvSecrets resolves secrets from environment variables,
+// the path of a secret being the name of one.
+type EnvSecrets struct {
+	// Prefixes of the names of the variables that may be
+	// looked up. If empty, any variable may be.
+	Prefixes []string `json:"prefixes,omitempty"`
+}
+
+// CaddyModule returns the Caddy module information.
+func (EnvSecrets) CaddyModule() caddy.ModuleInfo {
+	return caddy.ModuleInfo{
+		ID:  "admin.api.adapt.secrets.env",
+		New: func() caddy.Module { return new(EnvSecrets) },
+	}
+}
+
+// ResolveSecret returns the value of the environment variable path.
+func (e EnvSecrets) ResolveSecret(_ context.Context, path string) (string, error) {
+	if len(e.Prefixes) > 0 && !envAllowed(path, e.Prefixes) {
+		return "", fmt.Errorf("environment variable %s doesn't start with any of the prefixes", path)
+	}
+	val, ok := os.LookupEnv(path)
+	if !ok {
+		return "", fmt.Errorf("environment variable %s is not set", path)
+	}
+	return val, nil
+}
+
+// FileSecrets resolves secrets from files in a directory, such as
+// those that container orchestrators mount, the path of a secret
+// being that of its file relative to the directory. A trailing
+// newline is not part of the secret.
+type FileSecrets struct {
+	// The directory the files are in. Files outside
+	// of it can not be read.
+	Root string `json:"root"`
+}
+
+// CaddyModule returns the Caddy module information.
+func (FileSecrets) CaddyModule() caddy.ModuleInfo {
+	return caddy.ModuleInfo{
+		ID:  "admin.api.adapt.secrets.file",
+		New: func() caddy.Module { return new(FileSecrets) },
+	}
+}
+
+// Provision checks that the root is set.
+func (f *FileSecrets) Provision(_ caddy.Context) error {
+	if f.Root == "" {
+		return fmt.Errorf("root is required")
+	}
+	root, err := filepath.Abs(f.Root)
+	if err != nil {
+		return fmt.Errorf("root: %v", err)
+	}
+	f.Root = root
+	return nil
+}
+
+// ResolveSecret returns the contents of the file at path.
+func (f FileSecrets) ResolveSecret(_ context.Context, path string) (string, error) {
+	for _, elem := range strings.Split(filepath.ToSlash(path), "/") {
+		if elem == ".." {
+			return "", fmt.Errorf("%s may not traverse outside of the root", path)
+		}
+	}
+
+	// the file could still be a symlink to somewhere else
+	realRoot, err := filepath.EvalSymlinks(f.Root)
+	if err != nil {
+		return "", err
+	}
+	realPath, err := filepath.EvalSymlinks(filepath.Join(f.Root, filepath.FromSlash("/"+path)))
+	if err != nil {
+		return "", err
+	}
+	if !strings.HasPrefix(realPath, realRoot+string(filepath.Separator)) {
+		return "", fmt.Errorf("%s resolves to outside of the root", path)
+	}
+
+	secret, err := ioutil.ReadFile(realPath)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(secret), "\r\n"), nil
+}
+
+// ExecSecrets resolves secrets by running a command, such as a
+// secret manager's CLI, and taking what it prints. A trailing
+// newline is not part of the secret.
+type ExecSecrets struct {
+	// The command to run.
+	Command string `json:"command"`
+
+	// The arguments to run it with. {path} in them is replaced with
+	// the path of the secret; if none has it, the path is the last.
+	// Paths that start with "-", which would be taken for flags,
+	// aren't resolved.
+	Args []string `json:"args,omitempty"`
+
+	// How long the command may take. Default: 10s
+	Timeout caddy.Duration `json:"timeout,omitempty"`
+}
+
+// CaddyModule returns the Caddy module information.
+func (ExecSecrets) CaddyModule() caddy.ModuleInfo {
+	return caddy.ModuleInfo{
+		ID:  "admin.api.adapt.secrets.exec",
+		New: func() caddy.Module { return new(ExecSecrets) },
+	}
+}
+
+// Provision checks that the command is set.
+func (e *ExecSecrets) Provision(_ caddy.Context) error {
+	if e.Command == "" {
+		return fmt.Errorf("command is required")
+	}
+	if e.Timeout < 0 {
+		return fmt.Errorf("timeout may not be negative")
+	}
+	return nil
+}
+
+// ResolveSecret returns what the command prints for path.
+func (e ExecSecrets) ResolveSecret(ctx context.Context, path string) (string, error) {
+	// the path comes from the config, and as an argument of its
+	// own would otherwise be taken for one of the command's flags
+	if strings.HasPrefix(path, "-") {
+		return "", fmt.Errorf("secret path %s may not start with '-'", path)
+	}
+
+	timeout := time.Duration(e.Timeout)
+	if timeout == 0 {
+		timeout = defaultExecSecretsTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	args := make([]string, len(e.Args))
+	hasPath := false
+	for i, arg := range e.Args {
+		args[i] = strings.ReplaceAll(arg, "{path}", path)
+		hasPath = hasPath || args[i] != arg
+	}
+	if !hasPath {
+		args = append(args, path)
+	}
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, e.Command, args...)
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	if err := cmd.Run(); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			if len(msg) > 200 {
+				msg = msg[:200] + "..."
+			}
+			return "", fmt.Errorf("%s: %v: %s", e.Command, err, msg)
+		}
+		return "", fmt.Errorf("%s: %v", e.Command, err)
+	}
+	return strings.TrimRight(stdout.String(), "\r\n"), nil
+}
+
+// Interface guards
+var (
+	_ SecretResolver    = (*EnvSecrets)(nil)
+	_ SecretResolver    = (*FileSecrets)(nil)
+	_ SecretResolver    = (*ExecSecrets)(nil)
+	_ caddy.Provisioner = (*FileSecrets)(nil)
+	_ caddy.Provisioner = (*ExecSecrets)(nil)
+)
@@ -0,0 +1,72 @@
+package adapt
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+)
+
+func TestExecSecrets(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("no sh to run")
+	}
+	for _, test := range []struct {
+		resolver ExecSecrets
+		path     string
+		secret   string
+		err      string
+	}{
+		{ExecSecrets{Command: "sh", Args: []string{"-c", `printf '%s\n' "$0"`}}, "db/password", "db/password", ""},
+		{ExecSecrets{Command: "sh", Args: []string{"-c", `echo "secret for $1"`, "sh", "{path}"}}, "db", "secret for db", ""},
+		{ExecSecrets{Command: "sh", Args: []string{"-c", "echo"}}, "-n", "", "may not start with '-'"},
+		{ExecSecrets{Command: "adapt-test-no-such-command"}, "db", "", "executable file not found"},
+		{ExecSecrets{Command: "sh", Args: []string{"-c", "exit 3"}}, "db", "", "sh: exit status 3"},
+		{ExecSecrets{Command: "sh", Args: []string{"-c", "echo denied >&2; exit 1"}}, "db", "", "exit status 1: denied"},
+		{ExecSecrets{Command: "sh", Args: []string{"-c", "printf '%0300d' 0 >&2; exit 1"}}, "db", "", strings.Repeat("0", 200) + "..."},
+		{ExecSecrets{Command: "sh", Args: []string{"-c", "exec sleep 5"}, Timeout: caddy.Duration(100 * time.Millisecond)}, "db", "", "signal: killed"},
+	} {
+		secret, err := test.resolver.ResolveSecret(context.Background(), test.path)
+		if test.err == "" && (err != nil || secret != test.secret) {
+			t.Fatalf("%v %s: expected %q, got %q, %v", test.resolver.Args, test.path, test.secret, secret, err)
+		}
+		if test.err != "" && (err == nil || !strings.Contains(err.Error(), test.err)) {
+			t.Fatalf("%v %s: expected an error with %q, got %q, %v", test.resolver.Args, test.path, test.err, secret, err)
+		}
+	}
+}
+
+func TestExecSecretsInvalid(t *testing.T) {
+	for _, resolver := range []*ExecSecrets{
+		{},
+		{Command: "vault", Timeout: -1},
+	} {
+		if err := resolver.Provision(caddy.Context{}); err == nil {
+			t.Fatalf("expected %+v to be rejected", *resolver)
+		}
+	}
+}
+
+func TestSecretsNotResolved(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("no sh to run")
+	}
+	startApp(t, &App{SecretResolversRaw: map[string]json.RawMessage{
+		"fail": json.RawMessage(`{"resolver": "exec", "command": "sh", "args": ["-c", "echo denied >&2; exit 1"]}`),
+	}})
+
+	generation := settings().generation
+	w := post("/adapt/load?apply=true", "application/json", `{"apps": {"adapt": {}, "http": {"servers": {"srv": {"listen": ["{fail:db}"]}}}}}`)
+	expectStatus(t, w, http.StatusBadGateway)
+	if !strings.Contains(w.Body.String(), "resolving secret {fail:db}") || settings().generation != generation {
+		t.Fatalf("expected nothing to be applied without the secret, got %s", w.Body)
+	}
+
+	// adapting never applies anything, so it doesn't pretend to
+	expectStatus(t, post("/adapt?adapter=test-echo&apply=true", "", "{fail:db}"), http.StatusBadRequest)
+}
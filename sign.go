@@ -0,0 +1,210 @@
+package adapt
+
+import (
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"github.com/caddyserver/caddy/v2"
+)
+
+// handleSign adapts the config in the request like handleAdapt,
+// and signs the result with the configured Ed25519 key. The
+// signature is returned in the X-Signature header.
+func (adminAdapt) handleSign(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != http.MethodPost {
+		return caddy.APIError{
+			HTTPStatus: http.StatusMethodNotAllowed,
+			Err:        errorf("method not allowed"),
+		}
+	}
+
+	key := settings().signingKey
+	if key == nil {
+		return caddy.APIError{
+			HTTPStatus: http.StatusForbidden,
+			Err:        errorf("signing is disabled; no signing_key_file is configured"),
+		}
+	}
+
+	buf := getBuffer()
+	defer putBuffer(buf)
+
+	a, err := adaptRequest(r, buf)
+	if err != nil {
+		return err
+	}
+
+	sig := ed25519.Sign(key, a.result)
+
+	newProvenance(r, a.adapter, a.source).setHeaders(w.Header())
+	w.Header().Set("X-Signature", "ed25519="+base64.StdEncoding.EncodeToString(sig))
+	w.Header().Set("X-Signature-Key", base64.StdEncoding.EncodeToString(key.Public().(ed25519.PublicKey)))
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(a.result)
+
+	return nil
+}
+
+// handleVerify verifies the signature in the X-Signature header over
+// the config in the request body, as produced by handleSign, against
+// the trusted keys, or, for an HMAC, the HMAC secrets.
+func (adminAdapt) handleVerify(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != http.MethodPost {
+		return caddy.APIError{
+			HTTPStatus: http.StatusMethodNotAllowed,
+			Err:        errorf("method not allowed"),
+		}
+	}
+
+	app := settings()
+	if len(app.trustedKeys) == 0 && len(app.hmacSecrets) == 0 {
+		return caddy.APIError{
+			HTTPStatus: http.StatusForbidden,
+			Err:        errorf("verification is disabled; no trusted keys or HMAC secrets are configured"),
+		}
+	}
+
+	buf := getBuffer()
+	defer putBuffer(buf)
+
+	_, err := io.Copy(buf, r.Body)
+	if err != nil {
+		return caddy.APIError{
+			HTTPStatus: http.StatusBadRequest,
+			Err:        errorf("reading request body: %v", err),
+		}
+	}
+
+	res, err := verifySignature(r.Header.Get("X-Signature"), buf.Bytes())
+	if err != nil {
+		return err
+	}
+	w.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(w).Encode(res)
+}
+
+// verifySignature verifies the signature in the X-Signature header
+// value header over body: an Ed25519 signature by one of the trusted
+// keys, or an HMAC-SHA256 with one of the HMAC secrets.
+func verifySignature(header string, body []byte) (verifyResult, error) {
+	alg, sig, err := parseSignature(header)
+	if err != nil {
+		return verifyResult{}, caddy.APIError{
+			HTTPStatus: http.StatusBadRequest,
+			Err:        err,
+		}
+	}
+
+	app := settings()
+	switch alg {
+	case "ed25519":
+		for _, key := range app.trustedKeys {
+			if ed25519.Verify(key, body, sig) {
+				return verifyResult{
+					Valid:     true,
+					Algorithm: alg,
+					PublicKey: base64.StdEncoding.EncodeToString(key),
+				}, nil
+			}
+		}
+		return verifyResult{}, caddy.APIError{
+			HTTPStatus: http.StatusForbidden,
+			Err:        errorf("signature does not match any trusted key"),
+		}
+	case "hmac-sha256":
+		for _, secret := range app.hmacSecrets {
+			mac := hmac.New(sha256.New, secret)
+			mac.Write(body)
+			if hmac.Equal(mac.Sum(nil), sig) {
+				return verifyResult{Valid: true, Algorithm: alg}, nil
+			}
+		}
+		return verifyResult{}, caddy.APIError{
+			HTTPStatus: http.StatusForbidden,
+			Err:        errorf("signature does not match any HMAC secret"),
+		}
+	}
+	return verifyResult{}, caddy.APIError{
+		HTTPStatus: http.StatusBadRequest,
+		Err:        errorf("unsupported signature algorithm '%s'", alg),
+	}
+}
+
+// verifyResult is the response body of a successful verification.
+type verifyResult struct {
+	Valid     bool   `json:"valid"`
+	Algorithm string `json:"algorithm"`
+	PublicKey string `json:"public_key,omitempty"`
+}
+
+// parseSignature parses the value of an X-Signature header, which
+// has the form "<algorithm>=<base64 signature>", where the algorithm
+// is "ed25519" or "hmac-sha256". It returns the algorithm in lower
+// case, and the signature.
+func parseSignature(header string) (string, []byte, error) {
+	if header == "" {
+		return "", nil, errorf("missing X-Signature header")
+	}
+	eq := strings.Index(header, "=")
+	if eq < 0 {
+		return "", nil, errorf("malformed X-Signature header")
+	}
+	alg, encoded := strings.ToLower(strings.TrimSpace(header[:eq])), strings.TrimSpace(header[eq+1:])
+	var size int
+	switch alg {
+	case "ed25519":
+		size = ed25519.SignatureSize
+	case "hmac-sha256":
+		size = sha256.Size
+	default:
+		return "", nil, errorf("unsupported signature algorithm '%s'", alg)
+	}
+	sig, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil || len(sig) != size {
+		return "", nil, errorf("malformed %s signature", alg)
+	}
+	return alg, sig, nil
+}
+
+// loadSigningKey loads an Ed25519 private key from a PEM file
+// containing it in PKCS #8 form.
+func loadSigningKey(filename string) (ed25519.PrivateKey, error) {
+	keyPEM, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(keyPEM)
+	if block == nil {
+		return nil, errorf("no PEM data found")
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	edKey, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return nil, errorf("not an Ed25519 key: %T", key)
+	}
+	return edKey, nil
+}
+
+// parsePublicKey decodes a base64-encoded Ed25519 public key.
+func parsePublicKey(encoded string) (ed25519.PublicKey, error) {
+	key, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, err
+	}
+	if len(key) != ed25519.PublicKeySize {
+		return nil, errorf("wrong length for an Ed25519 public key: %d bytes", len(key))
+	}
+	return ed25519.PublicKey(key), nil
+}
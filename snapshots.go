@@ -0,0 +1,346 @@
+package adapt
+
+import (
+	"encoding/json"
+	"net/http"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/certmagic"
+	"go.uber.org/zap"
+)
+
+// snapshotPrefix is the storage key under which snapshots are kept.
+const snapshotPrefix = "adapt/snapshots"
+
+// snapshotNameRegexp matches valid snapshot names, which are used
+// in storage keys, so they can't contain slashes or start with a dot.
+var snapshotNameRegexp = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]{0,127}$`)
+
+// snapshot is an adapted config stored under a name.
+type snapshot struct {
+	Name       string          `json:"name"`
+	Provenance provenance      `json:"provenance"`
+	Warnings   []adaptWarning  `json:"warnings,omitempty"`
+	Config     json.RawMessage `json:"config,omitempty"`
+}
+
+// handleSnapshots manages named snapshots of adapted configs, kept
+// in Caddy's storage so that they survive restarts and are shared
+// by instances in a cluster:
+//
+//	GET    /adapt/snapshots/             lists them, a page at a time
+//	DELETE /adapt/snapshots/?before=     removes those stored before then
+//	POST   /adapt/snapshots/<name>       adapts the body and stores it
+//	GET    /adapt/snapshots/<name>       responds with its config
+//	DELETE /adapt/snapshots/<name>       removes it
+//	POST   /adapt/snapshots/<name>/load  loads its config
+func (adminAdapt) handleSnapshots(w http.ResponseWriter, r *http.Request) error {
+	name := strings.TrimPrefix(r.URL.Path, "/adapt/snapshots/")
+	if name == "" {
+		switch r.Method {
+		case http.MethodGet:
+			return listSnapshots(w, r)
+		case http.MethodDelete:
+			return purgeSnapshots(w, r)
+		}
+		return caddy.APIError{
+			HTTPStatus: http.StatusMethodNotAllowed,
+			Err:        errorf("method not allowed"),
+		}
+	}
+
+	load := strings.HasSuffix(name, "/load")
+	name = strings.TrimSuffix(name, "/load")
+	if !snapshotNameRegexp.MatchString(name) {
+		return caddy.APIError{
+			HTTPStatus: http.StatusBadRequest,
+			Err:        errorf("invalid snapshot name: %s", name),
+		}
+	}
+
+	switch {
+	case load && r.Method == http.MethodPost:
+		return loadSnapshot(w, r, name)
+	case !load && r.Method == http.MethodPost:
+		return storeSnapshot(w, r, name)
+	case !load && r.Method == http.MethodGet:
+		return getSnapshot(w, r, name)
+	case !load && r.Method == http.MethodDelete:
+		return deleteSnapshot(name)
+	}
+	return caddy.APIError{
+		HTTPStatus: http.StatusMethodNotAllowed,
+		Err:        errorf("method not allowed"),
+	}
+}
+
+// storeSnapshot adapts the config in r like handleAdapt
+// and stores the result as the snapshot with the given
+// name, replacing any that exists.
+func storeSnapshot(w http.ResponseWriter, r *http.Request, name string) error {
+	buf := getBuffer()
+	defer putBuffer(buf)
+
+	a, err := adaptRequest(r, buf)
+	if err != nil {
+		return err
+	}
+
+	snap := snapshot{
+		Name:       name,
+		Provenance: newProvenance(r, a.adapter, a.source),
+		Warnings:   a.warnings,
+		Config:     json.RawMessage(a.result),
+	}
+	stored, err := json.Marshal(snap)
+	if err != nil {
+		return caddy.APIError{
+			HTTPStatus: http.StatusBadRequest,
+			Err:        errorf("adapted config is not valid JSON: %v", err),
+		}
+	}
+	if stored, err = sealStored(snapshotKey(name), stored); err != nil {
+		return errorf("encrypting snapshot %s: %v", name, err)
+	}
+	if err := appStorage().Store(snapshotKey(name), stored); err != nil {
+		return errorf("storing snapshot %s: %v", name, err)
+	}
+
+	snap.Config = nil
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	return json.NewEncoder(w).Encode(snap)
+}
+
+// getSnapshot responds with the config of the named snapshot, with
+// the provenance of when it was stored, and a strong ETag of both, or
+// with 304 if r already has it.
+func getSnapshot(w http.ResponseWriter, r *http.Request, name string) error {
+	snap, err := readSnapshot(name)
+	if err != nil {
+		return err
+	}
+	snap.Provenance.setHeaders(w.Header())
+	tag := `"` + sha256Hex(append([]byte(snap.Provenance.Timestamp.String()+"\x00"), snap.Config...)) + `"`
+	w.Header().Set("ETag", tag)
+	if etagMatches(r, tag) {
+		w.WriteHeader(http.StatusNotModified)
+		return nil
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(snap.Config)
+	return nil
+}
+
+// loadSnapshot loads the config of the named snapshot, like
+// handleLoad does with an adapted config.
+func loadSnapshot(w http.ResponseWriter, r *http.Request, name string) error {
+	snap, err := readSnapshot(name)
+	if err != nil {
+		return err
+	}
+
+	cfgJSON, err := applySecrets(r, snap.Config)
+	if err != nil {
+		return err
+	}
+
+	forceReload := r.Header.Get("Cache-Control") == "must-revalidate"
+
+	err = applyConfig(w, r, func() error {
+		_, span := startSpan(r.Context(), "load config")
+		err := caddy.Load(cfgJSON, forceReload)
+		endSpan(span, err)
+		if err != nil {
+			return caddy.APIError{
+				HTTPStatus: http.StatusBadRequest,
+				Err:        errorf("loading snapshot %s: %v", name, err),
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	logger(r).Info("load complete")
+	autosave(r, snap.Config)
+
+	snap.Provenance.setHeaders(w.Header())
+	return nil
+}
+
+// deleteSnapshot removes the named snapshot.
+func deleteSnapshot(name string) error {
+	storage := appStorage()
+	if !storage.Exists(snapshotKey(name)) {
+		return snapshotNotFound(name)
+	}
+	if err := storage.Delete(snapshotKey(name)); err != nil {
+		return errorf("deleting snapshot %s: %v", name, err)
+	}
+	return nil
+}
+
+// purgeSnapshots removes the snapshots that were stored before ?before,
+// responding with how many it removed.
+func purgeSnapshots(w http.ResponseWriter, r *http.Request) error {
+	before, err := queryBefore(r)
+	if err != nil {
+		return err
+	}
+	snaps, err := readSnapshots()
+	if err != nil {
+		return err
+	}
+	deleted := 0
+	for _, snap := range snaps {
+		if !snap.Provenance.Timestamp.Before(before) {
+			continue
+		}
+		if err := deleteSnapshot(snap.Name); err != nil {
+			return err
+		}
+		deleted++
+	}
+	logger(r).Info("snapshots purged", zap.Int("deleted", deleted))
+	w.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(w).Encode(purgeResult{Deleted: deleted})
+}
+
+// listSnapshots responds with the snapshots, without their configs,
+// in order of name: those after ?cursor, stored from ?since until
+// ?until, at most ?limit of them. If there are more, X-Adapt-Cursor
+// is set to a cursor that ?cursor takes to respond with the rest.
+func listSnapshots(w http.ResponseWriter, r *http.Request) error {
+	limit := 0
+	if val := r.URL.Query().Get("limit"); val != "" {
+		n, err := strconv.Atoi(val)
+		if err != nil || n <= 0 {
+			return caddy.APIError{
+				HTTPStatus: http.StatusBadRequest,
+				Err:        errorf("invalid value for limit: %s (must be at least 1)", val),
+			}
+		}
+		limit = n
+	}
+	since, err := queryTime(r, "since")
+	if err != nil {
+		return err
+	}
+	until, err := queryTime(r, "until")
+	if err != nil {
+		return err
+	}
+	cursor := r.URL.Query().Get("cursor")
+
+	snaps, err := readSnapshots()
+	if err != nil {
+		return err
+	}
+	page := []snapshot{}
+	for _, snap := range snaps {
+		stored := snap.Provenance.Timestamp
+		if snap.Name <= cursor || stored.Before(since) || (!until.IsZero() && !stored.Before(until)) {
+			continue
+		}
+		if limit > 0 && len(page) == limit {
+			w.Header().Set("X-Adapt-Cursor", page[len(page)-1].Name)
+			break
+		}
+		snap.Config = nil
+		page = append(page, snap)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(w).Encode(page)
+}
+
+// readSnapshots returns all the snapshots in storage,
+// in order of name.
+func readSnapshots() ([]snapshot, error) {
+	storage := appStorage()
+	snaps := []snapshot{}
+	if storage.Exists(snapshotPrefix) {
+		keys, err := storage.List(snapshotPrefix, false)
+		if err != nil {
+			return nil, errorf("listing snapshots: %v", err)
+		}
+		for _, key := range keys {
+			snap, err := readSnapshot(path.Base(key))
+			if err != nil {
+				return nil, err
+			}
+			snaps = append(snaps, snap)
+		}
+	}
+	sort.Slice(snaps, func(i, j int) bool { return snaps[i].Name < snaps[j].Name })
+	return snaps, nil
+}
+
+// readSnapshot returns the named snapshot from storage.
+func readSnapshot(name string) (snapshot, error) {
+	storage := appStorage()
+	if !storage.Exists(snapshotKey(name)) {
+		return snapshot{}, snapshotNotFound(name)
+	}
+	stored, err := storage.Load(snapshotKey(name))
+	if err != nil {
+		return snapshot{}, errorf("reading snapshot %s: %v", name, err)
+	}
+	if stored, err = openStored(snapshotKey(name), stored); err != nil {
+		return snapshot{}, errorf("reading snapshot %s: %v", name, err)
+	}
+	var snap snapshot
+	if err := json.Unmarshal(stored, &snap); err != nil {
+		return snapshot{}, errorf("decoding snapshot %s: %v", name, err)
+	}
+	return snap, nil
+}
+
+// findSnapshot returns the snapshot named ref, or else the one
+// whose config has ref as its hash, as /adapt/equal reports them.
+func findSnapshot(ref string) (snapshot, error) {
+	if snapshotNameRegexp.MatchString(ref) && appStorage().Exists(snapshotKey(ref)) {
+		return readSnapshot(ref)
+	}
+	snaps, err := readSnapshots()
+	if err != nil {
+		return snapshot{}, err
+	}
+	for _, snap := range snaps {
+		if hash, err := configHash(snap.Config); err == nil && hash == strings.ToLower(ref) {
+			return snap, nil
+		}
+	}
+	return snapshot{}, caddy.APIError{
+		HTTPStatus: http.StatusNotFound,
+		Err:        errorf("no snapshot is named %s or has it as its hash", ref),
+	}
+}
+
+func snapshotNotFound(name string) error {
+	return caddy.APIError{
+		HTTPStatus: http.StatusNotFound,
+		Err:        errorf("unknown snapshot: %s", name),
+	}
+}
+
+// snapshotKey returns the storage key of the named snapshot.
+func snapshotKey(name string) string {
+	return path.Join(snapshotPrefix, name)
+}
+
+// appStorage returns the storage that snapshots and the audit log
+// are kept in: the one configured for the config the adapt app is
+// running in, or else Caddy's default storage.
+func appStorage() certmagic.Storage {
+	if storage := settings().storage; storage != nil {
+		return storage
+	}
+	return caddy.DefaultStorage
+}
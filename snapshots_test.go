@@ -0,0 +1,103 @@
+package adapt
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// listSnapshotNames gets target, a listing of the snapshots, and
+// returns their names and the cursor to the rest, if any.
+func listSnapshotNames(t *testing.T, target string) ([]string, string) {
+	t.Helper()
+	w := serve(httptest.NewRequest(http.MethodGet, target, nil))
+	expectStatus(t, w, http.StatusOK)
+	var snaps []snapshot
+	if err := json.Unmarshal(w.Body.Bytes(), &snaps); err != nil {
+		t.Fatal(err)
+	}
+	names := []string{}
+	for _, snap := range snaps {
+		names = append(names, snap.Name)
+	}
+	return names, w.Header().Get("X-Adapt-Cursor")
+}
+
+func TestSnapshotPaging(t *testing.T) {
+	startApp(t, &App{})
+	for _, name := range []string{"a", "b", "c", "d", "e"} {
+		expectStatus(t, post("/adapt/snapshots/"+name, "application/json", "{}"), http.StatusCreated)
+	}
+
+	var got []string
+	target := "/adapt/snapshots/?limit=2"
+	for pages := 0; ; pages++ {
+		names, cursor := listSnapshotNames(t, target)
+		got = append(got, names...)
+		if cursor == "" {
+			if pages != 2 {
+				t.Fatalf("expected 3 pages, got %d", pages+1)
+			}
+			break
+		}
+		target = "/adapt/snapshots/?limit=2&cursor=" + cursor
+	}
+	if len(got) != 5 || got[0] != "a" || got[4] != "e" {
+		t.Fatalf("expected every snapshot once, in order, got %v", got)
+	}
+
+	if names, _ := listSnapshotNames(t, "/adapt/snapshots/?until=2000-01-01T00:00:00Z"); len(names) != 0 {
+		t.Fatalf("expected no snapshots stored before 2000, got %v", names)
+	}
+	if names, _ := listSnapshotNames(t, "/adapt/snapshots/?since=2000-01-01T00:00:00Z"); len(names) != 5 {
+		t.Fatalf("expected every snapshot stored since 2000, got %v", names)
+	}
+}
+
+func TestSnapshotPurge(t *testing.T) {
+	startApp(t, &App{})
+	for _, name := range []string{"a", "b"} {
+		expectStatus(t, post("/adapt/snapshots/"+name, "application/json", "{}"), http.StatusCreated)
+	}
+	purge := func(before string) {
+		t.Helper()
+		w := serve(httptest.NewRequest(http.MethodDelete, "/adapt/snapshots/?before="+before, nil))
+		expectStatus(t, w, http.StatusOK)
+	}
+
+	purge("2000-01-01T00:00:00Z")
+	if names, _ := listSnapshotNames(t, "/adapt/snapshots/"); len(names) != 2 {
+		t.Fatalf("expected no snapshots to be purged, got %v", names)
+	}
+	purge(time.Now().Add(time.Minute).Format(time.RFC3339))
+	if names, _ := listSnapshotNames(t, "/adapt/snapshots/"); len(names) != 0 {
+		t.Fatalf("expected every snapshot to be purged, got %v", names)
+	}
+}
+
+func TestSnapshotETag(t *testing.T) {
+	startApp(t, &App{})
+	expectStatus(t, post("/adapt/snapshots/prod", "application/json", `{"apps":{}}`), http.StatusCreated)
+	get := func(ifNoneMatch string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, "/adapt/snapshots/prod", nil)
+		if ifNoneMatch != "" {
+			r.Header.Set("If-None-Match", ifNoneMatch)
+		}
+		return serve(r)
+	}
+
+	w := get("")
+	expectStatus(t, w, http.StatusOK)
+	tag := w.Header().Get("ETag")
+	if !strings.HasPrefix(tag, `"`) {
+		t.Fatalf("expected a strong ETag, got %q", tag)
+	}
+	expectStatus(t, get(tag), http.StatusNotModified)
+
+	// storing it again makes it a different snapshot
+	expectStatus(t, post("/adapt/snapshots/prod", "application/json", `{"apps":{}}`), http.StatusCreated)
+	expectStatus(t, get(tag), http.StatusOK)
+}
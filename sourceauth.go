@@ -0,0 +1,139 @@
+package adapt
+
+import (
+	"crypto/tls"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/caddyserver/caddy/v2"
+)
+
+// SourceCredentials authenticate the requests that fetch `?source`
+// URLs from one of the source_hosts, for private stores of configs.
+// They are only sent to that host, not along with redirects to
+// other ones.
+type SourceCredentials struct {
+	// The host, one of the source_hosts, that these are sent to.
+	Host string `json:"host"`
+
+	// A bearer token, sent in the Authorization header. It, the
+	// password and the values of the headers may be placeholders,
+	// such as {env.SOURCE_TOKEN}, or one of the secret resolvers,
+	// such as {vault:secret/source-token}.
+	BearerToken string `json:"bearer_token,omitempty"`
+
+	// A username and password for basic auth, instead of
+	// a bearer token.
+	Username string `json:"username,omitempty"`
+	Password string `json:"password,omitempty"`
+
+	// Headers to send, such as for an API key.
+	Headers map[string]string `json:"headers,omitempty"`
+
+	// PEM files of a client certificate and its key, for
+	// hosts that require mutual TLS.
+	ClientCertificateFile string `json:"client_certificate_file,omitempty"`
+	ClientKeyFile         string `json:"client_key_file,omitempty"`
+
+	header      http.Header
+	certificate *tls.Certificate
+}
+
+// provision checks c's settings and resolves its secrets, which may
+// be looked up by the app's secret resolvers.
+func (c *SourceCredentials) provision(ctx caddy.Context, a *App, repl *caddy.Replacer) error {
+	if !a.sourceHostAllowed(c.Host) {
+		return fmt.Errorf("host %q is not one of the source_hosts", c.Host)
+	}
+	if c.BearerToken != "" && (c.Username != "" || c.Password != "") {
+		return fmt.Errorf("bearer_token and basic auth are mutually exclusive")
+	}
+	if (c.ClientCertificateFile == "") != (c.ClientKeyFile == "") {
+		return fmt.Errorf("client_certificate_file and client_key_file must be set together")
+	}
+
+	c.header = make(http.Header)
+	for name, value := range c.Headers {
+		resolved, err := a.resolveSecretSetting(ctx, repl, value)
+		if err != nil {
+			return fmt.Errorf("header %s: %v", name, err)
+		}
+		c.header.Set(name, resolved)
+	}
+	if c.BearerToken != "" {
+		token, err := a.resolveSecretSetting(ctx, repl, c.BearerToken)
+		if err != nil {
+			return fmt.Errorf("bearer_token: %v", err)
+		}
+		c.header.Set("Authorization", "Bearer "+token)
+	}
+	if c.Username != "" || c.Password != "" {
+		var password string
+		if c.Password != "" {
+			var err error
+			if password, err = a.resolveSecretSetting(ctx, repl, c.Password); err != nil {
+				return fmt.Errorf("password: %v", err)
+			}
+		}
+		credentials := c.Username + ":" + password
+		c.header.Set("Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte(credentials)))
+	}
+
+	if c.ClientCertificateFile != "" {
+		cert, err := tls.LoadX509KeyPair(c.ClientCertificateFile, c.ClientKeyFile)
+		if err != nil {
+			return fmt.Errorf("loading client certificate: %v", err)
+		}
+		c.certificate = &cert
+	}
+	return nil
+}
+
+// credentialsTransport sends the source_credentials for the host
+// of each request along with it, which includes each redirect.
+type credentialsTransport struct {
+	base        *http.Transport
+	credentials map[string]*SourceCredentials // by lowercase host
+	tls         map[string]*http.Transport    // for client certificates
+}
+
+// sourceRoundTripper returns base, sending the source_credentials
+// along with the requests it makes.
+func (a *App) sourceRoundTripper(base *http.Transport) http.RoundTripper {
+	if len(a.sourceCredentials) == 0 {
+		return base
+	}
+	rt := credentialsTransport{
+		base:        base,
+		credentials: a.sourceCredentials,
+		tls:         make(map[string]*http.Transport),
+	}
+	for host, c := range a.sourceCredentials {
+		if c.certificate != nil {
+			t := base.Clone()
+			t.TLSClientConfig = &tls.Config{Certificates: []tls.Certificate{*c.certificate}}
+			rt.tls[host] = t
+		}
+	}
+	return rt
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t credentialsTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	host := strings.ToLower(req.URL.Hostname())
+	c, ok := t.credentials[host]
+	if !ok {
+		return t.base.RoundTrip(req)
+	}
+	// a RoundTripper may not modify the request it is given
+	req = req.Clone(req.Context())
+	for name, values := range c.header {
+		req.Header[name] = values
+	}
+	if tlsTransport, ok := t.tls[host]; ok {
+		return tlsTransport.RoundTrip(req)
+	}
+	return t.base.RoundTrip(req)
+}
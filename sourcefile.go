@@ -0,0 +1,100 @@
+package adapt
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/caddyserver/caddy/v2"
+)
+
+// readSourceFile reads the config file at name, which is relative to
+// root and may not escape it, not even through symlinks. It returns
+// the full path of the file along with its contents.
+func readSourceFile(root, name string) (string, []byte, error) {
+	if root == "" {
+		return "", nil, caddy.APIError{
+			HTTPStatus: http.StatusForbidden,
+			Err:        errorf("adapting files from disk is disabled; no source_root is configured"),
+		}
+	}
+	for _, elem := range strings.Split(filepath.ToSlash(name), "/") {
+		if elem == ".." {
+			return "", nil, caddy.APIError{
+				HTTPStatus: http.StatusBadRequest,
+				Err:        errorf("source_file may not traverse outside of the source root"),
+			}
+		}
+	}
+
+	path := filepath.Join(root, filepath.FromSlash("/"+name))
+
+	// the file could still be a symlink to somewhere else
+	realPath, err := resolveWithin(root, path)
+	if os.IsNotExist(err) {
+		return "", nil, caddy.APIError{
+			HTTPStatus: http.StatusNotFound,
+			Err:        errorf("source_file %s does not exist", name),
+		}
+	}
+	if err == errOutsideRoot {
+		return "", nil, caddy.APIError{
+			HTTPStatus: http.StatusForbidden,
+			Err:        errorf("source_file %s resolves to outside of the source root", name),
+		}
+	}
+	if err != nil {
+		return "", nil, errorf("resolving source_file: %v", err)
+	}
+
+	body, err := ioutil.ReadFile(realPath)
+	if err != nil {
+		return "", nil, caddy.APIError{
+			HTTPStatus: http.StatusBadRequest,
+			Err:        errorf("reading source_file: %v", err),
+		}
+	}
+	return path, body, nil
+}
+
+// errOutsideRoot is returned by resolveWithin for
+// paths outside of the root.
+var errOutsideRoot = errors.New("outside of the root")
+
+// resolveWithin returns path with any symlinks resolved, which must
+// be within root, itself with any symlinks resolved, or else it
+// returns errOutsideRoot.
+func resolveWithin(root, path string) (string, error) {
+	realRoot, err := filepath.EvalSymlinks(root)
+	if err != nil {
+		return "", errorf("resolving root: %v", err)
+	}
+	realPath, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return "", err
+	}
+	if realPath != realRoot && !strings.HasPrefix(realPath, realRoot+string(filepath.Separator)) {
+		return "", errOutsideRoot
+	}
+	return realPath, nil
+}
+
+// contentTypeForFile guesses the Content-Type of a config file by
+// its name, such that it names the adapter for the file. It returns
+// an empty string if there is no telling.
+func contentTypeForFile(path string) string {
+	base := filepath.Base(path)
+	ext := strings.ToLower(filepath.Ext(base))
+	switch {
+	case ext == ".json":
+		return "application/json"
+	case strings.HasPrefix(strings.ToLower(base), "caddyfile"):
+		return "text/caddyfile"
+	case ext == "":
+		return ""
+	}
+	return "text/" + ext[1:]
+}
@@ -0,0 +1,72 @@
+package adapt
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSourceFileStaysWithinRoot(t *testing.T) {
+	dir, err := ioutil.TempDir("", "adapt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	root := filepath.Join(dir, "root")
+	if err := os.MkdirAll(filepath.Join(root, "sub"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	for name, body := range map[string]string{
+		filepath.Join(dir, "secret"):            "secret",
+		filepath.Join(root, "sub", "Caddyfile"): "inside",
+	} {
+		if err := ioutil.WriteFile(name, []byte(body), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for link, target := range map[string]string{
+		filepath.Join(root, "escape"):       filepath.Join(dir, "secret"),
+		filepath.Join(root, "escapedir"):    dir,
+		filepath.Join(root, "inside"):       filepath.Join(root, "sub", "Caddyfile"),
+		filepath.Join(root, "sub", "upper"): "../../secret",
+	} {
+		if err := os.Symlink(target, link); err != nil {
+			t.Skipf("symlinks aren't supported: %v", err)
+		}
+	}
+
+	adaptFile := func(name string) string {
+		return "/adapt?adapter=test-echo&source_file=" + url.QueryEscape(name)
+	}
+
+	startApp(t, &App{})
+	expectStatus(t, post(adaptFile("sub/Caddyfile"), "", ""), http.StatusForbidden)
+
+	startApp(t, &App{SourceRoot: root})
+	for _, name := range []string{"sub/Caddyfile", "/sub/Caddyfile", "inside"} {
+		w := post(adaptFile(name), "", "")
+		expectStatus(t, w, http.StatusOK)
+		if !strings.Contains(w.Body.String(), "inside") {
+			t.Fatalf("%s: expected the file in the root, got %s", name, w.Body)
+		}
+	}
+	for name, status := range map[string]int{
+		"../secret":             http.StatusBadRequest,
+		"sub/../../secret":      http.StatusBadRequest,
+		"escape":                http.StatusForbidden,
+		"escapedir/secret":      http.StatusForbidden,
+		"sub/upper":             http.StatusForbidden,
+		"missing":               http.StatusNotFound,
+		"escapedir/root/inside": http.StatusOK, // back within it
+	} {
+		w := post(adaptFile(name), "", "")
+		expectStatus(t, w, status)
+		if strings.Contains(w.Body.String(), "secret") && !strings.Contains(w.Body.String(), "source_file") {
+			t.Fatalf("%s: expected the file outside of the root not to be read, got %s", name, w.Body)
+		}
+	}
+}
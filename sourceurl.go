@@ -0,0 +1,223 @@
+package adapt
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+)
+
+const (
+	// defaultSourceTimeout is how long fetching a ?source URL may
+	// take, unless source_timeout says otherwise.
+	defaultSourceTimeout = 10 * time.Second
+
+	// defaultMaxSourceSize is the largest config, in bytes, that is
+	// fetched from a ?source URL, unless max_source_size says
+	// otherwise.
+	defaultMaxSourceSize = 10 << 20
+
+	// maxSourceRedirects is how many redirects are followed when
+	// fetching a ?source URL.
+	maxSourceRedirects = 5
+
+	// sourceCacheSize is how many configs fetched from ?source
+	// URLs are kept, to fetch them again conditionally.
+	sourceCacheSize = 16
+)
+
+// fetchedSources holds the configs recently fetched from ?source
+// URLs along with their validators, so that fetching one again
+// asks for it only if it changed, and a 304 reuses it. The same
+// config then hits the result cache, skipping the adapter too.
+var fetchedSources = &sourceCache{max: sourceCacheSize, items: make(map[string]*fetchedSource)}
+
+// fetchedSource is a config fetched from a ?source URL.
+type fetchedSource struct {
+	etag         string
+	lastModified string
+	body         []byte
+	used         uint64
+}
+
+// sourceCache holds fetched configs by URL, evicting
+// the least recently used one when it is full.
+type sourceCache struct {
+	mu    sync.Mutex
+	max   int
+	items map[string]*fetchedSource
+	clock uint64
+}
+
+// get returns the config cached for rawURL, if any.
+func (c *sourceCache) get(rawURL string) *fetchedSource {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	src := c.items[rawURL]
+	if src != nil {
+		c.clock++
+		src.used = c.clock
+	}
+	return src
+}
+
+// put caches src for rawURL.
+func (c *sourceCache) put(rawURL string, src *fetchedSource) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.clock++
+	src.used = c.clock
+	c.items[rawURL] = src
+	if len(c.items) <= c.max {
+		return
+	}
+	var oldest string
+	for u, cached := range c.items {
+		if oldest == "" || cached.used < c.items[oldest].used {
+			oldest = u
+		}
+	}
+	delete(c.items, oldest)
+}
+
+// fetchSource fetches the config at rawURL, which must be an http or
+// https URL on one of the source_hosts. Redirects are only followed
+// to those hosts too. Unless disable_cache is set, a config fetched
+// before is asked for with If-None-Match or If-Modified-Since, and
+// reused if it is not modified.
+func fetchSource(ctx context.Context, rawURL string) ([]byte, error) {
+	app := settings()
+	if len(app.SourceHosts) == 0 {
+		return nil, caddy.APIError{
+			HTTPStatus: http.StatusForbidden,
+			Err:        errorf("adapting configs from URLs is disabled; no source_hosts are configured"),
+		}
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, caddy.APIError{
+			HTTPStatus: http.StatusBadRequest,
+			Err:        errorf("source %s is not an http or https URL", rawURL),
+		}
+	}
+	if !app.sourceHostAllowed(u.Hostname()) {
+		return nil, caddy.APIError{
+			HTTPStatus: http.StatusForbidden,
+			Err:        errorf("source host %s is not one of the source_hosts", u.Hostname()),
+		}
+	}
+
+	timeout := time.Duration(app.SourceTimeout)
+	if timeout == 0 {
+		timeout = defaultSourceTimeout
+	}
+	client := &http.Client{
+		Timeout:   timeout,
+		Transport: app.sourceRoundTripper(outboundTransport(timeout, true)),
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) > maxSourceRedirects {
+				return errorf("stopped after %d redirects", maxSourceRedirects)
+			}
+			if req.URL.Scheme != "http" && req.URL.Scheme != "https" {
+				return errorf("redirected to %s, which is not an http or https URL", req.URL)
+			}
+			if !app.sourceHostAllowed(req.URL.Hostname()) {
+				return errorf("redirected to host %s, which is not one of the source_hosts", req.URL.Hostname())
+			}
+			return nil
+		},
+	}
+
+	req, err := http.NewRequest(http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, caddy.APIError{
+			HTTPStatus: http.StatusBadRequest,
+			Err:        errorf("source %s is not a valid URL: %v", rawURL, err),
+		}
+	}
+	var cached *fetchedSource
+	if !app.DisableCache {
+		cached = fetchedSources.get(rawURL)
+	}
+	if cached != nil {
+		if cached.etag != "" {
+			req.Header.Set("If-None-Match", cached.etag)
+		}
+		if cached.lastModified != "" {
+			req.Header.Set("If-Modified-Since", cached.lastModified)
+		}
+	}
+	resp, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			err = urlErr.Err
+		}
+		return nil, caddy.APIError{
+			HTTPStatus: http.StatusBadGateway,
+			Err:        errorf("fetching source %s: %v", rawURL, err),
+		}
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotModified && cached != nil {
+		// a copy, as the config may be rewritten in place
+		return append([]byte(nil), cached.body...), nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, caddy.APIError{
+			HTTPStatus: http.StatusBadGateway,
+			Err:        errorf("fetching source %s: %s", rawURL, resp.Status),
+		}
+	}
+
+	max := app.MaxSourceSize
+	if max == 0 {
+		max = defaultMaxSourceSize
+	}
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, max+1))
+	if err != nil {
+		return nil, caddy.APIError{
+			HTTPStatus: http.StatusBadGateway,
+			Err:        errorf("fetching source %s: %v", rawURL, err),
+		}
+	}
+	if int64(len(body)) > max {
+		return nil, caddy.APIError{
+			HTTPStatus: http.StatusBadGateway,
+			Err:        errorf("source %s is larger than the maximum of %d bytes", rawURL, max),
+		}
+	}
+	etag, lastModified := resp.Header.Get("ETag"), resp.Header.Get("Last-Modified")
+	if !app.DisableCache && (etag != "" || lastModified != "") {
+		fetchedSources.put(rawURL, &fetchedSource{etag: etag, lastModified: lastModified, body: append([]byte(nil), body...)})
+	}
+	return body, nil
+}
+
+// sourceHostAllowed returns true if host is one of the source_hosts.
+func (a *App) sourceHostAllowed(host string) bool {
+	for _, allowed := range a.SourceHosts {
+		if strings.EqualFold(host, allowed) {
+			return true
+		}
+	}
+	return false
+}
+
+// sourceURLName returns the file name in the path of rawURL,
+// for telling which adapter the config there is for.
+func sourceURLName(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return ""
+	}
+	return path.Base("/" + u.Path)
+}
@@ -0,0 +1,252 @@
+package adapt
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig"
+)
+
+// startSourceApp starts the app with source_hosts and a dial policy
+// that allow fetching from srv.
+func startSourceApp(t *testing.T, srv *httptest.Server, app *App) {
+	t.Helper()
+	u, err := url.Parse(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	app.SourceHosts = append(app.SourceHosts, u.Hostname())
+	if app.DialPolicy == nil {
+		app.DialPolicy = &DialPolicy{Allow: []string{u.Hostname()}}
+	}
+	startApp(t, app)
+}
+
+func TestSourceConditionalFetch(t *testing.T) {
+	var fetches, notModified int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches++
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			notModified++
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", `"v1"`)
+		w.Write([]byte("conditional"))
+	}))
+	defer srv.Close()
+	startSourceApp(t, srv, &App{})
+
+	target := "/adapt?adapter=test-echo&source=" + url.QueryEscape(srv.URL+"/conditional/Caddyfile")
+	for i := 0; i < 2; i++ {
+		w := post(target, "", "")
+		expectStatus(t, w, http.StatusOK)
+		if !strings.Contains(w.Body.String(), "conditional") {
+			t.Fatalf("fetch %d: expected the fetched config, got %s", i, w.Body)
+		}
+	}
+	if fetches != 2 || notModified != 1 {
+		t.Fatalf("expected a second, conditional fetch answered with 304, got %d fetches and %d 304s", fetches, notModified)
+	}
+}
+
+func TestSourceConditionalFetchDisabled(t *testing.T) {
+	var conditional int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("If-None-Match") != "" || r.Header.Get("If-Modified-Since") != "" {
+			conditional++
+		}
+		w.Header().Set("Last-Modified", "Mon, 02 Jan 2006 15:04:05 GMT")
+		w.Write([]byte("unconditional"))
+	}))
+	defer srv.Close()
+	startSourceApp(t, srv, &App{DisableCache: true})
+
+	target := "/adapt?adapter=test-echo&source=" + url.QueryEscape(srv.URL+"/unconditional/Caddyfile")
+	for i := 0; i < 2; i++ {
+		expectStatus(t, post(target, "", ""), http.StatusOK)
+	}
+	if conditional != 0 {
+		t.Fatalf("expected no conditional fetches with disable_cache, got %d", conditional)
+	}
+}
+
+func TestSourceCredentials(t *testing.T) {
+	os.Setenv("ADAPT_TEST_SOURCE_TOKEN", "s3cret")
+	defer os.Unsetenv("ADAPT_TEST_SOURCE_TOKEN")
+
+	var redirectedAuth string
+	var srv *httptest.Server
+	srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/private/Caddyfile":
+			if r.Header.Get("Authorization") != "Bearer s3cret" || r.Header.Get("X-Api-Key") != "key" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			w.Write([]byte("private"))
+		case "/redirect/Caddyfile":
+			// to the same server, by another name
+			u, _ := url.Parse(srv.URL)
+			http.Redirect(w, r, "http://localhost:"+u.Port()+"/public/Caddyfile", http.StatusFound)
+		case "/public/Caddyfile":
+			redirectedAuth = r.Header.Get("Authorization")
+			w.Write([]byte("public"))
+		}
+	}))
+	defer srv.Close()
+	startSourceApp(t, srv, &App{
+		SourceHosts: []string{"localhost"},
+		DialPolicy:  &DialPolicy{Allow: []string{"127.0.0.1", "::1"}},
+		SourceCredentials: []*SourceCredentials{{
+			Host:        "127.0.0.1",
+			BearerToken: "{env.ADAPT_TEST_SOURCE_TOKEN}",
+			Headers:     map[string]string{"X-Api-Key": "key"},
+		}},
+	})
+
+	w := post("/adapt?adapter=test-echo&source="+url.QueryEscape(srv.URL+"/private/Caddyfile"), "", "")
+	expectStatus(t, w, http.StatusOK)
+	if !strings.Contains(w.Body.String(), "private") {
+		t.Fatalf("expected the private config, got %s", w.Body)
+	}
+
+	w = post("/adapt?adapter=test-echo&source="+url.QueryEscape(srv.URL+"/redirect/Caddyfile"), "", "")
+	expectStatus(t, w, http.StatusOK)
+	if redirectedAuth != "" {
+		t.Fatalf("expected no credentials sent to the host redirected to, got %q", redirectedAuth)
+	}
+}
+
+func TestSourceCredentialsHostNotAllowed(t *testing.T) {
+	app := &App{
+		SourceHosts:       []string{"example.com"},
+		SourceCredentials: []*SourceCredentials{{Host: "example.net", BearerToken: "token"}},
+	}
+	err := caddy.Validate(&caddy.Config{AppsRaw: caddy.ModuleMap{"adapt": caddyconfig.JSON(app, nil)}})
+	if err == nil || !strings.Contains(err.Error(), "not one of the source_hosts") {
+		t.Fatalf("expected credentials for a host that isn't a source host to be rejected, got %v", err)
+	}
+}
+
+func TestSourceOutboundProxy(t *testing.T) {
+	proxied := make(chan string, 2)
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		proxied <- r.Method + " " + r.URL.String()
+		w.Write([]byte("proxied"))
+	}))
+	defer proxy.Close()
+	direct := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("direct"))
+	}))
+	defer direct.Close()
+	// no dial policy allows loopback addresses, like the proxy's,
+	// which is connected to anyway, being configured
+	startApp(t, &App{
+		SourceHosts:   []string{"configs.example", "127.0.0.1"},
+		OutboundProxy: &OutboundProxy{URL: proxy.URL, NoProxy: []string{"127.0.0.1"}},
+		Webhooks:      []*Webhook{{URL: "http://hooks.example/adapted"}},
+	})
+
+	w := post("/adapt?adapter=test-echo&notify=true&source="+url.QueryEscape("http://configs.example/Caddyfile"), "", "")
+	expectStatus(t, w, http.StatusOK)
+	if !strings.Contains(w.Body.String(), "proxied") {
+		t.Fatalf("expected the config fetched through the proxy, got %s", w.Body)
+	}
+	for _, expected := range []string{"GET http://configs.example/Caddyfile", "POST http://hooks.example/adapted"} {
+		select {
+		case got := <-proxied:
+			if got != expected {
+				t.Fatalf("expected %q through the proxy, got %q", expected, got)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("expected %q through the proxy", expected)
+		}
+	}
+
+	// a no_proxy host is connected to directly, which
+	// the dial policy doesn't allow for loopback
+	w = post("/adapt?adapter=test-echo&source="+url.QueryEscape(direct.URL+"/Caddyfile"), "", "")
+	expectStatus(t, w, http.StatusBadGateway)
+	if !strings.Contains(w.Body.String(), "not allowed") {
+		t.Fatalf("expected the no_proxy host to be dialed directly, got %s", w.Body)
+	}
+}
+
+func TestSourceDialPolicy(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("loopback"))
+	}))
+	defer srv.Close()
+	u, err := url.Parse(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	startApp(t, &App{})
+	expectStatus(t, post("/adapt?adapter=test-echo&source="+url.QueryEscape(srv.URL+"/Caddyfile"), "", ""), http.StatusForbidden)
+
+	// a source host, but no dial policy allows its
+	// loopback address, by IP address or by name
+	startApp(t, &App{SourceHosts: []string{"127.0.0.1", "localhost", "other.example"}})
+	for _, source := range []string{srv.URL, "http://localhost:" + u.Port()} {
+		w := post("/adapt?adapter=test-echo&source="+url.QueryEscape(source+"/Caddyfile"), "", "")
+		expectStatus(t, w, http.StatusBadGateway)
+		// and not the config it would have served
+		if !strings.Contains(w.Body.String(), "not allowed") || strings.Contains(w.Body.String(), "loopback") {
+			t.Fatalf("%s: expected dialing it to be denied, got %s", source, w.Body)
+		}
+	}
+	expectStatus(t, post("/adapt?adapter=test-echo&source="+url.QueryEscape("http://config.example/Caddyfile"), "", ""), http.StatusForbidden)
+}
+
+func TestSourceRedirects(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch path := strings.TrimPrefix(r.URL.Path, "/"); {
+		case path == "elsewhere":
+			http.Redirect(w, r, "http://config.example/Caddyfile", http.StatusFound)
+		case path == "ftp":
+			http.Redirect(w, r, "ftp://127.0.0.1/Caddyfile", http.StatusFound)
+		case path == "0":
+			w.Write([]byte("redirected"))
+		default:
+			// counts down the redirects left to the config
+			n, err := strconv.Atoi(path)
+			if err != nil {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			http.Redirect(w, r, "/"+strconv.Itoa(n-1), http.StatusFound)
+		}
+	}))
+	defer srv.Close()
+	startSourceApp(t, srv, &App{})
+
+	adaptSource := func(path string) *httptest.ResponseRecorder {
+		return post("/adapt?adapter=test-echo&source="+url.QueryEscape(srv.URL+path), "", "")
+	}
+
+	w := adaptSource("/" + strconv.Itoa(maxSourceRedirects))
+	expectStatus(t, w, http.StatusOK)
+	if !strings.Contains(w.Body.String(), "redirected") {
+		t.Fatalf("expected the config redirected to, got %s", w.Body)
+	}
+	for path, expected := range map[string]string{
+		"/" + strconv.Itoa(maxSourceRedirects+1): "stopped after",
+		"/elsewhere":                             "not one of the source_hosts",
+		"/ftp":                                   "not an http or https URL",
+	} {
+		w := adaptSource(path)
+		expectStatus(t, w, http.StatusBadGateway)
+		if !strings.Contains(w.Body.String(), expected) {
+			t.Fatalf("%s: expected %q, got %s", path, expected, w.Body)
+		}
+	}
+}
@@ -0,0 +1,127 @@
+package adapt
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"sort"
+
+	"github.com/caddyserver/caddy/v2"
+)
+
+// splitManifest is the manifest.json of a config split with
+// ?split=zip, which says where in the config each file came from.
+type splitManifest struct {
+	Files      map[string]string `json:"files"` // file name to JSON pointer
+	Warnings   []adaptWarning    `json:"warnings"`
+	Provenance provenance        `json:"provenance"`
+}
+
+// splitMode returns the ?split parameter of r, which
+// if present must be "zip".
+func splitMode(r *http.Request) (string, error) {
+	split := r.URL.Query().Get("split")
+	if split != "" && split != "zip" {
+		return "", caddy.APIError{
+			HTTPStatus: http.StatusBadRequest,
+			Err:        errorf("invalid value for split: %s (must be zip)", split),
+		}
+	}
+	return split, nil
+}
+
+// splitZip returns the config in cfgJSON as a zip archive with one
+// file per app, named after it, such as http.json, and one per other
+// top-level key, such as admin.json, each in the given format, along
+// with a manifest.json.
+func splitZip(cfgJSON []byte, format string, warnings []adaptWarning, prov provenance) ([]byte, error) {
+	var cfg map[string]json.RawMessage
+	if err := json.Unmarshal(cfgJSON, &cfg); err != nil {
+		return nil, caddy.APIError{
+			HTTPStatus: http.StatusBadRequest,
+			Err:        errorf("adapted config is not a JSON object: %v", err),
+		}
+	}
+	var apps map[string]json.RawMessage
+	if len(cfg["apps"]) > 0 {
+		if err := json.Unmarshal(cfg["apps"], &apps); err != nil {
+			return nil, caddy.APIError{
+				HTTPStatus: http.StatusBadRequest,
+				Err:        errorf("apps is not a JSON object: %v", err),
+			}
+		}
+	}
+	delete(cfg, "apps")
+
+	manifest := splitManifest{
+		Files:      make(map[string]string),
+		Warnings:   warnings,
+		Provenance: prov,
+	}
+	contents := make(map[string][]byte)
+	add := func(name, pointer string, value json.RawMessage) error {
+		file := name + ".json"
+		if file == "manifest.json" {
+			return caddy.APIError{
+				HTTPStatus: http.StatusBadRequest,
+				Err:        errorf("cannot split config: %s would be the manifest", pointer),
+			}
+		}
+		if other, ok := manifest.Files[file]; ok {
+			return caddy.APIError{
+				HTTPStatus: http.StatusBadRequest,
+				Err:        errorf("cannot split config: %s and %s would both be %s", other, pointer, file),
+			}
+		}
+		formatted, err := formatJSON(value, format)
+		if err != nil {
+			return err
+		}
+		manifest.Files[file] = pointer
+		contents[file] = formatted
+		return nil
+	}
+	for name, app := range apps {
+		if err := add(name, "/apps/"+escapePointer(name), app); err != nil {
+			return nil, err
+		}
+	}
+	for key, value := range cfg {
+		if err := add(key, "/"+escapePointer(key), value); err != nil {
+			return nil, err
+		}
+	}
+
+	manifestJSON, err := json.MarshalIndent(manifest, "", "\t")
+	if err != nil {
+		return nil, err
+	}
+	contents["manifest.json"] = append(manifestJSON, '\n')
+
+	names := make([]string, 0, len(contents))
+	for name := range contents {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for _, name := range names {
+		f, err := zw.CreateHeader(&zip.FileHeader{
+			Name:     name,
+			Method:   zip.Deflate,
+			Modified: prov.Timestamp,
+		})
+		if err != nil {
+			return nil, err
+		}
+		if _, err := f.Write(contents[name]); err != nil {
+			return nil, err
+		}
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
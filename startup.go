@@ -0,0 +1,204 @@
+package adapt
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig"
+)
+
+// startupConfig is the config file Caddy was started with, and the
+// name of the adapter it was adapted with, if any. file is empty if
+// Caddy wasn't started from a file, such as when it resumed its last
+// config, read its config from stdin or was embedded in a program.
+type startupConfig struct {
+	file    string
+	adapter string
+}
+
+var (
+	startupOnce   sync.Once
+	startupSource startupConfig
+)
+
+// recordStartupConfig records the config file Caddy was started
+// with, the first time it is called. It is called when the app is
+// provisioned, which is first when the config from that file is
+// loaded, so that the working directory and adapters are as
+// they were when Caddy read it.
+func recordStartupConfig() startupConfig {
+	startupOnce.Do(func() {
+		startupSource = startupConfigFromArgs(os.Args)
+	})
+	return startupSource
+}
+
+// startupConfigFromArgs returns the config file and adapter that
+// `caddy run` picks with the command line args, which `caddy start`
+// passes along to it, the same way it does.
+func startupConfigFromArgs(args []string) startupConfig {
+	if len(args) < 2 || args[1] != "run" {
+		return startupConfig{}
+	}
+
+	var cfg startupConfig
+	var resume bool
+	for i := 2; i < len(args); i++ {
+		if args[i] == "--" || !strings.HasPrefix(args[i], "-") {
+			break
+		}
+		name := strings.TrimLeft(args[i], "-")
+		value, hasValue := "", false
+		if idx := strings.IndexByte(name, '='); idx >= 0 {
+			name, value, hasValue = name[:idx], name[idx+1:], true
+		}
+		switch name {
+		case "config", "adapter", "envfile", "pidfile", "pingback":
+			if !hasValue && i+1 < len(args) {
+				i++
+				value = args[i]
+			}
+			if name == "config" {
+				cfg.file = value
+			} else if name == "adapter" {
+				cfg.adapter = value
+			}
+		case "resume":
+			resume = !hasValue
+			if hasValue {
+				resume, _ = strconv.ParseBool(value)
+			}
+		}
+	}
+
+	// the last config is resumed instead, if there is one
+	if resume {
+		if _, err := os.Stat(caddy.ConfigAutosavePath); err == nil {
+			return startupConfig{}
+		}
+	}
+	if cfg.file == "" && cfg.adapter == "" && caddyconfig.GetAdapter("caddyfile") != nil {
+		if _, err := os.Stat("Caddyfile"); err == nil {
+			cfg.file = "Caddyfile"
+		}
+	}
+	if cfg.file == "" || cfg.file == "-" {
+		return startupConfig{}
+	}
+	base := filepath.Base(cfg.file)
+	if cfg.adapter == "" && strings.HasPrefix(base, "Caddyfile") && filepath.Ext(base) != ".json" {
+		cfg.adapter = "caddyfile"
+	}
+	if abs, err := filepath.Abs(cfg.file); err == nil {
+		cfg.file = abs
+	}
+	return cfg
+}
+
+// handleStartupConfig responds to GET /adapt with the config file
+// Caddy was started with, adapted afresh, to see what restarting it
+// would run. With ?diff=true, it responds with the JSON Patch that
+// turns the running config into it instead, which is empty unless
+// the file has changed since it was loaded or the config has been
+// changed through the API; ?unified=true makes it a unified diff.
+func (adminAdapt) handleStartupConfig(w http.ResponseWriter, r *http.Request) error {
+	withDiff, err := queryBool(r, "diff")
+	if err != nil {
+		return err
+	}
+	unified, err := queryBool(r, "unified")
+	if err != nil {
+		return err
+	}
+	format, err := outputFormat(r)
+	if err != nil {
+		return err
+	}
+
+	startup := recordStartupConfig()
+	if startup.file == "" {
+		return caddy.APIError{
+			HTTPStatus: http.StatusNotFound,
+			Err:        errorf("Caddy wasn't started from a config file"),
+		}
+	}
+	adapterName := startup.adapter
+	if adapterName == "" {
+		adapterName = "json"
+	}
+	adapter, err := AdapterByName(adapterName)
+	if err != nil {
+		return errorf("adapter of %s: %v", startup.file, err)
+	}
+
+	source, err := ioutil.ReadFile(startup.file)
+	if err != nil {
+		return errorf("reading %s: %v", startup.file, err)
+	}
+	start := time.Now()
+	result, warnings, err := adaptProfiled(r.Context(), r.URL.Path, adapter, source, map[string]interface{}{"filename": startup.file})
+	if err != nil {
+		if _, ok := err.(caddy.APIError); !ok {
+			err = caddy.APIError{
+				HTTPStatus: http.StatusUnprocessableEntity,
+				Err:        err,
+			}
+		}
+		return err
+	}
+	if result, err = transformConfig(r.Context(), result); err != nil {
+		return err
+	}
+	if r.Context().Err() != nil {
+		return contextError(r.Context())
+	}
+
+	a := adaptation{
+		adapter:  adapter.Name(),
+		source:   source,
+		result:   result,
+		warnings: classifyWarnings(warnings),
+		duration: time.Since(start),
+	}
+	newProvenance(r, a.adapter, source).setHeaders(w.Header())
+	a.setHeaders(w.Header())
+	w.Header().Set("X-Adapt-Source-File", startup.file)
+
+	if withDiff || unified {
+		var from, to interface{}
+		if err := json.Unmarshal(result, &to); err != nil {
+			return caddy.APIError{
+				HTTPStatus: http.StatusUnprocessableEntity,
+				Err:        errorf("adapted config is not valid JSON: %v", err),
+			}
+		}
+		runningJSON, err := runningConfig(r)
+		if err != nil {
+			return err
+		}
+		if err := json.Unmarshal(runningJSON, &from); err != nil {
+			return errorf("running config is not valid JSON: %v", err)
+		}
+		if unified {
+			return writeUnifiedDiff(w, "running", startup.file, from, to)
+		}
+		w.Header().Set("Content-Type", "application/json-patch+json")
+		return json.NewEncoder(w).Encode(jsonPatch("", from, to, []patchOp{}))
+	}
+
+	result, err = formatJSON(result, format)
+	if err != nil {
+		return err
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(result)
+	return nil
+}
@@ -0,0 +1,88 @@
+package adapt
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+
+	"github.com/caddyserver/caddy/v2"
+)
+
+// configStats are the size and complexity of an adapted config, in
+// the response body of /adapt with ?stats=true, for capacity planning.
+type configStats struct {
+	Size                  int            `json:"size"` // compact, in bytes
+	Servers               int            `json:"servers"`
+	Routes                int            `json:"routes"` // including those of subroutes
+	Handlers              map[string]int `json:"handlers"`
+	TLSAutomationPolicies int            `json:"tls_automation_policies"`
+	Upstreams             int            `json:"upstreams"`
+}
+
+// newStats returns the statistics of the config in cfgJSON.
+func newStats(cfgJSON []byte) (configStats, error) {
+	stats := configStats{Handlers: make(map[string]int)}
+
+	var compact bytes.Buffer
+	if err := json.Compact(&compact, cfgJSON); err != nil {
+		return stats, caddy.APIError{
+			HTTPStatus: http.StatusBadRequest,
+			Err:        errorf("adapted config is not valid JSON: %v", err),
+		}
+	}
+	stats.Size = compact.Len()
+
+	var cfg struct {
+		Apps struct {
+			HTTP struct {
+				Servers map[string]json.RawMessage `json:"servers"`
+			} `json:"http"`
+			TLS struct {
+				Automation struct {
+					Policies []json.RawMessage `json:"policies"`
+				} `json:"automation"`
+			} `json:"tls"`
+		} `json:"apps"`
+	}
+	if err := json.Unmarshal(cfgJSON, &cfg); err != nil {
+		return stats, caddy.APIError{
+			HTTPStatus: http.StatusBadRequest,
+			Err:        errorf("adapted config is not a Caddy config"),
+		}
+	}
+	stats.TLSAutomationPolicies = len(cfg.Apps.TLS.Automation.Policies)
+	stats.Servers = len(cfg.Apps.HTTP.Servers)
+	for _, srv := range cfg.Apps.HTTP.Servers {
+		var val interface{}
+		if err := json.Unmarshal(srv, &val); err != nil {
+			return stats, err
+		}
+		stats.count(val)
+	}
+	return stats, nil
+}
+
+// count adds the routes, handlers and upstreams in val, a decoded
+// part of an HTTP server's config, to the statistics.
+func (s *configStats) count(val interface{}) {
+	switch v := val.(type) {
+	case map[string]interface{}:
+		if name, ok := v["handler"].(string); ok {
+			s.Handlers[name]++
+			if name == "reverse_proxy" {
+				upstreams, _ := v["upstreams"].([]interface{})
+				s.Upstreams += len(upstreams)
+			}
+		}
+		if routes, ok := v["routes"].([]interface{}); ok {
+			s.Routes += len(routes)
+		}
+		for _, elem := range v {
+			s.count(elem)
+		}
+	case []interface{}:
+		for _, elem := range v {
+			s.count(elem)
+		}
+	}
+}
@@ -0,0 +1,56 @@
+package adapt
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"text/template"
+
+	"github.com/Masterminds/sprig/v3"
+	"github.com/caddyserver/caddy/v2"
+)
+
+// valuesField is the name of the multipart form field that holds
+// the values for ?template=true, as a JSON object.
+const valuesField = "values.json"
+
+// templateFuncs are the functions available to config templates:
+// sprig's, except those that read the environment, which is what
+// ?env and its env_prefixes are for.
+var templateFuncs = func() template.FuncMap {
+	funcs := sprig.TxtFuncMap()
+	delete(funcs, "env")
+	delete(funcs, "expandenv")
+	return funcs
+}()
+
+// renderTemplate renders body as a Go text/template, with values,
+// which is a JSON document or nil, as its data. Referring to a key
+// that isn't in the values is an error.
+func renderTemplate(body, values []byte) ([]byte, error) {
+	var data interface{}
+	if values != nil {
+		if err := json.Unmarshal(values, &data); err != nil {
+			return nil, caddy.APIError{
+				HTTPStatus: http.StatusBadRequest,
+				Err:        errorf("decoding %s: %v", valuesField, err),
+			}
+		}
+	}
+
+	tmpl, err := template.New("config").Funcs(templateFuncs).Option("missingkey=error").Parse(string(body))
+	if err != nil {
+		return nil, caddy.APIError{
+			HTTPStatus: http.StatusBadRequest,
+			Err:        errorf("parsing template: %v", err),
+		}
+	}
+	var out bytes.Buffer
+	if err := tmpl.Execute(&out, data); err != nil {
+		return nil, caddy.APIError{
+			HTTPStatus: http.StatusBadRequest,
+			Err:        errorf("rendering template: %v", err),
+		}
+	}
+	return out.Bytes(), nil
+}
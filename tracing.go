@@ -0,0 +1,152 @@
+package adapt
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp"
+	"go.opentelemetry.io/otel/exporters/otlp/otlphttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/semconv"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Tracing exports OpenTelemetry traces of requests to the /adapt
+// endpoints, with spans for reading the body, running the adapter
+// and loading the result, to an OTLP/HTTP collector. Requests that
+// carry a W3C traceparent header are traced as part of that trace.
+type Tracing struct {
+	// The host:port of the collector's OTLP/HTTP receiver.
+	Endpoint string `json:"endpoint"`
+
+	// Sends traces over plain HTTP instead of HTTPS.
+	Insecure bool `json:"insecure,omitempty"`
+
+	// Headers to send with traces, such as for authentication.
+	// Their values may be placeholders, such as {env.OTLP_TOKEN}.
+	Headers map[string]string `json:"headers,omitempty"`
+
+	// The service name that spans are reported under. Default: caddy
+	ServiceName string `json:"service_name,omitempty"`
+
+	// The fraction of requests, from 0 to 1, that are traced, unless
+	// the trace they're part of says whether to. Default: 1
+	SampleRatio *float64 `json:"sample_ratio,omitempty"`
+}
+
+// newTracerProvider returns a tracer provider that
+// exports spans as t configures.
+func (t *Tracing) newTracerProvider(repl *caddy.Replacer) (*sdktrace.TracerProvider, error) {
+	if t.Endpoint == "" {
+		return nil, fmt.Errorf("endpoint is required")
+	}
+	ratio := 1.0
+	if t.SampleRatio != nil {
+		ratio = *t.SampleRatio
+		if ratio < 0 || ratio > 1 {
+			return nil, fmt.Errorf("sample_ratio must be from 0 to 1")
+		}
+	}
+	serviceName := t.ServiceName
+	if serviceName == "" {
+		serviceName = "caddy"
+	}
+
+	opts := []otlphttp.Option{otlphttp.WithEndpoint(t.Endpoint)}
+	if t.Insecure {
+		opts = append(opts, otlphttp.WithInsecure())
+	}
+	if len(t.Headers) > 0 {
+		headers := make(map[string]string, len(t.Headers))
+		for name, value := range t.Headers {
+			headers[name] = repl.ReplaceAll(value, "")
+		}
+		opts = append(opts, otlphttp.WithHeaders(headers))
+	}
+	exporter, err := otlp.NewExporter(context.Background(), otlphttp.NewDriver(opts...))
+	if err != nil {
+		return nil, err
+	}
+
+	return sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(ratio))),
+		sdktrace.WithResource(resource.NewWithAttributes(semconv.ServiceNameKey.String(serviceName))),
+	), nil
+}
+
+// tracer returns the tracer that spans are started with: the one
+// of the tracing settings, or else the global one, which doesn't
+// record anything unless something else has set it up.
+func tracer() trace.Tracer {
+	if tp := settings().tracerProvider; tp != nil {
+		return tp.Tracer(modulePath)
+	}
+	return otel.Tracer(modulePath)
+}
+
+// startSpan starts a span named name as a child of any in ctx.
+func startSpan(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return tracer().Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// endSpan ends span, which failed if err isn't nil.
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// traced wraps h, the handler of the route with the given pattern,
+// so that each request is a span, which is a child of the one in
+// its traceparent header, if any.
+func traced(pattern string, h caddy.AdminHandler) caddy.AdminHandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		ctx := propagation.TraceContext{}.Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+		ctx, span := tracer().Start(ctx, r.Method+" "+pattern,
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(
+				semconv.HTTPMethodKey.String(r.Method),
+				semconv.HTTPTargetKey.String(r.URL.RequestURI()),
+			))
+		sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+
+		err := h.ServeHTTP(sw, r.WithContext(ctx))
+
+		status := sw.status
+		if err != nil {
+			status = errorStatus(err)
+		}
+		span.SetAttributes(semconv.HTTPStatusCodeKey.Int(status))
+		if stats, ok := ctx.Value(requestStatsCtxKey).(*requestStats); ok && stats.adapter != "" {
+			span.SetAttributes(attribute.String("adapt.adapter", stats.adapter))
+		}
+		spanErr := err
+		if spanErr == nil && status >= 400 {
+			spanErr = fmt.Errorf("%d %s", status, http.StatusText(status))
+		}
+		endSpan(span, spanErr)
+		return err
+	}
+}
+
+// shutdownTracing flushes and stops the tracer provider
+// of the app, if it has one.
+func (a *App) shutdownTracing() error {
+	if a.tracerProvider == nil {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	return a.tracerProvider.Shutdown(ctx)
+}
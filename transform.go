@@ -0,0 +1,128 @@
+package adapt
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/caddyserver/caddy/v2"
+)
+
+func init() {
+	caddy.RegisterModule(DefaultsTransformer{})
+}
+
+// Transformer post-processes adapted configs, such as to apply
+// org-wide policy to every one of them. Transform is given the
+// adapted config, which it must not modify, and returns the config
+// as transformed. It may return a caddy.APIError to reject a config
+// with its own status. Modules in the admin.api.adapt.transformers
+// namespace implement it.
+type Transformer interface {
+	Transform(cfgJSON []byte) ([]byte, error)
+}
+
+// transformConfig runs cfgJSON through the configured transformers,
+// in order, and returns the result. It returns cfgJSON as it is if
+// there are none.
+func transformConfig(ctx context.Context, cfgJSON []byte) ([]byte, error) {
+	transformers := settings().transformers
+	if len(transformers) == 0 {
+		return cfgJSON, nil
+	}
+	_, span := startSpan(ctx, "transform config")
+	var err error
+	for i, t := range transformers {
+		cfgJSON, err = t.Transform(cfgJSON)
+		if err == nil && !json.Valid(cfgJSON) {
+			err = errorf("transformer %d returned invalid JSON", i)
+		}
+		if err != nil {
+			if _, ok := err.(caddy.APIError); !ok {
+				err = caddy.APIError{
+					HTTPStatus: http.StatusInternalServerError,
+					Err:        errorf("transformer %d: %v", i, err),
+				}
+			}
+			break
+		}
+	}
+	endSpan(span, err)
+	if err != nil {
+		return nil, err
+	}
+	return cfgJSON, nil
+}
+
+// DefaultsTransformer fills in values that adapted configs leave
+// out, such as the admin listener or logging that every config in
+// an organization should have. Values the config already has are
+// kept; objects are filled in recursively.
+type DefaultsTransformer struct {
+	// The defaults, as a partial config, such as
+	// {"admin": {"listen": "localhost:2019"}}.
+	Values json.RawMessage `json:"values"`
+
+	values map[string]interface{}
+}
+
+// CaddyModule returns the Caddy module information.
+func (DefaultsTransformer) CaddyModule() caddy.ModuleInfo {
+	return caddy.ModuleInfo{
+		ID:  "admin.api.adapt.transformers.defaults",
+		New: func() caddy.Module { return new(DefaultsTransformer) },
+	}
+}
+
+// Provision decodes the defaults.
+func (d *DefaultsTransformer) Provision(_ caddy.Context) error {
+	dec := json.NewDecoder(bytes.NewReader(d.Values))
+	dec.UseNumber()
+	if err := dec.Decode(&d.values); err != nil || d.values == nil {
+		return fmt.Errorf("values must be a JSON object")
+	}
+	return nil
+}
+
+// Transform returns cfgJSON with the defaults filled in.
+func (d *DefaultsTransformer) Transform(cfgJSON []byte) ([]byte, error) {
+	dec := json.NewDecoder(bytes.NewReader(cfgJSON))
+	dec.UseNumber()
+	var cfg map[string]interface{}
+	if err := dec.Decode(&cfg); err != nil {
+		return nil, caddy.APIError{
+			HTTPStatus: http.StatusBadRequest,
+			Err:        errorf("adapted config is not a JSON object: %v", err),
+		}
+	}
+	if cfg == nil {
+		cfg = make(map[string]interface{})
+	}
+	fillDefaults(cfg, d.values)
+	return json.Marshal(cfg)
+}
+
+// fillDefaults sets the keys of defaults that cfg lacks to their
+// values in defaults, and fills in the objects that both have.
+func fillDefaults(cfg, defaults map[string]interface{}) {
+	for key, def := range defaults {
+		val, ok := cfg[key]
+		if !ok {
+			cfg[key] = def
+			continue
+		}
+		valObj, ok := val.(map[string]interface{})
+		defObj, defOK := def.(map[string]interface{})
+		if ok && defOK {
+			fillDefaults(valObj, defObj)
+		}
+	}
+}
+
+// Interface guards
+var (
+	_ Transformer       = (*DefaultsTransformer)(nil)
+	_ caddy.Provisioner = (*DefaultsTransformer)(nil)
+)
@@ -0,0 +1,255 @@
+package adapt
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// unifiedContext is how many unchanged lines surround each
+// change in a unified diff, and maxDiffEdits the most edits
+// looked for between two configs before the rest of them is
+// diffed as replaced wholesale, to bound the time it takes.
+const (
+	unifiedContext = 3
+	maxDiffEdits   = 4096
+)
+
+// lineOp is a line of a line-by-line diff: kept (' '),
+// removed ('-') or added ('+').
+type lineOp struct {
+	kind byte
+	text string
+}
+
+// unifiedJSONDiff returns a unified diff from the JSON document from
+// to the one to, each indented with its keys sorted, so that the
+// lines that differ are the values that do, labeled with the given
+// names. It is empty if they are equal.
+func unifiedJSONDiff(fromName, toName string, from, to interface{}) ([]byte, error) {
+	fromLines, err := jsonLines(from)
+	if err != nil {
+		return nil, err
+	}
+	toLines, err := jsonLines(to)
+	if err != nil {
+		return nil, err
+	}
+	return unifiedDiff(fromName, toName, diffLines(fromLines, toLines)), nil
+}
+
+// jsonLines returns val as indented JSON, with sorted keys, in lines.
+func jsonLines(val interface{}) ([]string, error) {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "\t")
+	if err := enc.Encode(val); err != nil {
+		return nil, err
+	}
+	return strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n"), nil
+}
+
+// diffLines returns the shortest edit script, by Myers' algorithm in
+// linear space, that turns the lines a into the lines b.
+func diffLines(a, b []string) []lineOp {
+	var prefix, suffix []lineOp
+	for len(a) > 0 && len(b) > 0 && a[0] == b[0] {
+		prefix = append(prefix, lineOp{' ', a[0]})
+		a, b = a[1:], b[1:]
+	}
+	for len(a) > 0 && len(b) > 0 && a[len(a)-1] == b[len(b)-1] {
+		suffix = append([]lineOp{{' ', a[len(a)-1]}}, suffix...)
+		a, b = a[:len(a)-1], b[:len(b)-1]
+	}
+
+	var ops []lineOp
+	if len(a) > 0 && len(b) > 0 {
+		// every edit script found below the first has fewer
+		// edits, so bounding them here bounds them all
+		if _, _, _, _, ok := middleSnake(a, b, maxDiffEdits); !ok {
+			for _, line := range a {
+				ops = append(ops, lineOp{'-', line})
+			}
+			for _, line := range b {
+				ops = append(ops, lineOp{'+', line})
+			}
+			return append(append(prefix, ops...), suffix...)
+		}
+	}
+	ops = appendDiff(ops, a, b)
+	return append(append(prefix, ops...), suffix...)
+}
+
+// appendDiff appends the shortest edit script that turns the lines a
+// into the lines b to ops, dividing it at its middle snake.
+func appendDiff(ops []lineOp, a, b []string) []lineOp {
+	for len(a) > 0 && len(b) > 0 && a[0] == b[0] {
+		ops = append(ops, lineOp{' ', a[0]})
+		a, b = a[1:], b[1:]
+	}
+	n := 0
+	for n < len(a) && n < len(b) && a[len(a)-1-n] == b[len(b)-1-n] {
+		n++
+	}
+	kept := a[len(a)-n:]
+	a, b = a[:len(a)-n], b[:len(b)-n]
+
+	switch {
+	case len(a) == 0:
+		for _, line := range b {
+			ops = append(ops, lineOp{'+', line})
+		}
+	case len(b) == 0:
+		for _, line := range a {
+			ops = append(ops, lineOp{'-', line})
+		}
+	default:
+		// with neither a common first nor last line, there are at
+		// least two edits, so both halves have fewer than the whole
+		x, y, u, v, _ := middleSnake(a, b, len(a)+len(b))
+		ops = appendDiff(ops, a[:x], b[:y])
+		for _, line := range a[x:u] {
+			ops = append(ops, lineOp{' ', line})
+		}
+		ops = appendDiff(ops, a[u:], b[v:])
+	}
+
+	for _, line := range kept {
+		ops = append(ops, lineOp{' ', line})
+	}
+	return ops
+}
+
+// middleSnake returns where the middle snake of the shortest edit
+// script that turns the lines a into the lines b starts, (x, y), and
+// ends, (u, v): the run of kept lines after its middle edit, found by
+// searching from both ends at once, in space linear in the edits. It
+// returns false if the script has more than maxEdits edits.
+func middleSnake(a, b []string, maxEdits int) (x, y, u, v int, ok bool) {
+	n, m := len(a), len(b)
+	delta := n - m
+	odd := delta%2 != 0
+	maxD := (n + m + 1) / 2
+	if limit := (maxEdits + 1) / 2; limit < maxD {
+		maxD = limit
+	}
+	// forward[offset+k] is the furthest x reached on the diagonal
+	// k = x-y from the start, backward[offset+k] how far from the
+	// end the search from it reached on the diagonal n-x - (m-y)
+	offset := maxD + 1
+	forward := make([]int, 2*offset+1)
+	backward := make([]int, 2*offset+1)
+	for d := 0; d <= maxD; d++ {
+		for k := -d; k <= d; k += 2 {
+			if k == -d || (k != d && forward[offset+k-1] < forward[offset+k+1]) {
+				x = forward[offset+k+1]
+			} else {
+				x = forward[offset+k-1] + 1
+			}
+			y = x - k
+			u, v = x, y
+			for u < n && v < m && a[u] == b[v] {
+				u, v = u+1, v+1
+			}
+			forward[offset+k] = u
+			if back := delta - k; odd && back >= -(d-1) && back <= d-1 && u+backward[offset+back] >= n {
+				return x, y, u, v, 2*d-1 <= maxEdits
+			}
+		}
+		for k := -d; k <= d; k += 2 {
+			var bx int
+			if k == -d || (k != d && backward[offset+k-1] < backward[offset+k+1]) {
+				bx = backward[offset+k+1]
+			} else {
+				bx = backward[offset+k-1] + 1
+			}
+			by := bx - k
+			ex, ey := bx, by
+			for ex < n && ey < m && a[n-1-ex] == b[m-1-ey] {
+				ex, ey = ex+1, ey+1
+			}
+			backward[offset+k] = ex
+			if fwd := delta - k; !odd && fwd >= -d && fwd <= d && ex+forward[offset+fwd] >= n {
+				return n - ex, m - ey, n - bx, m - by, 2*d <= maxEdits
+			}
+		}
+	}
+	return 0, 0, 0, 0, false
+}
+
+// unifiedDiff formats ops as a unified diff from the file fromName
+// to toName, which is empty if nothing changed.
+func unifiedDiff(fromName, toName string, ops []lineOp) []byte {
+	var out bytes.Buffer
+	for i := 0; i < len(ops); {
+		if ops[i].kind == ' ' {
+			i++
+			continue
+		}
+
+		// a hunk starts with the context before the change, and goes
+		// on until a change is followed by more unchanged lines than
+		// the context after it and before the next change
+		start := i - unifiedContext
+		if start < 0 {
+			start = 0
+		}
+		end := i
+		for j := i; j < len(ops); j++ {
+			if ops[j].kind != ' ' {
+				end = j + 1
+			} else if j-end >= 2*unifiedContext {
+				break
+			}
+		}
+		end += unifiedContext
+		if end > len(ops) {
+			end = len(ops)
+		}
+
+		fromStart, toStart := 0, 0
+		for _, op := range ops[:start] {
+			if op.kind != '+' {
+				fromStart++
+			}
+			if op.kind != '-' {
+				toStart++
+			}
+		}
+		var fromLen, toLen int
+		for _, op := range ops[start:end] {
+			if op.kind != '+' {
+				fromLen++
+			}
+			if op.kind != '-' {
+				toLen++
+			}
+		}
+
+		if out.Len() == 0 {
+			fmt.Fprintf(&out, "--- %s\n+++ %s\n", fromName, toName)
+		}
+		fmt.Fprintf(&out, "@@ -%s +%s @@\n", hunkRange(fromStart, fromLen), hunkRange(toStart, toLen))
+		for _, op := range ops[start:end] {
+			out.WriteByte(op.kind)
+			out.WriteString(op.text)
+			out.WriteByte('\n')
+		}
+		i = end
+	}
+	return out.Bytes()
+}
+
+// hunkRange formats the range of lines in a hunk header, given
+// how many lines come before it and how many it has.
+func hunkRange(before, length int) string {
+	if length == 0 {
+		return fmt.Sprintf("%d,0", before)
+	}
+	if length == 1 {
+		return fmt.Sprintf("%d", before+1)
+	}
+	return fmt.Sprintf("%d,%d", before+1, length)
+}
@@ -0,0 +1,110 @@
+package adapt
+
+import (
+	"math/rand"
+	"strconv"
+	"testing"
+)
+
+// checkDiffLines fails t unless ops turns a into b with as
+// few edits as the longest common subsequence allows.
+func checkDiffLines(t *testing.T, a, b []string, ops []lineOp) {
+	t.Helper()
+	var from, to []string
+	edits := 0
+	for _, op := range ops {
+		if op.kind != '+' {
+			from = append(from, op.text)
+		}
+		if op.kind != '-' {
+			to = append(to, op.text)
+		}
+		if op.kind != ' ' {
+			edits++
+		}
+	}
+	if !equalLines(from, a) || !equalLines(to, b) {
+		t.Fatalf("diff of %q and %q doesn't turn one into the other: %q", a, b, ops)
+	}
+
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] > lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+	if shortest := len(a) + len(b) - 2*lcs[0][0]; edits != shortest {
+		t.Fatalf("diff of %q and %q has %d edits, expected %d", a, b, edits, shortest)
+	}
+}
+
+func equalLines(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestDiffLinesShortest(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	randomLines := func() []string {
+		lines := make([]string, rnd.Intn(12))
+		for i := range lines {
+			lines[i] = string(rune('a' + rnd.Intn(4)))
+		}
+		return lines
+	}
+	for i := 0; i < 2000; i++ {
+		a, b := randomLines(), randomLines()
+		checkDiffLines(t, a, b, diffLines(a, b))
+	}
+}
+
+func TestDiffLinesManyEdits(t *testing.T) {
+	// every other line changed, for more edits than
+	// maxDiffEdits, and then just within it
+	for _, n := range []int{maxDiffEdits, maxDiffEdits/2 - 1} {
+		a, b := make([]string, 2*n), make([]string, 2*n)
+		for i := range a {
+			a[i] = strconv.Itoa(i)
+			b[i] = a[i]
+			if i%2 == 1 {
+				b[i] += "'"
+			}
+		}
+		ops := diffLines(a, b)
+		var removed, kept int
+		for _, op := range ops {
+			switch op.kind {
+			case '-':
+				removed++
+			case ' ':
+				kept++
+			}
+		}
+		if 2*n > maxDiffEdits {
+			// but for the first line, which is the same
+			if kept != 1 {
+				t.Fatalf("%d edits: expected a wholesale replace, got %d lines kept", 2*n, kept)
+			}
+			continue
+		}
+		if removed != n || kept != n {
+			t.Fatalf("%d edits: expected %d lines removed and %d kept, got %d and %d", 2*n, n, n, removed, kept)
+		}
+	}
+}
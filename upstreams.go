@@ -0,0 +1,221 @@
+package adapt
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+)
+
+// upstreamsResult is the response body of /adapt/upstreams.
+type upstreamsResult struct {
+	Reachable bool             `json:"reachable"`
+	Upstreams []upstreamResult `json:"upstreams"`
+}
+
+// upstreamResult is the outcome of dialing one upstream.
+type upstreamResult struct {
+	Dial      string `json:"dial"`
+	TLS       bool   `json:"tls,omitempty"`
+	Reachable bool   `json:"reachable"`
+	Skipped   bool   `json:"skipped,omitempty"`
+	Error     string `json:"error,omitempty"`
+}
+
+// upstream is a reverse_proxy upstream found in a config.
+type upstream struct {
+	dial string
+	tls  *tls.Config
+}
+
+const (
+	// defaultDialTimeout is how long each upstream is given to
+	// accept a connection, unless ?timeout says otherwise.
+	defaultDialTimeout = 3 * time.Second
+
+	// maxDialTimeout caps ?timeout.
+	maxDialTimeout = 30 * time.Second
+
+	// maxConcurrentDials is how many upstreams are dialed at once.
+	maxConcurrentDials = 16
+)
+
+// handleUpstreams adapts the config in the request like handleAdapt,
+// then dials each reverse_proxy upstream in the result and reports
+// which ones are unreachable, before the config is loaded.
+func (adminAdapt) handleUpstreams(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != http.MethodPost {
+		return caddy.APIError{
+			HTTPStatus: http.StatusMethodNotAllowed,
+			Err:        errorf("method not allowed"),
+		}
+	}
+
+	timeout := defaultDialTimeout
+	if t := r.URL.Query().Get("timeout"); t != "" {
+		d, err := caddy.ParseDuration(t)
+		if err != nil || d <= 0 {
+			return caddy.APIError{
+				HTTPStatus: http.StatusBadRequest,
+				Err:        errorf("invalid timeout: %s", t),
+			}
+		}
+		if d > maxDialTimeout {
+			d = maxDialTimeout
+		}
+		timeout = d
+	}
+
+	buf := getBuffer()
+	defer putBuffer(buf)
+
+	a, err := adaptRequest(r, buf)
+	if err != nil {
+		return err
+	}
+	var cfg interface{}
+	if err := json.Unmarshal(a.result, &cfg); err != nil {
+		return caddy.APIError{
+			HTTPStatus: http.StatusBadRequest,
+			Err:        errorf("adapted config is not valid JSON: %v", err),
+		}
+	}
+
+	results := dialUpstreams(r.Context(), findUpstreams(cfg), timeout)
+	reachable := true
+	for _, res := range results {
+		if !res.Reachable && !res.Skipped {
+			reachable = false
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(w).Encode(upstreamsResult{
+		Reachable: reachable,
+		Upstreams: results,
+	})
+}
+
+// findUpstreams returns the upstreams of the reverse_proxy handlers
+// in cfg, a decoded JSON config, without duplicates.
+func findUpstreams(cfg interface{}) []upstream {
+	var found []upstream
+	seen := make(map[string]bool)
+
+	var walk func(val interface{})
+	walk = func(val interface{}) {
+		switch v := val.(type) {
+		case map[string]interface{}:
+			if v["handler"] == "reverse_proxy" {
+				tlsConfig := upstreamTLS(v["transport"])
+				upstreams, _ := v["upstreams"].([]interface{})
+				for _, u := range upstreams {
+					obj, _ := u.(map[string]interface{})
+					dial, _ := obj["dial"].(string)
+					key := dial
+					if tlsConfig != nil {
+						key += " tls"
+					}
+					if dial == "" || seen[key] {
+						continue
+					}
+					seen[key] = true
+					found = append(found, upstream{dial: dial, tls: tlsConfig})
+				}
+			}
+			for _, elem := range v {
+				walk(elem)
+			}
+		case []interface{}:
+			for _, elem := range v {
+				walk(elem)
+			}
+		}
+	}
+	walk(cfg)
+
+	sort.SliceStable(found, func(i, j int) bool { return found[i].dial < found[j].dial })
+	return found
+}
+
+// upstreamTLS returns the client TLS config of a reverse_proxy
+// transport, or nil if it doesn't use TLS.
+func upstreamTLS(transport interface{}) *tls.Config {
+	t, _ := transport.(map[string]interface{})
+	tlsSettings, ok := t["tls"].(map[string]interface{})
+	if !ok {
+		return nil
+	}
+	cfg := new(tls.Config)
+	cfg.ServerName, _ = tlsSettings["server_name"].(string)
+	cfg.InsecureSkipVerify, _ = tlsSettings["insecure_skip_verify"].(bool)
+	return cfg
+}
+
+// dialUpstreams dials upstreams, at most maxConcurrentDials at a
+// time, and returns the results in the same order.
+func dialUpstreams(ctx context.Context, upstreams []upstream, timeout time.Duration) []upstreamResult {
+	results := make([]upstreamResult, len(upstreams))
+	sem := make(chan struct{}, maxConcurrentDials)
+	var wg sync.WaitGroup
+	for i, u := range upstreams {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int, u upstream) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			results[i] = dialUpstream(ctx, u, timeout)
+		}(i, u)
+	}
+	wg.Wait()
+	return results
+}
+
+// dialUpstream connects to u, including the TLS handshake if
+// it uses TLS. Upstreams whose address has placeholders are
+// only known at request time, so they are skipped.
+func dialUpstream(ctx context.Context, u upstream, timeout time.Duration) upstreamResult {
+	res := upstreamResult{Dial: u.dial, TLS: u.tls != nil}
+	if strings.Contains(u.dial, "{") {
+		res.Skipped = true
+		return res
+	}
+	addr, err := caddy.ParseNetworkAddress(u.dial)
+	if err != nil {
+		res.Error = err.Error()
+		return res
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	conn, err := newDialer(0).DialContext(ctx, addr.Network, addr.JoinHostPort(0))
+	if err != nil {
+		res.Error = err.Error()
+		return res
+	}
+	defer conn.Close()
+
+	if u.tls != nil {
+		cfg := u.tls.Clone()
+		if cfg.ServerName == "" {
+			cfg.ServerName = addr.Host
+		}
+		conn.SetDeadline(time.Now().Add(timeout))
+		if err := tls.Client(conn, cfg).Handshake(); err != nil {
+			res.Error = err.Error()
+			return res
+		}
+	}
+
+	res.Reachable = true
+	return res
+}
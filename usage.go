@@ -0,0 +1,87 @@
+package adapt
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+)
+
+// moduleUsage is how much a module is used across the snapshots.
+type moduleUsage struct {
+	Module    string    `json:"module"`
+	Snapshots []string  `json:"snapshots"`
+	FirstSeen time.Time `json:"first_seen"`
+	LastSeen  time.Time `json:"last_seen"`
+}
+
+// usageResult is the response body of /adapt/usage.
+type usageResult struct {
+	Snapshots int           `json:"snapshots"`
+	Modules   []moduleUsage `json:"modules"`
+}
+
+// handleUsage responds with which modules, such as HTTP handlers
+// and matchers, are used by the stored snapshots, and since and
+// until when, by when the snapshots that use them were stored, so
+// that platform teams can tell whether a plugin is still used before
+// dropping it from their builds. ?prefix limits it to modules whose
+// IDs start with it, such as http.handlers.
+func (adminAdapt) handleUsage(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != http.MethodGet {
+		return caddy.APIError{
+			HTTPStatus: http.StatusMethodNotAllowed,
+			Err:        errorf("method not allowed"),
+		}
+	}
+	prefix := r.URL.Query().Get("prefix")
+
+	snaps, err := readSnapshots()
+	if err != nil {
+		return err
+	}
+	usage := make(map[string]*moduleUsage)
+	for _, snap := range snaps {
+		found, err := configModules(snap.Config)
+		if err != nil {
+			return errorf("snapshot %s: %v", snap.Name, err)
+		}
+		stored := snap.Provenance.Timestamp
+		for id := range found {
+			if !strings.HasPrefix(id, prefix) {
+				continue
+			}
+			mu, ok := usage[id]
+			if !ok {
+				mu = &moduleUsage{Module: id, FirstSeen: stored, LastSeen: stored}
+				usage[id] = mu
+			}
+			mu.Snapshots = append(mu.Snapshots, snap.Name)
+			if stored.Before(mu.FirstSeen) {
+				mu.FirstSeen = stored
+			}
+			if stored.After(mu.LastSeen) {
+				mu.LastSeen = stored
+			}
+		}
+	}
+
+	res := usageResult{Snapshots: len(snaps), Modules: []moduleUsage{}}
+	for _, mu := range usage {
+		res.Modules = append(res.Modules, *mu)
+	}
+	// the most used first
+	sort.Slice(res.Modules, func(i, j int) bool {
+		a, b := res.Modules[i], res.Modules[j]
+		if len(a.Snapshots) != len(b.Snapshots) {
+			return len(a.Snapshots) > len(b.Snapshots)
+		}
+		return a.Module < b.Module
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(w).Encode(res)
+}
@@ -0,0 +1,41 @@
+package adapt
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestUsage(t *testing.T) {
+	startApp(t, &App{})
+	for name, cfg := range map[string]string{
+		"a": `{"apps":{"http":{"servers":{"s":{"routes":[{"handle":[{"handler":"file_server"},{"handler":"reverse_proxy"}]}]}}}}}`,
+		"b": `{"apps":{"http":{"servers":{"s":{"routes":[{"handle":[{"handler":"reverse_proxy"}]}]}}}}}`,
+	} {
+		expectStatus(t, post("/adapt/snapshots/"+name, "application/json", cfg), http.StatusCreated)
+	}
+
+	w := serve(httptest.NewRequest(http.MethodGet, "/adapt/usage?prefix=http.handlers.", nil))
+	expectStatus(t, w, http.StatusOK)
+	var res usageResult
+	if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil {
+		t.Fatal(err)
+	}
+	if res.Snapshots != 2 || len(res.Modules) != 2 {
+		t.Fatalf("unexpected usage %s", w.Body)
+	}
+	for i, want := range []moduleUsage{
+		{Module: "http.handlers.reverse_proxy", Snapshots: []string{"a", "b"}},
+		{Module: "http.handlers.file_server", Snapshots: []string{"a"}},
+	} {
+		got := res.Modules[i]
+		if got.Module != want.Module || !reflect.DeepEqual(got.Snapshots, want.Snapshots) {
+			t.Fatalf("expected %+v, got %+v", want, got)
+		}
+		if got.FirstSeen.IsZero() || got.LastSeen.Before(got.FirstSeen) {
+			t.Fatalf("%s: unexpected first and last seen %v, %v", got.Module, got.FirstSeen, got.LastSeen)
+		}
+	}
+}
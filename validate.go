@@ -0,0 +1,68 @@
+package adapt
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/caddyserver/caddy/v2"
+)
+
+// validateResult is the response body of /adapt/validate.
+type validateResult struct {
+	Valid    bool           `json:"valid"`
+	Error    string         `json:"error,omitempty"`
+	Warnings []adaptWarning `json:"warnings"`
+}
+
+// handleValidate adapts the config in the request like handleAdapt,
+// then validates the result. It tells whether the config would load,
+// without loading it.
+func (adminAdapt) handleValidate(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != http.MethodPost {
+		return caddy.APIError{
+			HTTPStatus: http.StatusMethodNotAllowed,
+			Err:        errorf("method not allowed"),
+		}
+	}
+
+	buf := getBuffer()
+	defer putBuffer(buf)
+
+	a, err := adaptRequest(r, buf)
+	if err != nil {
+		return err
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(w).Encode(validateConfig(a.result, a.warnings))
+}
+
+// validateConfig validates cfgJSON, an adapted config, the way
+// `caddy validate` does, which provisions every module in it
+// without starting any apps.
+func validateConfig(cfgJSON []byte, warnings []adaptWarning) validateResult {
+	res := validateResult{Valid: true, Warnings: warnings}
+
+	var cfg *caddy.Config
+	err := json.Unmarshal(cfgJSON, &cfg)
+	if err == nil {
+		err = validateCaddyConfig(cfg)
+	}
+	if err != nil {
+		res.Valid = false
+		res.Error = err.Error()
+	}
+	return res
+}
+
+// validateCaddyConfig calls caddy.Validate, turning a panic into an
+// error, since some invalid configs, such as one whose storage names
+// no module, make it panic rather than fail.
+func validateCaddyConfig(cfg *caddy.Config) (err error) {
+	defer func() {
+		if rec := recover(); rec != nil {
+			err = errorf("validating config panicked: %v", rec)
+		}
+	}()
+	return caddy.Validate(cfg)
+}
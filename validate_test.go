@@ -0,0 +1,43 @@
+package adapt
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+)
+
+func TestValidateRecoversPanic(t *testing.T) {
+	startApp(t, &App{})
+	// a storage that names no module makes caddy.Validate panic
+	body := `{"storage":{}}`
+
+	w := post("/adapt/validate", "application/json", body)
+	expectStatus(t, w, http.StatusOK)
+	var res validateResult
+	if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil {
+		t.Fatal(err)
+	}
+	if res.Valid || res.Error == "" {
+		t.Fatalf("expected the config to be invalid, got %s", w.Body)
+	}
+
+	if err := callRPC(t, "validate", rpcAdaptParams{Body: body}, &res); err != nil {
+		t.Fatalf("validate failed: %s", err.Message)
+	}
+	if res.Valid || res.Error == "" {
+		t.Fatalf("expected the config to be invalid, got %+v", res)
+	}
+}
+
+func TestDryRunRecoversPanic(t *testing.T) {
+	startApp(t, &App{})
+	w := post("/adapt/dry-run", "application/json", `{"storage":{}}`)
+	expectStatus(t, w, http.StatusOK)
+	var res dryRunResult
+	if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil {
+		t.Fatal(err)
+	}
+	if res.Valid || res.Error == "" {
+		t.Fatalf("expected the config to be invalid, got %s", w.Body)
+	}
+}
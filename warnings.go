@@ -0,0 +1,169 @@
+package adapt
+
+import (
+	"fmt"
+	"regexp"
+
+	"github.com/caddyserver/caddy/v2/caddyconfig"
+)
+
+// Warning severities, from least to most pressing.
+const (
+	severityInfo        = "info"
+	severityDeprecation = "deprecation"
+	severityWarn        = "warn"
+)
+
+// adaptWarning is a warning from a config adapter, with a code that
+// doesn't change with its wording and a severity, so that clients can
+// act on classes of warnings without matching their messages.
+type adaptWarning struct {
+	caddyconfig.Warning
+	Code     string `json:"code"`
+	Severity string `json:"severity"`
+
+	// the message, if this module produced the warning rather
+	// than an adapter, which the Message is the English of
+	msg message
+}
+
+// warningClasses classify adapter warnings by their messages, which
+// is all there is to go by. The first that matches applies; warnings
+// that none match are ADAPTER_WARNING.
+var warningClasses = []struct {
+	message  *regexp.Regexp
+	code     string
+	severity string
+}{
+	{regexp.MustCompile(`^input is not formatted with 'caddy fmt'$`), "CADDYFILE_NOT_FORMATTED", severityInfo},
+	{regexp.MustCompile(`global option is deprecated`), "CADDYFILE_DEPRECATED_OPTION", severityDeprecation},
+	{regexp.MustCompile(`(?i)\bdeprecated\b`), "CADDYFILE_DEPRECATED_DIRECTIVE", severityDeprecation},
+	{regexp.MustCompile(`^not an? (integer|string|duration) type$`), "CADDYFILE_INVALID_OPTION_TYPE", severityWarn},
+	{regexp.MustCompile(`^module not registered`), "UNKNOWN_MODULE", severityWarn},
+	{regexp.MustCompile(`^json: `), "ENCODING_FAILED", severityWarn},
+}
+
+// WarningRules pick out adapter warnings to leave out of responses,
+// or to fail requests with, such as known noise or deprecations that
+// should fail a CI build. Each rule is either the code of warnings,
+// such as CADDYFILE_NOT_FORMATTED, or a regular expression that
+// their messages match, such as "transport" or "(?i)deprecated".
+type WarningRules struct {
+	// Warnings to leave out of responses, so that they don't count
+	// as errors in strict mode, or for error_on, either.
+	Suppress []string `json:"suppress,omitempty"`
+
+	// Warnings that fail the request with 422, like in strict mode,
+	// even if it isn't on.
+	ErrorOn []string `json:"error_on,omitempty"`
+
+	suppress []*regexp.Regexp
+	errorOn  []*regexp.Regexp
+}
+
+// provision compiles the rules.
+func (wr *WarningRules) provision() error {
+	var err error
+	if wr.suppress, err = compileWarningRules(wr.Suppress); err != nil {
+		return fmt.Errorf("suppress: %v", err)
+	}
+	if wr.errorOn, err = compileWarningRules(wr.ErrorOn); err != nil {
+		return fmt.Errorf("error_on: %v", err)
+	}
+	return nil
+}
+
+// compileWarningRules compiles rules as regular expressions.
+func compileWarningRules(rules []string) ([]*regexp.Regexp, error) {
+	compiled := make([]*regexp.Regexp, len(rules))
+	for i, rule := range rules {
+		if rule == "" {
+			return nil, fmt.Errorf("rule %d is empty", i)
+		}
+		re, err := regexp.Compile(rule)
+		if err != nil {
+			return nil, fmt.Errorf("rule %d: %v", i, err)
+		}
+		compiled[i] = re
+	}
+	return compiled, nil
+}
+
+// matchesWarningRule returns true if any of rules, as compiled
+// in compiled, matches w.
+func matchesWarningRule(rules []string, compiled []*regexp.Regexp, w adaptWarning) bool {
+	for i, rule := range rules {
+		if rule == w.Code || compiled[i].MatchString(w.Message) {
+			return true
+		}
+	}
+	return false
+}
+
+// classifyWarnings returns warnings with their codes and severities,
+// leaving out those that the warning_rules suppress. The result is
+// never nil.
+func classifyWarnings(warnings []caddyconfig.Warning) []adaptWarning {
+	classified := make([]adaptWarning, 0, len(warnings))
+	for _, warning := range warnings {
+		w := classifyWarning(warning)
+		if !suppressedWarning(w) {
+			classified = append(classified, w)
+		}
+	}
+	return classified
+}
+
+// suppressedWarning returns true if the warning_rules leave w
+// out of responses.
+func suppressedWarning(w adaptWarning) bool {
+	rules := settings().WarningRules
+	return rules != nil && matchesWarningRule(rules.Suppress, rules.suppress, w)
+}
+
+// errorWarnings returns those of warnings that the error_on
+// warning rules make errors.
+func errorWarnings(warnings []adaptWarning) []adaptWarning {
+	rules := settings().WarningRules
+	if rules == nil {
+		return nil
+	}
+	var errs []adaptWarning
+	for _, w := range warnings {
+		if matchesWarningRule(rules.ErrorOn, rules.errorOn, w) {
+			errs = append(errs, w)
+		}
+	}
+	return errs
+}
+
+// localizeWarnings localizes the messages of those of warnings that
+// this module produced into one of langs. The warning rules match
+// their English messages, so this is done once they have been.
+func localizeWarnings(warnings []adaptWarning, langs []string) {
+	for i, w := range warnings {
+		if w.msg.format != "" {
+			warnings[i].Message = w.msg.localize(langs)
+		}
+	}
+}
+
+// classifyWarning returns warning with its code and severity.
+func classifyWarning(warning caddyconfig.Warning) adaptWarning {
+	for _, class := range warningClasses {
+		if class.message.MatchString(warning.Message) {
+			return adaptWarning{Warning: warning, Code: class.code, Severity: class.severity}
+		}
+	}
+	return adaptWarning{Warning: warning, Code: "ADAPTER_WARNING", Severity: severityWarn}
+}
+
+// containsString returns true if list contains s.
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,51 @@
+package adapt
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestSuppressWarnings(t *testing.T) {
+	for _, app := range []*App{
+		{WarningRules: &WarningRules{Suppress: []string{"ADAPTER_WARNING"}}},
+		{WarningRules: &WarningRules{Suppress: []string{"^echo"}}},
+		{SuppressWarnings: []string{"ADAPTER_WARNING"}},
+		{
+			SuppressWarnings: []string{"ADAPTER_WARNING"},
+			WarningRules:     &WarningRules{ErrorOn: []string{"ADAPTER_WARNING"}},
+		},
+	} {
+		app.StrictWarnings = true
+		startApp(t, app)
+		expectStatus(t, post("/adapt", "text/test-echo", "hi"), http.StatusOK)
+	}
+}
+
+func TestWarningRulesErrorOn(t *testing.T) {
+	startApp(t, &App{WarningRules: &WarningRules{ErrorOn: []string{"^echoed$"}}})
+	expectStatus(t, post("/adapt?strict=false", "text/test-echo", "hi"), http.StatusUnprocessableEntity)
+}
+
+func TestLocalizedWarnings(t *testing.T) {
+	RegisterMessages("x-test", map[string]string{
+		"module %s at %s is not registered in this Caddy build": "%s (%s) is missing",
+		"formatted": "tidied",
+	})
+	startApp(t, &App{})
+	w := post("/adapt?warnings=true&caddy_version=2.4.6", "text/test-echo", "hi", "Accept-Language", "x-test")
+	expectStatus(t, w, http.StatusOK)
+	if !strings.Contains(w.Body.String(), "echo (/apps) is missing") {
+		t.Fatalf("expected a localized warning, got %s", w.Body)
+	}
+	w = post("/adapt/fix", "text/caddyfile", "example.com {\nrespond hi\n}", "Accept-Language", "x-test")
+	expectStatus(t, w, http.StatusOK)
+	if !strings.Contains(w.Body.String(), `"tidied"`) {
+		t.Fatalf("expected a localized fix, got %s", w.Body)
+	}
+
+	// warning rules match the English, whatever the language
+	startApp(t, &App{WarningRules: &WarningRules{ErrorOn: []string{"is not registered"}}})
+	w = post("/adapt?caddy_version=2.4.6", "text/test-echo", "hi", "Accept-Language", "x-test")
+	expectStatus(t, w, http.StatusUnprocessableEntity)
+}
@@ -0,0 +1,232 @@
+package adapt
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"go.uber.org/zap"
+)
+
+// Defaults for webhooks that don't set their own.
+const (
+	defaultWebhookTimeout    = 10 * time.Second
+	defaultWebhookRetries    = 3
+	defaultWebhookRetryDelay = time.Second
+)
+
+// Webhook is a URL that adapted configs are POSTed to, when
+// requests to /adapt ask for it with `?notify=true`, and, if it
+// wants diffs, the changes to the running config that are loaded.
+type Webhook struct {
+	// The http or https URL to POST to.
+	URL string `json:"url"`
+
+	// A shared secret to sign deliveries with, by HMAC-SHA256
+	// over the body, in an `X-Signature: hmac-sha256=<base64>`
+	// header like /adapt/verify accepts. It may be a placeholder,
+	// such as {env.WEBHOOK_SECRET}. If empty, deliveries
+	// aren't signed.
+	Secret string `json:"secret,omitempty"`
+
+	// How many times a delivery that fails is retried, which is
+	// when the URL can't be reached or it responds with 429 or
+	// a 5xx status. Default: 3
+	MaxRetries *int `json:"max_retries,omitempty"`
+
+	// How long to wait before the first retry, which doubles
+	// for each one after it. Default: 1s
+	RetryDelay caddy.Duration `json:"retry_delay,omitempty"`
+
+	// How long each attempt may take. Default: 10s
+	Timeout caddy.Duration `json:"timeout,omitempty"`
+
+	// Also POSTs the changes to the running config, as a JSON Patch,
+	// whenever this module loads one that is different from it.
+	Diffs bool `json:"diffs,omitempty"`
+
+	// The redaction profile that both configs are redacted with
+	// before they are diffed, since the running config has the
+	// secrets that were resolved for it in it. Default: support-bundle
+	RedactDiffs string `json:"redact_diffs,omitempty"`
+
+	secret []byte
+}
+
+// provision checks w's settings and resolves its secret.
+func (w *Webhook) provision(repl *caddy.Replacer) error {
+	u, err := url.Parse(w.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("url %s is not an http or https URL", w.URL)
+	}
+	if w.MaxRetries != nil && *w.MaxRetries < 0 {
+		return fmt.Errorf("max_retries may not be negative")
+	}
+	if w.RetryDelay < 0 || w.Timeout < 0 {
+		return fmt.Errorf("retry_delay and timeout may not be negative")
+	}
+	if w.Secret != "" {
+		secret := repl.ReplaceAll(w.Secret, "")
+		if secret == "" {
+			return fmt.Errorf("secret is empty")
+		}
+		w.secret = []byte(secret)
+	}
+	if w.RedactDiffs == "" {
+		w.RedactDiffs = "support-bundle"
+	}
+	return nil
+}
+
+// notifyWebhooks delivers cfgJSON, adapted for r as prov describes,
+// to every configured webhook. Deliveries happen in the background,
+// so failures are only logged.
+func notifyWebhooks(r *http.Request, cfgJSON []byte, prov provenance) {
+	log := logger(r)
+	header := make(http.Header)
+	header.Set("Content-Type", "application/json")
+	header.Set("X-Adapt-Event", "adapt")
+	if id := requestID(r.Context()); id != "" {
+		header.Set("X-Request-ID", id)
+	}
+	prov.setHeaders(header)
+	for _, wh := range settings().Webhooks {
+		go func(wh *Webhook) {
+			if err := wh.deliver(cfgJSON, header); err != nil {
+				log.Error("failed to deliver adapted config to webhook",
+					zap.String("url", wh.URL),
+					zap.Error(err))
+			}
+		}(wh)
+	}
+}
+
+// wantDiffs returns true if any webhook is
+// delivered the changes to the running config.
+func wantDiffs() bool {
+	for _, wh := range settings().Webhooks {
+		if wh.Diffs {
+			return true
+		}
+	}
+	return false
+}
+
+// notifyLoadDiff delivers the changes from the running config before,
+// whose hash is beforeHash, to after, which r loaded, to the webhooks
+// that want diffs. Deliveries happen in the background, so failures
+// are only logged.
+func notifyLoadDiff(r *http.Request, before, after []byte, beforeHash, afterHash string) {
+	log := logger(r)
+	header := make(http.Header)
+	header.Set("Content-Type", "application/json-patch+json")
+	header.Set("X-Adapt-Event", "load")
+	if id := requestID(r.Context()); id != "" {
+		header.Set("X-Request-ID", id)
+	}
+	header.Set("X-Adapt-Previous-Hash", beforeHash)
+	header.Set("X-Adapt-Hash", afterHash)
+	for _, wh := range settings().Webhooks {
+		if !wh.Diffs {
+			continue
+		}
+		go func(wh *Webhook) {
+			diff, err := wh.diff(before, after)
+			if err == nil {
+				err = wh.deliver(diff, header)
+			}
+			if err != nil {
+				log.Error("failed to deliver config changes to webhook",
+					zap.String("url", wh.URL),
+					zap.Error(err))
+			}
+		}(wh)
+	}
+}
+
+// diff returns the JSON Patch from the config before to the config
+// after, both redacted with w's profile.
+func (w *Webhook) diff(before, after []byte) ([]byte, error) {
+	profile, err := redactionProfile(w.RedactDiffs)
+	if err != nil {
+		return nil, err
+	}
+	var from, to interface{}
+	if err := json.Unmarshal(before, &from); err != nil {
+		return nil, fmt.Errorf("decoding previous config: %v", err)
+	}
+	if err := json.Unmarshal(after, &to); err != nil {
+		return nil, fmt.Errorf("decoding loaded config: %v", err)
+	}
+	return json.Marshal(jsonPatch("", profile.redact(from), profile.redact(to), []patchOp{}))
+}
+
+// deliver POSTs body to w with header, retrying with backoff until
+// it succeeds, fails for good, or runs out of retries.
+func (w *Webhook) deliver(body []byte, header http.Header) error {
+	retries := defaultWebhookRetries
+	if w.MaxRetries != nil {
+		retries = *w.MaxRetries
+	}
+	delay := time.Duration(w.RetryDelay)
+	if delay == 0 {
+		delay = defaultWebhookRetryDelay
+	}
+	timeout := time.Duration(w.Timeout)
+	if timeout == 0 {
+		timeout = defaultWebhookTimeout
+	}
+	client := &http.Client{
+		Timeout:   timeout,
+		Transport: outboundTransport(timeout, false),
+	}
+
+	var err error
+	for attempt := 0; ; attempt++ {
+		var retry bool
+		retry, err = w.post(client, body, header)
+		if err == nil || !retry || attempt == retries {
+			return err
+		}
+		time.Sleep(delay)
+		delay *= 2
+	}
+}
+
+// post makes one attempt at delivering body to w, and
+// returns whether it is worth trying again if it fails.
+func (w *Webhook) post(client *http.Client, body []byte, header http.Header) (bool, error) {
+	req, err := http.NewRequest(http.MethodPost, w.URL, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	if w.secret != nil {
+		mac := hmac.New(sha256.New, w.secret)
+		mac.Write(body)
+		req.Header.Set("X-Signature", "hmac-sha256="+base64.StdEncoding.EncodeToString(mac.Sum(nil)))
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	io.Copy(ioutil.Discard, io.LimitReader(resp.Body, 1<<20))
+	resp.Body.Close()
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return false, nil
+	}
+	retry := resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500
+	return retry, fmt.Errorf("%s responded with %s", w.URL, resp.Status)
+}